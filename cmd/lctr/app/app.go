@@ -20,11 +20,19 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/containerd/containerd/version"
+	"github.com/containerd/lcontainerd/cmd/lctr/app/container"
 	"github.com/containerd/lcontainerd/cmd/lctr/app/content"
+	"github.com/containerd/lcontainerd/cmd/lctr/app/database"
+	"github.com/containerd/lcontainerd/cmd/lctr/app/datadir"
+	"github.com/containerd/lcontainerd/cmd/lctr/app/gc"
 	"github.com/containerd/lcontainerd/cmd/lctr/app/image"
 	"github.com/containerd/lcontainerd/cmd/lctr/app/lease"
+	"github.com/containerd/lcontainerd/cmd/lctr/app/selftest"
+	"github.com/containerd/lcontainerd/pkg/cli/profile"
+	"github.com/containerd/lcontainerd/pkg/iobuf"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 )
@@ -37,13 +45,13 @@ func init() {
 
 // New returns a *cli.App instance.
 func New() *cli.App {
-	datadir := os.Getenv("XDG_DATA_HOME")
-	if datadir == "" {
+	datahome := os.Getenv("XDG_DATA_HOME")
+	if datahome == "" {
 		hd, err := os.UserHomeDir()
 		if err != nil {
 			panic(err)
 		}
-		datadir = filepath.Join(hd, ".local", "share")
+		datahome = filepath.Join(hd, ".local", "share")
 	}
 
 	app := cli.NewApp()
@@ -68,30 +76,109 @@ containerd CLI for library and hacks
 			Name:  "debug",
 			Usage: "enable debug output in logs",
 		},
+		cli.StringFlag{
+			Name:   "cpuprofile",
+			Usage:  "write a cpu profile of the command to the file",
+			Hidden: true,
+		},
+		cli.StringFlag{
+			Name:   "memprofile",
+			Usage:  "write a memory profile to the file when the command exits",
+			Hidden: true,
+		},
 		cli.StringFlag{
 			Name:  "data-dir, d",
 			Usage: "data directory for all metadata",
-			Value: filepath.Join(datadir, "lctr"),
+			Value: filepath.Join(datahome, "lctr"),
+		},
+		cli.StringFlag{
+			Name:  "data-dir-mode",
+			Usage: "octal mode used when creating the data directory, database, and content store",
+			Value: "0700",
+		},
+		cli.StringFlag{
+			Name:  "config",
+			Usage: "TOML file of default registry and transfer options, flags given on the command line take precedence",
+		},
+		cli.DurationFlag{
+			Name:  "gc-min-age",
+			Usage: "minimum age of content before garbage collection may remove it when unreferenced",
+		},
+		cli.DurationFlag{
+			Name:  "db-timeout",
+			Usage: "how long to wait for a database locked by another lctr process, 0 waits indefinitely",
+			Value: 10 * time.Second,
+		},
+		cli.IntFlag{
+			Name:  "gc-concurrency",
+			Usage: "number of resources to resolve references for at once when marking during garbage collection, limited to the number of CPUs",
+			Value: 1,
+		},
+		cli.BoolFlag{
+			Name:  "check-consistency",
+			Usage: "warn about expired ingests, dangling content references, and missing image targets when opening the database, removing the expired ingests unless read-only",
+		},
+		cli.BoolFlag{
+			Name:  "no-sync",
+			Usage: "do not wait for metadata writes to reach the disk, use \"db sync\" to make them durable",
+		},
+		cli.IntFlag{
+			Name:  "io-buffer-size",
+			Usage: "size in bytes of the buffer used when copying content",
+			Value: iobuf.DefaultBufferSize,
+		},
+		cli.Int64Flag{
+			Name:  "max-manifest-size",
+			Usage: "maximum size in bytes of manifest, index, and config blobs read into memory",
+			Value: iobuf.DefaultMaxBlobSize,
 		},
 	}
 	app.Commands = []cli.Command{
+		container.Command,
 		content.Command,
+		database.Command,
+		gc.Command,
 		image.Command,
 		lease.Command,
+		selftest.Command,
 	}
+	// stopProfile writes any profiles started for the command
+	stopProfile := func() error { return nil }
 	app.Before = func(context *cli.Context) error {
 		if context.GlobalBool("debug") {
 			logrus.SetLevel(logrus.DebugLevel)
 		}
-		datadir := context.GlobalString("data-dir")
-		if _, err := os.Stat(datadir); os.IsNotExist(err) {
-			if err := os.MkdirAll(datadir, 0700); err != nil {
+		if cpu, mem := context.GlobalString("cpuprofile"), context.GlobalString("memprofile"); cpu != "" || mem != "" {
+			stop, err := profile.Start(cpu, mem)
+			if err != nil {
+				return err
+			}
+			logrus.WithField("cpu", cpu).WithField("memory", mem).Debug("profiling command")
+			stopProfile = stop
+		}
+		if err := iobuf.SetBufferSize(context.GlobalInt("io-buffer-size")); err != nil {
+			return err
+		}
+		if err := iobuf.SetMaxBlobSize(context.GlobalInt64("max-manifest-size")); err != nil {
+			return err
+		}
+		mode, err := datadir.Mode(context)
+		if err != nil {
+			return err
+		}
+		dir := context.GlobalString("data-dir")
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			if err := os.MkdirAll(dir, mode); err != nil {
 				return err
 			}
+			return os.Chmod(dir, mode)
 		} else if err != nil {
 			return err
 		}
 		return nil
 	}
+	app.After = func(context *cli.Context) error {
+		return stopProfile()
+	}
 	return app
 }
@@ -0,0 +1,330 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/lcontainerd/cmd/lctr/app/datadir"
+	"github.com/containerd/lcontainerd/cmd/lctr/app/listing"
+	"github.com/containerd/lcontainerd/pkg/db"
+	"github.com/containerd/typeurl/v2"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/urfave/cli"
+)
+
+func init() {
+	// Use the same type url as containerd so stored specs may be read by
+	// containerd clients
+	typeurl.Register(&specs.Spec{}, "types.containerd.io", "opencontainers/runtime-spec", strconv.Itoa(specs.VersionMajor), "Spec")
+}
+
+// Command is the cli command for managing containers
+var Command = cli.Command{
+	Name:  "container",
+	Usage: "manage containers",
+	Subcommands: cli.Commands{
+		createCommand,
+		listCommand,
+		inspectCommand,
+		removeCommand,
+	},
+}
+
+var createCommand = cli.Command{
+	Name:      "create",
+	Usage:     "create a container",
+	ArgsUsage: "<container id> [flags]",
+	Description: `Creates a container record in the metadata database.
+
+The container is only recorded, nothing is run. Use --spec to store an OCI
+runtime spec read from a JSON file, otherwise an empty spec is stored. Use
+--snapshotter and --snapshot-key to reference the container's root
+filesystem snapshot, which is then kept from garbage collection for as
+long as the container exists.
+`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "runtime",
+			Usage: "name of the runtime used to run the container",
+			Value: "io.containerd.runc.v2",
+		},
+		cli.StringFlag{
+			Name:  "image",
+			Usage: "name of the image the container was created from",
+		},
+		cli.StringFlag{
+			Name:  "snapshotter",
+			Usage: "name of the snapshotter holding the container's snapshot",
+		},
+		cli.StringFlag{
+			Name:  "snapshot-key",
+			Usage: "key of the container's snapshot, requires --snapshotter",
+		},
+		cli.StringFlag{
+			Name:  "spec",
+			Usage: "path to a JSON file holding the OCI runtime spec",
+		},
+		cli.StringSliceFlag{
+			Name:  "label",
+			Usage: "labels to add to the container, given as key=value",
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		var (
+			ctx = context.Background()
+			id  = clicontext.Args().First()
+		)
+		if id == "" {
+			return fmt.Errorf("must provide a container ID")
+		}
+		labels, err := keyValueArgs(clicontext.StringSlice("label"))
+		if err != nil {
+			return err
+		}
+		spec, err := readSpec(clicontext.String("spec"))
+		if err != nil {
+			return err
+		}
+		specAny, err := typeurl.MarshalAny(spec)
+		if err != nil {
+			return err
+		}
+
+		mdb, err := datadir.OpenDB(clicontext)
+		if err != nil {
+			return err
+		}
+		defer mdb.Close(ctx)
+
+		c, err := db.NewContainerStore(mdb).Create(ctx, containers.Container{
+			ID:          id,
+			Labels:      labels,
+			Image:       clicontext.String("image"),
+			Snapshotter: clicontext.String("snapshotter"),
+			SnapshotKey: clicontext.String("snapshot-key"),
+			Runtime: containers.RuntimeInfo{
+				Name: clicontext.String("runtime"),
+			},
+			Spec: specAny,
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stdout, "Created container %s\n", c.ID)
+		return nil
+	},
+}
+
+var listCommand = cli.Command{
+	Name:      "list",
+	Aliases:   []string{"ls"},
+	Usage:     "list all containers",
+	ArgsUsage: "[flags]",
+	Description: `Lists all containers.
+
+Use --filter to only list matching containers, such as containers created
+from an image with --filter 'image==docker.io/library/alpine:latest'. Filters
+use the containerd filter syntax on the id, image, runtime.name, and labels
+fields.
+`,
+	Flags: []cli.Flag{listing.FilterFlag},
+	Action: func(clicontext *cli.Context) error {
+		var (
+			ctx = context.Background()
+		)
+		mdb, err := datadir.OpenDB(clicontext, db.WithReadOnly)
+		if err != nil {
+			return err
+		}
+		defer mdb.Close(ctx)
+
+		cs, err := db.NewContainerStore(mdb).List(ctx, clicontext.StringSlice("filter")...)
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 8, 3, 1, ' ', 0)
+		fmt.Fprintf(tw, "Container ID\tImage\tRuntime\tSnapshot\tLabels\n")
+		fmt.Fprintf(tw, "------------\t-----\t-------\t--------\t------\n")
+
+		for _, c := range cs {
+			image := c.Image
+			if image == "" {
+				image = "-"
+			}
+			snapshot := "-"
+			if c.SnapshotKey != "" {
+				snapshot = fmt.Sprintf("%s/%s", c.Snapshotter, c.SnapshotKey)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", c.ID, image, c.Runtime.Name, snapshot, formatLabels(c.Labels))
+		}
+
+		return tw.Flush()
+	},
+}
+
+// containerInfo is the JSON form of a container written by inspect
+type containerInfo struct {
+	ID          string            `json:"id"`
+	Image       string            `json:"image,omitempty"`
+	Runtime     string            `json:"runtime"`
+	Snapshotter string            `json:"snapshotter,omitempty"`
+	SnapshotKey string            `json:"snapshotKey,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	CreatedAt   time.Time         `json:"createdAt"`
+	UpdatedAt   time.Time         `json:"updatedAt"`
+	Spec        *specs.Spec       `json:"spec,omitempty"`
+}
+
+var inspectCommand = cli.Command{
+	Name:        "inspect",
+	Usage:       "inspect a container",
+	ArgsUsage:   "<container id> [flags]",
+	Description: `Writes the container and its runtime spec as JSON.`,
+	Action: func(clicontext *cli.Context) error {
+		var (
+			ctx = context.Background()
+			id  = clicontext.Args().First()
+		)
+		if id == "" {
+			return fmt.Errorf("must provide a container ID")
+		}
+		mdb, err := datadir.OpenDB(clicontext, db.WithReadOnly)
+		if err != nil {
+			return err
+		}
+		defer mdb.Close(ctx)
+
+		c, err := db.NewContainerStore(mdb).Get(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		info := containerInfo{
+			ID:          c.ID,
+			Image:       c.Image,
+			Runtime:     c.Runtime.Name,
+			Snapshotter: c.Snapshotter,
+			SnapshotKey: c.SnapshotKey,
+			Labels:      c.Labels,
+			CreatedAt:   c.CreatedAt,
+			UpdatedAt:   c.UpdatedAt,
+		}
+		if c.Spec != nil {
+			v, err := typeurl.UnmarshalAny(c.Spec)
+			if err != nil {
+				return fmt.Errorf("failed to read spec of container %s: %w", c.ID, err)
+			}
+			spec, ok := v.(*specs.Spec)
+			if !ok {
+				return fmt.Errorf("unexpected spec type %s for container %s", c.Spec.GetTypeUrl(), c.ID)
+			}
+			info.Spec = spec
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(info)
+	},
+}
+
+var removeCommand = cli.Command{
+	Name:      "remove",
+	Aliases:   []string{"rm"},
+	Usage:     "remove containers",
+	ArgsUsage: "<container id> [<container id>...] [flags]",
+	Description: `Removes the containers from the metadata database.
+
+The container's snapshot is no longer kept once the container is removed,
+it is removed by the next garbage collection unless otherwise referenced.
+`,
+	Action: func(clicontext *cli.Context) error {
+		var (
+			ctx = context.Background()
+			ids = clicontext.Args()
+		)
+		if len(ids) == 0 {
+			return fmt.Errorf("must provide a container ID")
+		}
+		mdb, err := datadir.OpenDB(clicontext)
+		if err != nil {
+			return err
+		}
+		defer mdb.Close(ctx)
+
+		store := db.NewContainerStore(mdb)
+		for _, id := range ids {
+			if err := store.Delete(ctx, id); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stdout, "Deleted container %s\n", id)
+		}
+		return nil
+	},
+}
+
+// readSpec reads the runtime spec from the JSON file, an empty spec is
+// returned when no file is given
+func readSpec(path string) (*specs.Spec, error) {
+	spec := &specs.Spec{Version: specs.Version}
+	if path == "" {
+		return spec, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, spec); err != nil {
+		return nil, fmt.Errorf("invalid spec %s: %w", path, err)
+	}
+	return spec, nil
+}
+
+func keyValueArgs(args []string) (map[string]string, error) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	kvs := make(map[string]string, len(args))
+	for _, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid key=value format: %v", arg)
+		}
+		kvs[parts[0]] = parts[1]
+	}
+	return kvs, nil
+}
+
+func formatLabels(l map[string]string) string {
+	var ls []string
+	for k, v := range l {
+		ls = append(ls, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(ls)
+	return strings.Join(ls, ", ")
+}
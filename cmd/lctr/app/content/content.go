@@ -24,6 +24,14 @@ var Command = cli.Command{
 	Aliases: []string{"c"},
 	Usage:   "manage content",
 	Subcommands: cli.Commands{
+		listCommand,
 		readCommand,
+		holdersCommand,
+		rootCommand,
+		rootsCommand,
+		orphansCommand,
+		removeCommand,
+		verifyCommand,
+		ingestCommand,
 	},
 }
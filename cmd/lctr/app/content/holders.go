@@ -0,0 +1,71 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package content
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/containerd/lcontainerd/cmd/lctr/app/datadir"
+	"github.com/containerd/lcontainerd/pkg/db"
+	"github.com/opencontainers/go-digest"
+	"github.com/urfave/cli"
+)
+
+var holdersCommand = cli.Command{
+	Name:        "holders",
+	Usage:       "list leases and images holding content",
+	ArgsUsage:   "<digest>",
+	Description: `Lists the leases and images which prevent content from being garbage collected`,
+	Action: func(clicontext *cli.Context) error {
+		var (
+			ctx = context.Background()
+		)
+
+		dgst, err := digest.Parse(clicontext.Args().First())
+		if err != nil {
+			return fmt.Errorf("invalid digest: %w", err)
+		}
+
+		mdb, err := datadir.OpenDB(clicontext, db.WithReadOnly)
+		if err != nil {
+			return err
+		}
+		defer mdb.Close(ctx)
+
+		if _, err := mdb.ContentStore().Info(ctx, dgst); err != nil {
+			return err
+		}
+
+		holders, err := mdb.ContentHolders(ctx, dgst)
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 8, 3, 1, ' ', 0)
+		fmt.Fprintf(tw, "Type\tName\n")
+		fmt.Fprintf(tw, "----\t----\n")
+
+		for _, h := range holders {
+			fmt.Fprintf(tw, "%s\t%s\n", h.Type, h.Name)
+		}
+
+		return tw.Flush()
+	},
+}
@@ -0,0 +1,180 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package content
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/lcontainerd/cmd/lctr/app/datadir"
+	"github.com/containerd/lcontainerd/pkg/cli/progress"
+	"github.com/containerd/lcontainerd/pkg/db"
+	"github.com/containerd/lcontainerd/pkg/iobuf"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/urfave/cli"
+)
+
+var ingestCommand = cli.Command{
+	Name:      "ingest",
+	Usage:     "add a raw blob to the content store",
+	ArgsUsage: "<file>|-",
+	Description: `Writes a file, or stdin when given "-", to the content store and prints
+the resulting descriptor.
+
+Content which is not referenced by a root, an image, or a lease is
+removed by garbage collection when the command exits, use --gc-root to
+keep the blob. With --progress, the write progress is displayed on stderr
+while the descriptor is printed on stdout.
+`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "media-type",
+			Usage: "media type of the printed descriptor",
+			Value: "application/octet-stream",
+		},
+		cli.StringSliceFlag{
+			Name:  "label",
+			Usage: "labels to set on the content (key=value)",
+			Value: &cli.StringSlice{},
+		},
+		cli.BoolFlag{
+			Name:  "gc-root",
+			Usage: "mark the content as a garbage collection root",
+		},
+		cli.BoolFlag{
+			Name:  "progress",
+			Usage: "display the write progress on stderr",
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		var (
+			ctx = context.Background()
+		)
+		if clicontext.NArg() != 1 {
+			return fmt.Errorf("must specify a single file to ingest")
+		}
+
+		labels := map[string]string{}
+		for _, arg := range clicontext.StringSlice("label") {
+			parts := strings.SplitN(arg, "=", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("invalid label %q, must be key=value", arg)
+			}
+			labels[parts[0]] = parts[1]
+		}
+		if clicontext.Bool("gc-root") {
+			labels[db.LabelGCRoot] = time.Now().UTC().Format(time.RFC3339)
+		}
+
+		r, desc, err := openBlob(clicontext.Args().First())
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		desc.MediaType = clicontext.String("media-type")
+
+		if _, ok := labels[db.LabelGCRoot]; !ok {
+			fmt.Fprintln(os.Stderr, "warning: content is not a root and may be garbage collected, use --gc-root to keep it")
+		}
+
+		mdb, err := datadir.OpenDB(clicontext)
+		if err != nil {
+			return err
+		}
+		defer mdb.Close(ctx)
+
+		cs := mdb.ContentStore()
+		ref := "ingest-" + desc.Digest.String()
+		var rd io.Reader = r
+		if clicontext.Bool("progress") {
+			// Stdout holds the descriptor, keep the progress separate
+			pr := progress.Auto(ctx, os.Stderr, 100*time.Millisecond)
+			defer pr.Close()
+			rd = db.NewProgressReader(r, ref, desc.Size, pr.Progress)
+		}
+		if err := content.WriteBlob(ctx, cs, ref, rd, desc, content.WithLabels(labels)); err != nil {
+			return fmt.Errorf("failed to write blob: %w", err)
+		}
+
+		// Labels are not applied when the content already exists
+		if len(labels) > 0 {
+			fieldpaths := make([]string, 0, len(labels))
+			for k := range labels {
+				fieldpaths = append(fieldpaths, "labels."+k)
+			}
+			if _, err := cs.Update(ctx, content.Info{Digest: desc.Digest, Labels: labels}, fieldpaths...); err != nil {
+				return fmt.Errorf("failed to update labels: %w", err)
+			}
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(desc)
+	},
+}
+
+// openBlob opens the named file, or stdin for "-", and returns a reader
+// positioned at the start of the data along with its digest and size.
+// Stdin is buffered to a temporary file while digesting so that large
+// blobs are not held in memory.
+func openBlob(name string) (io.ReadCloser, ocispec.Descriptor, error) {
+	var (
+		f        *os.File
+		w        io.Writer
+		src      io.Reader
+		digester = digest.Canonical.Digester()
+		err      error
+	)
+	if name == "-" {
+		f, err = os.CreateTemp("", "lctr-ingest-")
+		if err != nil {
+			return nil, ocispec.Descriptor{}, err
+		}
+		// Remove the name immediately, the open file remains readable
+		os.Remove(f.Name())
+		w = io.MultiWriter(f, digester.Hash())
+		src = os.Stdin
+	} else {
+		f, err = os.Open(name)
+		if err != nil {
+			return nil, ocispec.Descriptor{}, err
+		}
+		w = digester.Hash()
+		src = f
+	}
+
+	n, err := iobuf.Copy(w, src)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, ocispec.Descriptor{}, err
+	}
+
+	return f, ocispec.Descriptor{
+		Digest: digester.Digest(),
+		Size:   n,
+	}, nil
+}
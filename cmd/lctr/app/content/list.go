@@ -0,0 +1,111 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package content
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/containerd/lcontainerd/cmd/lctr/app/datadir"
+	"github.com/containerd/lcontainerd/cmd/lctr/app/listing"
+	"github.com/containerd/lcontainerd/pkg/db"
+	"github.com/urfave/cli"
+)
+
+var listCommand = cli.Command{
+	Name:      "list",
+	Aliases:   []string{"ls"},
+	Usage:     "list content",
+	ArgsUsage: "[flags]",
+	Description: `Lists content in the local content store with its size, creation time,
+and labels.
+
+Use --filter to only list matching content, such as the garbage collection
+roots with --filter 'labels."containerd.io/gc.root"'. Filters use the
+containerd filter syntax on the digest, size, and labels fields.
+
+Use --limit to list content a page at a time, the token printed after a
+page is passed to --next to continue listing from the end of the page.
+`,
+	Flags: append([]cli.Flag{
+		listing.FilterFlag,
+		cli.BoolFlag{
+			Name:  "quiet, q",
+			Usage: "only print the digests",
+		},
+	}, listing.PageFlags...),
+	Action: func(clicontext *cli.Context) error {
+		var (
+			ctx = context.Background()
+		)
+		page, err := listing.Page(clicontext)
+		if err != nil {
+			return err
+		}
+
+		mdb, err := datadir.OpenDB(clicontext, db.WithReadOnly)
+		if err != nil {
+			return err
+		}
+		defer mdb.Close(ctx)
+
+		infos, next, err := mdb.ListContent(ctx, page, clicontext.StringSlice("filter")...)
+		if err != nil {
+			return err
+		}
+		if clicontext.Bool("quiet") {
+			for _, info := range infos {
+				fmt.Println(info.Digest)
+			}
+			listing.PrintNext(os.Stderr, next)
+			return nil
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 8, 3, 1, ' ', 0)
+		fmt.Fprintf(tw, "Digest\tSize\tCreated\tLabels\n")
+		fmt.Fprintf(tw, "------\t----\t-------\t------\n")
+
+		for _, info := range infos {
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", info.Digest, info.Size, info.CreatedAt.Format(time.RFC3339), formatLabels(info.Labels))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+
+		listing.PrintNext(os.Stderr, next)
+		return nil
+	},
+}
+
+// formatLabels returns the labels sorted by key as comma separated key=value
+// pairs, or "-" when there are no labels
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return "-"
+	}
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,62 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package content
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/containerd/lcontainerd/cmd/lctr/app/datadir"
+	"github.com/containerd/lcontainerd/pkg/db"
+	"github.com/urfave/cli"
+)
+
+var orphansCommand = cli.Command{
+	Name:      "orphans",
+	Usage:     "list content not referenced by any image, lease, or root",
+	ArgsUsage: "[flags]",
+	Description: `Lists content which is not referenced by any image, lease, or garbage
+collection root, either directly or through another piece of content. This
+content is removed by the next garbage collection.
+`,
+	Action: func(clicontext *cli.Context) error {
+		var (
+			ctx = context.Background()
+		)
+		mdb, err := datadir.OpenDB(clicontext, db.WithReadOnly)
+		if err != nil {
+			return err
+		}
+		defer mdb.Close(ctx)
+
+		orphans, err := mdb.Orphans(ctx)
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 8, 3, 1, ' ', 0)
+		fmt.Fprintf(tw, "Digest\tSize\tCreated\n")
+		fmt.Fprintf(tw, "------\t----\t-------\n")
+		for _, info := range orphans {
+			fmt.Fprintf(tw, "%s\t%d\t%s\n", info.Digest, info.Size, info.CreatedAt.Format(time.RFC3339))
+		}
+		return tw.Flush()
+	},
+}
@@ -22,8 +22,9 @@ import (
 	"io"
 	"os"
 
-	"github.com/containerd/containerd/content"
+	"github.com/containerd/lcontainerd/cmd/lctr/app/datadir"
 	"github.com/containerd/lcontainerd/pkg/db"
+	"github.com/containerd/lcontainerd/pkg/iobuf"
 	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/urfave/cli"
@@ -34,7 +35,16 @@ var readCommand = cli.Command{
 	Usage:       "get content",
 	ArgsUsage:   "<digest> [<file>]",
 	Description: `Gets content from the local content store`,
-	Flags:       []cli.Flag{},
+	Flags: []cli.Flag{
+		cli.Int64Flag{
+			Name:  "offset",
+			Usage: "byte offset to start reading from",
+		},
+		cli.Int64Flag{
+			Name:  "length",
+			Usage: "number of bytes to read, defaults to the remainder of the blob",
+		},
+	},
 	Action: func(clicontext *cli.Context) error {
 		var (
 			ctx = context.Background()
@@ -45,6 +55,11 @@ var readCommand = cli.Command{
 			return fmt.Errorf("invalid digest: %w", err)
 		}
 
+		offset, length := clicontext.Int64("offset"), clicontext.Int64("length")
+		if offset < 0 || length < 0 {
+			return fmt.Errorf("offset and length must not be negative")
+		}
+
 		var f io.Writer
 		if path := clicontext.Args().Get(1); path != "" {
 			fp, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0600)
@@ -57,18 +72,33 @@ var readCommand = cli.Command{
 			f = os.Stdout
 		}
 
-		mdb, err := db.NewDB(clicontext.GlobalString("data-dir"), db.WithReadOnly)
+		mdb, err := datadir.OpenDB(clicontext, db.WithReadOnly)
 		if err != nil {
 			return err
 		}
 		defer mdb.Close(ctx)
 
-		ra, err := mdb.ContentStore().ReaderAt(ctx, ocispec.Descriptor{Digest: dgst})
+		cs := mdb.ContentStore()
+		info, err := cs.Info(ctx, dgst)
+		if err != nil {
+			return err
+		}
+		if offset > info.Size {
+			return fmt.Errorf("offset %d exceeds blob size %d", offset, info.Size)
+		}
+		if !clicontext.IsSet("length") {
+			length = info.Size - offset
+		} else if length > info.Size-offset {
+			return fmt.Errorf("range %d-%d exceeds blob size %d", offset, offset+length, info.Size)
+		}
+
+		ra, err := cs.ReaderAt(ctx, ocispec.Descriptor{Digest: dgst, Size: info.Size})
 		if err != nil {
 			return err
 		}
+		defer ra.Close()
 
-		_, err = io.Copy(f, content.NewReader(ra))
+		_, err = iobuf.Copy(f, io.NewSectionReader(ra, offset, length))
 
 		return err
 	},
@@ -0,0 +1,107 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package content
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/lcontainerd/cmd/lctr/app/datadir"
+	"github.com/containerd/lcontainerd/pkg/db"
+	"github.com/opencontainers/go-digest"
+	"github.com/urfave/cli"
+)
+
+var removeCommand = cli.Command{
+	Name:      "remove",
+	Aliases:   []string{"rm", "delete"},
+	Usage:     "remove one or more blobs",
+	ArgsUsage: "[flags] <digest> [<digest>, ...]",
+	Description: `Removes blobs from the local content store.
+
+Each blob is removed and its result reported, a blob which cannot be removed
+does not stop the removal of the others. The removed blobs are deleted from
+disk by the garbage collection run once all are removed.
+
+Blobs are removed even when referenced by an image, leaving the image
+incomplete. Use "content holders" to check what references a blob first.
+
+With --dry-run, nothing is removed and the blobs which would be removed are
+listed.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "show the blobs which would be removed without removing",
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		var (
+			ctx    = context.Background()
+			args   = clicontext.Args()
+			dryRun = clicontext.Bool("dry-run")
+		)
+		if len(args) == 0 {
+			return fmt.Errorf("no digest given")
+		}
+
+		var opts []db.DBOpt
+		if dryRun {
+			opts = append(opts, db.WithReadOnly)
+		}
+		mdb, err := datadir.OpenDB(clicontext, opts...)
+		if err != nil {
+			return err
+		}
+
+		var failed int
+		for _, arg := range args {
+			dgst, err := digest.Parse(arg)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: invalid digest: %v\n", arg, err)
+				failed++
+				continue
+			}
+			if dryRun {
+				info, ierr := mdb.ContentStore().Info(ctx, dgst)
+				if ierr == nil {
+					fmt.Printf("%s would be removed (%d bytes)\n", dgst, info.Size)
+					continue
+				}
+				err = ierr
+			} else if err = mdb.ContentStore().Delete(ctx, dgst); err == nil {
+				fmt.Printf("%s successfully removed\n", dgst)
+				continue
+			}
+			if errdefs.IsNotFound(err) {
+				fmt.Fprintf(os.Stderr, "%s: no such blob\n", dgst)
+			} else {
+				fmt.Fprintf(os.Stderr, "%s failed to remove: %v\n", dgst, err)
+			}
+			failed++
+		}
+		// Closing garbage collects the removed blobs
+		if err := mdb.Close(ctx); err != nil {
+			return err
+		}
+		if failed > 0 {
+			return cli.NewExitError(fmt.Sprintf("failed to remove %d blobs", failed), 1)
+		}
+		return nil
+	},
+}
@@ -0,0 +1,171 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package content
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/lcontainerd/cmd/lctr/app/datadir"
+	"github.com/containerd/lcontainerd/pkg/db"
+	"github.com/opencontainers/go-digest"
+	"github.com/urfave/cli"
+)
+
+var rootCommand = cli.Command{
+	Name:  "root",
+	Usage: "manage garbage collection roots",
+	Subcommands: cli.Commands{
+		addRootCommand,
+		removeRootCommand,
+	},
+}
+
+var addRootCommand = cli.Command{
+	Name:        "add",
+	Usage:       "mark content as a garbage collection root",
+	ArgsUsage:   "<digest> [<digest>, ...]",
+	Description: `Marks content as a root, preventing the content and anything it references from being garbage collected`,
+	Action: func(clicontext *cli.Context) error {
+		var (
+			ctx = context.Background()
+		)
+		dgsts, err := parseDigests(clicontext.Args())
+		if err != nil {
+			return err
+		}
+
+		mdb, err := datadir.OpenDB(clicontext)
+		if err != nil {
+			return err
+		}
+		defer mdb.Close(ctx)
+
+		for _, dgst := range dgsts {
+			if err := mdb.AddContentRoot(ctx, dgst); err != nil {
+				return fmt.Errorf("failed to add root %s: %w", dgst, err)
+			}
+		}
+		return nil
+	},
+}
+
+var removeRootCommand = cli.Command{
+	Name:      "remove",
+	Aliases:   []string{"rm"},
+	Usage:     "remove garbage collection root from content",
+	ArgsUsage: "<digest> [<digest>, ...]",
+	Description: `Removes the root mark from content.
+
+Content which is no longer referenced by another root, an image, or a
+lease is garbage collected before the command exits, the removed content
+is reported. Recently written content is kept until the next collection.
+`,
+	Action: func(clicontext *cli.Context) error {
+		var (
+			ctx = context.Background()
+		)
+		dgsts, err := parseDigests(clicontext.Args())
+		if err != nil {
+			return err
+		}
+
+		mdb, err := datadir.OpenDB(clicontext)
+		if err != nil {
+			return err
+		}
+		defer mdb.Close(ctx)
+
+		var collectible []digest.Digest
+		for _, dgst := range dgsts {
+			unreferenced, err := mdb.RemoveContentRoot(ctx, dgst)
+			if err != nil {
+				return fmt.Errorf("failed to remove root %s: %w", dgst, err)
+			}
+			if unreferenced {
+				collectible = append(collectible, dgst)
+			}
+		}
+		if len(collectible) == 0 {
+			return nil
+		}
+
+		// Collect now rather than on close so the removal can be reported
+		if _, err := mdb.GarbageCollect(ctx); err != nil {
+			return fmt.Errorf("failed to garbage collect: %w", err)
+		}
+		cs := mdb.ContentStore()
+		for _, dgst := range collectible {
+			if _, err := cs.Info(ctx, dgst); err == nil {
+				fmt.Fprintf(os.Stderr, "WARNING: %s is no longer referenced and will be garbage collected\n", dgst)
+			} else if errdefs.IsNotFound(err) {
+				fmt.Fprintf(os.Stderr, "%s is no longer referenced and was garbage collected\n", dgst)
+			} else {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+var rootsCommand = cli.Command{
+	Name:        "roots",
+	Usage:       "list garbage collection roots",
+	ArgsUsage:   "[flags]",
+	Description: `Lists all content marked as a garbage collection root`,
+	Action: func(clicontext *cli.Context) error {
+		var (
+			ctx = context.Background()
+		)
+		mdb, err := datadir.OpenDB(clicontext, db.WithReadOnly)
+		if err != nil {
+			return err
+		}
+		defer mdb.Close(ctx)
+
+		roots, err := mdb.ContentRoots(ctx)
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 8, 3, 1, ' ', 0)
+		fmt.Fprintf(tw, "Digest\tSize\tRoot\n")
+		fmt.Fprintf(tw, "------\t----\t----\n")
+		for _, info := range roots {
+			fmt.Fprintf(tw, "%s\t%d\t%s\n", info.Digest, info.Size, info.Labels[db.LabelGCRoot])
+		}
+		return tw.Flush()
+	},
+}
+
+func parseDigests(args cli.Args) ([]digest.Digest, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("please provide a digest")
+	}
+	dgsts := make([]digest.Digest, 0, len(args))
+	for _, arg := range args {
+		dgst, err := digest.Parse(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid digest %q: %w", arg, err)
+		}
+		dgsts = append(dgsts, dgst)
+	}
+	return dgsts, nil
+}
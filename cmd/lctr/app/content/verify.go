@@ -0,0 +1,142 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package content
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/containerd/lcontainerd/cmd/lctr/app/datadir"
+	"github.com/containerd/lcontainerd/pkg/db"
+	"github.com/opencontainers/go-digest"
+	"github.com/urfave/cli"
+)
+
+var verifyCommand = cli.Command{
+	Name:      "verify",
+	Aliases:   []string{"fsck"},
+	Usage:     "check blobs for corrupt data",
+	ArgsUsage: "[flags] [<digest>, ...]",
+	Description: `Reads back the data of the given blobs, or every blob when none are given,
+and checks it matches the blob's digest and size. Blobs with mismatched,
+truncated, or missing data are reported along with ingests whose data is
+missing.
+
+With --fix, corrupt blobs are removed so they are fetched again by the next
+pull, and dangling ingests are aborted. Images referencing a removed blob are
+left incomplete until it is fetched again.
+`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "fix",
+			Usage: "remove corrupt blobs and abort dangling ingests",
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		var (
+			ctx   = context.Background()
+			fix   = clicontext.Bool("fix")
+			dgsts []digest.Digest
+			opts  []db.DBOpt
+		)
+		for _, arg := range clicontext.Args() {
+			dgst, err := digest.Parse(arg)
+			if err != nil {
+				return fmt.Errorf("invalid digest %q: %w", arg, err)
+			}
+			dgsts = append(dgsts, dgst)
+		}
+		if !fix {
+			opts = append(opts, db.WithReadOnly)
+		}
+		mdb, err := datadir.OpenDB(clicontext, opts...)
+		if err != nil {
+			return err
+		}
+		defer mdb.Close(ctx)
+
+		corrupt, err := mdb.VerifyContent(ctx, dgsts...)
+		if err != nil {
+			return err
+		}
+		// Ingests are only checked when verifying every blob
+		var dangling []string
+		if len(dgsts) == 0 {
+			if dangling, err = mdb.DanglingIngests(ctx); err != nil {
+				return err
+			}
+		}
+		if len(corrupt) == 0 && len(dangling) == 0 {
+			fmt.Println("no corrupt content found")
+			return nil
+		}
+
+		var (
+			cs        = mdb.ContentStore()
+			remaining int
+			tw        = tabwriter.NewWriter(os.Stdout, 8, 3, 1, ' ', 0)
+		)
+		fmt.Fprintf(tw, "Resource\tProblem\tStatus\n")
+		fmt.Fprintf(tw, "--------\t-------\t------\n")
+		for _, b := range corrupt {
+			status := "corrupt"
+			if fix {
+				if err := cs.Delete(ctx, b.Digest); err != nil {
+					return fmt.Errorf("failed to remove %s: %w", b.Digest, err)
+				}
+				status = "removed"
+			} else {
+				remaining++
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", b.Digest, blobProblem(b), status)
+		}
+		for _, ref := range dangling {
+			status := "dangling"
+			if fix {
+				if err := cs.Abort(ctx, ref); err != nil {
+					return fmt.Errorf("failed to abort ingest %s: %w", ref, err)
+				}
+				status = "aborted"
+			} else {
+				remaining++
+			}
+			fmt.Fprintf(tw, "ingest %s\tingest data missing\t%s\n", ref, status)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+
+		if remaining > 0 {
+			return cli.NewExitError(fmt.Sprintf("found %d corrupt blobs or dangling ingests", remaining), 1)
+		}
+		return nil
+	},
+}
+
+func blobProblem(b db.CorruptBlob) string {
+	switch {
+	case b.Missing:
+		return "data missing"
+	case b.Truncated():
+		return fmt.Sprintf("truncated, %d of %d bytes", b.ActualSize, b.Size)
+	case b.ActualSize != b.Size:
+		return fmt.Sprintf("size mismatch, %d bytes expected %d", b.ActualSize, b.Size)
+	}
+	return fmt.Sprintf("digest mismatch, data has digest %s", b.Actual)
+}
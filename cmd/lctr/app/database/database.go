@@ -0,0 +1,319 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package database provides the cli commands for managing the data
+// directory as a whole.
+package database
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/lcontainerd/cmd/lctr/app/datadir"
+	"github.com/containerd/lcontainerd/pkg/cli/display"
+	"github.com/containerd/lcontainerd/pkg/db"
+	"github.com/urfave/cli"
+)
+
+// Command is the cli command for managing the database
+var Command = cli.Command{
+	Name:  "db",
+	Usage: "manage the metadata database and content",
+	Subcommands: cli.Commands{
+		infoCommand,
+		backupCommand,
+		restoreCommand,
+		syncCommand,
+		recoverCommand,
+		usageCommand,
+	},
+}
+
+var infoCommand = cli.Command{
+	Name:      "info",
+	Usage:     "show information about the metadata database and content",
+	ArgsUsage: "[flags]",
+	Description: `Shows information about the data directory.
+
+With --integrity, a summary of the images and content is computed from the
+metadata along with checksums over the image names and targets and over the
+content digests and sizes. Blob data is not read. The summary may be compared
+between stores or, with --record, stored as the known state of this store.
+When a summary has been recorded, any change from it is reported and the
+command exits with a non-zero status.
+`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "integrity",
+			Usage: "show the integrity summary and compare against the recorded summary",
+		},
+		cli.BoolFlag{
+			Name:  "record",
+			Usage: "record the integrity summary as the known state",
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		var (
+			ctx     = context.Background()
+			dataDir = clicontext.GlobalString("data-dir")
+			record  = clicontext.Bool("record")
+			opts    []db.DBOpt
+		)
+		if !record {
+			opts = append(opts, db.WithReadOnly)
+		}
+		mdb, err := datadir.OpenDB(clicontext, opts...)
+		if err != nil {
+			return err
+		}
+		defer mdb.Close(ctx)
+
+		tw := tabwriter.NewWriter(os.Stdout, 8, 3, 1, ' ', 0)
+		fmt.Fprintf(tw, "Data Directory:\t%s\n", dataDir)
+		if fi, err := os.Stat(filepath.Join(dataDir, "meta.db")); err == nil {
+			fmt.Fprintf(tw, "Metadata Size:\t%d\n", fi.Size())
+		}
+		if !clicontext.Bool("integrity") && !record {
+			return tw.Flush()
+		}
+
+		summary, err := mdb.Summary(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(tw, "Images:\t%d\n", summary.Images)
+		fmt.Fprintf(tw, "Image Checksum:\t%s\n", summary.ImageChecksum)
+		fmt.Fprintf(tw, "Content:\t%d\n", summary.Content)
+		fmt.Fprintf(tw, "Content Size:\t%d\n", summary.ContentSize)
+		fmt.Fprintf(tw, "Content Checksum:\t%s\n", summary.ContentChecksum)
+
+		var changed bool
+		recorded, err := mdb.RecordedSummary(ctx)
+		if err == nil {
+			changed = !summary.Equal(recorded)
+			status := "unchanged"
+			if changed {
+				status = "changed"
+			}
+			fmt.Fprintf(tw, "Recorded:\t%s (%s)\n", recorded.CreatedAt.Format(time.RFC3339), status)
+		} else if !errdefs.IsNotFound(err) {
+			return err
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+
+		if record {
+			return mdb.RecordSummary(ctx, summary)
+		}
+		if changed {
+			return cli.NewExitError(fmt.Sprintf("store has changed since the summary recorded at %s", recorded.CreatedAt.Format(time.RFC3339)), 1)
+		}
+		return nil
+	},
+}
+
+var backupCommand = cli.Command{
+	Name:      "backup",
+	Usage:     "write a backup of the metadata and content",
+	ArgsUsage: "<archive>",
+	Description: `Writes a tar archive holding a snapshot of the metadata database and all
+committed content. Use - to write the archive to stdout.
+`,
+	Action: func(clicontext *cli.Context) error {
+		var (
+			ctx     = context.Background()
+			archive = clicontext.Args().First()
+		)
+		if archive == "" {
+			return fmt.Errorf("please provide an archive to write")
+		}
+
+		mdb, err := datadir.OpenDB(clicontext, db.WithReadOnly)
+		if err != nil {
+			return err
+		}
+		defer mdb.Close(ctx)
+
+		var w io.Writer = os.Stdout
+		if archive != "-" {
+			f, err := os.OpenFile(archive, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+
+		if err := mdb.Backup(ctx, w); err != nil {
+			if archive != "-" {
+				os.Remove(archive)
+			}
+			return err
+		}
+		return nil
+	},
+}
+
+var restoreCommand = cli.Command{
+	Name:      "restore",
+	Usage:     "restore a backup of the metadata and content",
+	ArgsUsage: "[flags] <archive>",
+	Description: `Restores a backup written by backup, use - to read the archive from stdin.
+
+By default only the metadata database is restored and the existing content
+is kept. With --with-content, the metadata database and content are both
+restored, replacing the content directory. Other files in the data directory,
+such as saved credentials and snapshots, are kept.
+
+The restore fails when another lctr command has the database open, waiting
+up to --db-timeout for it to finish.
+
+The backup is extracted to a temporary directory and every image is checked
+for missing content before any existing files are replaced. When content is
+missing, the restore is refused and the data directory is left unchanged.
+`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "with-content",
+			Usage: "restore the content along with the metadata",
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		var (
+			ctx     = context.Background()
+			archive = clicontext.Args().First()
+		)
+		if archive == "" {
+			return fmt.Errorf("please provide an archive to restore")
+		}
+
+		var r io.Reader = os.Stdin
+		if archive != "-" {
+			f, err := os.Open(archive)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+
+		mode, err := datadir.Mode(clicontext)
+		if err != nil {
+			return err
+		}
+		opts := []db.DBOpt{db.WithDirMode(mode)}
+		if d := clicontext.GlobalDuration("db-timeout"); d > 0 {
+			opts = append(opts, db.WithTimeout(d))
+		}
+		return db.Restore(ctx, clicontext.GlobalString("data-dir"), r, clicontext.Bool("with-content"), opts...)
+	},
+}
+
+var syncCommand = cli.Command{
+	Name:  "sync",
+	Usage: "flush the metadata database and content to disk",
+	Description: `Flushes the metadata database and content store to disk.
+
+Commands run with the global --no-sync flag do not wait for their writes to
+reach the disk. Run sync after a batch of such commands, such as a bulk
+import, to make all of their writes durable at once.
+`,
+	Action: func(clicontext *cli.Context) error {
+		ctx := context.Background()
+
+		mdb, err := datadir.OpenDB(clicontext)
+		if err != nil {
+			return err
+		}
+		defer mdb.Close(ctx)
+
+		return mdb.Sync(ctx)
+	},
+}
+
+var recoverCommand = cli.Command{
+	Name:  "recover",
+	Usage: "list manifests left unreferenced by an interrupted image change",
+	Description: `Lists the manifests and indexes which are not referenced by any image, lease,
+garbage collection root, or other content.
+
+An image create, append, or edit which is interrupted after writing the new
+manifest but before updating the image leaves the manifest unreferenced. The
+manifest is reused when the same change is run again. Otherwise it is removed
+by the next garbage collection unless kept with "content root add".
+`,
+	Action: func(clicontext *cli.Context) error {
+		ctx := context.Background()
+
+		mdb, err := datadir.OpenDB(clicontext, db.WithReadOnly)
+		if err != nil {
+			return err
+		}
+		defer mdb.Close(ctx)
+
+		manifests, err := mdb.OrphanedManifests(ctx)
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 8, 3, 1, ' ', 0)
+		fmt.Fprintf(tw, "Digest\tMedia Type\tSize\n")
+		fmt.Fprintf(tw, "------\t----------\t----\n")
+		for _, desc := range manifests {
+			fmt.Fprintf(tw, "%s\t%s\t%d\n", desc.Digest, desc.MediaType, desc.Size)
+		}
+		return tw.Flush()
+	},
+}
+
+var usageCommand = cli.Command{
+	Name:    "du",
+	Aliases: []string{"disk-usage"},
+	Usage:   "show the content size used by each image",
+	Description: `Shows the size of the content used by each image.
+
+Content used by only one image is counted as unique to that image. Content
+used by more than one image is counted as shared by each of them, so shared
+sizes should not be added together. The total is the size of all content in
+the store, including content not used by any image.
+`,
+	Action: func(clicontext *cli.Context) error {
+		ctx := context.Background()
+
+		mdb, err := datadir.OpenDB(clicontext, db.WithReadOnly)
+		if err != nil {
+			return err
+		}
+		defer mdb.Close(ctx)
+
+		imgs, err := db.NewImageStore(mdb).List(ctx)
+		if err != nil {
+			return err
+		}
+		du, err := display.GetDiskUsage(ctx, mdb.ContentStore(), imgs)
+		if err != nil {
+			return err
+		}
+		return display.NewPrinter().PrintDiskUsage(du)
+	},
+}
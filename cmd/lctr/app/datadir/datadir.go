@@ -0,0 +1,142 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package datadir opens the local data directory configured by the
+// global cli flags.
+package datadir
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/containerd/snapshots/native"
+	"github.com/containerd/lcontainerd/pkg/db"
+	"github.com/containerd/lcontainerd/pkg/remote"
+	"github.com/urfave/cli"
+)
+
+// DefaultMode is the mode used to create the data directory when
+// no mode is configured
+const DefaultMode os.FileMode = 0700
+
+// Mode returns the mode used for creating the data directory
+func Mode(clicontext *cli.Context) (os.FileMode, error) {
+	s := clicontext.GlobalString("data-dir-mode")
+	if s == "" {
+		return DefaultMode, nil
+	}
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || os.FileMode(m)&^os.ModePerm != 0 {
+		return 0, fmt.Errorf("invalid data directory mode %q, must be octal permissions such as 0750", s)
+	}
+	return os.FileMode(m), nil
+}
+
+// OpenDB opens the metadata database in the configured data directory
+func OpenDB(clicontext *cli.Context, opts ...db.DBOpt) (*db.DB, error) {
+	mode, err := Mode(clicontext)
+	if err != nil {
+		return nil, err
+	}
+	dbopts := []db.DBOpt{db.WithDirMode(mode)}
+	if age := clicontext.GlobalDuration("gc-min-age"); age > 0 {
+		dbopts = append(dbopts, db.WithMinContentAge(age))
+	}
+	if n := clicontext.GlobalInt("gc-concurrency"); n > 1 {
+		dbopts = append(dbopts, db.WithGCConcurrency(n))
+	}
+	if clicontext.GlobalBool("check-consistency") {
+		dbopts = append(dbopts, db.WithConsistencyCheck(true))
+	}
+	if clicontext.GlobalBool("no-sync") {
+		dbopts = append(dbopts, db.WithNoSync)
+	}
+	if d := clicontext.GlobalDuration("db-timeout"); d > 0 {
+		dbopts = append(dbopts, db.WithTimeout(d))
+	}
+	snopts, err := snapshotterOpts(clicontext)
+	if err != nil {
+		return nil, err
+	}
+	dbopts = append(dbopts, snopts...)
+	mdb, err := db.NewDB(clicontext.GlobalString("data-dir"), append(dbopts, opts...)...)
+	if errdefs.IsUnavailable(err) {
+		// Only one writer may open the database at a time, readers wait for
+		// any open writer
+		return nil, fmt.Errorf("%w: wait for other lctr commands writing to the data directory to finish or increase --db-timeout", err)
+	}
+	return mdb, err
+}
+
+// BlobCache returns the cache in the configured data directory for blobs
+// fetched from remote registries
+func BlobCache(clicontext *cli.Context) (*remote.BlobCache, error) {
+	mode, err := Mode(clicontext)
+	if err != nil {
+		return nil, err
+	}
+	return remote.NewBlobCache(filepath.Join(clicontext.GlobalString("data-dir"), "cache", "blobs"), mode), nil
+}
+
+// snapshotters are the snapshotters which may be stored in the data
+// directory, created from the directory holding their snapshots
+var snapshotters = map[string]func(root string) (snapshots.Snapshotter, error){
+	"native": native.NewSnapshotter,
+}
+
+// PrepareSnapshotter creates the directory of the snapshotter with the given
+// name. Snapshotters with a directory are registered by OpenDB so their
+// snapshots are garbage collected by any command writing to the database.
+func PrepareSnapshotter(clicontext *cli.Context, name string) error {
+	if _, ok := snapshotters[name]; !ok {
+		return fmt.Errorf("unsupported snapshotter %q: %w", name, errdefs.ErrNotImplemented)
+	}
+	mode, err := Mode(clicontext)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(filepath.Join(clicontext.GlobalString("data-dir"), "snapshots", name), mode)
+}
+
+// snapshotterOpts returns the options registering each snapshotter with a
+// directory in the data directory
+func snapshotterOpts(clicontext *cli.Context) ([]db.DBOpt, error) {
+	root := filepath.Join(clicontext.GlobalString("data-dir"), "snapshots")
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var opts []db.DBOpt
+	for _, entry := range entries {
+		newSnapshotter, ok := snapshotters[entry.Name()]
+		if !ok || !entry.IsDir() {
+			continue
+		}
+		sn, err := newSnapshotter(filepath.Join(root, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open snapshotter %s: %w", entry.Name(), err)
+		}
+		opts = append(opts, db.WithSnapshotter(entry.Name(), sn))
+	}
+	return opts, nil
+}
@@ -0,0 +1,152 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package gc provides the cli command for running garbage collection.
+package gc
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/containerd/containerd/gc"
+	"github.com/containerd/lcontainerd/cmd/lctr/app/datadir"
+	"github.com/containerd/lcontainerd/pkg/db"
+	"github.com/urfave/cli"
+)
+
+// Command is the cli command for running garbage collection
+var Command = cli.Command{
+	Name:  "gc",
+	Usage: "run garbage collection",
+	Description: `Removes the content which is not referenced by any image, lease, or garbage
+collection root and shows the time taken by each phase of the collection.
+
+Garbage collection also runs after every command which changes the data
+directory. Running it directly shows whether removing content from disk
+failed, which otherwise is only logged. The command exits with a non-zero
+status when any phase of the collection fails.
+
+Use --dry-run to list the resources which would be removed, grouped by type,
+without removing anything.
+`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "quiet, q",
+			Usage: "do not show the collection times",
+		},
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "list the resources which would be removed without removing them",
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		ctx := context.Background()
+
+		if clicontext.Bool("dry-run") {
+			return dryRun(ctx, clicontext)
+		}
+
+		// The collection is run and reported here rather than on close
+		mdb, err := datadir.OpenDB(clicontext, db.WithNoCollectOnClose)
+		if err != nil {
+			return err
+		}
+		defer mdb.Close(ctx)
+
+		s, err := mdb.GarbageCollect(ctx)
+		if err != nil {
+			return fmt.Errorf("garbage collection failed: %w", err)
+		}
+		stats := s.(db.GCStats)
+
+		if !clicontext.Bool("quiet") {
+			tw := tabwriter.NewWriter(os.Stdout, 8, 3, 1, ' ', 0)
+			fmt.Fprintf(tw, "Phase\tDuration\n")
+			fmt.Fprintf(tw, "-----\t--------\n")
+			fmt.Fprintf(tw, "metadata\t%s\n", stats.MetaD)
+			fmt.Fprintf(tw, "content\t%s\n", stats.ContentD)
+			var snapshotters []string
+			for name := range stats.SnapshotD {
+				snapshotters = append(snapshotters, name)
+			}
+			sort.Strings(snapshotters)
+			for _, name := range snapshotters {
+				fmt.Fprintf(tw, "snapshotter %s\t%s\n", name, stats.SnapshotD[name])
+			}
+			if err := tw.Flush(); err != nil {
+				return err
+			}
+		}
+		if stats.ContentErr != nil {
+			return fmt.Errorf("failed to remove collected content: %w", stats.ContentErr)
+		}
+		return nil
+	},
+}
+
+// dryRun prints the resources which would be collected grouped by type
+func dryRun(ctx context.Context, clicontext *cli.Context) error {
+	// Closing a writable database runs a collection
+	mdb, err := datadir.OpenDB(clicontext, db.WithReadOnly)
+	if err != nil {
+		return err
+	}
+	defer mdb.Close(ctx)
+
+	nodes, err := mdb.GarbageCollectDryRun(ctx)
+	if err != nil {
+		return fmt.Errorf("garbage collection dry run failed: %w", err)
+	}
+	if len(nodes) == 0 {
+		fmt.Fprintln(os.Stdout, "Nothing to collect")
+		return nil
+	}
+
+	// Nodes are sorted by type
+	for i, n := range nodes {
+		if i == 0 || nodes[i-1].Type != n.Type {
+			if i > 0 {
+				fmt.Fprintln(os.Stdout)
+			}
+			fmt.Fprintf(os.Stdout, "%s:\n", resourceName(n.Type))
+		}
+		fmt.Fprintf(os.Stdout, "  %s\n", n.Key)
+	}
+	return nil
+}
+
+func resourceName(t gc.ResourceType) string {
+	switch t {
+	case db.ResourceContent:
+		return "content"
+	case db.ResourceSnapshot:
+		return "snapshot"
+	case db.ResourceContainer:
+		return "container"
+	case db.ResourceTask:
+		return "task"
+	case db.ResourceLease:
+		return "lease"
+	case db.ResourceIngest:
+		return "ingest"
+	case db.ResourceStream:
+		return "stream"
+	}
+	return fmt.Sprintf("resource type %d", t)
+}
@@ -0,0 +1,219 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/lcontainerd/cmd/lctr/app/datadir"
+	"github.com/containerd/lcontainerd/pkg/db"
+	"github.com/containerd/lcontainerd/pkg/iobuf"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/urfave/cli"
+)
+
+// dockerMediaTypes maps each OCI media type to its Docker schema2 equivalent
+var dockerMediaTypes = map[string]string{
+	ocispec.MediaTypeImageIndex:                     images.MediaTypeDockerSchema2ManifestList,
+	ocispec.MediaTypeImageManifest:                  images.MediaTypeDockerSchema2Manifest,
+	ocispec.MediaTypeImageConfig:                    images.MediaTypeDockerSchema2Config,
+	ocispec.MediaTypeImageLayer:                     images.MediaTypeDockerSchema2Layer,
+	ocispec.MediaTypeImageLayerGzip:                 images.MediaTypeDockerSchema2LayerGzip,
+	ocispec.MediaTypeImageLayerNonDistributable:     images.MediaTypeDockerSchema2LayerForeign,
+	ocispec.MediaTypeImageLayerNonDistributableGzip: images.MediaTypeDockerSchema2LayerForeignGzip,
+}
+
+// ociMediaTypes maps each Docker schema2 media type to its OCI equivalent
+var ociMediaTypes = func() map[string]string {
+	m := make(map[string]string, len(dockerMediaTypes))
+	for oci, docker := range dockerMediaTypes {
+		m[docker] = oci
+	}
+	return m
+}()
+
+var convertCommand = cli.Command{
+	Name:      "convert",
+	Usage:     "convert the media types of an image between OCI and Docker",
+	ArgsUsage: "[flags] <image> <new-image>",
+	Description: `Converts a local image between OCI and Docker schema2 media types.
+
+The media types of the index, manifests, configs, and layers are rewritten to
+their equivalent in the given format and new manifests and indexes are
+written. Configs and layers are not changed, only the descriptors referencing
+them. The result is stored as a new image, the source image is left
+unchanged. Without --format, OCI images are converted to Docker and Docker
+images to OCI.
+
+Every manifest of an index must be available locally. Media types without an
+equivalent in the other format, such as zstd compressed layers or artifact
+configs, can not be converted.
+`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "format",
+			Usage: "Format to convert to, oci or docker",
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		var (
+			ctx = context.Background()
+			src = clicontext.Args().First()
+			dst = clicontext.Args().Get(1)
+		)
+		if src == "" || dst == "" {
+			return fmt.Errorf("please provide a source image and new image name")
+		}
+
+		mdb, err := datadir.OpenDB(clicontext)
+		if err != nil {
+			return err
+		}
+		defer mdb.Close(ctx)
+
+		imgdb := db.NewImageStore(mdb)
+		if _, err := imgdb.Get(ctx, dst); err == nil {
+			return fmt.Errorf("image %s already exists", dst)
+		}
+		img, err := imgdb.Get(ctx, src)
+		if err != nil {
+			return err
+		}
+
+		var mediaTypes map[string]string
+		switch format := clicontext.String("format"); format {
+		case "oci":
+			mediaTypes = ociMediaTypes
+		case "docker":
+			mediaTypes = dockerMediaTypes
+		case "":
+			if _, ok := dockerMediaTypes[img.Target.MediaType]; ok {
+				mediaTypes = dockerMediaTypes
+			} else {
+				mediaTypes = ociMediaTypes
+			}
+		default:
+			return fmt.Errorf("unsupported format %q, must be oci or docker", format)
+		}
+
+		target, err := convertTarget(ctx, mdb.ContentStore(), img.Target, mediaTypes)
+		if err != nil {
+			return fmt.Errorf("failed to convert %s: %w", src, err)
+		}
+
+		if _, err := imgdb.Create(ctx, images.Image{
+			Name:   dst,
+			Target: target,
+		}); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "%s %s\n", dst, target.Digest)
+		return nil
+	},
+}
+
+// convertMediaType returns the media type converted using the mapping, a
+// media type already in the converted format is returned unchanged
+func convertMediaType(mediaType string, mediaTypes map[string]string) (string, error) {
+	if converted, ok := mediaTypes[mediaType]; ok {
+		return converted, nil
+	}
+	for _, converted := range mediaTypes {
+		if converted == mediaType {
+			return mediaType, nil
+		}
+	}
+	return "", fmt.Errorf("no known mapping for media type %s", mediaType)
+}
+
+// convertTarget writes a new manifest or index with the media types of it and
+// its descriptors converted, returning the descriptor of the new blob. The
+// manifests of an index are converted first so the index references the new
+// manifests.
+func convertTarget(ctx context.Context, cs content.Store, target ocispec.Descriptor, mediaTypes map[string]string) (ocispec.Descriptor, error) {
+	mediaType, err := convertMediaType(target.MediaType, mediaTypes)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	b, err := iobuf.ReadBlob(ctx, cs, target)
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("failed to read %s: %w", target.Digest, err)
+	}
+
+	var (
+		manifest interface{}
+		labels   map[string]string
+	)
+	switch {
+	case images.IsIndexType(target.MediaType):
+		var idx ocispec.Index
+		if err := json.Unmarshal(b, &idx); err != nil {
+			return ocispec.Descriptor{}, err
+		}
+		for i, m := range idx.Manifests {
+			if idx.Manifests[i], err = convertTarget(ctx, cs, m, mediaTypes); err != nil {
+				return ocispec.Descriptor{}, err
+			}
+			if labels, err = contentGCLabels(ctx, cs, idx.Manifests[i], i, labels); err != nil {
+				return ocispec.Descriptor{}, err
+			}
+		}
+		idx.MediaType = mediaType
+		manifest = idx
+	case images.IsManifestType(target.MediaType):
+		var m ocispec.Manifest
+		if err := json.Unmarshal(b, &m); err != nil {
+			return ocispec.Descriptor{}, err
+		}
+		if m.Config.MediaType, err = convertMediaType(m.Config.MediaType, mediaTypes); err != nil {
+			return ocispec.Descriptor{}, err
+		}
+		if labels, err = contentGCLabels(ctx, cs, m.Config, 0, labels); err != nil {
+			return ocispec.Descriptor{}, err
+		}
+		for i := range m.Layers {
+			if m.Layers[i].MediaType, err = convertMediaType(m.Layers[i].MediaType, mediaTypes); err != nil {
+				return ocispec.Descriptor{}, err
+			}
+			// Add 1 to position to account for config as the first element for child labeling
+			if labels, err = contentGCLabels(ctx, cs, m.Layers[i], i+1, labels); err != nil {
+				return ocispec.Descriptor{}, err
+			}
+		}
+		m.MediaType = mediaType
+		manifest = m
+	default:
+		return ocispec.Descriptor{}, fmt.Errorf("media type not supported for conversion: %s", target.MediaType)
+	}
+	if b, err = json.Marshal(manifest); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	target.MediaType = mediaType
+	target.Size = int64(len(b))
+	target.Digest = target.Digest.Algorithm().FromBytes(b)
+
+	if err := db.WriteTarget(ctx, cs, b, target, labels); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	return target, nil
+}
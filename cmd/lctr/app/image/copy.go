@@ -0,0 +1,166 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package image
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/pkg/transfer/local"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/lcontainerd/cmd/lctr/app/datadir"
+	"github.com/containerd/lcontainerd/pkg/db"
+	"github.com/containerd/lcontainerd/pkg/remote"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/urfave/cli"
+)
+
+var copyCommand = cli.Command{
+	Name:      "copy",
+	Aliases:   []string{"cp"},
+	Usage:     "copy an image from one remote to another",
+	ArgsUsage: "[flags] <src-ref> <dst-ref>",
+	Description: `Copies an image between registries through the local content store.
+
+The image is fetched from the source registry into the local content store
+and then pushed to the destination registry. When --ephemeral is given, the
+fetched content is removed from the local store after the push completes.
+
+With --platform, the image is stored locally with only the manifests for the
+given platforms. The copy is refused when a local image of the source name
+already exists, use --ephemeral to copy without replacing it.
+
+When --skip-existing is given, the image is copied directly between the
+registries without using the local content store. Each blob is checked on the
+destination and only fetched from the source when missing, so copying an
+updated image to a mirror only transfers the changed blobs. Blobs are mounted
+from the source repository when both are on the same registry.
+`,
+	Before: applyConfig,
+	Flags: append(registryFlags,
+		cli.StringSliceFlag{
+			Name:  "platform",
+			Usage: "Copy content from a specific platform",
+			Value: &cli.StringSlice{},
+		},
+		cli.BoolFlag{
+			Name:  "ephemeral",
+			Usage: "remove the copied content from the local store after pushing",
+		},
+		cli.BoolFlag{
+			Name:  "skip-existing",
+			Usage: "copy directly between registries, only transferring blobs missing from the destination",
+		},
+		progressFlag,
+		protoOutFlag,
+		progressSocketFlag,
+		statusLineFlag,
+		progressIntervalFlag,
+	),
+	Action: func(clicontext *cli.Context) error {
+		var (
+			src = clicontext.Args().First()
+			dst = clicontext.Args().Get(1)
+			ctx = context.Background()
+			err error
+		)
+		if src == "" || dst == "" {
+			return fmt.Errorf("please provide a source and destination image reference")
+		}
+		if src, err = normalizeName(src); err != nil {
+			return err
+		}
+		if dst, err = normalizeName(dst); err != nil {
+			return err
+		}
+
+		skipExisting := clicontext.Bool("skip-existing")
+		if skipExisting && len(clicontext.StringSlice("platform")) > 0 {
+			return fmt.Errorf("--platform cannot be used with --skip-existing")
+		}
+
+		var p []ocispec.Platform
+		for _, s := range clicontext.StringSlice("platform") {
+			ps, err := platforms.Parse(s)
+			if err != nil {
+				return fmt.Errorf("unable to parse platform %s: %w", s, err)
+			}
+			p = append(p, ps)
+		}
+
+		srcCreds, err := getCredentialHelper(clicontext, src)
+		if err != nil {
+			return err
+		}
+		dstCreds, err := getCredentialHelper(clicontext, dst)
+		if err != nil {
+			return err
+		}
+
+		// Both registries share the client so a single trace is written
+		client, closeClient, err := getRegistryClient(clicontext, src, dst)
+		if err != nil {
+			return err
+		}
+		defer closeClient()
+
+		if skipExisting {
+			stats, err := remote.Copy(ctx,
+				remote.NewRegistry(src, registryOpts(clicontext, srcCreds, client)...),
+				remote.NewRegistry(dst, registryOpts(clicontext, dstCreds, client)...))
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%d blobs copied, %d already present\n", stats.Copied, stats.Existing)
+			return nil
+		}
+
+		mdb, err := datadir.OpenDB(clicontext)
+		if err != nil {
+			return err
+		}
+		defer mdb.Close(ctx)
+
+		// Store under a temporary name when ephemeral to avoid replacing
+		// an existing local image which is then removed
+		localName := src
+		ephemeral := clicontext.Bool("ephemeral")
+		if ephemeral {
+			localName = fmt.Sprintf("lctr-copy-%d", time.Now().UnixNano())
+		}
+
+		imgdb := db.NewImageStore(mdb)
+		ts := local.NewTransferService(db.NewLeaseManager(mdb), mdb.ContentStore(), imgdb, &local.TransferConfig{})
+		transfer := func(ctx context.Context, src, dst interface{}) error {
+			return runTransfer(ctx, clicontext, ts, src, dst)
+		}
+		if ephemeral {
+			defer imgdb.Delete(ctx, localName)
+		}
+
+		err = remote.CopyThrough(ctx, transfer, mdb.ContentStore(), imgdb,
+			remote.NewRegistry(src, registryOpts(clicontext, srcCreds, client)...),
+			remote.NewRegistry(dst, registryOpts(clicontext, dstCreds, client)...),
+			localName, p...)
+		if errdefs.IsAlreadyExists(err) {
+			return fmt.Errorf("%w, use --ephemeral to copy without storing the image locally", err)
+		}
+		return err
+	},
+}
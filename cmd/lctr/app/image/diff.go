@@ -0,0 +1,255 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/lcontainerd/cmd/lctr/app/datadir"
+	"github.com/containerd/lcontainerd/pkg/db"
+	"github.com/containerd/lcontainerd/pkg/index"
+	"github.com/containerd/lcontainerd/pkg/iobuf"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/urfave/cli"
+)
+
+var diffCommand = cli.Command{
+	Name:      "diff",
+	Usage:     "compare the layers and config of two images",
+	ArgsUsage: "[flags] <image-a> <image-b>",
+	Description: `Compares the manifests of two local images.
+
+The layers are listed in order as shared when in both images, removed when
+only in the first image, and added when only in the second image. The env,
+entrypoint, and cmd of the image configs are compared after the layers.
+
+Images with multiple platforms are compared using the manifest for a common
+platform. When one image is a single manifest, the platform of its config is
+used to select from the other. When both have multiple platforms, the current
+platform is preferred, otherwise the first platform found in both. Use
+--platform to select the platform to compare.
+`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "platform",
+			Usage: "Platform of the manifests to compare",
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		var (
+			ctx = context.Background()
+			a   = clicontext.Args().First()
+			b   = clicontext.Args().Get(1)
+		)
+		if a == "" || b == "" {
+			return fmt.Errorf("please provide two images to compare")
+		}
+
+		mdb, err := datadir.OpenDB(clicontext, db.WithReadOnly)
+		if err != nil {
+			return err
+		}
+		defer mdb.Close(ctx)
+
+		imgdb := db.NewImageStore(mdb)
+		imgA, err := imgdb.Get(ctx, a)
+		if err != nil {
+			return err
+		}
+		imgB, err := imgdb.Get(ctx, b)
+		if err != nil {
+			return err
+		}
+
+		cs := mdb.ContentStore()
+		platform := clicontext.String("platform")
+		if platform == "" {
+			if platform, err = commonPlatform(ctx, cs, imgA.Target, imgB.Target); err != nil {
+				return err
+			}
+		}
+		descA, err := platformManifest(ctx, cs, imgA.Target, platform)
+		if err != nil {
+			return fmt.Errorf("%s: %w", a, err)
+		}
+		descB, err := platformManifest(ctx, cs, imgB.Target, platform)
+		if err != nil {
+			return fmt.Errorf("%s: %w", b, err)
+		}
+
+		var manifestA, manifestB ocispec.Manifest
+		if err := readJSON(ctx, cs, descA, &manifestA); err != nil {
+			return err
+		}
+		if err := readJSON(ctx, cs, descB, &manifestB); err != nil {
+			return err
+		}
+		var configA, configB ocispec.Image
+		if err := readJSON(ctx, cs, manifestA.Config, &configA); err != nil {
+			return fmt.Errorf("failed to read config of %s: %w", a, err)
+		}
+		if err := readJSON(ctx, cs, manifestB.Config, &configB); err != nil {
+			return fmt.Errorf("failed to read config of %s: %w", b, err)
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 8, 3, 1, ' ', 0)
+		fmt.Fprintf(tw, "Image\tManifest\tPlatform\n")
+		fmt.Fprintf(tw, "-----\t--------\t--------\n")
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", a, descA.Digest, configPlatform(configA))
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", b, descB.Digest, configPlatform(configB))
+		fmt.Fprintln(tw)
+
+		inA := map[string]struct{}{}
+		for _, l := range manifestA.Layers {
+			inA[l.Digest.String()] = struct{}{}
+		}
+		inB := map[string]struct{}{}
+		for _, l := range manifestB.Layers {
+			inB[l.Digest.String()] = struct{}{}
+		}
+		fmt.Fprintf(tw, "Layer\tDigest\tSize\tStatus\n")
+		fmt.Fprintf(tw, "-----\t------\t----\t------\n")
+		for i, l := range manifestA.Layers {
+			status := "shared"
+			if _, ok := inB[l.Digest.String()]; !ok {
+				status = "removed"
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%d\t%s\n", i, l.Digest, l.Size, status)
+		}
+		for i, l := range manifestB.Layers {
+			if _, ok := inA[l.Digest.String()]; !ok {
+				fmt.Fprintf(tw, "%d\t%s\t%d\t%s\n", i, l.Digest, l.Size, "added")
+			}
+		}
+		fmt.Fprintln(tw)
+
+		fmt.Fprintf(tw, "Config\tValue\tStatus\n")
+		fmt.Fprintf(tw, "------\t-----\t------\n")
+		for _, v := range configA.Config.Env {
+			if !contains(configB.Config.Env, v) {
+				fmt.Fprintf(tw, "Env\t%s\tremoved\n", v)
+			}
+		}
+		for _, v := range configB.Config.Env {
+			if !contains(configA.Config.Env, v) {
+				fmt.Fprintf(tw, "Env\t%s\tadded\n", v)
+			}
+		}
+		for _, field := range []struct {
+			name string
+			a, b []string
+		}{
+			{"Entrypoint", configA.Config.Entrypoint, configB.Config.Entrypoint},
+			{"Cmd", configA.Config.Cmd, configB.Config.Cmd},
+		} {
+			va, _ := json.Marshal(field.a)
+			vb, _ := json.Marshal(field.b)
+			if string(va) == string(vb) {
+				continue
+			}
+			fmt.Fprintf(tw, "%s\t%s\tremoved\n", field.name, va)
+			fmt.Fprintf(tw, "%s\t%s\tadded\n", field.name, vb)
+		}
+
+		return tw.Flush()
+	},
+}
+
+// commonPlatform returns the platform to select the manifests to compare
+// from the targets. An empty platform is returned when neither target has
+// multiple platforms.
+func commonPlatform(ctx context.Context, provider content.Provider, a, b ocispec.Descriptor) (string, error) {
+	if images.IsManifestType(a.MediaType) && images.IsManifestType(b.MediaType) {
+		return "", nil
+	}
+	if images.IsManifestType(a.MediaType) || images.IsManifestType(b.MediaType) {
+		manifest := a
+		if images.IsManifestType(b.MediaType) {
+			manifest = b
+		}
+		p, err := index.ManifestPlatform(ctx, provider, manifest)
+		if err != nil {
+			return "", err
+		}
+		if p == nil {
+			return "", fmt.Errorf("unable to determine platform of manifest %s, please provide a platform", manifest.Digest)
+		}
+		return platforms.Format(*p), nil
+	}
+
+	var idxA, idxB ocispec.Index
+	if err := readJSON(ctx, provider, a, &idxA); err != nil {
+		return "", err
+	}
+	if err := readJSON(ctx, provider, b, &idxB); err != nil {
+		return "", err
+	}
+	var common []ocispec.Platform
+	for _, ma := range idxA.Manifests {
+		if ma.Platform == nil || !images.IsManifestType(ma.MediaType) {
+			continue
+		}
+		matcher := platforms.Only(*ma.Platform)
+		for _, mb := range idxB.Manifests {
+			if mb.Platform != nil && images.IsManifestType(mb.MediaType) && matcher.Match(*mb.Platform) {
+				common = append(common, *ma.Platform)
+				break
+			}
+		}
+	}
+	if len(common) == 0 {
+		return "", fmt.Errorf("images have no common platform, please provide a platform")
+	}
+	for _, p := range common {
+		if platforms.Default().Match(p) {
+			return platforms.Format(p), nil
+		}
+	}
+	return platforms.Format(common[0]), nil
+}
+
+// configPlatform returns the formatted platform of the image config
+func configPlatform(config ocispec.Image) string {
+	if config.OS == "" || config.Architecture == "" {
+		return "-"
+	}
+	return platforms.Format(platforms.Normalize(config.Platform))
+}
+
+func readJSON(ctx context.Context, provider content.Provider, desc ocispec.Descriptor, v interface{}) error {
+	b, err := iobuf.ReadBlob(ctx, provider, desc)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
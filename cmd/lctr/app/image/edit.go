@@ -22,13 +22,18 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/url"
 	"os"
 	"strings"
 
 	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/images"
 	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/lcontainerd/cmd/lctr/app/datadir"
 	"github.com/containerd/lcontainerd/pkg/db"
+	"github.com/containerd/lcontainerd/pkg/index"
+	"github.com/containerd/lcontainerd/pkg/iobuf"
 	"github.com/opencontainers/go-digest"
 	"github.com/opencontainers/image-spec/specs-go"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
@@ -56,6 +61,29 @@ var descriptorFlags = []cli.Flag{
 		Name:  "platform",
 		Usage: "Platform to apply to descriptor",
 	},
+	cli.StringFlag{
+		Name:  "digest-algorithm",
+		Usage: "Algorithm used to digest new content (sha256, sha512)",
+		Value: string(digest.SHA256),
+	},
+	cli.StringSliceFlag{
+		Name:  "url",
+		Usage: "URL the content may be fetched from, such as for a foreign layer",
+	},
+	cli.StringFlag{
+		Name:  "digest",
+		Usage: "Digest of content only referenced by --url",
+	},
+	cli.Int64Flag{
+		Name:  "size",
+		Usage: "Size of content only referenced by --url",
+	},
+}
+
+// gcRefFlag adds explicit references to content from the manifest
+var gcRefFlag = cli.StringSliceFlag{
+	Name:  "gc-ref",
+	Usage: "Digest of content to reference from the manifest, retaining it from garbage collection",
 }
 
 var createCommand = cli.Command{
@@ -72,6 +100,15 @@ var createCommand = cli.Command{
 			Name:  "label",
 			Usage: "Labels to add to the image",
 		},
+		cli.BoolFlag{
+			Name:  "empty-config",
+			Usage: "Use the empty descriptor as the manifest config, used for artifacts",
+		},
+		cli.StringFlag{
+			Name:  "artifact-type",
+			Usage: "Artifact type to set on the manifest",
+		},
+		gcRefFlag,
 	),
 	Action: func(clicontext *cli.Context) error {
 		var (
@@ -79,7 +116,7 @@ var createCommand = cli.Command{
 			ref = clicontext.Args().First()
 		)
 
-		mdb, err := db.NewDB(clicontext.GlobalString("data-dir"))
+		mdb, err := datadir.OpenDB(clicontext)
 		if err != nil {
 			return err
 		}
@@ -95,6 +132,15 @@ var createCommand = cli.Command{
 			return err
 		}
 
+		if clicontext.Bool("empty-config") {
+			if desc != nil {
+				return fmt.Errorf("empty config cannot be used along with a config file or image")
+			}
+			if desc, err = writeEmptyConfig(ctx, mdb.ContentStore()); err != nil {
+				return err
+			}
+		}
+
 		annotations, err := keyValueArgs(clicontext.StringSlice("manifest-annotation"), "")
 		if err != nil {
 			return err
@@ -105,7 +151,12 @@ var createCommand = cli.Command{
 			return err
 		}
 
-		var copts []content.Opt
+		refs, err := gcRefs(ctx, clicontext, mdb.ContentStore())
+		if err != nil {
+			return err
+		}
+
+		var mlabels map[string]string
 		var manifest interface{}
 		var target ocispec.Descriptor
 		if desc == nil {
@@ -123,23 +174,32 @@ var createCommand = cli.Command{
 				Versioned: specs.Versioned{
 					SchemaVersion: 2,
 				},
-				MediaType:   target.MediaType,
-				Config:      *desc,
-				Annotations: annotations,
+				MediaType:    target.MediaType,
+				ArtifactType: clicontext.String("artifact-type"),
+				Config:       *desc,
+				Layers:       []ocispec.Descriptor{},
+				Annotations:  annotations,
+			}
+			if mlabels, err = contentGCLabels(ctx, mdb.ContentStore(), *desc, 0, nil); err != nil {
+				return err
 			}
-			copts = append(copts, content.WithLabels(getChildGCLabels(*desc, 0, nil)))
 		}
+		mlabels = db.AddContentRefLabels(mlabels, refs...)
 
 		b, err := json.Marshal(manifest)
 		if err != nil {
 			return err
 		}
 
+		alg, err := digestAlgorithm(clicontext)
+		if err != nil {
+			return err
+		}
 		target.Size = int64(len(b))
-		target.Digest = digest.FromBytes(b)
+		target.Digest = alg.FromBytes(b)
 
 		// Add content label
-		if err := content.WriteBlob(ctx, mdb.ContentStore(), target.Digest.String()+"-ingest", bytes.NewReader(b), target, copts...); err != nil {
+		if err := db.WriteTarget(ctx, mdb.ContentStore(), b, target, mlabels); err != nil {
 			return fmt.Errorf("failed to write manifest: %w", err)
 		}
 
@@ -158,13 +218,13 @@ var appendCommand = cli.Command{
 	Usage:       "create a new image",
 	ArgsUsage:   "<image-name> [flags]",
 	Description: `appends descriptor image locally`,
-	Flags:       descriptorFlags,
+	Flags:       append(descriptorFlags, gcRefFlag),
 	Action: func(clicontext *cli.Context) error {
 		var (
 			ctx = context.Background()
 			ref = clicontext.Args().First()
 		)
-		mdb, err := db.NewDB(clicontext.GlobalString("data-dir"))
+		mdb, err := datadir.OpenDB(clicontext)
 		if err != nil {
 			return err
 		}
@@ -190,25 +250,23 @@ var appendCommand = cli.Command{
 			return err
 		}
 
-		var copts []content.Opt
-		var manifest interface{}
-		var position int
+		refs, err := gcRefs(ctx, clicontext, mdb.ContentStore())
+		if err != nil {
+			return err
+		}
+
+		var (
+			b        []byte
+			position int
+		)
 		switch img.Target.MediaType {
-		case "application/vnd.oci.image.index.v1+json":
-			b, err := content.ReadBlob(ctx, mdb.ContentStore(), img.Target)
-			if err != nil {
-				return err
-			}
-			var idx ocispec.Index
-			if err := json.Unmarshal(b, &idx); err != nil {
+		case ocispec.MediaTypeImageIndex, images.MediaTypeDockerSchema2ManifestList:
+			// Populates the platform from the manifest config when not provided
+			if b, position, err = index.AppendBlob(ctx, mdb.ContentStore(), img.Target, *desc); err != nil {
 				return err
 			}
-			position = len(idx.Manifests)
-			idx.Manifests = append(idx.Manifests, *desc)
-			manifest = idx
-		case "application/vnd.oci.image.manifest.v1+json":
-			b, err := content.ReadBlob(ctx, mdb.ContentStore(), img.Target)
-			if err != nil {
+		case ocispec.MediaTypeImageManifest:
+			if b, err = iobuf.ReadBlob(ctx, mdb.ContentStore(), img.Target); err != nil {
 				return err
 			}
 			var m ocispec.Manifest
@@ -218,21 +276,26 @@ var appendCommand = cli.Command{
 			// Add 1 to position to account for config as the first element for child labeling
 			position = len(m.Layers) + 1
 			m.Layers = append(m.Layers, *desc)
-			manifest = m
+			if b, err = json.Marshal(m); err != nil {
+				return err
+			}
 		default:
 			return fmt.Errorf("media type not supported for making updates: %s", img.Target.MediaType)
 		}
-		copts = append(copts, content.WithLabels(getChildGCLabels(*desc, position, info.Labels)))
-
-		b, err := json.Marshal(manifest)
+		labels, err := contentGCLabels(ctx, mdb.ContentStore(), *desc, position, info.Labels)
 		if err != nil {
 			return err
 		}
+		labels = db.AddContentRefLabels(labels, refs...)
 
+		alg, err := digestAlgorithm(clicontext)
+		if err != nil {
+			return err
+		}
 		img.Target.Size = int64(len(b))
-		img.Target.Digest = digest.FromBytes(b)
+		img.Target.Digest = alg.FromBytes(b)
 
-		if err := content.WriteBlob(ctx, mdb.ContentStore(), img.Target.Digest.String()+"-ingest", bytes.NewReader(b), img.Target, copts...); err != nil {
+		if err := db.WriteTarget(ctx, mdb.ContentStore(), b, img.Target, labels); err != nil {
 			return err
 		}
 		_, err = imgdb.Update(ctx, img)
@@ -241,6 +304,18 @@ var appendCommand = cli.Command{
 	},
 }
 
+// digestAlgorithm returns the algorithm used to digest new content
+func digestAlgorithm(clicontext *cli.Context) (digest.Algorithm, error) {
+	switch alg := digest.Algorithm(clicontext.String("digest-algorithm")); alg {
+	case "":
+		return digest.Canonical, nil
+	case digest.SHA256, digest.SHA512:
+		return alg, nil
+	default:
+		return "", fmt.Errorf("unsupported digest algorithm %q, must be sha256 or sha512", alg)
+	}
+}
+
 func getDescriptor(ctx context.Context, clicontext *cli.Context, ing content.Ingester, is images.Store) (desc *ocispec.Descriptor, err error) {
 	if file := clicontext.String("file"); file != "" {
 		var r io.Reader
@@ -256,15 +331,19 @@ func getDescriptor(ctx context.Context, clicontext *cli.Context, ing content.Ing
 		}
 
 		buf := bytes.NewBuffer(nil)
-		if _, err := io.Copy(buf, r); err != nil {
+		if _, err := iobuf.Copy(buf, r); err != nil {
 			return nil, err
 		}
 
+		alg, err := digestAlgorithm(clicontext)
+		if err != nil {
+			return nil, err
+		}
 		b := buf.Bytes()
 		desc = &ocispec.Descriptor{
 			MediaType: clicontext.String("media-type"),
 			Size:      int64(buf.Len()),
-			Digest:    digest.FromBytes(b),
+			Digest:    alg.FromBytes(b),
 		}
 		if desc.MediaType == "" {
 			// Default?
@@ -279,21 +358,40 @@ func getDescriptor(ctx context.Context, clicontext *cli.Context, ing content.Ing
 			return nil, err
 		}
 		desc = &i.Target
+	} else if len(clicontext.StringSlice("url")) > 0 {
+		// Foreign content is only referenced by its urls and not stored locally
+		dgst, err := digest.Parse(clicontext.String("digest"))
+		if err != nil {
+			return nil, fmt.Errorf("content only referenced by url requires a valid --digest: %w", err)
+		}
+		desc = &ocispec.Descriptor{
+			MediaType: clicontext.String("media-type"),
+			Size:      clicontext.Int64("size"),
+			Digest:    dgst,
+		}
+		if desc.MediaType == "" || desc.Size <= 0 {
+			return nil, fmt.Errorf("content only referenced by url requires --media-type and --size")
+		}
 	} else {
 		return nil, nil
 	}
 
-	annotations, err := keyValueArgs(clicontext.StringSlice("annotations"), "")
+	for _, s := range clicontext.StringSlice("url") {
+		u, err := url.Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid url %q: %w", s, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid url %q, must be an http or https url", s)
+		}
+		desc.URLs = append(desc.URLs, s)
+	}
+
+	annotations, err := keyValueArgs(clicontext.StringSlice("annotation"), "")
 	if err != nil {
 		return nil, err
 	}
-	if len(desc.Annotations) > 0 {
-		for k, v := range annotations {
-			desc.Annotations[k] = v
-		}
-	} else {
-		desc.Annotations = annotations
-	}
+	*desc = index.Annotate(*desc, annotations)
 
 	if ps := clicontext.String("platform"); ps != "" {
 		p, err := platforms.Parse(ps)
@@ -306,103 +404,168 @@ func getDescriptor(ctx context.Context, clicontext *cli.Context, ing content.Ing
 	return
 }
 
+// emptyJSON is the canonical content of the empty descriptor
+var emptyJSON = []byte("{}")
+
+// writeEmptyConfig ensures the empty descriptor content exists in the store
+// and returns its descriptor
+func writeEmptyConfig(ctx context.Context, ing content.Ingester) (*ocispec.Descriptor, error) {
+	desc := ocispec.Descriptor{
+		MediaType: index.MediaTypeEmptyJSON,
+		Digest:    digest.FromBytes(emptyJSON),
+		Size:      int64(len(emptyJSON)),
+		Data:      emptyJSON,
+	}
+	if err := content.WriteBlob(ctx, ing, desc.Digest.String()+"-ingest", bytes.NewReader(emptyJSON), desc); err != nil {
+		return nil, fmt.Errorf("failed to write empty config: %w", err)
+	}
+	return &desc, nil
+}
+
 var editImageCommand = cli.Command{
-	Name:        "edit",
-	Usage:       "edit image annotations",
-	ArgsUsage:   "<image-name> [flags]",
-	Description: `edit image annotations and updates media type`,
+	Name:      "edit",
+	Usage:     "edit image annotations and labels",
+	ArgsUsage: "<image-name> [flags]",
+	Description: `Edit image annotations and labels.
+
+Manifest annotations are applied by writing a new manifest or index, which
+also updates the media type to its OCI equivalent. Image labels are changed
+on the image only, a label given with an empty value is removed. When only
+labels are given, the target is left unchanged.
+
+Image labels such as containerd.io/gc.ref.content.* hold content for garbage
+collection, removing such a label leaves the content eligible to be collected
+once nothing else references it.`,
 	Flags: []cli.Flag{
 		cli.StringSliceFlag{
 			Name:  "manifest-annotation",
 			Usage: "Annotations to apply to the manifest",
 		},
+		cli.StringSliceFlag{
+			Name:  "label",
+			Usage: "Labels to set on the image, an empty value removes the label",
+		},
+		cli.StringSliceFlag{
+			Name:  "remove-label",
+			Usage: "Labels to remove from the image",
+		},
 	},
 	Action: func(clicontext *cli.Context) error {
 		var (
 			ctx = context.Background()
 			ref = clicontext.Args().First()
 		)
-		mdb, err := db.NewDB(clicontext.GlobalString("data-dir"))
+		annotations, err := keyValueArgs(clicontext.StringSlice("manifest-annotation"), "")
 		if err != nil {
 			return err
 		}
-		defer mdb.Close(ctx)
-
-		imgdb := db.NewImageStore(mdb)
-		img, err := imgdb.Get(ctx, ref)
+		labels, err := keyValueArgs(clicontext.StringSlice("label"), "")
 		if err != nil {
-			return fmt.Errorf("image could not be retrieved: %w", err)
+			return err
+		}
+		for _, key := range clicontext.StringSlice("remove-label") {
+			if labels == nil {
+				labels = map[string]string{}
+			}
+			labels[key] = ""
 		}
 
-		annotations, err := keyValueArgs(clicontext.StringSlice("manifest-annotation"), "")
+		mdb, err := datadir.OpenDB(clicontext)
 		if err != nil {
 			return err
 		}
+		defer mdb.Close(ctx)
 
-		info, err := mdb.ContentStore().Info(ctx, img.Target.Digest)
+		imgdb := db.NewImageStore(mdb)
+		img, err := imgdb.Get(ctx, ref)
 		if err != nil {
-			return err
+			return fmt.Errorf("image could not be retrieved: %w", err)
 		}
 
-		var copts []content.Opt
-		var manifest interface{}
-		switch img.Target.MediaType {
-		case ocispec.MediaTypeImageIndex, images.MediaTypeDockerSchema2ManifestList:
-			b, err := content.ReadBlob(ctx, mdb.ContentStore(), img.Target)
-			if err != nil {
-				return err
-			}
-			var idx ocispec.Index
-			if err := json.Unmarshal(b, &idx); err != nil {
-				return err
-			}
-			for k, v := range annotations {
-				if idx.Annotations == nil {
-					idx.Annotations = map[string]string{}
-				}
-				idx.Annotations[k] = v
-			}
-			idx.MediaType = ocispec.MediaTypeImageIndex
-			img.Target.MediaType = idx.MediaType
-			manifest = idx
-		case ocispec.MediaTypeImageManifest, images.MediaTypeDockerSchema2Manifest:
-			b, err := content.ReadBlob(ctx, mdb.ContentStore(), img.Target)
-			if err != nil {
-				return err
+		// Only update the changed fields so concurrent label changes
+		// are not overwritten
+		var fieldpaths []string
+		for k, v := range labels {
+			if img.Labels == nil {
+				img.Labels = map[string]string{}
 			}
-			var m ocispec.Manifest
-			if err := json.Unmarshal(b, &m); err != nil {
+			img.Labels[k] = v
+			fieldpaths = append(fieldpaths, "labels."+k)
+		}
+		if len(annotations) > 0 || len(labels) == 0 {
+			if img.Target, err = annotateTarget(ctx, mdb, img.Target, annotations); err != nil {
 				return err
 			}
-			for k, v := range annotations {
-				if m.Annotations == nil {
-					m.Annotations = map[string]string{}
-				}
-				m.Annotations[k] = v
-			}
-			m.MediaType = ocispec.MediaTypeImageManifest
-			img.Target.MediaType = m.MediaType
-			manifest = m
-		default:
-			return fmt.Errorf("media type not supported for making updates: %s", img.Target.MediaType)
+			fieldpaths = append(fieldpaths, "target")
 		}
-		copts = append(copts, content.WithLabels(info.Labels))
 
-		b, err := json.Marshal(manifest)
-		if err != nil {
-			return err
-		}
+		_, err = imgdb.Update(ctx, img, fieldpaths...)
 
-		img.Target.Size = int64(len(b))
-		img.Target.Digest = digest.FromBytes(b)
+		return err
+	},
+}
 
-		if err := content.WriteBlob(ctx, mdb.ContentStore(), img.Target.Digest.String()+"-ingest", bytes.NewReader(b), img.Target, copts...); err != nil {
-			return err
+// annotateTarget writes a new manifest or index with the annotations applied
+// and an OCI media type, returning the descriptor of the new target
+func annotateTarget(ctx context.Context, mdb *db.DB, target ocispec.Descriptor, annotations map[string]string) (ocispec.Descriptor, error) {
+	info, err := mdb.ContentStore().Info(ctx, target.Digest)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	var manifest interface{}
+	switch target.MediaType {
+	case ocispec.MediaTypeImageIndex, images.MediaTypeDockerSchema2ManifestList:
+		b, err := iobuf.ReadBlob(ctx, mdb.ContentStore(), target)
+		if err != nil {
+			return ocispec.Descriptor{}, err
 		}
-		_, err = imgdb.Update(ctx, img)
+		var idx ocispec.Index
+		if err := json.Unmarshal(b, &idx); err != nil {
+			return ocispec.Descriptor{}, err
+		}
+		for k, v := range annotations {
+			if idx.Annotations == nil {
+				idx.Annotations = map[string]string{}
+			}
+			idx.Annotations[k] = v
+		}
+		idx.MediaType = ocispec.MediaTypeImageIndex
+		target.MediaType = idx.MediaType
+		manifest = idx
+	case ocispec.MediaTypeImageManifest, images.MediaTypeDockerSchema2Manifest:
+		b, err := iobuf.ReadBlob(ctx, mdb.ContentStore(), target)
+		if err != nil {
+			return ocispec.Descriptor{}, err
+		}
+		var m ocispec.Manifest
+		if err := json.Unmarshal(b, &m); err != nil {
+			return ocispec.Descriptor{}, err
+		}
+		for k, v := range annotations {
+			if m.Annotations == nil {
+				m.Annotations = map[string]string{}
+			}
+			m.Annotations[k] = v
+		}
+		m.MediaType = ocispec.MediaTypeImageManifest
+		target.MediaType = m.MediaType
+		manifest = m
+	default:
+		return ocispec.Descriptor{}, fmt.Errorf("media type not supported for making updates: %s", target.MediaType)
+	}
+	b, err := json.Marshal(manifest)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
 
-		return err
-	},
+	target.Size = int64(len(b))
+	target.Digest = target.Digest.Algorithm().FromBytes(b)
+
+	if err := db.WriteTarget(ctx, mdb.ContentStore(), b, target, info.Labels); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	return target, nil
 }
 
 func keyValueArgs(args []string, defaultValue string) (map[string]string, error) {
@@ -426,19 +589,33 @@ func keyValueArgs(args []string, defaultValue string) (map[string]string, error)
 	return kvs, nil
 }
 
-func getChildGCLabels(desc ocispec.Descriptor, position int, labels map[string]string) map[string]string {
-	prefixes := images.ChildGCLabels(desc)
-	if len(prefixes) > 0 {
-		if labels == nil {
-			labels = map[string]string{}
+// gcRefs returns the digests of the content to explicitly reference, the
+// content must exist in the content store
+func gcRefs(ctx context.Context, clicontext *cli.Context, cs content.Store) ([]digest.Digest, error) {
+	var refs []digest.Digest
+	for _, s := range clicontext.StringSlice("gc-ref") {
+		dgst, err := digest.Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gc reference %q: %w", s, err)
 		}
-		for _, key := range prefixes {
-			if strings.HasSuffix(key, ".") {
-				key = fmt.Sprintf("%s%d", key, position)
-			}
-			labels[key] = desc.Digest.String()
+		if _, err := cs.Info(ctx, dgst); err != nil {
+			return nil, fmt.Errorf("gc reference %s: %w", dgst, err)
 		}
+		refs = append(refs, dgst)
+	}
+	return refs, nil
+}
 
+// contentGCLabels returns the labels referencing the descriptor's content from
+// its parent. Content only referenced by its urls is not stored locally and
+// is left unreferenced so collection does not expect a local blob.
+func contentGCLabels(ctx context.Context, cs content.Store, desc ocispec.Descriptor, position int, labels map[string]string) (map[string]string, error) {
+	if len(desc.URLs) > 0 {
+		if _, err := cs.Info(ctx, desc.Digest); errdefs.IsNotFound(err) {
+			return labels, nil
+		} else if err != nil {
+			return nil, err
+		}
 	}
-	return labels
+	return index.ChildGCLabels(desc, position, labels), nil
 }
@@ -0,0 +1,136 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package image
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/containerd/containerd/pkg/transfer/archive"
+	image "github.com/containerd/containerd/pkg/transfer/image"
+	"github.com/containerd/containerd/pkg/transfer/local"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/lcontainerd/cmd/lctr/app/datadir"
+	"github.com/containerd/lcontainerd/pkg/db"
+	"github.com/urfave/cli"
+)
+
+var exportCommand = cli.Command{
+	Name:      "export",
+	Usage:     "exports an image to an OCI archive",
+	ArgsUsage: "[flags] <image> <file>|-",
+	Description: `Exports a local image as an OCI archive.
+
+The archive is written to the file, or to stdout when the file is "-" so it
+may be piped into another tool. When writing to stdout, progress is displayed
+on stderr instead.
+
+Only the manifest for the current platform is exported unless --platform or
+--all-platforms is given. The content for every exported platform must be
+stored locally.`,
+	Before: applyConfig,
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "platform",
+			Usage: "Export content for a specific platform",
+			Value: &cli.StringSlice{},
+		},
+		cli.BoolFlag{
+			Name:  "all-platforms",
+			Usage: "export content for all platforms",
+		},
+		progressFlag,
+		protoOutFlag,
+		progressSocketFlag,
+		statusLineFlag,
+		progressIntervalFlag,
+	},
+	Action: func(clicontext *cli.Context) error {
+		var (
+			ref = clicontext.Args().First()
+			out = clicontext.Args().Get(1)
+			ctx = context.Background()
+		)
+		if ref == "" || out == "" {
+			return fmt.Errorf("please provide an image and a file to export to")
+		}
+		format, err := progressFormat(clicontext)
+		if err != nil {
+			return err
+		}
+		if out == "-" && format == "proto" && clicontext.String("progress-socket") == "" {
+			return fmt.Errorf("--progress=proto requires --progress-socket when exporting to stdout")
+		}
+
+		var eopts []archive.ExportOpt
+		for _, s := range clicontext.StringSlice("platform") {
+			p, err := platforms.Parse(s)
+			if err != nil {
+				return fmt.Errorf("unable to parse platform %s: %w", s, err)
+			}
+			eopts = append(eopts, archive.WithPlatform(p))
+		}
+		if clicontext.Bool("all-platforms") {
+			eopts = append(eopts, archive.WithAllPlatforms)
+		}
+
+		mdb, err := datadir.OpenDB(clicontext)
+		if err != nil {
+			return err
+		}
+		defer mdb.Close(ctx)
+
+		ts := local.NewTransferService(db.NewLeaseManager(mdb), mdb.ContentStore(), db.NewImageStore(mdb), &local.TransferConfig{})
+
+		var (
+			w        io.WriteCloser
+			progress io.Writer = os.Stdout
+		)
+		if out == "-" {
+			w = nopCloser{os.Stdout}
+			progress = os.Stderr
+		} else {
+			f, err := os.Create(out)
+			if err != nil {
+				return err
+			}
+			w = f
+		}
+		ies := archive.NewImageExportStream(w, "", eopts...)
+
+		err = runTransferTo(ctx, clicontext, ts, image.NewStore(ref), ies, progress)
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil && out != "-" {
+			// Do not leave a partial archive behind
+			os.Remove(out)
+		}
+		return err
+	},
+}
+
+// nopCloser allows writing to stdout without closing it
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
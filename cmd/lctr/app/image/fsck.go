@@ -0,0 +1,108 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package image
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/containerd/lcontainerd/cmd/lctr/app/datadir"
+	"github.com/containerd/lcontainerd/pkg/db"
+	"github.com/urfave/cli"
+)
+
+var fsckCommand = cli.Command{
+	Name:      "fsck",
+	Usage:     "check images for missing content",
+	ArgsUsage: "[flags]",
+	Description: `Checks that the target of every image and the manifests it references
+exist in the content store.
+
+Manifests missing from an index are only reported when none of the index's
+manifests are present, since pulling a subset of platforms leaves the other
+manifests absent.
+`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "remove-broken",
+			Usage: "remove images whose target is missing",
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		var (
+			ctx    = context.Background()
+			remove = clicontext.Bool("remove-broken")
+			opts   []db.DBOpt
+		)
+		if !remove {
+			opts = append(opts, db.WithReadOnly)
+		}
+		mdb, err := datadir.OpenDB(clicontext, opts...)
+		if err != nil {
+			return err
+		}
+		defer mdb.Close(ctx)
+
+		broken, err := mdb.CheckImages(ctx)
+		if err != nil {
+			return err
+		}
+		if len(broken) == 0 {
+			fmt.Println("no broken images found")
+			return nil
+		}
+
+		var (
+			imgdb     = db.NewImageStore(mdb)
+			remaining int
+			tw        = tabwriter.NewWriter(os.Stdout, 8, 3, 1, ' ', 0)
+		)
+		fmt.Fprintf(tw, "Image\tMissing\tStatus\n")
+		fmt.Fprintf(tw, "-----\t-------\t------\n")
+		for _, b := range broken {
+			status := "missing manifests"
+			if b.TargetMissing {
+				status = "missing target"
+			}
+			if b.TargetMissing && remove {
+				if err := imgdb.Delete(ctx, b.Image.Name); err != nil {
+					return fmt.Errorf("failed to remove image %s: %w", b.Image.Name, err)
+				}
+				status = "removed"
+			} else {
+				remaining++
+			}
+			for i, desc := range b.Missing {
+				name := b.Image.Name
+				if i > 0 {
+					name = ""
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\n", name, desc.Digest, status)
+			}
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+
+		if remaining > 0 {
+			return cli.NewExitError(fmt.Sprintf("found %d broken images", remaining), 1)
+		}
+		return nil
+	},
+}
@@ -26,15 +26,28 @@ var Command = cli.Command{
 	Subcommands: cli.Commands{
 		pullCommand,
 		pushCommand,
+		copyCommand,
 		importCommand,
+		exportCommand,
 		listCommand,
 		readCommand,
+		platformsCommand,
+		annotationsCommand,
+		inspectRemoteCommand,
 		createCommand,
 		appendCommand,
 		editImageCommand,
+		squashCommand,
+		convertCommand,
+		unpackCommand,
+		diffCommand,
+		tagCommand,
 		removeCommand,
+		logCommand,
+		fsckCommand,
 		leaseImageCommand,
 		getContentCommand,
 		loginCommand,
+		logoutCommand,
 	},
 }
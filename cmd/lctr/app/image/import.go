@@ -23,29 +23,46 @@ import (
 	"os"
 
 	"github.com/containerd/containerd/cmd/ctr/commands"
-	"github.com/containerd/containerd/pkg/transfer"
 	"github.com/containerd/containerd/pkg/transfer/archive"
-	image "github.com/containerd/containerd/pkg/transfer/image"
 	"github.com/containerd/containerd/pkg/transfer/local"
-	"github.com/containerd/lcontainerd/pkg/cli/progress"
+	"github.com/containerd/lcontainerd/cmd/lctr/app/datadir"
+	lcarchive "github.com/containerd/lcontainerd/pkg/archive"
 	"github.com/containerd/lcontainerd/pkg/db"
 	"github.com/urfave/cli"
 )
 
 var importCommand = cli.Command{
-	Name:        "import",
-	Usage:       "imports an image locally",
-	ArgsUsage:   "[flags] <file>|-",
-	Description: `Imports an OCI archive into the content and image store.`,
+	Name:      "import",
+	Usage:     "imports an image locally",
+	ArgsUsage: "[flags] <file>|-",
+	Description: `Imports an OCI archive into the content and image store.
+
+Each manifest in the archive's index with an org.opencontainers.image.ref.name
+annotation is stored as an image with that name. Names which are only a tag are
+added to the --name-prefix, when set.
+
+Blobs which are already present locally are not written again, the number of
+blobs written and already present is shown once the import completes.
+
+Use --lease to hold the imported content in a lease, which is created if it
+does not exist. The content remains after the images are removed until the
+lease is deleted.`,
+	Before: applyConfig,
 	Flags: append(append(commands.RegistryFlags, commands.LabelFlag),
 		cli.StringFlag{
 			Name:  "index-name",
 			Usage: "image name to store index as",
 		},
-		cli.BoolFlag{
-			Name:  "proto-out",
-			Usage: "output progress directly to stdout as proto messages",
+		cli.StringFlag{
+			Name:  "name-prefix",
+			Usage: "image name to prefix tag only reference names with",
 		},
+		progressFlag,
+		protoOutFlag,
+		progressSocketFlag,
+		statusLineFlag,
+		progressIntervalFlag,
+		leaseFlag,
 	),
 	Action: func(clicontext *cli.Context) error {
 		var (
@@ -56,36 +73,35 @@ var importCommand = cli.Command{
 		if in == "" {
 			return fmt.Errorf("please provide a file to import")
 		}
+		format, err := progressFormat(clicontext)
+		if err != nil {
+			return err
+		}
 
-		mdb, err := db.NewDB(clicontext.GlobalString("data-dir"))
+		mdb, err := datadir.OpenDB(clicontext)
 		if err != nil {
 			return err
 		}
 		defer mdb.Close(ctx)
 
-		ts := local.NewTransferService(db.NewLeaseManager(mdb), mdb.ContentStore(), db.NewImageStore(mdb), &local.TransferConfig{})
-
-		var opts []image.StoreOpt
-		/*
-			prefix := clicontext.String("base-name")
-			if prefix == "" {
-				prefix = fmt.Sprintf("import-%s", time.Now().Format("2006-01-02"))
-				opts = append(opts, image.WithNamePrefix(prefix, false))
-			} else {
-				// When provided, filter out references which do not match
-				opts = append(opts, image.WithNamePrefix(prefix, true))
-			}
+		ctx, err = withLeaseFlag(ctx, clicontext, mdb)
+		if err != nil {
+			return err
+		}
 
-			if clicontext.Bool("digests") {
-				opts = append(opts, image.WithDigestRefs(!clicontext.Bool("skip-digest-for-named")))
-			}
-		*/
+		// Blobs which already exist are not written again, count them
+		// to report how many were reused
+		stats := lcarchive.NewBlobStats(mdb.ContentStore())
+		ts := local.NewTransferService(db.NewLeaseManager(mdb), stats, db.NewImageStore(mdb), &local.TransferConfig{})
 
 		// TODO: Add platform options
 
 		// TODO: Add unpack options
 
-		is := image.NewStore(clicontext.String("index-name"), opts...)
+		is := lcarchive.NewImportStore(
+			lcarchive.WithIndexName(clicontext.String("index-name")),
+			lcarchive.WithNamePrefix(clicontext.String("name-prefix")),
+		)
 
 		var iopts []archive.ImportOpt
 
@@ -106,18 +122,15 @@ var importCommand = cli.Command{
 		}
 		iis := archive.NewImageImportStream(r, "", iopts...)
 
-		var pf transfer.ProgressFunc
-		if clicontext.Bool("proto-out") {
-			pf = progress.ForwardProto(ctx, os.Stdout)
-		} else {
-			pf = progress.Hierarchical(ctx, os.Stdout)
-		}
-
-		err = ts.Transfer(ctx, iis, is, transfer.WithProgress(pf))
+		err = runTransfer(ctx, clicontext, ts, iis, is)
 		closeErr := r.Close()
 		if err != nil {
 			return err
 		}
+		// Keep stdout to the progress messages when they are for a program
+		if (format != "proto" && format != "json") || clicontext.String("progress-socket") != "" {
+			fmt.Printf("%d blobs written, %d already present\n", stats.Written(), stats.Reused())
+		}
 
 		return closeErr
 	},
@@ -0,0 +1,158 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package image
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/lcontainerd/cmd/lctr/app/datadir"
+	"github.com/containerd/lcontainerd/pkg/cli/display"
+	"github.com/containerd/lcontainerd/pkg/db"
+	"github.com/containerd/lcontainerd/pkg/remote"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/urfave/cli"
+)
+
+var inspectRemoteCommand = cli.Command{
+	Name:      "inspect-remote",
+	Usage:     "inspect an image in a remote without storing it",
+	ArgsUsage: "[flags] <ref>",
+	Description: `Inspect an image in a remote registry.
+
+Only the index, manifests, and configs are fetched, layers are never
+downloaded. Fetched content is held by a temporary lease and removed
+from the local store once the image has been displayed.
+
+Fetched index, manifest, and config blobs are cached by digest in the data
+directory, repeated inspection of the same digest is read from the cache.
+Use --no-cache to always fetch from the registry.
+`,
+	Before: applyConfig,
+	Flags: append(registryFlags,
+		cli.BoolFlag{
+			Name:  "content",
+			Usage: "Show JSON content",
+		},
+		cli.StringSliceFlag{
+			Name:  "platform",
+			Usage: "Only inspect manifests for a specific platform",
+			Value: &cli.StringSlice{},
+		},
+		cli.BoolFlag{
+			Name:  "no-cache",
+			Usage: "Do not read or write cached blobs",
+		},
+	),
+	Action: func(clicontext *cli.Context) error {
+		var (
+			ref = clicontext.Args().First()
+			ctx = context.Background()
+			err error
+		)
+		if ref == "" {
+			return fmt.Errorf("please provide an image reference to inspect")
+		}
+		if ref, err = normalizeName(ref); err != nil {
+			return err
+		}
+
+		matcher := platforms.All
+		if ps := clicontext.StringSlice("platform"); len(ps) > 0 {
+			var p []ocispec.Platform
+			for _, s := range ps {
+				pp, err := platforms.Parse(s)
+				if err != nil {
+					return fmt.Errorf("unable to parse platform %s: %w", s, err)
+				}
+				p = append(p, pp)
+			}
+			matcher = platforms.Any(p...)
+		}
+
+		ch, err := getCredentialHelper(clicontext, ref)
+		if err != nil {
+			return err
+		}
+
+		mdb, err := datadir.OpenDB(clicontext)
+		if err != nil {
+			return err
+		}
+		defer mdb.Close(ctx)
+
+		client, closeClient, err := getRegistryClient(clicontext, ref)
+		if err != nil {
+			return err
+		}
+		defer closeClient()
+
+		reg := remote.NewRegistry(ref, registryOpts(clicontext, ch, client)...)
+		name, desc, err := reg.Resolve(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to resolve image: %w", err)
+		}
+		var fetcher remotes.Fetcher
+		fetcher, err = reg.Fetcher(ctx, name)
+		if err != nil {
+			return err
+		}
+		if !clicontext.Bool("no-cache") {
+			cache, err := datadir.BlobCache(clicontext)
+			if err != nil {
+				return err
+			}
+			fetcher = cache.Fetcher(fetcher)
+		}
+
+		cs := mdb.ContentStore()
+		opts := []display.PrintOpt{
+			display.WithWriter(os.Stdout),
+		}
+		if clicontext.Bool("content") {
+			opts = append(opts, display.Verbose)
+		}
+		printer := display.NewPrinter(opts...)
+
+		return remote.InspectMetadata(ctx, db.NewLeaseManager(mdb), cs, fetcher, desc, matcher, func(ctx context.Context) error {
+			if images.IsIndexType(desc.MediaType) && len(clicontext.StringSlice("platform")) > 0 {
+				manifests, err := images.Children(ctx, cs, desc)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintln(os.Stdout, name)
+				for _, m := range manifests {
+					if m.Platform != nil && matcher.Match(*m.Platform) {
+						if err := printer.PrintManifestTree(ctx, m, cs); err != nil {
+							return err
+						}
+					}
+				}
+				return nil
+			}
+
+			return printer.PrintImageTree(ctx, images.Image{
+				Name:   name,
+				Target: desc,
+			}, cs)
+		})
+	},
+}
@@ -22,6 +22,7 @@ import (
 	"os"
 
 	"github.com/containerd/containerd/leases"
+	"github.com/containerd/lcontainerd/cmd/lctr/app/datadir"
 	"github.com/containerd/lcontainerd/pkg/db"
 	"github.com/urfave/cli"
 )
@@ -46,7 +47,7 @@ var leaseImageCommand = cli.Command{
 			ctx   = context.Background()
 			image = clicontext.Args().First()
 		)
-		mdb, err := db.NewDB(clicontext.GlobalString("data-dir"))
+		mdb, err := datadir.OpenDB(clicontext)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,66 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package image
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/containerd/lcontainerd/cmd/lctr/app/datadir"
+	"github.com/containerd/lcontainerd/pkg/db"
+	"github.com/urfave/cli"
+)
+
+var logCommand = cli.Command{
+	Name:      "log",
+	Usage:     "show the history of image changes",
+	ArgsUsage: "[<name>]",
+	Description: `Shows image creates, updates, and deletes from oldest to newest, with the
+image target after each change. Only changes to the named image are shown
+when a name is given.
+
+The log keeps a bounded number of recent events, older events are removed
+as new changes are made.
+`,
+	Action: func(clicontext *cli.Context) error {
+		var (
+			ctx  = context.Background()
+			name = clicontext.Args().First()
+		)
+		mdb, err := datadir.OpenDB(clicontext, db.WithReadOnly)
+		if err != nil {
+			return err
+		}
+		defer mdb.Close(ctx)
+
+		events, err := mdb.ImageLog(ctx, name)
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 8, 3, 1, ' ', 0)
+		fmt.Fprintf(tw, "Time\tAction\tImage Name\tDigest\n")
+		fmt.Fprintf(tw, "----\t------\t----------\t------\n")
+		for _, e := range events {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", e.Time.Format(time.RFC3339), e.Action, e.Name, e.Target)
+		}
+		return tw.Flush()
+	},
+}
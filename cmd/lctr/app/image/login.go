@@ -17,12 +17,21 @@
 package image
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/containerd/containerd/cmd/ctr/commands"
+	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/pkg/transfer/registry"
+	"github.com/containerd/containerd/remotes/docker"
 	"github.com/containerd/lcontainerd/pkg/cli/credentials"
+	"github.com/containerd/lcontainerd/pkg/remote"
 	"github.com/urfave/cli"
 )
 
@@ -31,21 +40,13 @@ var loginCommand = cli.Command{
 	Usage:       "saves login for a registry",
 	ArgsUsage:   "[flags] <host>",
 	Description: `Imports an OCI archive into the content and image store.`,
-	Flags:       registryFlags,
+	Flags:       append(commands.RegistryFlags, loginFlags...),
 	Action: func(clicontext *cli.Context) error {
 		ctx := context.Background()
 
-		host := clicontext.Args().First()
-		if host == "" {
-			return cli.NewExitError("provide a host", 1)
-		}
-		if u, err := url.Parse(host); err != nil {
+		host, err := loginHost(clicontext.Args().First())
+		if err != nil {
 			return err
-		} else if u.Host != "" {
-			host = u.Host
-		}
-		if host == "docker.io" {
-			host = "registry-1.docker.io"
 		}
 
 		ch, err := commands.NewStaticCredentials(ctx, clicontext, "")
@@ -62,22 +63,303 @@ var loginCommand = cli.Command{
 	},
 }
 
+var logoutCommand = cli.Command{
+	Name:      "logout",
+	Usage:     "removes the saved login for a registry",
+	ArgsUsage: "[flags] <host>",
+	Description: `Removes the credentials saved for a registry host.
+
+The credentials are removed from the credential store used by login and,
+when --credential-directory is given, from that directory. Logging out of a
+host without saved credentials is not an error.`,
+	Flags: loginFlags,
+	Action: func(clicontext *cli.Context) error {
+		ctx := context.Background()
+
+		host, err := loginHost(clicontext.Args().First())
+		if err != nil {
+			return err
+		}
+
+		removed, err := deleteCredentials(ctx, clicontext, host)
+		if err != nil {
+			return err
+		}
+		if !removed {
+			fmt.Printf("no saved login for %s\n", host)
+			return nil
+		}
+		fmt.Printf("removed login for %s\n", host)
+		return nil
+	},
+}
+
+// loginHost returns the registry host credentials are saved under for the
+// host or url argument
+func loginHost(host string) (string, error) {
+	if host == "" {
+		return "", cli.NewExitError("provide a host", 1)
+	}
+	if u, err := url.Parse(host); err != nil {
+		return "", err
+	} else if u.Host != "" {
+		host = u.Host
+	}
+	if host == "docker.io" {
+		host = "registry-1.docker.io"
+	}
+	return host, nil
+}
+
 func storeCredentials(ctx context.Context, clicontext *cli.Context, host string, creds registry.Credentials) error {
 	if dir := clicontext.String("credential-directory"); dir != "" {
-		// TODO: Support keyfile decoder/encoder
-		encdec := credentials.NewUnencryptedJSON()
+		encdec, err := directoryEncoderDecoder(clicontext)
+		if err != nil {
+			return err
+		}
+		return credentials.StoreCredentialsLocal(ctx, dir, host, creds, encdec)
+	}
+	if d := clicontext.Duration("credential-timeout"); d > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+	kind, err := credentials.ResolveStore(ctx, clicontext.String("credential-store"))
+	if err != nil {
+		return err
+	}
+	if kind == credentials.StoreFile {
+		dir, encdec, err := fileCredentialStore(clicontext)
+		if err != nil {
+			return err
+		}
 		return credentials.StoreCredentialsLocal(ctx, dir, host, creds, encdec)
 	}
 	return credentials.StoreCredentialsInKeychain(ctx, host, creds)
 }
 
+// deleteCredentials removes the credentials for the host from the credential
+// directory, when given, and from the credential store, returning whether
+// any were removed
+func deleteCredentials(ctx context.Context, clicontext *cli.Context, host string) (bool, error) {
+	var removed bool
+	if dir := clicontext.String("credential-directory"); dir != "" {
+		if err := credentials.DeleteCredentialsLocal(ctx, dir, host); err == nil {
+			removed = true
+		} else if !errdefs.IsNotFound(err) {
+			return false, err
+		}
+	}
+	if d := clicontext.Duration("credential-timeout"); d > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+	kind, err := credentials.ResolveStore(ctx, clicontext.String("credential-store"))
+	if err != nil {
+		return false, err
+	}
+	if kind == credentials.StoreFile {
+		var dir string
+		if dir, _, err = fileCredentialStore(clicontext); err != nil {
+			return false, err
+		}
+		err = credentials.DeleteCredentialsLocal(ctx, dir, host)
+	} else {
+		err = credentials.DeleteCredentialsInKeychain(ctx, host)
+	}
+	if err == nil {
+		removed = true
+	} else if !errdefs.IsNotFound(err) {
+		return false, err
+	}
+	return removed, nil
+}
+
+// getCredentialHelper returns the helper for credentials saved by login,
+// falling back to the Docker config when none are saved for the host
 func getCredentialHelper(clicontext *cli.Context, ref string) (registry.CredentialHelper, error) {
+	ch, err := savedCredentialHelper(clicontext, ref)
+	if err != nil {
+		return nil, err
+	}
+	docker, err := credentials.NewDockerConfigCredentialHelper(ref, "")
+	if err != nil {
+		// Without a home directory there is no Docker config to read
+		return ch, nil
+	}
+	return credentials.NewFallbackCredentialHelper(ch, docker), nil
+}
+
+func savedCredentialHelper(clicontext *cli.Context, ref string) (registry.CredentialHelper, error) {
 	if dir := clicontext.String("credential-directory"); dir != "" {
-		// TODO: Support keyfile decoder/encoder
-		encdec := credentials.NewUnencryptedJSON()
+		encdec, err := directoryEncoderDecoder(clicontext)
+		if err != nil {
+			return nil, err
+		}
 		return credentials.NewLocalCredentialHelper(ref, clicontext.String("user"), dir, encdec)
 	}
-	return credentials.NewKeychainCredentialHelper(ref, clicontext.String("user"))
+	timeout := clicontext.Duration("credential-timeout")
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	kind, err := credentials.ResolveStore(ctx, clicontext.String("credential-store"))
+	if err != nil {
+		return nil, err
+	}
+	if kind == credentials.StoreFile {
+		dir, encdec, err := fileCredentialStore(clicontext)
+		if err != nil {
+			return nil, err
+		}
+		return credentials.NewLocalCredentialHelper(ref, clicontext.String("user"), dir, encdec)
+	}
+	return credentials.NewKeychainCredentialHelper(ref, clicontext.String("user"), credentials.WithTimeout(timeout))
+}
+
+// directoryEncoderDecoder returns the encoder for the --credential-directory,
+// credentials are encrypted with the --credential-key-file when given
+func directoryEncoderDecoder(clicontext *cli.Context) (credentials.EncoderDecoder, error) {
+	path := clicontext.String("credential-key-file")
+	if path == "" {
+		return credentials.NewUnencryptedJSON(), nil
+	}
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credential key file: %w", err)
+	}
+	// A passphrase written with a trailing newline is the same passphrase
+	return credentials.NewEncryptedJSON(bytes.TrimRight(key, "\r\n"))
+}
+
+// fileCredentialStore returns the directory of the file credential store in
+// the data directory and the encoder using the key stored alongside it
+func fileCredentialStore(clicontext *cli.Context) (string, credentials.EncoderDecoder, error) {
+	root := clicontext.GlobalString("data-dir")
+	encdec, err := credentials.NewKeyFileEncoderDecoder(filepath.Join(root, "credentials.key"))
+	if err != nil {
+		return "", nil, err
+	}
+	return filepath.Join(root, "credentials"), encdec, nil
+}
+
+// registryClient is the http client for registry requests along with the
+// transport at the base of the client's transport, hosts configured with
+// certificates in the hosts directory are sent through the client by
+// adding their transport to it
+type registryClient struct {
+	client *http.Client
+	hosts  *remote.HostTransport
+}
+
+// getRegistryClient returns the client to use for registry requests to the
+// hosts of the given references and a function to close any resources held
+// by the client. A nil client is returned when the default client should be
+// used.
+func getRegistryClient(clicontext *cli.Context, refs ...string) (*registryClient, func() error, error) {
+	var (
+		traceFile  = clicontext.String("trace-file")
+		rps        = clicontext.Float64("requests-per-second")
+		skipVerify = clicontext.Bool("skip-verify")
+		base       = http.DefaultTransport
+		closer     = func() error { return nil }
+	)
+	proxy, err := proxyURL(clicontext)
+	if err != nil {
+		return nil, nil, err
+	}
+	if traceFile == "" && rps == 0 && !skipVerify && proxy == nil {
+		return nil, closer, nil
+	}
+	if rps < 0 {
+		return nil, nil, fmt.Errorf("invalid requests per second %v, must not be negative", rps)
+	}
+	if proxy != nil {
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		tr.Proxy = http.ProxyURL(proxy)
+		base = tr
+	}
+	var names []string
+	if skipVerify {
+		for _, ref := range refs {
+			_, host, err := remote.NormalizeReference(ref)
+			if err != nil {
+				return nil, nil, err
+			}
+			names = append(names, host)
+		}
+	}
+	var (
+		hosts                   = remote.NewHostTransport(base, names...)
+		rt    http.RoundTripper = hosts
+	)
+	if traceFile != "" {
+		f, err := os.OpenFile(traceFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open trace file: %w", err)
+		}
+		rt = remote.NewTraceTransport(f, rt)
+		closer = f.Close
+	}
+	if rps > 0 {
+		rt = remote.NewRateLimitTransport(rt, rps)
+	}
+	return &registryClient{
+		client: &http.Client{
+			Transport: rt,
+		},
+		hosts: hosts,
+	}, closer, nil
+}
+
+// registryOpts returns the options for a registry using the credentials and
+// client, configured by the registry cli flags
+func registryOpts(clicontext *cli.Context, ch registry.CredentialHelper, client *registryClient) []remote.RegistryOpt {
+	opts := []remote.RegistryOpt{
+		remote.WithCredentials(ch),
+		remote.WithPlainHTTP(plainHTTP(clicontext)),
+		remote.WithHostsDir(clicontext.String("hosts-dir")),
+	}
+	if client != nil {
+		opts = append(opts, remote.WithClient(client.client), remote.WithHostTransport(client.hosts))
+	}
+	// The proxy is validated when getting the client
+	if proxy, _ := proxyURL(clicontext); proxy != nil {
+		opts = append(opts, remote.WithProxy(http.ProxyURL(proxy)))
+	}
+	return opts
+}
+
+// proxyURL returns the proxy given with --proxy, nil when the proxy is
+// taken from the environment
+func proxyURL(clicontext *cli.Context) (*url.URL, error) {
+	proxy := clicontext.String("proxy")
+	if proxy == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(proxy)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %q, must be a URL such as http://proxy.example.com:3128", proxy)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("invalid proxy %q, scheme must be http, https or socks5", proxy)
+	}
+	return u, nil
+}
+
+// plainHTTP returns the function selecting the registry hosts accessed over
+// plain http, only localhost unless --plain-http is given. The function is
+// only called for the host of the registry reference.
+func plainHTTP(clicontext *cli.Context) func(string) (bool, error) {
+	if clicontext.Bool("plain-http") {
+		return docker.MatchAllHosts
+	}
+	return docker.MatchLocalhost
 }
 
 // loginFlags are cli flags specifying registry options
@@ -87,7 +369,36 @@ var loginFlags = []cli.Flag{
 		Usage:  "a directory for storing credentials",
 		EnvVar: "CONTAINERD_CREDENTIAL_DIRECTORY",
 	},
-	// TODO: Keyfile for encryption
+	cli.StringFlag{
+		Name:  "credential-store",
+		Usage: "credential store to use, \"keychain\" for the system store, \"file\" for encrypted files in the data directory, or \"auto\" to use the system store when available",
+		Value: credentials.StoreAuto,
+	},
+	cli.DurationFlag{
+		Name:  "credential-timeout",
+		Usage: "maximum time to wait on the system credential store, 0 to wait indefinitely",
+		Value: 30 * time.Second,
+	},
+	cli.StringFlag{
+		Name:  "credential-key-file",
+		Usage: "file holding a key or passphrase to encrypt the credentials in the --credential-directory",
+	},
+}
+
+// clientFlags are cli flags configuring the registry http client
+var clientFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "trace-file",
+		Usage: "file to log registry requests and responses to, credentials are redacted",
+	},
+	cli.StringFlag{
+		Name:  "proxy",
+		Usage: "proxy URL for registry requests, overrides HTTP_PROXY, HTTPS_PROXY and NO_PROXY",
+	},
+	cli.Float64Flag{
+		Name:  "requests-per-second",
+		Usage: "maximum number of requests per second to each registry host, 0 for no limit",
+	},
 }
 
-var registryFlags = append(commands.RegistryFlags, loginFlags...)
+var registryFlags = append(append(commands.RegistryFlags, loginFlags...), clientFlags...)
@@ -19,17 +19,14 @@ package image
 import (
 	"context"
 	"fmt"
-	"os"
 
 	"github.com/containerd/containerd/cmd/ctr/commands"
-	"github.com/containerd/containerd/pkg/transfer"
 	image "github.com/containerd/containerd/pkg/transfer/image"
 	"github.com/containerd/containerd/pkg/transfer/local"
-	"github.com/containerd/containerd/pkg/transfer/registry"
 	"github.com/containerd/containerd/platforms"
-	dockerref "github.com/containerd/containerd/reference/docker"
-	"github.com/containerd/lcontainerd/pkg/cli/progress"
+	"github.com/containerd/lcontainerd/cmd/lctr/app/datadir"
 	"github.com/containerd/lcontainerd/pkg/db"
+	"github.com/containerd/lcontainerd/pkg/remote"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/urfave/cli"
 )
@@ -44,9 +41,31 @@ After pulling an image, it should be ready to use the same reference in a run
 command. As part of this process, we do the following:
 
 1. Fetch all resources into containerd.
-2. Prepare the snapshot filesystem with the pulled resources.
-3. Register metadata for the image.
+2. Register metadata for the image.
+
+The snapshot filesystem is not prepared by pull, use "lctr image unpack" to
+unpack the layers of the pulled image into a snapshotter.
+
+The reference the image was pulled from is stored in the image's
+"lctr.io/source-ref" label.
+
+Only the content for the current platform is pulled by default. Use
+--platform to pull specific platforms or --all-platforms to pull every
+platform in the index, the two may not be combined.
+
+Use --no-resolve with a digest reference, such as "repo@sha256:...", to
+fetch the manifest by digest without first resolving the reference, saving
+a request to the registry.
+
+Use --lease to hold the pulled content in a lease, which is created if it does
+not exist. The content remains after the image is removed until the lease is
+deleted.
+
+Use --registry-allowlist to restrict the registry hosts images may be pulled
+from, such as in the [registry] section of the --config file. References
+without a host are from "docker.io".
 `,
+	Before: applyConfig,
 	Flags: append(append(registryFlags, commands.LabelFlag),
 		cli.StringSliceFlag{
 			Name:  "platform",
@@ -54,12 +73,27 @@ command. As part of this process, we do the following:
 			Value: &cli.StringSlice{},
 		},
 		cli.BoolFlag{
-			Name:  "proto-out",
-			Usage: "output progress directly to stdout as proto messages",
+			Name:  "all-platforms",
+			Usage: "Pull content from all platforms",
 		},
+		progressFlag,
+		protoOutFlag,
+		progressSocketFlag,
+		statusLineFlag,
+		progressIntervalFlag,
+		leaseFlag,
 		cli.IntFlag{
 			Name:  "max-concurrent-downloads",
-			Usage: "Set the max concurrent downloads for each pull",
+			Usage: "Set the max concurrent downloads for each pull, 0 for no limit",
+		},
+		cli.StringSliceFlag{
+			Name:  "registry-allowlist",
+			Usage: "Only pull from the given registry hosts, may be repeated",
+			Value: &cli.StringSlice{},
+		},
+		cli.BoolFlag{
+			Name:  "no-resolve",
+			Usage: "Fetch the manifest of a digest reference directly without resolving",
 		},
 	),
 	Action: func(clicontext *cli.Context) error {
@@ -70,28 +104,46 @@ command. As part of this process, we do the following:
 		if ref == "" {
 			return fmt.Errorf("please provide an image reference to pull")
 		}
+		// The transfer service limits concurrent fetches when set
+		tc, err := remote.NewTransferConfig(clicontext.Int("max-concurrent-downloads"))
+		if err != nil {
+			return err
+		}
 
-		named, err := dockerref.ParseDockerRef(ref)
+		name, _, err := remote.NormalizeReference(ref)
 		if err != nil {
 			return err
 		}
+		// Fail before any other work when the host is not allowed
+		allowlist := clicontext.StringSlice("registry-allowlist")
+		if err := remote.CheckAllowedHost(name, allowlist); err != nil {
+			return err
+		}
 
-		ch, err := getCredentialHelper(clicontext, named.String())
+		ch, err := getCredentialHelper(clicontext, name)
 		if err != nil {
 			return err
 		}
 
-		mdb, err := db.NewDB(clicontext.GlobalString("data-dir"))
+		mdb, err := datadir.OpenDB(clicontext)
 		if err != nil {
 			return err
 		}
 		defer mdb.Close(ctx)
 
-		var sopts []image.StoreOpt
-		storeplatforms := clicontext.StringSlice("platform")
-		// Add platforms if provided, default to all platforms
-		if len(storeplatforms) > 0 {
-			var p []ocispec.Platform
+		var (
+			sopts          []image.StoreOpt
+			p              []ocispec.Platform
+			storeplatforms = clicontext.StringSlice("platform")
+		)
+		// Add platforms if provided, default to the current platform. No
+		// platforms are given to the store to pull all platforms.
+		switch {
+		case clicontext.Bool("all-platforms"):
+			if len(storeplatforms) > 0 {
+				return fmt.Errorf("--platform cannot be used with --all-platforms")
+			}
+		case len(storeplatforms) > 0:
 			for _, s := range storeplatforms {
 				ps, err := platforms.Parse(s)
 				if err != nil {
@@ -99,25 +151,44 @@ command. As part of this process, we do the following:
 				}
 				p = append(p, ps)
 			}
+		default:
+			p = append(p, platforms.DefaultSpec())
+		}
+		if len(p) > 0 {
 			sopts = append(sopts, image.WithPlatforms(p...))
 		}
 
-		reg := registry.NewOCIRegistry(named.String(), nil, ch)
-		is := image.NewStore(named.String(), sopts...)
+		client, closeClient, err := getRegistryClient(clicontext, name)
+		if err != nil {
+			return err
+		}
+		defer closeClient()
 
-		ts := local.NewTransferService(db.NewLeaseManager(mdb), mdb.ContentStore(), db.NewImageStore(mdb), &local.TransferConfig{})
+		ropts := append(registryOpts(clicontext, ch, client), remote.WithAllowedHosts(allowlist...))
+		if clicontext.Bool("no-resolve") {
+			ropts = append(ropts, remote.WithNoResolve)
+		}
+		reg := remote.NewRegistry(name, ropts...)
+		// Fail before fetching any content when no manifest matches
+		if err := reg.CheckPlatforms(ctx, p); err != nil {
+			return err
+		}
 
-		var pf transfer.ProgressFunc
-		if clicontext.Bool("proto-out") {
-			pf = progress.ForwardProto(ctx, os.Stdout)
-		} else {
-			pf = progress.Hierarchical(ctx, os.Stdout)
+		labels, err := keyValueArgs(clicontext.StringSlice("label"), "true")
+		if err != nil {
+			return err
 		}
+		// Record the source reference for the pulled image
+		sopts = append(sopts, image.WithImageLabels(reg.SourceLabels(labels)))
+		is := image.NewStore(name, sopts...)
+
+		ts := local.NewTransferService(db.NewLeaseManager(mdb), mdb.ContentStore(), db.NewImageStore(mdb), tc)
 
-		if err := ts.Transfer(ctx, reg, is, transfer.WithProgress(pf)); err != nil {
+		ctx, err = withLeaseFlag(ctx, clicontext, mdb)
+		if err != nil {
 			return err
 		}
 
-		return nil
+		return runTransfer(ctx, clicontext, ts, reg, is)
 	},
 }
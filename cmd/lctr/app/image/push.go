@@ -19,16 +19,13 @@ package image
 import (
 	"context"
 	"fmt"
-	"os"
 
 	"github.com/containerd/containerd/cmd/ctr/commands"
-	"github.com/containerd/containerd/pkg/transfer"
 	image "github.com/containerd/containerd/pkg/transfer/image"
 	"github.com/containerd/containerd/pkg/transfer/local"
-	"github.com/containerd/containerd/pkg/transfer/registry"
-	dockerref "github.com/containerd/containerd/reference/docker"
-	"github.com/containerd/lcontainerd/pkg/cli/progress"
+	"github.com/containerd/lcontainerd/cmd/lctr/app/datadir"
 	"github.com/containerd/lcontainerd/pkg/db"
+	"github.com/containerd/lcontainerd/pkg/remote"
 	"github.com/urfave/cli"
 )
 
@@ -37,6 +34,7 @@ var pushCommand = cli.Command{
 	Usage:       "push an image to a remote",
 	ArgsUsage:   "[flags] <ref> [<local>]",
 	Description: `Pushes an image to an OCI registry`,
+	Before:      applyConfig,
 	Flags: append(append(registryFlags, commands.LabelFlag),
 		cli.StringSliceFlag{
 			Name:  "platform",
@@ -47,10 +45,11 @@ var pushCommand = cli.Command{
 			Name:  "all-platforms",
 			Usage: "pull content and metadata from all platforms",
 		},
-		cli.BoolFlag{
-			Name:  "proto-out",
-			Usage: "output progress directly to stdout as proto messages",
-		},
+		progressFlag,
+		protoOutFlag,
+		progressSocketFlag,
+		statusLineFlag,
+		progressIntervalFlag,
 	),
 	Action: func(clicontext *cli.Context) error {
 		var (
@@ -81,36 +80,28 @@ var pushCommand = cli.Command{
 			return err
 		}
 
-		mdb, err := db.NewDB(clicontext.GlobalString("data-dir"))
+		mdb, err := datadir.OpenDB(clicontext)
 		if err != nil {
 			return err
 		}
 		defer mdb.Close(ctx)
 
-		reg := registry.NewOCIRegistry(ref, nil, ch)
+		client, closeClient, err := getRegistryClient(clicontext, ref)
+		if err != nil {
+			return err
+		}
+		defer closeClient()
+
+		reg := remote.NewRegistry(ref, registryOpts(clicontext, ch, client)...)
 		is := image.NewStore(localref)
 
 		ts := local.NewTransferService(db.NewLeaseManager(mdb), mdb.ContentStore(), db.NewImageStore(mdb), &local.TransferConfig{})
 
-		var pf transfer.ProgressFunc
-		if clicontext.Bool("proto-out") {
-			pf = progress.ForwardProto(ctx, os.Stdout)
-		} else {
-			pf = progress.Hierarchical(ctx, os.Stdout)
-		}
-
-		if err := ts.Transfer(ctx, is, reg, transfer.WithProgress(pf)); err != nil {
-			return err
-		}
-
-		return nil
+		return runTransfer(ctx, clicontext, ts, is, reg)
 	},
 }
 
 func normalizeName(name string) (string, error) {
-	named, err := dockerref.ParseDockerRef(name)
-	if err != nil {
-		return "", err
-	}
-	return named.String(), nil
+	name, _, err := remote.NormalizeReference(name)
+	return name, err
 }
@@ -26,30 +26,51 @@ import (
 
 	"github.com/containerd/containerd/content"
 	"github.com/containerd/containerd/images"
+	"github.com/containerd/lcontainerd/cmd/lctr/app/datadir"
+	"github.com/containerd/lcontainerd/cmd/lctr/app/listing"
 	"github.com/containerd/lcontainerd/pkg/cli/display"
 	"github.com/containerd/lcontainerd/pkg/db"
+	"github.com/containerd/lcontainerd/pkg/iobuf"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/urfave/cli"
 )
 
 var listCommand = cli.Command{
-	Name:        "list",
-	Aliases:     []string{"ls"},
-	Usage:       "list all images",
-	ArgsUsage:   "[flags]",
-	Description: `Lists all images stored locally`,
+	Name:      "list",
+	Aliases:   []string{"ls"},
+	Usage:     "list all images",
+	ArgsUsage: "[flags]",
+	Description: `Lists all images stored locally.
+
+Use --limit to list images a page at a time, the token printed after a
+page is passed to --next to continue listing from the end of the page.
+
+Use --since and --until to only list images last updated within a time
+range. The range includes --since and excludes --until.
+
+Use --filter to only list matching images, such as images from a repository
+with --filter 'name~=^docker.io/library/' or indexes with
+--filter 'target.mediatype==application/vnd.oci.image.index.v1+json'. Filters
+use the containerd filter syntax on the name, target.digest, target.mediatype,
+labels, and annotations fields.
+`,
+	Flags: append(append(listing.PageFlags, listing.TimeFlags...), listing.FilterFlag),
 	Action: func(clicontext *cli.Context) error {
 		var (
 			ctx = context.Background()
 		)
-		mdb, err := db.NewDB(clicontext.GlobalString("data-dir"), db.WithReadOnly)
+		page, err := listing.Page(clicontext)
+		if err != nil {
+			return err
+		}
+
+		mdb, err := datadir.OpenDB(clicontext, db.WithReadOnly)
 		if err != nil {
 			return err
 		}
 		defer mdb.Close(ctx)
 
-		imgdb := db.NewImageStore(mdb)
-		images, err := imgdb.List(ctx)
+		images, next, err := mdb.ListImages(ctx, page, clicontext.StringSlice("filter")...)
 		if err != nil {
 			return err
 		}
@@ -60,29 +81,62 @@ var listCommand = cli.Command{
 		for _, img := range images {
 			fmt.Fprintf(tw, "%s\t%s\t%s\n", img.Name, img.Target.Digest, img.Target.MediaType)
 		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
 
-		return tw.Flush()
+		listing.PrintNext(os.Stderr, next)
+		return nil
 	},
 }
 
 var readCommand = cli.Command{
-	Name:        "inspect",
-	Aliases:     []string{"i"},
-	Usage:       "inspect an image",
-	ArgsUsage:   "<image> [flags]",
-	Description: `Inspect an image`,
+	Name:      "inspect",
+	Aliases:   []string{"i"},
+	Usage:     "inspect an image",
+	ArgsUsage: "<image> [flags]",
+	Description: `Inspect an image.
+
+Use --raw to write the unformatted bytes of the image target, such as for
+piping into jq. Use --json to write the whole tree as a single JSON document,
+with --content the JSON content of manifests and configs is included in the
+document. Use --resolve to inspect the manifest for a platform when
+the image target is an index. Use --depth to limit how many levels of a
+large index are expanded and --max-layers to collapse the layers of each
+manifest beyond a count into a single entry with their total size.
+`,
 	Flags: []cli.Flag{
 		cli.BoolFlag{
 			Name:  "content",
 			Usage: "Show JSON content",
 		},
+		cli.BoolFlag{
+			Name:  "raw",
+			Usage: "Write the raw manifest or index bytes",
+		},
+		cli.BoolFlag{
+			Name:  "json",
+			Usage: "Write the tree as JSON",
+		},
+		cli.StringFlag{
+			Name:  "resolve",
+			Usage: "Resolve the index to the manifest for a platform",
+		},
+		cli.IntFlag{
+			Name:  "depth",
+			Usage: "Limit how many levels below the image target are expanded, 0 for no limit",
+		},
+		cli.IntFlag{
+			Name:  "max-layers",
+			Usage: "Limit how many layers of each manifest are shown, 0 for no limit",
+		},
 	},
 	Action: func(clicontext *cli.Context) error {
 		var (
 			ctx = context.Background()
 			ref = clicontext.Args().First()
 		)
-		mdb, err := db.NewDB(clicontext.GlobalString("data-dir"), db.WithReadOnly)
+		mdb, err := datadir.OpenDB(clicontext, db.WithReadOnly)
 		if err != nil {
 			return err
 		}
@@ -100,8 +154,119 @@ var readCommand = cli.Command{
 		if clicontext.Bool("content") {
 			opts = append(opts, display.Verbose)
 		}
+		if clicontext.Bool("json") {
+			opts = append(opts, display.WithJSON)
+		}
+		if depth := clicontext.Int("depth"); depth < 0 {
+			return fmt.Errorf("invalid depth %d, must not be negative", depth)
+		} else if depth > 0 {
+			opts = append(opts, display.WithDepth(depth))
+		}
+		if limit := clicontext.Int("max-layers"); limit < 0 {
+			return fmt.Errorf("invalid max layers %d, must not be negative", limit)
+		} else if limit > 0 {
+			opts = append(opts, display.WithLayerLimit(limit))
+		}
+		printer := display.NewPrinter(opts...)
+
+		desc := img.Target
+		if platform := clicontext.String("resolve"); platform != "" {
+			if desc, err = platformManifest(ctx, mdb.ContentStore(), desc, platform); err != nil {
+				return err
+			}
+		}
+		if clicontext.Bool("raw") {
+			return printer.PrintRaw(ctx, desc, mdb.ContentStore())
+		}
+		if desc.Digest != img.Target.Digest {
+			return printer.PrintManifestTree(ctx, desc, mdb.ContentStore())
+		}
+		return printer.PrintImageTree(ctx, img, mdb.ContentStore())
+	},
+}
+
+var platformsCommand = cli.Command{
+	Name:      "platforms",
+	Usage:     "list the platforms available for an image",
+	ArgsUsage: "<image>",
+	Description: `Lists the platform and digest of each manifest in an image.
+
+For an index, the platforms given in the index are shown, including those of
+nested indexes. For a single manifest, the platform from its config is shown.
+Manifests without a platform are shown as "unknown".
+`,
+	Action: func(clicontext *cli.Context) error {
+		var (
+			ctx = context.Background()
+			ref = clicontext.Args().First()
+		)
+		if ref == "" {
+			return fmt.Errorf("please provide an image name")
+		}
+		mdb, err := datadir.OpenDB(clicontext, db.WithReadOnly)
+		if err != nil {
+			return err
+		}
+		defer mdb.Close(ctx)
+
+		img, err := db.NewImageStore(mdb).Get(ctx, ref)
+		if err != nil {
+			return err
+		}
+		return display.NewPrinter(display.WithWriter(os.Stdout)).PrintPlatforms(ctx, img.Target, mdb.ContentStore())
+	},
+}
 
-		return display.NewPrinter(opts...).PrintImageTree(ctx, img, mdb.ContentStore())
+var annotationsCommand = cli.Command{
+	Name:      "annotations",
+	Usage:     "print the annotations of an image manifest or index",
+	ArgsUsage: "<image> [flags]",
+	Description: `Prints the annotations set in the image's target manifest or index, such
+as those set with --manifest-annotation.
+
+Use --platform to print the annotations of the manifest for a platform when
+the image target is an index.
+`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "platform",
+			Usage: "Print the annotations of the index manifest for a platform",
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Usage: "Output format, either \"table\" or \"json\"",
+			Value: "table",
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		var (
+			ctx    = context.Background()
+			ref    = clicontext.Args().First()
+			format = clicontext.String("format")
+		)
+		if ref == "" {
+			return fmt.Errorf("please provide an image name")
+		}
+		if format != "table" && format != "json" {
+			return fmt.Errorf("invalid format %q, must be table or json", format)
+		}
+		mdb, err := datadir.OpenDB(clicontext, db.WithReadOnly)
+		if err != nil {
+			return err
+		}
+		defer mdb.Close(ctx)
+
+		img, err := db.NewImageStore(mdb).Get(ctx, ref)
+		if err != nil {
+			return err
+		}
+		desc := img.Target
+		if platform := clicontext.String("platform"); platform != "" {
+			if desc, err = platformManifest(ctx, mdb.ContentStore(), desc, platform); err != nil {
+				return err
+			}
+		}
+		return display.NewPrinter(display.WithWriter(os.Stdout)).PrintAnnotations(ctx, desc, mdb.ContentStore(), format == "json")
 	},
 }
 
@@ -143,7 +308,7 @@ var getContentCommand = cli.Command{
 			ctx = context.Background()
 			ref = clicontext.Args().First()
 		)
-		mdb, err := db.NewDB(clicontext.GlobalString("data-dir"), db.WithReadOnly)
+		mdb, err := datadir.OpenDB(clicontext, db.WithReadOnly)
 		if err != nil {
 			return err
 		}
@@ -182,7 +347,7 @@ var getContentCommand = cli.Command{
 			return err
 		}
 
-		_, err = io.Copy(f, content.NewReader(ra))
+		_, err = iobuf.Copy(f, content.NewReader(ra))
 		return err
 	},
 }
@@ -200,7 +365,7 @@ func resolveGetDigest(ctx context.Context, desc ocispec.Descriptor, clicontext *
 		if getManifest {
 			return desc, nil
 		}
-		b, err := content.ReadBlob(ctx, store, desc)
+		b, err := iobuf.ReadBlob(ctx, store, desc)
 		if err != nil {
 			return ocispec.Descriptor{}, err
 		}
@@ -221,7 +386,7 @@ func resolveGetDigest(ctx context.Context, desc ocispec.Descriptor, clicontext *
 		if getIndex {
 			return desc, nil
 		}
-		b, err := content.ReadBlob(ctx, store, desc)
+		b, err := iobuf.ReadBlob(ctx, store, desc)
 		if err != nil {
 			return ocispec.Descriptor{}, err
 		}
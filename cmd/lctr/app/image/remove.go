@@ -19,41 +19,98 @@ package image
 import (
 	"context"
 	"fmt"
+	"os"
+	"text/tabwriter"
 
+	"github.com/containerd/lcontainerd/cmd/lctr/app/datadir"
 	"github.com/containerd/lcontainerd/pkg/db"
 	"github.com/urfave/cli"
 )
 
 var removeCommand = cli.Command{
-	Name:        "remove",
-	Aliases:     []string{"rm"},
-	Usage:       "remove an image",
-	ArgsUsage:   "<image name> [flags]",
-	Description: `Removes an image stored locally`,
+	Name:      "remove",
+	Aliases:   []string{"rm"},
+	Usage:     "remove one or more images",
+	ArgsUsage: "[flags] <image name> [<image name>, ...]",
+	Description: `Removes images stored locally.
+
+Images are removed in order, stopping at the first failure unless --keep-going
+is given. Unreferenced content is garbage collected once after all removals.
+
+With --dry-run, nothing is removed. The content which would be garbage
+collected after removing the images is listed along with the total size
+reclaimed. Content shared with other images is not included.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "keep-going",
+			Usage: "continue removing the remaining images after a failure",
+		},
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "show the content which would be reclaimed without removing",
+		},
+	},
 	Action: func(clicontext *cli.Context) error {
 		var (
-			ctx = context.Background()
-			ref = clicontext.Args().First()
+			ctx  = context.Background()
+			refs = clicontext.Args()
 		)
-		if ref == "" {
+		if len(refs) == 0 {
 			return fmt.Errorf("no reference given")
 		}
-		mdb, err := db.NewDB(clicontext.GlobalString("data-dir"))
+		if clicontext.Bool("dry-run") {
+			return reclaimable(ctx, clicontext, refs)
+		}
+		mdb, err := datadir.OpenDB(clicontext)
 		if err != nil {
 			return err
 		}
 
-		imgdb := db.NewImageStore(mdb)
-		if err := imgdb.Delete(ctx, ref); err != nil {
-			mdb.Close(ctx)
-			return err
+		var failed int
+		for _, result := range mdb.RemoveImages(ctx, refs, clicontext.Bool("keep-going")) {
+			if result.Err != nil {
+				fmt.Fprintf(os.Stderr, "%s failed to delete: %v\n", result.Name, result.Err)
+				failed++
+			} else {
+				fmt.Printf("%s successfully deleted\n", result.Name)
+			}
 		}
 		if err := mdb.Close(ctx); err != nil {
 			return err
 		}
-
-		fmt.Printf("%s successfully deleted\n", ref)
+		if failed > 0 {
+			return cli.NewExitError(fmt.Sprintf("failed to remove %d images", failed), 1)
+		}
 
 		return nil
 	},
 }
+
+// reclaimable prints the content which would be removed by garbage collection
+// after removing the images
+func reclaimable(ctx context.Context, clicontext *cli.Context, refs []string) error {
+	mdb, err := datadir.OpenDB(clicontext, db.WithReadOnly)
+	if err != nil {
+		return err
+	}
+	defer mdb.Close(ctx)
+
+	infos, err := mdb.Reclaimable(ctx, refs...)
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	tw := tabwriter.NewWriter(os.Stdout, 8, 3, 1, ' ', 0)
+	fmt.Fprintf(tw, "Digest\tSize\n")
+	fmt.Fprintf(tw, "------\t----\n")
+	for _, info := range infos {
+		fmt.Fprintf(tw, "%s\t%d\n", info.Digest, info.Size)
+		total += info.Size
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("%d bytes reclaimable from %d blobs\n", total, len(infos))
+	return nil
+}
@@ -0,0 +1,144 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/lcontainerd/cmd/lctr/app/datadir"
+	"github.com/containerd/lcontainerd/pkg/db"
+	"github.com/containerd/lcontainerd/pkg/iobuf"
+	"github.com/containerd/lcontainerd/pkg/squash"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/urfave/cli"
+)
+
+var squashCommand = cli.Command{
+	Name:      "squash",
+	Usage:     "squash the layers of an image into a single layer",
+	ArgsUsage: "[flags] <image> <new-image>",
+	Description: `Squashes all the layers of a local image into a single layer.
+
+The layers are merged in order, applying whiteouts, and written as a new
+layer along with a new config and manifest. The result is stored as a new
+image, the source image is left unchanged. Images with multiple platforms
+require --platform to select the manifest to squash.
+`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "platform",
+			Usage: "Platform of the manifest to squash",
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		var (
+			ctx = context.Background()
+			src = clicontext.Args().First()
+			dst = clicontext.Args().Get(1)
+		)
+		if src == "" || dst == "" {
+			return fmt.Errorf("please provide a source image and new image name")
+		}
+
+		mdb, err := datadir.OpenDB(clicontext)
+		if err != nil {
+			return err
+		}
+		defer mdb.Close(ctx)
+
+		imgdb := db.NewImageStore(mdb)
+		if _, err := imgdb.Get(ctx, dst); err == nil {
+			return fmt.Errorf("image %s already exists", dst)
+		}
+		img, err := imgdb.Get(ctx, src)
+		if err != nil {
+			return err
+		}
+
+		cs := mdb.ContentStore()
+		desc, err := platformManifest(ctx, cs, img.Target, clicontext.String("platform"))
+		if err != nil {
+			return err
+		}
+
+		target, err := squash.Squash(ctx, cs, desc)
+		if err != nil {
+			return fmt.Errorf("failed to squash %s: %w", src, err)
+		}
+		target.Platform = desc.Platform
+
+		if _, err := imgdb.Create(ctx, images.Image{
+			Name:   dst,
+			Target: target,
+		}); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "%s %s\n", dst, target.Digest)
+		return nil
+	},
+}
+
+// platformManifest returns the manifest descriptor of the image for the
+// platform. A platform is required to select from an index, when the target
+// is already a manifest the platform is ignored.
+func platformManifest(ctx context.Context, provider content.Provider, target ocispec.Descriptor, platform string) (ocispec.Descriptor, error) {
+	if images.IsManifestType(target.MediaType) {
+		return target, nil
+	}
+	if !images.IsIndexType(target.MediaType) {
+		return ocispec.Descriptor{}, fmt.Errorf("unsupported image media type %s", target.MediaType)
+	}
+	if platform == "" {
+		return ocispec.Descriptor{}, fmt.Errorf("image has multiple platforms, please provide a platform")
+	}
+	p, err := platforms.Parse(platform)
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("unable to parse platform %s: %w", platform, err)
+	}
+	matcher := platforms.Only(p)
+
+	b, err := iobuf.ReadBlob(ctx, provider, target)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	var idx ocispec.Index
+	if err := json.Unmarshal(b, &idx); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	var (
+		match ocispec.Descriptor
+		found bool
+	)
+	for _, m := range idx.Manifests {
+		if m.Platform == nil || !matcher.Match(*m.Platform) || !images.IsManifestType(m.MediaType) {
+			continue
+		}
+		if !found || matcher.Less(*m.Platform, *match.Platform) {
+			match, found = m, true
+		}
+	}
+	if !found {
+		return ocispec.Descriptor{}, fmt.Errorf("no manifest for platform %s", platform)
+	}
+	return match, nil
+}
@@ -0,0 +1,108 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package image
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/lcontainerd/cmd/lctr/app/datadir"
+	"github.com/containerd/lcontainerd/pkg/db"
+	"github.com/urfave/cli"
+)
+
+var tagCommand = cli.Command{
+	Name:      "tag",
+	Usage:     "give an existing image a new name",
+	ArgsUsage: "[flags] <image> <new-image>",
+	Description: `Creates a new image with the target and labels of an existing image.
+
+No content is copied, both names refer to the same target. The existing
+image is left unchanged unless --rename is given, in which case it is
+removed once the new name has been created. Garbage collection only runs
+after both changes, so content shared by the names is never removed.
+
+Use --force to replace the target and labels of an image which already has
+the new name.
+`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "force",
+			Usage: "Overwrite the new image if it already exists",
+		},
+		cli.BoolFlag{
+			Name:  "rename",
+			Usage: "Remove the existing image after tagging",
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		var (
+			ctx = context.Background()
+			src = clicontext.Args().First()
+			dst = clicontext.Args().Get(1)
+		)
+		if src == "" || dst == "" {
+			return fmt.Errorf("please provide a source image and new image name")
+		}
+		if src == dst {
+			return fmt.Errorf("new image name must differ from %s", src)
+		}
+
+		mdb, err := datadir.OpenDB(clicontext)
+		if err != nil {
+			return err
+		}
+		defer mdb.Close(ctx)
+
+		imgdb := db.NewImageStore(mdb)
+		img, err := imgdb.Get(ctx, src)
+		if err != nil {
+			return err
+		}
+
+		// The image store references the target for garbage collection, the
+		// content below the target is already held by its own labels
+		tagged := images.Image{
+			Name:   dst,
+			Target: img.Target,
+			Labels: img.Labels,
+		}
+		if _, err := imgdb.Create(ctx, tagged); err != nil {
+			if !errdefs.IsAlreadyExists(err) {
+				return err
+			}
+			if !clicontext.Bool("force") {
+				return fmt.Errorf("image %s already exists, use --force to overwrite", dst)
+			}
+			if _, err := imgdb.Update(ctx, tagged, "target", "labels"); err != nil {
+				return err
+			}
+		}
+
+		// Only remove the source once the new name holds the target
+		if clicontext.Bool("rename") {
+			if err := imgdb.Delete(ctx, src); err != nil {
+				return fmt.Errorf("failed to remove %s after tagging: %w", src, err)
+			}
+		}
+		fmt.Fprintf(os.Stdout, "%s %s\n", dst, img.Target.Digest)
+		return nil
+	},
+}
@@ -0,0 +1,188 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package image
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/pkg/transfer"
+	"github.com/containerd/lcontainerd/pkg/cli/config"
+	"github.com/containerd/lcontainerd/pkg/cli/progress"
+	"github.com/containerd/lcontainerd/pkg/db"
+	"github.com/urfave/cli"
+)
+
+// progressSocketFlag serves progress to a separate process, such as a user
+// interface, rather than displaying it
+var progressSocketFlag = cli.StringFlag{
+	Name:  "progress-socket",
+	Usage: "serve progress to a single client on a unix socket as JSON lines, or proto messages with --progress=proto",
+}
+
+// statusLineFlag displays progress as a single line, suited for logs and
+// terminals which should not be redrawn
+var statusLineFlag = cli.BoolFlag{
+	Name:  "status-line",
+	Usage: "display progress as a single updating status line",
+}
+
+// progressFlag selects how progress is output, the tree is redrawn when the
+// output is a terminal by default, otherwise a line is written per change
+var progressFlag = cli.StringFlag{
+	Name:  "progress",
+	Usage: "progress output, one of auto, tty, plain, json or proto",
+	Value: "auto",
+}
+
+// protoOutFlag is kept as an alias for --progress=proto
+var protoOutFlag = cli.BoolFlag{
+	Name:  "proto-out",
+	Usage: "output progress directly to stdout as proto messages, alias for --progress=proto",
+}
+
+// progressIntervalFlag limits how often the progress is redrawn, avoiding
+// flooding slow terminals when events arrive quickly
+var progressIntervalFlag = cli.DurationFlag{
+	Name:  "progress-interval",
+	Usage: "minimum time between progress redraws, redraw on every event when 0",
+	Value: 100 * time.Millisecond,
+}
+
+// leaseFlag holds the transferred content in a lease which remains after
+// the command, rather than a temporary lease
+var leaseFlag = cli.StringFlag{
+	Name:  "lease",
+	Usage: "hold transferred content in the lease with the given id, creating it if needed",
+}
+
+// withLeaseFlag returns a context using the lease from the --lease flag, the
+// lease is created when it does not exist.
+func withLeaseFlag(ctx context.Context, clicontext *cli.Context, mdb *db.DB) (context.Context, error) {
+	id := clicontext.String("lease")
+	if id == "" {
+		return ctx, nil
+	}
+	return db.WithLease(ctx, db.NewLeaseManager(mdb), id)
+}
+
+// applyConfig sets flags which were not given on the command line from the
+// config file passed with the global --config flag, it is used as the Before
+// of each command which transfers content so all read the same options.
+func applyConfig(clicontext *cli.Context) error {
+	path := clicontext.GlobalString("config")
+	if path == "" {
+		return nil
+	}
+	c, err := config.Load(path)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, f := range clicontext.Command.Flags {
+		for _, name := range strings.Split(f.GetName(), ",") {
+			names = append(names, strings.TrimSpace(name))
+		}
+	}
+	return c.Apply(clicontext, names)
+}
+
+// progressFormat returns the progress output selected with --progress,
+// --proto-out may be used in place of --progress=proto
+func progressFormat(clicontext *cli.Context) (string, error) {
+	format := clicontext.String("progress")
+	if clicontext.Bool("proto-out") {
+		if format != "" && format != "auto" && format != "proto" {
+			return "", fmt.Errorf("--proto-out may not be combined with --progress=%s", format)
+		}
+		format = "proto"
+	}
+	switch format {
+	case "", "auto", "tty", "plain", "json", "proto":
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown progress output %q, must be one of auto, tty, plain, json or proto", format)
+}
+
+// runTransfer runs the transfer with the progress output configured from
+// the cli flags. When the transfer fails, the object named by the error is
+// reported as failed and the others still in flight as aborted.
+func runTransfer(ctx context.Context, clicontext *cli.Context, ts transfer.Transferrer, src, dst interface{}) error {
+	return runTransferTo(ctx, clicontext, ts, src, dst, os.Stdout)
+}
+
+// runTransferTo runs the transfer like runTransfer, displaying progress on
+// out rather than stdout, such as when stdout is the destination
+func runTransferTo(ctx context.Context, clicontext *cli.Context, ts transfer.Transferrer, src, dst interface{}, out io.Writer) error {
+	var (
+		pf     transfer.ProgressFunc
+		socket = clicontext.String("progress-socket")
+	)
+	format, err := progressFormat(clicontext)
+	if err != nil {
+		return err
+	}
+	if socket != "" {
+		s, err := progress.ListenSocket(ctx, socket)
+		if err != nil {
+			return fmt.Errorf("failed to listen on progress socket: %w", err)
+		}
+		defer s.Close()
+		out = s
+	}
+	switch format {
+	case "proto":
+		pf = progress.ForwardProto(ctx, out)
+	case "json":
+		pf = progress.ForwardJSON(ctx, out)
+	default:
+		if socket != "" {
+			pf = progress.ForwardJSON(ctx, out)
+			break
+		}
+		if clicontext.Bool("status-line") {
+			pf = progress.StatusLine(ctx, out)
+			// End the status line once the transfer is done
+			defer fmt.Fprintln(out)
+			break
+		}
+		var r *progress.Renderer
+		switch format {
+		case "tty":
+			r = progress.Hierarchical(ctx, out, clicontext.Duration("progress-interval"))
+		case "plain":
+			r = progress.Plain(ctx, out)
+		default:
+			r = progress.Auto(ctx, out, clicontext.Duration("progress-interval"))
+		}
+		// Render the final state once the transfer is done
+		defer r.Close()
+		pf = r.Progress
+	}
+
+	ft := progress.NewFailureTracker(pf)
+	if err := ts.Transfer(ctx, src, dst, transfer.WithProgress(ft.Progress)); err != nil {
+		ft.Fail(err)
+		return err
+	}
+
+	return nil
+}
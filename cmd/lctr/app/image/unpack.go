@@ -0,0 +1,146 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/containerd/containerd/diff/apply"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/lcontainerd/cmd/lctr/app/datadir"
+	"github.com/containerd/lcontainerd/pkg/db"
+	"github.com/containerd/lcontainerd/pkg/iobuf"
+	"github.com/containerd/lcontainerd/pkg/unpack"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/urfave/cli"
+)
+
+var unpackCommand = cli.Command{
+	Name:      "unpack",
+	Usage:     "unpack the layers of an image into a snapshotter",
+	ArgsUsage: "[flags] <image>",
+	Description: `Unpacks the layers of a local image into a snapshotter.
+
+Each layer is applied on top of the snapshot of the layers before it and
+committed under its chain ID, layers already unpacked are not applied again.
+The snapshot key and chain ID of the top layer are printed, the snapshot may
+be used as the parent of a container root filesystem.
+
+The snapshotter is given labels describing the image reference, manifest,
+and layer when preparing the snapshot of each layer, along with any labels
+given with --snapshotter-label. Remote snapshotters use these labels to fetch
+the layer contents lazily.
+
+The snapshots are referenced from the image config, they are kept until the
+image config is garbage collected. Images with multiple platforms unpack the
+manifest for the current platform unless --platform is given.
+`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "snapshotter",
+			Usage: "Snapshotter to unpack into",
+			Value: "native",
+		},
+		cli.StringFlag{
+			Name:  "platform",
+			Usage: "Platform of the manifest to unpack",
+		},
+		cli.StringSliceFlag{
+			Name:  "snapshotter-label",
+			Usage: "Labels to pass to the snapshotter when preparing each layer",
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		var (
+			ctx  = context.Background()
+			ref  = clicontext.Args().First()
+			name = clicontext.String("snapshotter")
+		)
+		if ref == "" {
+			return fmt.Errorf("please provide an image to unpack")
+		}
+		labels, err := keyValueArgs(clicontext.StringSlice("snapshotter-label"), "")
+		if err != nil {
+			return err
+		}
+		platform := clicontext.String("platform")
+		if platform == "" {
+			platform = platforms.DefaultString()
+		}
+
+		if err := datadir.PrepareSnapshotter(clicontext, name); err != nil {
+			return err
+		}
+		mdb, err := datadir.OpenDB(clicontext)
+		if err != nil {
+			return err
+		}
+		defer mdb.Close(ctx)
+
+		img, err := db.NewImageStore(mdb).Get(ctx, ref)
+		if err != nil {
+			return err
+		}
+
+		cs := mdb.ContentStore()
+		desc, err := platformManifest(ctx, cs, img.Target, platform)
+		if err != nil {
+			return err
+		}
+		b, err := iobuf.ReadBlob(ctx, cs, desc)
+		if err != nil {
+			return err
+		}
+		var manifest ocispec.Manifest
+		if err := json.Unmarshal(b, &manifest); err != nil {
+			return err
+		}
+
+		uopts := []unpack.Opt{unpack.WithImageRef(img.Name), unpack.WithSnapshotterLabels(labels)}
+		chainID, err := unpack.Unpack(ctx, cs, mdb.Snapshotter(name), apply.NewFileSystemApplier(cs), desc, uopts...)
+		if err != nil {
+			return fmt.Errorf("failed to unpack %s: %w", ref, err)
+		}
+
+		// Reference the snapshot from the config so it is not collected
+		// while the image is retained
+		info, err := cs.Info(ctx, manifest.Config.Digest)
+		if err != nil {
+			return err
+		}
+		labelKey := fmt.Sprintf("containerd.io/gc.ref.snapshot.%s", name)
+		if info.Labels[labelKey] != chainID.String() {
+			if info.Labels == nil {
+				info.Labels = map[string]string{}
+			}
+			info.Labels[labelKey] = chainID.String()
+			if _, err := cs.Update(ctx, info, "labels."+labelKey); err != nil {
+				return err
+			}
+		}
+
+		sinfo, err := mdb.Snapshotter(name).Stat(ctx, chainID.String())
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "snapshot key: %s\nchain ID: %s\n", sinfo.Name, chainID)
+		return nil
+	},
+}
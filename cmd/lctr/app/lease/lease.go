@@ -23,34 +23,120 @@ import (
 	"strings"
 	"text/tabwriter"
 
+	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/leases"
+	"github.com/containerd/lcontainerd/cmd/lctr/app/datadir"
+	"github.com/containerd/lcontainerd/cmd/lctr/app/listing"
 	"github.com/containerd/lcontainerd/pkg/db"
 	"github.com/urfave/cli"
 )
 
+const labelGCExpire = "containerd.io/gc.expire"
+
 // Command is the cli command for managing images
 var Command = cli.Command{
 	Name:    "lease",
 	Aliases: []string{"l"},
 	Usage:   "manage leases",
 	Subcommands: cli.Commands{
+		createLeaseCommand,
 		listLeaseCommand,
 		inspectLeaseCommand,
+		updateLeaseCommand,
 		removeLeaseCommand,
+		addResourceCommand,
+		deleteResourceCommand,
+	},
+}
+
+var createLeaseCommand = cli.Command{
+	Name:      "create",
+	Usage:     "create a lease",
+	ArgsUsage: "[lease id] [flags]",
+	Description: `Creates an empty lease, a random ID is used when none is given.
+
+Use "lease add-resource" to add resources to the lease.
+`,
+	Flags: []cli.Flag{
+		cli.DurationFlag{
+			Name:  "expiration",
+			Usage: "When to expire the lease",
+		},
+		cli.StringSliceFlag{
+			Name:  "label",
+			Usage: "Labels to add to the lease (key=value)",
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		var (
+			ctx  = context.Background()
+			opts []leases.Opt
+		)
+		if id := clicontext.Args().First(); id != "" {
+			opts = append(opts, leases.WithID(id))
+		} else {
+			opts = append(opts, leases.WithRandomID())
+		}
+		labels, err := parseLabels(clicontext.StringSlice("label"))
+		if err != nil {
+			return err
+		}
+		if len(labels) > 0 {
+			opts = append(opts, leases.WithLabels(labels))
+		}
+		if d := clicontext.Duration("expiration"); d > 0 {
+			opts = append(opts, leases.WithExpiration(d))
+		}
+
+		mdb, err := datadir.OpenDB(clicontext)
+		if err != nil {
+			return err
+		}
+		defer mdb.Close(ctx)
+
+		lease, err := db.NewLeaseManager(mdb).Create(ctx, opts...)
+		if err != nil {
+			if errdefs.IsAlreadyExists(err) {
+				return fmt.Errorf("lease %q already exists, use a different ID: %w", clicontext.Args().First(), errdefs.ErrAlreadyExists)
+			}
+			return err
+		}
+
+		fmt.Fprintf(os.Stdout, "Created lease %s\n", lease.ID)
+		return nil
 	},
 }
 
 var listLeaseCommand = cli.Command{
-	Name:        "list",
-	Aliases:     []string{"ls"},
-	Usage:       "list all leases",
-	ArgsUsage:   "[flags]",
-	Description: `Lists all leases`,
+	Name:      "list",
+	Aliases:   []string{"ls"},
+	Usage:     "list all leases",
+	ArgsUsage: "[flags]",
+	Description: `Lists all leases.
+
+Use --since and --until to only list leases created within a time range.
+The range includes --since and excludes --until.
+
+Use --filter to only list leases matching a filter, such as
+labels."containerd.io/gc.expire" to list expiring leases.
+`,
+	Flags: append([]cli.Flag{
+		listing.FilterFlag,
+		cli.BoolFlag{
+			Name:  "quiet, q",
+			Usage: "only print the lease IDs",
+		},
+	}, listing.TimeFlags...),
 	Action: func(clicontext *cli.Context) error {
 		var (
 			ctx = context.Background()
 		)
-		mdb, err := db.NewDB(clicontext.GlobalString("data-dir"), db.WithReadOnly)
+		created, err := listing.TimeRange(clicontext)
+		if err != nil {
+			return err
+		}
+
+		mdb, err := datadir.OpenDB(clicontext, db.WithReadOnly)
 		if err != nil {
 			return err
 		}
@@ -58,16 +144,28 @@ var listLeaseCommand = cli.Command{
 
 		lm := db.NewLeaseManager(mdb)
 
-		leases, err := lm.List(ctx)
+		leases, err := lm.List(ctx, clicontext.StringSlice("filter")...)
 		if err != nil {
 			return err
 		}
 
+		if clicontext.Bool("quiet") {
+			for _, l := range leases {
+				if created.Match(l.CreatedAt) {
+					fmt.Println(l.ID)
+				}
+			}
+			return nil
+		}
+
 		tw := tabwriter.NewWriter(os.Stdout, 8, 3, 1, ' ', 0)
 		fmt.Fprintf(tw, "Lease ID\tCreated At\tLabels\n")
 		fmt.Fprintf(tw, "----------\t------\t----------\n")
 
 		for _, l := range leases {
+			if !created.Match(l.CreatedAt) {
+				continue
+			}
 			fmt.Fprintf(tw, "%s\t%s\t%s\n", l.ID, l.CreatedAt, formatLabels(l.Labels))
 		}
 
@@ -88,7 +186,7 @@ var inspectLeaseCommand = cli.Command{
 		if lid == "" {
 			return fmt.Errorf("must provide a lease ID")
 		}
-		mdb, err := db.NewDB(clicontext.GlobalString("data-dir"), db.WithReadOnly)
+		mdb, err := datadir.OpenDB(clicontext, db.WithReadOnly)
 		if err != nil {
 			return err
 		}
@@ -113,6 +211,85 @@ var inspectLeaseCommand = cli.Command{
 	},
 }
 
+var updateLeaseCommand = cli.Command{
+	Name:      "update",
+	Usage:     "update the expiration and labels of a lease",
+	ArgsUsage: "<lease id> [flags]",
+	Description: `Updates the labels of a lease.
+
+Use --expiration to set the lease to expire after the given duration from
+now, an expiration of 0 removes the expiration making the lease permanent.
+`,
+	Flags: []cli.Flag{
+		cli.DurationFlag{
+			Name:  "expiration",
+			Usage: "When to expire the lease, 0 to never expire",
+		},
+		cli.StringSliceFlag{
+			Name:  "label",
+			Usage: "Labels to set on the lease (key=value)",
+		},
+		cli.StringSliceFlag{
+			Name:  "remove-label",
+			Usage: "Labels to remove from the lease",
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		var (
+			ctx = context.Background()
+			lid = clicontext.Args().First()
+		)
+		if lid == "" {
+			return fmt.Errorf("must provide a lease ID")
+		}
+		labels, err := parseLabels(clicontext.StringSlice("label"))
+		if err != nil {
+			return err
+		}
+
+		mdb, err := datadir.OpenDB(clicontext)
+		if err != nil {
+			return err
+		}
+		defer mdb.Close(ctx)
+
+		ll, err := db.NewLeaseManager(mdb).List(ctx, fmt.Sprintf("id==%q", lid))
+		if err != nil {
+			return err
+		}
+		if len(ll) == 0 {
+			return fmt.Errorf("lease %q: %w", lid, errdefs.ErrNotFound)
+		}
+		lease := ll[0]
+		if lease.Labels == nil {
+			lease.Labels = map[string]string{}
+		}
+
+		for k, v := range labels {
+			lease.Labels[k] = v
+		}
+		for _, k := range clicontext.StringSlice("remove-label") {
+			delete(lease.Labels, k)
+		}
+		if clicontext.IsSet("expiration") {
+			if d := clicontext.Duration("expiration"); d > 0 {
+				if err := leases.WithExpiration(d)(&lease); err != nil {
+					return err
+				}
+			} else {
+				delete(lease.Labels, labelGCExpire)
+			}
+		}
+
+		if _, err := mdb.UpdateLease(ctx, lease); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stdout, "Updated lease %s\n", lid)
+		return nil
+	},
+}
+
 var removeLeaseCommand = cli.Command{
 	Name:        "remove",
 	Aliases:     []string{"rm"},
@@ -127,7 +304,7 @@ var removeLeaseCommand = cli.Command{
 		if lid == "" {
 			return fmt.Errorf("must provide a lease ID")
 		}
-		mdb, err := db.NewDB(clicontext.GlobalString("data-dir"))
+		mdb, err := datadir.OpenDB(clicontext)
 		if err != nil {
 			return err
 		}
@@ -145,6 +322,90 @@ var removeLeaseCommand = cli.Command{
 	},
 }
 
+var addResourceCommand = cli.Command{
+	Name:      "add-resource",
+	Aliases:   []string{"add"},
+	Usage:     "add a resource to a lease",
+	ArgsUsage: "<lease id> <type> <id>",
+	Description: `Adds a resource to a lease, preventing it from being garbage collected
+until the lease is removed.
+
+The type is one of "content", "ingest" or "snapshots/<snapshotter>".
+`,
+	Action: func(clicontext *cli.Context) error {
+		return updateResource(clicontext, func(ctx context.Context, lm leases.Manager, l leases.Lease, r leases.Resource) error {
+			return lm.AddResource(ctx, l, r)
+		})
+	},
+}
+
+var deleteResourceCommand = cli.Command{
+	Name:      "delete-resource",
+	Usage:     "delete a resource from a lease",
+	ArgsUsage: "<lease id> <type> <id>",
+	Description: `Deletes a resource from a lease.
+
+Resources which are no longer referenced are removed by the next garbage
+collection.
+`,
+	Action: func(clicontext *cli.Context) error {
+		return updateResource(clicontext, func(ctx context.Context, lm leases.Manager, l leases.Lease, r leases.Resource) error {
+			return lm.DeleteResource(ctx, l, r)
+		})
+	},
+}
+
+func updateResource(clicontext *cli.Context, fn func(context.Context, leases.Manager, leases.Lease, leases.Resource) error) error {
+	var (
+		ctx  = context.Background()
+		args = clicontext.Args()
+	)
+	if len(args) != 3 {
+		return fmt.Errorf("must provide a lease ID, resource type and resource ID")
+	}
+	typ, err := resourceType(args[1])
+	if err != nil {
+		return err
+	}
+
+	mdb, err := datadir.OpenDB(clicontext)
+	if err != nil {
+		return err
+	}
+	defer mdb.Close(ctx)
+
+	return fn(ctx, db.NewLeaseManager(mdb), leases.Lease{ID: args[0]}, leases.Resource{
+		ID:   args[2],
+		Type: typ,
+	})
+}
+
+// resourceType returns the lease resource type for the given argument
+func resourceType(typ string) (string, error) {
+	switch typ {
+	case "content":
+		return typ, nil
+	case "ingest", "ingests":
+		return "ingests", nil
+	}
+	if name := strings.TrimPrefix(typ, "snapshots/"); name != typ && name != "" && !strings.Contains(name, "/") {
+		return typ, nil
+	}
+	return "", fmt.Errorf("unknown resource type %q, must be content, ingest or snapshots/<snapshotter>", typ)
+}
+
+func parseLabels(args []string) (map[string]string, error) {
+	labels := make(map[string]string, len(args))
+	for _, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid label %q, must be key=value", arg)
+		}
+		labels[parts[0]] = parts[1]
+	}
+	return labels, nil
+}
+
 func formatLabels(l map[string]string) string {
 	var ls []string
 	for k, v := range l {
@@ -0,0 +1,115 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package listing provides the cli flags for paginating and filtering list
+// commands.
+package listing
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/containerd/lcontainerd/pkg/db"
+	"github.com/urfave/cli"
+)
+
+// PageFlags are the flags used to select a page of a listing
+var PageFlags = []cli.Flag{
+	cli.IntFlag{
+		Name:  "limit",
+		Usage: "maximum number of entries to list, 0 for no limit",
+	},
+	cli.IntFlag{
+		Name:  "offset",
+		Usage: "number of entries to skip before listing",
+	},
+	cli.StringFlag{
+		Name:  "next",
+		Usage: "token from a previous listing to continue listing from",
+	},
+}
+
+// FilterFlag selects the entries to list with containerd filter syntax
+var FilterFlag = cli.StringSliceFlag{
+	Name:  "filter",
+	Usage: "only list entries matching the filter, such as labels.\"containerd.io/gc.root\", entries matching any filter are listed",
+}
+
+// TimeFlags are the flags used to select entries by time
+var TimeFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "since",
+		Usage: "only list entries at or after a time, given as RFC3339 or a duration before now",
+	},
+	cli.StringFlag{
+		Name:  "until",
+		Usage: "only list entries before a time, given as RFC3339 or a duration before now",
+	},
+}
+
+// Page returns the page selected by the cli flags
+func Page(clicontext *cli.Context) (db.Page, error) {
+	r, err := TimeRange(clicontext)
+	if err != nil {
+		return db.Page{}, err
+	}
+	return db.Page{
+		Limit:   clicontext.Int("limit"),
+		Offset:  clicontext.Int("offset"),
+		Token:   clicontext.String("next"),
+		Updated: r,
+	}, nil
+}
+
+// TimeRange returns the time range selected by the cli flags
+func TimeRange(clicontext *cli.Context) (db.TimeRange, error) {
+	var (
+		r   db.TimeRange
+		now = time.Now()
+		err error
+	)
+	if s := clicontext.String("since"); s != "" {
+		if r.Since, err = parseTime(s, now); err != nil {
+			return r, fmt.Errorf("invalid --since: %w", err)
+		}
+	}
+	if s := clicontext.String("until"); s != "" {
+		if r.Until, err = parseTime(s, now); err != nil {
+			return r, fmt.Errorf("invalid --until: %w", err)
+		}
+	}
+	return r, nil
+}
+
+// parseTime parses an RFC3339 time or a duration before now
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not an RFC3339 time or duration", s)
+	}
+	return t, nil
+}
+
+// PrintNext prints the token to continue listing from, if any
+func PrintNext(w io.Writer, next string) {
+	if next != "" {
+		fmt.Fprintf(w, "\nMore entries available, continue with --next %s\n", next)
+	}
+}
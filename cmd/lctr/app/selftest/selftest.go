@@ -0,0 +1,334 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package selftest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/leases"
+	"github.com/containerd/containerd/pkg/transfer/registry"
+	"github.com/containerd/lcontainerd/cmd/lctr/app/datadir"
+	"github.com/containerd/lcontainerd/pkg/cli/credentials"
+	"github.com/containerd/lcontainerd/pkg/db"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/urfave/cli"
+)
+
+const (
+	testImage = "selftest.lctr.local/selftest:latest"
+	testHost  = "selftest.lctr.local"
+)
+
+// Command is the cli command for testing lctr on the current platform
+var Command = cli.Command{
+	Name:      "selftest",
+	Usage:     "test lctr and its stores on this platform",
+	ArgsUsage: "[flags]",
+	Description: `Runs the core operations of lctr in a temporary data directory.
+
+Each step is reported as passed, failed, or skipped along with how long
+it took. The configured data directory is not used or modified. The
+system credential store is only read from, use --skip-credentials to
+skip credential store checks on systems without one.
+`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "skip-credentials",
+			Usage: "skip testing the credential stores",
+		},
+		cli.DurationFlag{
+			Name:  "credential-timeout",
+			Usage: "maximum time to wait on the system credential store",
+			Value: 10 * time.Second,
+		},
+		cli.BoolFlag{
+			Name:  "keep",
+			Usage: "keep the temporary data directory after testing",
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		var (
+			ctx = context.Background()
+			st  = &selftest{}
+		)
+		mode, err := datadir.Mode(clicontext)
+		if err != nil {
+			return err
+		}
+		root, err := os.MkdirTemp("", "lctr-selftest-")
+		if err != nil {
+			return err
+		}
+		if clicontext.Bool("keep") {
+			fmt.Fprintf(os.Stdout, "Data directory: %s\n\n", root)
+		} else {
+			defer os.RemoveAll(root)
+		}
+
+		var (
+			mdb  *db.DB
+			blob = []byte("lctr selftest content")
+			desc = ocispec.Descriptor{
+				MediaType: "application/vnd.lctr.selftest",
+				Digest:    digest.FromBytes(blob),
+				Size:      int64(len(blob)),
+			}
+			manifest ocispec.Descriptor
+		)
+		st.run("open database", func() (err error) {
+			mdb, err = db.NewDB(filepath.Join(root, "data"), db.WithDirMode(mode))
+			return
+		})
+		st.run("write blob", func() error {
+			return content.WriteBlob(ctx, mdb.ContentStore(), "selftest-blob", bytes.NewReader(blob), desc)
+		})
+		st.run("read blob", func() error {
+			b, err := content.ReadBlob(ctx, mdb.ContentStore(), desc)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(b, blob) {
+				return fmt.Errorf("read content does not match written content")
+			}
+			return nil
+		})
+		st.run("create image", func() (err error) {
+			manifest, err = writeManifest(ctx, mdb.ContentStore(), desc)
+			if err != nil {
+				return err
+			}
+			_, err = db.NewImageStore(mdb).Create(ctx, images.Image{
+				Name:   testImage,
+				Target: manifest,
+			})
+			return
+		})
+		st.run("list images", func() error {
+			imgs, err := db.NewImageStore(mdb).List(ctx)
+			if err != nil {
+				return err
+			}
+			for _, img := range imgs {
+				if img.Name == testImage && img.Target.Digest == manifest.Digest {
+					return nil
+				}
+			}
+			return fmt.Errorf("created image %s not listed", testImage)
+		})
+		st.run("garbage collect", func() error {
+			if _, err := mdb.GarbageCollect(ctx); err != nil {
+				return err
+			}
+			if _, err := mdb.ContentStore().Info(ctx, desc.Digest); err != nil {
+				return fmt.Errorf("referenced content removed: %w", err)
+			}
+			if err := db.NewImageStore(mdb).Delete(ctx, testImage); err != nil {
+				return err
+			}
+			if _, err := mdb.GarbageCollect(ctx); err != nil {
+				return err
+			}
+			if _, err := mdb.ContentStore().Info(ctx, desc.Digest); !errdefs.IsNotFound(err) {
+				return fmt.Errorf("unreferenced content not removed: %v", err)
+			}
+			return nil
+		})
+		st.run("lease content", func() error {
+			lm := db.NewLeaseManager(mdb)
+			l, err := lm.Create(ctx, leases.WithRandomID())
+			if err != nil {
+				return err
+			}
+			lctx := leases.WithLease(ctx, l.ID)
+			if err := content.WriteBlob(lctx, mdb.ContentStore(), "selftest-leased", bytes.NewReader(blob), desc); err != nil {
+				return err
+			}
+			if _, err := mdb.GarbageCollect(ctx); err != nil {
+				return err
+			}
+			if _, err := mdb.ContentStore().Info(ctx, desc.Digest); err != nil {
+				return fmt.Errorf("leased content removed: %w", err)
+			}
+			return lm.Delete(ctx, l)
+		})
+		st.run("close database", func() error {
+			defer func() { mdb = nil }()
+			return mdb.Close(ctx)
+		})
+		if mdb != nil {
+			mdb.Close(ctx)
+		}
+
+		// Credential stores do not depend on the database
+		st.group()
+		if clicontext.Bool("skip-credentials") {
+			st.skip("local credentials")
+			st.skip("system credentials")
+		} else {
+			st.run("local credentials", func() error {
+				return testLocalCredentials(ctx, filepath.Join(root, "credentials"))
+			})
+			st.run("system credentials", func() error {
+				// Only lookup to avoid leaving entries in the system store
+				helper, err := credentials.NewKeychainCredentialHelper(testImage, "", credentials.WithTimeout(clicontext.Duration("credential-timeout")))
+				if err != nil {
+					return err
+				}
+				_, err = helper.GetCredentials(ctx, testImage, testHost)
+				return err
+			})
+		}
+
+		if err := st.print(); err != nil {
+			return err
+		}
+		if st.failed > 0 {
+			return fmt.Errorf("selftest failed: %d of %d steps failed", st.failed, len(st.results))
+		}
+		return nil
+	},
+}
+
+type result struct {
+	step     string
+	status   string
+	duration time.Duration
+	err      error
+}
+
+type selftest struct {
+	results []result
+	failed  int
+	// broken is set when a step in the current group failed
+	broken bool
+}
+
+// group starts a group of steps which do not depend on earlier steps
+func (st *selftest) group() {
+	st.broken = false
+}
+
+// run runs the step, steps are skipped after an earlier step in the same
+// group failed since they depend on the state left by earlier steps
+func (st *selftest) run(step string, fn func() error) {
+	if st.broken {
+		st.skip(step)
+		return
+	}
+	start := time.Now()
+	err := fn()
+	r := result{
+		step:     step,
+		status:   "PASS",
+		duration: time.Since(start),
+		err:      err,
+	}
+	if err != nil {
+		r.status = "FAIL"
+		st.failed++
+		st.broken = true
+	}
+	st.results = append(st.results, r)
+}
+
+func (st *selftest) skip(step string) {
+	st.results = append(st.results, result{
+		step:   step,
+		status: "SKIP",
+	})
+}
+
+func (st *selftest) print() error {
+	tw := tabwriter.NewWriter(os.Stdout, 8, 3, 1, ' ', 0)
+	fmt.Fprintf(tw, "Step\tResult\tDuration\tError\n")
+	fmt.Fprintf(tw, "----\t------\t--------\t-----\n")
+	for _, r := range st.results {
+		var d, e string
+		if r.status != "SKIP" {
+			d = r.duration.Round(time.Microsecond).String()
+		}
+		if r.err != nil {
+			e = r.err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.step, r.status, d, e)
+	}
+	return tw.Flush()
+}
+
+func writeManifest(ctx context.Context, cs content.Store, config ocispec.Descriptor) (ocispec.Descriptor, error) {
+	b, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{
+			SchemaVersion: 2,
+		},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []ocispec.Descriptor{},
+	})
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	desc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Digest:    digest.FromBytes(b),
+		Size:      int64(len(b)),
+	}
+	labels := map[string]string{
+		"containerd.io/gc.ref.content.config": config.Digest.String(),
+	}
+	if err := content.WriteBlob(ctx, cs, desc.Digest.String()+"-ingest", bytes.NewReader(b), desc, content.WithLabels(labels)); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	return desc, nil
+}
+
+func testLocalCredentials(ctx context.Context, dir string) error {
+	var (
+		encdec = credentials.NewUnencryptedJSON()
+		creds  = registry.Credentials{
+			Host:     testHost,
+			Username: "selftest",
+			Secret:   "selftest-secret",
+		}
+	)
+	if err := credentials.StoreCredentialsLocal(ctx, dir, testHost, creds, encdec); err != nil {
+		return err
+	}
+	helper, err := credentials.NewLocalCredentialHelper(testImage, creds.Username, dir, encdec)
+	if err != nil {
+		return err
+	}
+	stored, err := helper.GetCredentials(ctx, testImage, testHost)
+	if err != nil {
+		return err
+	}
+	if stored != creds {
+		return fmt.Errorf("stored credentials do not match")
+	}
+	return nil
+}
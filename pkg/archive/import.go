@@ -0,0 +1,138 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package archive provides the destination for images imported from OCI
+// archives with the transfer service.
+package archive
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/images/archive"
+	"github.com/containerd/containerd/pkg/transfer"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// refSourceAnnotation is set by the transfer service on each manifest of
+// the imported index to indicate the image name comes from the annotations
+const refSourceAnnotation = "io.containerd.import.ref-source"
+
+// ImportOpt configures an import store
+type ImportOpt func(*ImportStore)
+
+// WithIndexName stores the imported index as an image with the given name
+func WithIndexName(name string) ImportOpt {
+	return func(s *ImportStore) {
+		s.indexName = name
+	}
+}
+
+// WithNamePrefix sets the image name used as the prefix for reference names
+// which only have a tag. Full reference names are used as is.
+func WithNamePrefix(prefix string) ImportOpt {
+	return func(s *ImportStore) {
+		s.prefix = prefix
+	}
+}
+
+// ImportStore stores the images imported from an archive. Each manifest in
+// the archive's index is stored using the name from its
+// org.opencontainers.image.ref.name or io.containerd.image.name annotation.
+// Manifests without a name annotation are not stored.
+type ImportStore struct {
+	indexName string
+	prefix    string
+}
+
+var _ transfer.ImageStorer = &ImportStore{}
+
+// NewImportStore returns a new store for imported images
+func NewImportStore(opts ...ImportOpt) *ImportStore {
+	s := &ImportStore{}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *ImportStore) String() string {
+	if s.indexName != "" {
+		return fmt.Sprintf("Local Image Store (%s)", s.indexName)
+	}
+	return "Local Image Store"
+}
+
+// Store creates or updates the image for the descriptor, returning
+// errdefs.ErrNotFound when the descriptor has no name to store as
+func (s *ImportStore) Store(ctx context.Context, desc ocispec.Descriptor, store images.Store) ([]images.Image, error) {
+	var name string
+	if _, ok := desc.Annotations[refSourceAnnotation]; ok {
+		annotations := make(map[string]string, len(desc.Annotations)-1)
+		for k, v := range desc.Annotations {
+			if k != refSourceAnnotation {
+				annotations[k] = v
+			}
+		}
+		if len(annotations) == 0 {
+			annotations = nil
+		}
+		desc.Annotations = annotations
+		name = s.imageName(desc.Annotations)
+	} else {
+		name = s.indexName
+	}
+	if name == "" {
+		return nil, fmt.Errorf("no image name found: %w", errdefs.ErrNotFound)
+	}
+
+	img := images.Image{
+		Name:   name,
+		Target: desc,
+	}
+	for {
+		created, err := store.Create(ctx, img)
+		if err == nil {
+			return []images.Image{created}, nil
+		} else if !errdefs.IsAlreadyExists(err) {
+			return nil, err
+		}
+
+		updated, err := store.Update(ctx, img)
+		if err == nil {
+			return []images.Image{updated}, nil
+		} else if !errdefs.IsNotFound(err) {
+			return nil, err
+		}
+		// Image was removed after create failed, try again
+	}
+}
+
+// imageName returns the name of the image from the annotations. The
+// containerd image name is always a full reference and used as is, the OCI
+// reference name may be only a tag and is added to the prefix.
+func (s *ImportStore) imageName(annotations map[string]string) string {
+	if name := annotations[images.AnnotationImageName]; name != "" {
+		return name
+	}
+	name := annotations[ocispec.AnnotationRefName]
+	if name != "" && s.prefix != "" {
+		name = archive.AddRefPrefix(s.prefix)(name)
+	}
+	return name
+}
@@ -0,0 +1,199 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+	"testing"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/namespaces"
+	transferarchive "github.com/containerd/containerd/pkg/transfer/archive"
+	"github.com/containerd/containerd/pkg/transfer/local"
+	"github.com/containerd/lcontainerd/pkg/db"
+	"github.com/google/go-cmp/cmp"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestImportRefName(t *testing.T) {
+	ctx := namespaces.WithNamespace(context.Background(), "testing")
+	layout := testLayout(t, map[string]string{
+		"v1":                 "",
+		"example.com/foo:v2": "",
+		"":                   "",
+		"v3":                 "example.com/other:v3",
+	})
+
+	for _, tc := range []struct {
+		name     string
+		opts     []ImportOpt
+		expected []string
+	}{
+		{
+			name:     "NoPrefix",
+			expected: []string{"example.com/foo:v2", "example.com/other:v3", "v1"},
+		},
+		{
+			name:     "Prefix",
+			opts:     []ImportOpt{WithNamePrefix("example.com/foo")},
+			expected: []string{"example.com/foo:v1", "example.com/foo:v2", "example.com/other:v3"},
+		},
+		{
+			name:     "IndexName",
+			opts:     []ImportOpt{WithIndexName("index"), WithNamePrefix("example.com/foo")},
+			expected: []string{"example.com/foo:v1", "example.com/foo:v2", "example.com/other:v3", "index"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mdb, err := db.NewDB(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer mdb.Close(ctx)
+
+			is := db.NewImageStore(mdb)
+			ts := local.NewTransferService(db.NewLeaseManager(mdb), mdb.ContentStore(), is, &local.TransferConfig{})
+			iis := transferarchive.NewImageImportStream(bytes.NewReader(layout), "")
+			if err := ts.Transfer(ctx, iis, NewImportStore(tc.opts...)); err != nil {
+				t.Fatal(err)
+			}
+
+			imgs, err := is.List(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, img := range imgs {
+				names = append(names, img.Name)
+				if _, ok := img.Target.Annotations[refSourceAnnotation]; ok {
+					t.Errorf("%s target has import annotation", img.Name)
+				}
+			}
+			sort.Strings(names)
+			if diff := cmp.Diff(tc.expected, names); diff != "" {
+				t.Fatalf("unexpected images (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestImportBlobStats(t *testing.T) {
+	ctx := namespaces.WithNamespace(context.Background(), "testing")
+	layout := testLayout(t, map[string]string{"v1": "", "v2": ""})
+
+	mdb, err := db.NewDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mdb.Close(ctx)
+
+	var written int64
+	for i := 0; i < 2; i++ {
+		stats := NewBlobStats(mdb.ContentStore())
+		ts := local.NewTransferService(db.NewLeaseManager(mdb), stats, db.NewImageStore(mdb), &local.TransferConfig{})
+		iis := transferarchive.NewImageImportStream(bytes.NewReader(layout), "")
+		if err := ts.Transfer(ctx, iis, NewImportStore()); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			// Two manifests, two configs, and the index
+			if stats.Written() != 5 || stats.Reused() != 0 {
+				t.Fatalf("expected 5 blobs written on first import, got %d written and %d reused", stats.Written(), stats.Reused())
+			}
+			written = stats.Written()
+			continue
+		}
+		if stats.Written() != 0 || stats.Reused() != written {
+			t.Fatalf("expected all %d blobs reused on second import, got %d written and %d reused", written, stats.Written(), stats.Reused())
+		}
+	}
+}
+
+// testLayout returns an OCI layout archive with a manifest for each
+// reference name, optionally with a containerd image name
+func testLayout(t *testing.T, refs map[string]string) []byte {
+	t.Helper()
+	var (
+		buf bytes.Buffer
+		tw  = tar.NewWriter(&buf)
+		idx = ocispec.Index{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: ocispec.MediaTypeImageIndex,
+		}
+	)
+	write := func(name string, b []byte) {
+		t.Helper()
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(b)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	blob := func(mediaType string, v interface{}) ocispec.Descriptor {
+		t.Helper()
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dgst := digest.FromBytes(b)
+		write("blobs/sha256/"+dgst.Encoded(), b)
+		return ocispec.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(b))}
+	}
+
+	write(ocispec.ImageLayoutFile, []byte(`{"imageLayoutVersion":"1.0.0"}`))
+	var names []string
+	for ref := range refs {
+		names = append(names, ref)
+	}
+	sort.Strings(names)
+	for _, ref := range names {
+		config := blob(ocispec.MediaTypeImageConfig, ocispec.Image{
+			Platform: ocispec.Platform{OS: "linux", Architecture: "amd64"},
+			Author:   ref,
+		})
+		desc := blob(ocispec.MediaTypeImageManifest, ocispec.Manifest{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: ocispec.MediaTypeImageManifest,
+			Config:    config,
+			Layers:    []ocispec.Descriptor{},
+		})
+		desc.Annotations = map[string]string{}
+		if ref != "" {
+			desc.Annotations[ocispec.AnnotationRefName] = ref
+		}
+		if name := refs[ref]; name != "" {
+			desc.Annotations[images.AnnotationImageName] = name
+		}
+		idx.Manifests = append(idx.Manifests, desc)
+	}
+	b, err := json.Marshal(idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write("index.json", b)
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,79 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package archive
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// BlobStats counts the blobs written to a content store. The content store
+// does not write blobs which already exist, these are counted as reused so
+// the storage saved by importing overlapping images can be reported.
+type BlobStats struct {
+	content.Store
+
+	written int64
+	reused  int64
+}
+
+// NewBlobStats wraps the content store to count the blobs written through it
+func NewBlobStats(cs content.Store) *BlobStats {
+	return &BlobStats{Store: cs}
+}
+
+// Written returns the number of blobs newly written
+func (s *BlobStats) Written() int64 {
+	return atomic.LoadInt64(&s.written)
+}
+
+// Reused returns the number of blobs which already existed
+func (s *BlobStats) Reused() int64 {
+	return atomic.LoadInt64(&s.reused)
+}
+
+func (s *BlobStats) Writer(ctx context.Context, opts ...content.WriterOpt) (content.Writer, error) {
+	w, err := s.Store.Writer(ctx, opts...)
+	if err != nil {
+		if errdefs.IsAlreadyExists(err) {
+			atomic.AddInt64(&s.reused, 1)
+		}
+		return nil, err
+	}
+	return &countingWriter{Writer: w, stats: s}, nil
+}
+
+type countingWriter struct {
+	content.Writer
+	stats *BlobStats
+}
+
+func (w *countingWriter) Commit(ctx context.Context, size int64, expected digest.Digest, opts ...content.Opt) error {
+	err := w.Writer.Commit(ctx, size, expected, opts...)
+	switch {
+	case err == nil:
+		atomic.AddInt64(&w.stats.written, 1)
+	case errdefs.IsAlreadyExists(err):
+		// Written concurrently by another ingest
+		atomic.AddInt64(&w.stats.reused, 1)
+	}
+	return err
+}
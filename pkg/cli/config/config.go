@@ -0,0 +1,145 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package config loads default option values for cli commands from a file.
+//
+// The file is TOML with tables grouping options by purpose, each key is the
+// name of a command line flag:
+//
+//	[registry]
+//	hosts-dir = "/etc/containerd/certs.d"
+//	requests-per-second = 5
+//
+//	[transfer]
+//	max-concurrent-downloads = 3
+//	status-line = true
+//	progress-interval = "250ms"
+package config
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/pelletier/go-toml"
+)
+
+// Config holds option values keyed by flag name
+type Config struct {
+	Registry map[string]interface{} `toml:"registry"`
+	Transfer map[string]interface{} `toml:"transfer"`
+}
+
+// FlagSetter is the view of the command line needed to apply a config,
+// satisfied by a cli context
+type FlagSetter interface {
+	IsSet(name string) bool
+	Set(name, value string) error
+}
+
+// Load reads the config file at path, unknown tables are rejected so that
+// misspelled sections are not silently ignored
+func Load(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := toml.LoadBytes(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	for _, k := range tree.Keys() {
+		if k != "registry" && k != "transfer" {
+			return nil, fmt.Errorf("unknown config section %q in %s: %w", k, path, errdefs.ErrInvalidArgument)
+		}
+	}
+	var c Config
+	if err := tree.Unmarshal(&c); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	for k := range c.Registry {
+		if _, ok := c.Transfer[k]; ok {
+			return nil, fmt.Errorf("option %q set in multiple sections of %s: %w", k, path, errdefs.ErrInvalidArgument)
+		}
+	}
+	return &c, nil
+}
+
+// Apply sets each configured option on fs which is defined by the command
+// and was not set on the command line, so flags always override the config.
+// Options for flags the command does not define are ignored since one config
+// is shared by all commands.
+func (c *Config) Apply(fs FlagSetter, flags []string) error {
+	defined := map[string]struct{}{}
+	for _, name := range flags {
+		defined[name] = struct{}{}
+	}
+	for _, options := range []map[string]interface{}{c.Registry, c.Transfer} {
+		// Apply in a consistent order so errors are reproducible
+		names := make([]string, 0, len(options))
+		for name := range options {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if _, ok := defined[name]; !ok || fs.IsSet(name) {
+				continue
+			}
+			values, err := flagValues(options[name])
+			if err != nil {
+				return fmt.Errorf("invalid config option %q: %w", name, err)
+			}
+			for _, v := range values {
+				if err := fs.Set(name, v); err != nil {
+					return fmt.Errorf("invalid config option %q: %w", name, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// flagValues formats a config value as it would be given on the command
+// line, arrays are given as repeated flags
+func flagValues(v interface{}) ([]string, error) {
+	switch v := v.(type) {
+	case string:
+		return []string{v}, nil
+	case bool:
+		return []string{strconv.FormatBool(v)}, nil
+	case int64:
+		return []string{strconv.FormatInt(v, 10)}, nil
+	case float64:
+		return []string{strconv.FormatFloat(v, 'g', -1, 64)}, nil
+	case []interface{}:
+		var values []string
+		for _, e := range v {
+			if _, ok := e.([]interface{}); ok {
+				return nil, fmt.Errorf("nested arrays are not supported: %w", errdefs.ErrInvalidArgument)
+			}
+			ev, err := flagValues(e)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, ev...)
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("unsupported value type %T: %w", v, errdefs.ErrInvalidArgument)
+	}
+}
@@ -0,0 +1,121 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+)
+
+// flagSet records values set on flags like a cli context
+type flagSet map[string][]string
+
+func (fs flagSet) IsSet(name string) bool {
+	_, ok := fs[name]
+	return ok
+}
+
+func (fs flagSet) Set(name, value string) error {
+	fs[name] = append(fs[name], value)
+	return nil
+}
+
+func writeConfig(t *testing.T, s string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(p, []byte(s), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestApply(t *testing.T) {
+	c, err := Load(writeConfig(t, `
+[registry]
+hosts-dir = "/etc/containerd/certs.d"
+plain-http = true
+requests-per-second = 2.5
+credential-timeout = "10s"
+
+[transfer]
+max-concurrent-downloads = 3
+platform = ["linux/amd64", "linux/arm64"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags := []string{"hosts-dir", "plain-http", "requests-per-second", "credential-timeout", "max-concurrent-downloads", "platform"}
+
+	// Config values are applied when no flags are given
+	fs := flagSet{}
+	if err := c.Apply(fs, flags); err != nil {
+		t.Fatal(err)
+	}
+	expected := flagSet{
+		"hosts-dir":                {"/etc/containerd/certs.d"},
+		"plain-http":               {"true"},
+		"requests-per-second":      {"2.5"},
+		"credential-timeout":       {"10s"},
+		"max-concurrent-downloads": {"3"},
+		"platform":                 {"linux/amd64", "linux/arm64"},
+	}
+	if !reflect.DeepEqual(fs, expected) {
+		t.Fatalf("unexpected flags applied:\n%v\nexpected:\n%v", fs, expected)
+	}
+
+	// Flags given on the command line override the config and options for
+	// flags the command does not define are ignored
+	fs = flagSet{
+		"plain-http": {"false"},
+		"platform":   {"linux/s390x"},
+	}
+	if err := c.Apply(fs, []string{"plain-http", "platform", "max-concurrent-downloads"}); err != nil {
+		t.Fatal(err)
+	}
+	expected = flagSet{
+		"plain-http":               {"false"},
+		"platform":                 {"linux/s390x"},
+		"max-concurrent-downloads": {"3"},
+	}
+	if !reflect.DeepEqual(fs, expected) {
+		t.Fatalf("unexpected flags applied:\n%v\nexpected:\n%v", fs, expected)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	for _, s := range []string{
+		"[registy]\nhosts-dir = \"/etc\"\n",
+		"[registry]\nuser = \"a\"\n[transfer]\nuser = \"b\"\n",
+	} {
+		if _, err := Load(writeConfig(t, s)); !errors.Is(err, errdefs.ErrInvalidArgument) {
+			t.Errorf("expected invalid argument loading %q, got %v", s, err)
+		}
+	}
+
+	c, err := Load(writeConfig(t, "[registry]\nuser = 2023-01-01T00:00:00Z\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Apply(flagSet{}, []string{"user"}); !errors.Is(err, errdefs.ErrInvalidArgument) {
+		t.Fatalf("expected invalid argument applying unsupported value, got %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/pkg/transfer/registry"
+)
+
+// dockerHubServer is the server Docker stores Docker Hub credentials under
+const dockerHubServer = "https://index.docker.io/v1/"
+
+// dockerConfig is the credential portion of the Docker config.json
+type dockerConfig struct {
+	Auths       map[string]dockerAuth `json:"auths"`
+	CredsStore  string                `json:"credsStore"`
+	CredHelpers map[string]string     `json:"credHelpers"`
+}
+
+type dockerAuth struct {
+	Auth          string `json:"auth"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	IdentityToken string `json:"identitytoken"`
+}
+
+// helperCredentials is the output of a Docker credential helper get
+type helperCredentials struct {
+	Username string
+	Secret   string
+}
+
+// runCredentialHelper runs the Docker credential helper program with the
+// server on stdin and returns its output
+var runCredentialHelper = func(ctx context.Context, helper, server string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(server)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		// Helpers report missing credentials on stdout with a failed exit
+		msg := strings.TrimSpace(stdout.String())
+		if msg == "credentials not found in native keychain" {
+			return nil, nil
+		} else if msg == "" {
+			msg = strings.TrimSpace(stderr.String())
+		}
+		return nil, fmt.Errorf("credential helper %s failed: %s: %w", helper, msg, err)
+	}
+	return stdout.Bytes(), nil
+}
+
+type dockerConfigCredentials struct {
+	ref  string
+	path string
+}
+
+// NewDockerConfigCredentialHelper gets credentials from a Docker config.json,
+// either from its auths or by running its credential helpers. When path is
+// empty, the config in $DOCKER_CONFIG or ~/.docker is used.
+func NewDockerConfigCredentialHelper(ref, path string) (registry.CredentialHelper, error) {
+	if path == "" {
+		dir := os.Getenv("DOCKER_CONFIG")
+		if dir == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, err
+			}
+			dir = filepath.Join(home, ".docker")
+		}
+		path = filepath.Join(dir, "config.json")
+	}
+	return &dockerConfigCredentials{
+		ref:  ref,
+		path: path,
+	}, nil
+}
+
+func (dc *dockerConfigCredentials) GetCredentials(ctx context.Context, ref, host string) (registry.Credentials, error) {
+	if ref != dc.ref {
+		return registry.Credentials{}, nil
+	}
+	b, err := os.ReadFile(dc.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		return registry.Credentials{}, err
+	}
+	var config dockerConfig
+	if err := json.Unmarshal(b, &config); err != nil {
+		return registry.Credentials{}, fmt.Errorf("failed to read docker config %s: %w", dc.path, err)
+	}
+
+	server := host
+	if host == "registry-1.docker.io" {
+		server = dockerHubServer
+	}
+
+	helper := config.CredsStore
+	if h, ok := config.CredHelpers[host]; ok {
+		helper = h
+	} else if h, ok := config.CredHelpers[server]; ok {
+		helper = h
+	}
+	if helper != "" {
+		out, err := runCredentialHelper(ctx, helper, server)
+		if err != nil || out == nil {
+			return registry.Credentials{}, err
+		}
+		var hc helperCredentials
+		if err := json.Unmarshal(out, &hc); err != nil {
+			return registry.Credentials{}, fmt.Errorf("invalid output from credential helper %s: %w", helper, err)
+		}
+		creds := registry.Credentials{Host: host, Username: hc.Username, Secret: hc.Secret}
+		if hc.Username == "<token>" {
+			// Identity tokens are refresh tokens, which are used without a username
+			creds.Username = ""
+		}
+		return creds, nil
+	}
+
+	for key, auth := range config.Auths {
+		if dockerConfigHost(key) != dockerConfigHost(server) {
+			continue
+		}
+		creds := registry.Credentials{Host: host, Username: auth.Username, Secret: auth.Password}
+		if auth.Auth != "" {
+			b, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return registry.Credentials{}, fmt.Errorf("invalid auth for %s in docker config: %w", key, err)
+			}
+			parts := strings.SplitN(string(b), ":", 2)
+			if len(parts) != 2 {
+				return registry.Credentials{}, fmt.Errorf("invalid auth for %s in docker config, must be user:password", key)
+			}
+			creds.Username, creds.Secret = parts[0], parts[1]
+		}
+		if auth.IdentityToken != "" {
+			// Identity tokens are refresh tokens, which are used without a username
+			creds.Username, creds.Secret = "", auth.IdentityToken
+		}
+		return creds, nil
+	}
+
+	return registry.Credentials{}, nil
+}
+
+// dockerConfigHost returns the host of an auths key, which may be a host
+// or a url such as "https://index.docker.io/v1/"
+func dockerConfigHost(key string) string {
+	key = strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	if i := strings.Index(key, "/"); i >= 0 {
+		key = key[:i]
+	}
+	return key
+}
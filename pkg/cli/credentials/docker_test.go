@@ -0,0 +1,145 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package credentials
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/pkg/transfer/registry"
+)
+
+func TestDockerConfigCredentials(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		path   = filepath.Join(t.TempDir(), "config.json")
+		helped []string
+	)
+	origRun := runCredentialHelper
+	runCredentialHelper = func(ctx context.Context, helper, server string) ([]byte, error) {
+		helped = append(helped, helper+" "+server)
+		switch server {
+		case "helper.example.com":
+			return []byte(`{"ServerURL":"helper.example.com","Username":"helper","Secret":"helped"}`), nil
+		case "token.example.com":
+			return []byte(`{"ServerURL":"token.example.com","Username":"<token>","Secret":"refresh"}`), nil
+		}
+		return nil, nil
+	}
+	t.Cleanup(func() {
+		runCredentialHelper = origRun
+	})
+
+	config := `{
+	"auths": {
+		"https://index.docker.io/v1/": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("hub:password")) + `"},
+		"registry.example.com": {"username": "user", "password": "secret"},
+		"identity.example.com": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("<token>:")) + `", "identitytoken": "idtoken"}
+	},
+	"credHelpers": {
+		"helper.example.com": "test",
+		"token.example.com": "test",
+		"missing.example.com": "test"
+	}
+}`
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		host     string
+		expected registry.Credentials
+	}{
+		{"registry-1.docker.io", registry.Credentials{Host: "registry-1.docker.io", Username: "hub", Secret: "password"}},
+		{"registry.example.com", registry.Credentials{Host: "registry.example.com", Username: "user", Secret: "secret"}},
+		{"identity.example.com", registry.Credentials{Host: "identity.example.com", Secret: "idtoken"}},
+		{"helper.example.com", registry.Credentials{Host: "helper.example.com", Username: "helper", Secret: "helped"}},
+		{"token.example.com", registry.Credentials{Host: "token.example.com", Secret: "refresh"}},
+		{"missing.example.com", registry.Credentials{}},
+		{"other.example.com", registry.Credentials{}},
+	} {
+		ref := tc.host + "/test"
+		ch, err := NewDockerConfigCredentialHelper(ref, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		creds, err := ch.GetCredentials(ctx, ref, tc.host)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.host, err)
+		}
+		if creds != tc.expected {
+			t.Errorf("%s: expected %v, got %v", tc.host, tc.expected, creds)
+		}
+	}
+	if len(helped) != 3 {
+		t.Errorf("expected helper run for each helper host, got %v", helped)
+	}
+
+	// A missing config has no credentials
+	ch, err := NewDockerConfigCredentialHelper("registry.example.com/test", filepath.Join(t.TempDir(), "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds, err := ch.GetCredentials(ctx, "registry.example.com/test", "registry.example.com"); err != nil || creds != (registry.Credentials{}) {
+		t.Fatalf("expected no credentials without config, got %v: %v", creds, err)
+	}
+}
+
+func TestFallbackCredentials(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		host = "registry.example.com"
+		ref  = host + "/test"
+		dir  = t.TempDir()
+		enc  = NewUnencryptedJSON()
+		path = filepath.Join(t.TempDir(), "config.json")
+	)
+	if err := os.WriteFile(path, []byte(`{"auths": {"registry.example.com": {"username": "docker", "password": "fallback"}}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	local, err := NewLocalCredentialHelper(ref, "", dir, enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	docker, err := NewDockerConfigCredentialHelper(ref, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := NewFallbackCredentialHelper(local, docker)
+
+	creds, err := ch.GetCredentials(ctx, ref, host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.Secret != "fallback" {
+		t.Fatalf("expected docker config credentials when none stored, got %v", creds)
+	}
+
+	stored := registry.Credentials{Username: "user", Secret: "stored"}
+	if err := StoreCredentialsLocal(ctx, dir, host, stored, enc); err != nil {
+		t.Fatal(err)
+	}
+	if creds, err = ch.GetCredentials(ctx, ref, host); err != nil {
+		t.Fatal(err)
+	}
+	if creds != stored {
+		t.Fatalf("expected stored credentials before docker config, got %v", creds)
+	}
+}
@@ -0,0 +1,123 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package credentials
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/containerd/containerd/pkg/transfer/registry"
+	"golang.org/x/crypto/scrypt"
+)
+
+// encryptedJSONVersion is the first byte of encrypted credentials, it
+// identifies the key derivation and cipher used
+const encryptedJSONVersion = 1
+
+// saltSize is the size of the random salt stored with each credential
+const saltSize = 16
+
+type encryptedJSON struct {
+	passphrase []byte
+
+	// keys holds the key derived for each salt, deriving a key is slow so
+	// credentials encoded by the same store share a salt and the key for
+	// each salt is only derived once
+	mu   sync.Mutex
+	salt []byte
+	keys map[string]keyFile
+}
+
+// NewEncryptedJSON encrypts credentials with a key derived from the given
+// key material, such as a passphrase or the contents of a key file. Each
+// credential is stored as a version byte, the random salt the key was
+// derived with, and the credentials sealed the same as with a key file.
+func NewEncryptedJSON(key []byte) (EncoderDecoder, error) {
+	if len(key) == 0 {
+		return nil, errors.New("credential key must not be empty")
+	}
+	return &encryptedJSON{
+		passphrase: key,
+		keys:       map[string]keyFile{},
+	}, nil
+}
+
+// keyFile returns the key derived for the salt
+func (e *encryptedJSON) keyFile(salt []byte) (keyFile, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if k, ok := e.keys[string(salt)]; ok {
+		return k, nil
+	}
+	key, err := scrypt.Key(e.passphrase, salt, 1<<15, 8, 1, keySize)
+	if err != nil {
+		return keyFile{}, err
+	}
+	k, err := newKeyFile(key, "wrong credential key")
+	if err != nil {
+		return keyFile{}, err
+	}
+	e.keys[string(salt)] = k
+	return k, nil
+}
+
+// encodeSalt returns the salt used for encoding, generated once
+func (e *encryptedJSON) encodeSalt() ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.salt == nil {
+		salt := make([]byte, saltSize)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return nil, err
+		}
+		e.salt = salt
+	}
+	return e.salt, nil
+}
+
+func (e *encryptedJSON) Encode(creds registry.Credentials) ([]byte, error) {
+	salt, err := e.encodeSalt()
+	if err != nil {
+		return nil, err
+	}
+	k, err := e.keyFile(salt)
+	if err != nil {
+		return nil, err
+	}
+	b, err := k.Encode(creds)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{encryptedJSONVersion}, salt...), b...), nil
+}
+
+func (e *encryptedJSON) Decode(b []byte) (creds registry.Credentials, err error) {
+	if len(b) == 0 || b[0] != encryptedJSONVersion {
+		return creds, errors.New("credentials are not encrypted or use an unsupported format")
+	}
+	b = b[1:]
+	if len(b) < saltSize {
+		return creds, errors.New("encrypted credentials are too short")
+	}
+	k, err := e.keyFile(b[:saltSize])
+	if err != nil {
+		return creds, err
+	}
+	return k.Decode(b[saltSize:])
+}
@@ -0,0 +1,102 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/pkg/transfer/registry"
+)
+
+func TestEncryptedJSON(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		dir   = t.TempDir()
+		creds = registry.Credentials{Host: "registry.example.com", Username: "user1", Secret: "secret"}
+	)
+	if _, err := NewEncryptedJSON(nil); err == nil {
+		t.Fatal("expected error for empty key")
+	}
+	encdec, err := NewEncryptedJSON([]byte("correct horse battery staple"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := StoreCredentialsLocal(ctx, dir, creds.Host, creds, encdec); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "user1@"+creds.Host))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b[0] != encryptedJSONVersion {
+		t.Fatalf("expected version %d, got %d", encryptedJSONVersion, b[0])
+	}
+	if bytes.Contains(b, []byte(creds.Secret)) || bytes.Contains(b, []byte(creds.Username)) {
+		t.Fatal("credentials stored unencrypted")
+	}
+
+	// Credentials encoded by the same store share the derived key
+	other, err := encdec.Encode(registry.Credentials{Host: "other.example.com", Username: "user2", Secret: "secret2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(other[1:1+saltSize], b[1:1+saltSize]) {
+		t.Fatal("expected credentials from the same store to share a salt")
+	}
+	if _, err := encdec.Decode(other); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(encdec.(*encryptedJSON).keys); n != 1 {
+		t.Fatalf("expected the key to be derived once, got %d keys", n)
+	}
+
+	helper, err := NewLocalCredentialHelper("registry.example.com/test", "", dir, encdec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := helper.GetCredentials(ctx, "registry.example.com/test", creds.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored != creds {
+		t.Fatalf("expected %v, got %v", creds, stored)
+	}
+
+	wrong, err := NewEncryptedJSON([]byte("wrong"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wrong.Decode(b); err == nil || !strings.Contains(err.Error(), "wrong credential key") {
+		t.Fatalf("expected wrong key error, got %v", err)
+	}
+
+	plain, err := NewUnencryptedJSON().Encode(creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, invalid := range [][]byte{nil, plain, b[:10], b[:len(b)-1]} {
+		if _, err := encdec.Decode(invalid); err == nil {
+			t.Fatalf("expected error decoding %q", invalid)
+		}
+	}
+}
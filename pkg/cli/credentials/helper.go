@@ -23,29 +23,61 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/pkg/transfer/registry"
 )
 
 type keychainCredentials struct {
-	user string
-	ref  string
+	user    string
+	ref     string
+	timeout time.Duration
+}
+
+// HelperOpt configures a credential helper
+type HelperOpt func(*keychainCredentials)
+
+// WithTimeout sets the maximum time to wait for the credential store,
+// a zero duration waits until the request's context is done
+func WithTimeout(d time.Duration) HelperOpt {
+	return func(kc *keychainCredentials) {
+		kc.timeout = d
+	}
 }
 
 // NewKeychainCredentialHelper gets credentials from the default credential store
-func NewKeychainCredentialHelper(ref, user string) (registry.CredentialHelper, error) {
-	return &keychainCredentials{
+func NewKeychainCredentialHelper(ref, user string, opts ...HelperOpt) (registry.CredentialHelper, error) {
+	kc := &keychainCredentials{
 		user: user,
 		ref:  ref,
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(kc)
+	}
+	return kc, nil
 }
 
 func (sc *keychainCredentials) GetCredentials(ctx context.Context, ref, host string) (registry.Credentials, error) {
 	if ref == sc.ref {
-		creds, err := getCredentials(ctx, host, sc.user)
-		if !errors.Is(err, errdefs.ErrNotFound) {
-			return creds, err
+		if sc.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, sc.timeout)
+			defer cancel()
+		}
+		var (
+			creds registry.Credentials
+			get   = keychainGet
+		)
+		err := withContext(ctx, func() (err error) {
+			creds, err = get(ctx, host, sc.user)
+			return
+		})
+		if err == nil {
+			return creds, nil
+		} else if !errors.Is(err, errdefs.ErrNotFound) {
+			// creds must not be read unless the lookup completed
+			return registry.Credentials{}, err
 		}
 	}
 	return registry.Credentials{}, nil
@@ -107,3 +139,25 @@ func (lc *localCredentials) GetCredentials(ctx context.Context, ref, host string
 
 	return lc.decoder.Decode(b)
 }
+
+type fallbackCredentials []registry.CredentialHelper
+
+// NewFallbackCredentialHelper gets credentials from the first helper which
+// has credentials for the host, errors are returned without trying the
+// remaining helpers
+func NewFallbackCredentialHelper(helpers ...registry.CredentialHelper) registry.CredentialHelper {
+	return fallbackCredentials(helpers)
+}
+
+func (fc fallbackCredentials) GetCredentials(ctx context.Context, ref, host string) (registry.Credentials, error) {
+	for _, h := range fc {
+		creds, err := h.GetCredentials(ctx, ref, host)
+		if err != nil {
+			return registry.Credentials{}, err
+		}
+		if creds.Username != "" || creds.Secret != "" || creds.Header != "" {
+			return creds, nil
+		}
+	}
+	return registry.Credentials{}, nil
+}
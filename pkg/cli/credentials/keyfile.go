@@ -0,0 +1,127 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package credentials
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/containerd/pkg/transfer/registry"
+)
+
+// keySize is the size of the AES-256 key stored in the key file
+const keySize = 32
+
+type keyFile struct {
+	aead cipher.AEAD
+	// mismatch describes the likely cause of a failure to decrypt
+	mismatch string
+}
+
+// newKeyFile returns an encoder sealing credentials with AES-256-GCM using
+// the key, the nonce is stored ahead of the sealed credentials
+func newKeyFile(key []byte, mismatch string) (keyFile, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return keyFile{}, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return keyFile{}, err
+	}
+	return keyFile{aead: aead, mismatch: mismatch}, nil
+}
+
+// NewKeyFileEncoderDecoder encrypts credentials with a key read from the
+// given file, a random key is generated and written to the file when it
+// does not exist. The key file is only readable by the owner, the stored
+// credentials are only as safe as the key file.
+func NewKeyFileEncoderDecoder(path string) (EncoderDecoder, error) {
+	key, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key, err = createKey(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("invalid key file %s, must be %d bytes", path, keySize)
+	}
+	return newKeyFile(key, "key file may have changed")
+}
+
+// createKey writes a new random key, failing if another process created the
+// key first so that credentials are never encrypted with different keys
+func createKey(path string) ([]byte, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return os.ReadFile(path)
+		}
+		return nil, err
+	}
+	if _, err := f.Write(key); err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return key, nil
+}
+
+func (k keyFile) Encode(creds registry.Credentials) ([]byte, error) {
+	b, err := json.Marshal(creds)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	// The nonce is stored ahead of the sealed credentials
+	return k.aead.Seal(nonce, nonce, b, nil), nil
+}
+
+func (k keyFile) Decode(b []byte) (creds registry.Credentials, err error) {
+	ns := k.aead.NonceSize()
+	if len(b) < ns {
+		return creds, errors.New("encrypted credentials are too short")
+	}
+	pt, err := k.aead.Open(nil, b[:ns], b[ns:], nil)
+	if err != nil {
+		return creds, fmt.Errorf("failed to decrypt credentials, %s: %w", k.mismatch, err)
+	}
+	err = json.Unmarshal(pt, &creds)
+	return
+}
@@ -0,0 +1,92 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/pkg/transfer/registry"
+)
+
+func TestKeyFileEncoderDecoder(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		dir     = t.TempDir()
+		keyPath = filepath.Join(dir, "credentials.key")
+		credDir = filepath.Join(dir, "credentials")
+		creds   = registry.Credentials{Host: "registry.example.com", Username: "user1", Secret: "secret"}
+	)
+	encdec, err := NewKeyFileEncoderDecoder(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 || fi.Size() != keySize {
+		t.Fatalf("unexpected key file mode %v and size %d", fi.Mode().Perm(), fi.Size())
+	}
+
+	if err := StoreCredentialsLocal(ctx, credDir, creds.Host, creds, encdec); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(credDir, "user1@"+creds.Host))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, []byte(creds.Secret)) {
+		t.Fatal("secret stored unencrypted")
+	}
+
+	// The existing key is used after reopening
+	encdec, err = NewKeyFileEncoderDecoder(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	helper, err := NewLocalCredentialHelper("registry.example.com/test", "", credDir, encdec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := helper.GetCredentials(ctx, "registry.example.com/test", creds.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored != creds {
+		t.Fatalf("expected %v, got %v", creds, stored)
+	}
+
+	// A different key cannot decrypt the credentials
+	other, err := NewKeyFileEncoderDecoder(filepath.Join(dir, "other.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Decode(b); err == nil {
+		t.Fatal("expected decrypt error with a different key")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "short.key"), []byte("short"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewKeyFileEncoderDecoder(filepath.Join(dir, "short.key")); err == nil {
+		t.Fatal("expected error for invalid key file")
+	}
+}
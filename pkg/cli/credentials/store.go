@@ -18,20 +18,115 @@ package credentials
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
 	"github.com/containerd/containerd/pkg/transfer/registry"
 )
 
+// ErrCredentialStoreTimeout is returned when the system credential store
+// does not respond in time, such as when the keyring is locked
+var ErrCredentialStoreTimeout = errors.New("credential store timed out, keyring may be locked")
+
+// ErrNoSystemStore is returned when there is no system credential store
+// on the platform
+var ErrNoSystemStore = errors.New("no system credential store available")
+
+// Credential store kinds
+const (
+	// StoreAuto uses the system store when available, otherwise the file store
+	StoreAuto = "auto"
+	// StoreKeychain is the system credential store, the keychain on macOS,
+	// the secret service on Linux, and the Credential Manager on Windows
+	StoreKeychain = "keychain"
+	// StoreFile stores encrypted credentials in a local directory
+	StoreFile = "file"
+)
+
+// keychainStore, keychainGet, keychainDelete, and keychainCheck access the
+// system credential store, these calls may block and do not observe context
+// cancellation.
+var (
+	keychainStore  = storeCredentials
+	keychainGet    = getCredentials
+	keychainDelete = deleteCredentials
+	keychainCheck  = checkSystemStore
+)
+
+// id returns the name credentials for the host are stored under in system
+// stores which are keyed by a single name, such as the macOS keychain
+func id(host string) string {
+	return fmt.Sprintf("containerd login: %s", host)
+}
+
+// CheckSystemStore returns an error when the system credential store cannot
+// be used, such as on Linux without a secret service daemon
+func CheckSystemStore(ctx context.Context) error {
+	check := keychainCheck
+	return withContext(ctx, check)
+}
+
+// ResolveStore returns the kind of credential store to use, the automatic
+// kind resolves to the system store when it is available and otherwise the
+// file store
+func ResolveStore(ctx context.Context, kind string) (string, error) {
+	switch kind {
+	case StoreKeychain, StoreFile:
+		return kind, nil
+	case StoreAuto, "":
+		if err := CheckSystemStore(ctx); err != nil {
+			log.G(ctx).WithError(err).Debug("system credential store unavailable, using file store")
+			return StoreFile, nil
+		}
+		return StoreKeychain, nil
+	}
+	return "", fmt.Errorf("invalid credential store %q, must be %s, %s, or %s", kind, StoreAuto, StoreKeychain, StoreFile)
+}
+
 // StoreCredentialsInKeychain stores the credentials in the default keychain credential store
 // for the system or environment
 func StoreCredentialsInKeychain(ctx context.Context, host string, creds registry.Credentials) error {
-	return storeCredentials(ctx, host, creds)
+	store := keychainStore
+	return withContext(ctx, func() error {
+		return store(ctx, host, creds)
+	})
+}
+
+// DeleteCredentialsInKeychain removes the credentials stored for the host in
+// the default keychain credential store, errdefs.ErrNotFound is returned
+// when no credentials are stored for the host
+func DeleteCredentialsInKeychain(ctx context.Context, host string) error {
+	del := keychainDelete
+	return withContext(ctx, func() error {
+		return del(ctx, host)
+	})
 }
 
-// StoreCredentialsLocal stores the credentials to a local directory using the provided encoder
+// withContext runs the blocking function in a goroutine and returns early
+// if the context is done before the function completes
+func withContext(ctx context.Context, fn func() error) error {
+	errC := make(chan error, 1)
+	go func() {
+		errC <- fn()
+	}()
+	select {
+	case err := <-errC:
+		return err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("%w: %v", ErrCredentialStoreTimeout, ctx.Err())
+		}
+		return ctx.Err()
+	}
+}
+
+// StoreCredentialsLocal stores the credentials to a local directory using the provided encoder,
+// replacing any credentials previously stored for the host
 func StoreCredentialsLocal(ctx context.Context, dir, host string, creds registry.Credentials, encoder Encoder) error {
 	if err := os.MkdirAll(dir, 0700); err != nil {
 		return err
@@ -41,12 +136,85 @@ func StoreCredentialsLocal(ctx context.Context, dir, host string, creds registry
 		return err
 	}
 
-	// TODO: Store at user@host and host if not exists?
 	name := host
 	if creds.Username != "" {
 		name = fmt.Sprintf("%s@%s", creds.Username, host)
 	}
-	return os.WriteFile(filepath.Join(dir, name), b, 0600)
+	if err := writeFileAtomic(dir, name, b); err != nil {
+		return err
+	}
+
+	// Only one credential is kept per host, remove any left over from a
+	// login with a different username so lookups cannot return stale entries
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range files {
+		n := e.Name()
+		if n == name || (n != host && !strings.HasSuffix(n, "@"+host)) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, n)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove stale credentials %q: %w", n, err)
+		}
+	}
+	return nil
+}
+
+// DeleteCredentialsLocal removes the credentials stored for the host in the
+// local directory, including those stored with a username. errdefs.ErrNotFound
+// is returned when no credentials are stored for the host.
+func DeleteCredentialsLocal(ctx context.Context, dir, host string) error {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("no credentials for %s: %w", host, errdefs.ErrNotFound)
+		}
+		return err
+	}
+	var removed int
+	for _, e := range files {
+		n := e.Name()
+		if n != host && !strings.HasSuffix(n, "@"+host) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, n)); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("failed to remove credentials %q: %w", n, err)
+		}
+		removed++
+	}
+	if removed == 0 {
+		return fmt.Errorf("no credentials for %s: %w", host, errdefs.ErrNotFound)
+	}
+	return nil
+}
+
+// writeFileAtomic writes to a temporary file in dir and renames it over name
+// so an interrupted login never leaves a partially written credential
+func writeFileAtomic(dir, name string, b []byte) error {
+	f, err := os.CreateTemp(dir, ".tmp-"+name)
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
 }
 
 type Encoder interface {
@@ -1,3 +1,5 @@
+//go:build cgo
+
 /*
    Copyright The containerd Authors.
 
@@ -19,7 +21,9 @@ package credentials
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/log"
@@ -28,6 +32,68 @@ import (
 	"github.com/keybase/go-keychain"
 )
 
+// keychainRetries is how many times a keychain call is retried after a
+// transient error, the delay before each retry starts at keychainBackoff
+// and doubles with each attempt.
+var (
+	keychainRetries = 3
+	keychainBackoff = 50 * time.Millisecond
+)
+
+// keychainAdd, keychainUpdate, and keychainQuery call the system keychain
+var (
+	keychainAdd    = keychain.AddItem
+	keychainUpdate = keychain.UpdateItem
+	keychainQuery  = keychain.QueryItem
+)
+
+// isTransient returns whether the keychain error may succeed when retried,
+// such as when the keychain is being accessed concurrently. Errors for
+// missing items or denied access are never retried.
+func isTransient(err error) bool {
+	var kerr keychain.Error
+	if !errors.As(err, &kerr) {
+		return false
+	}
+	return kerr == keychain.ErrorNotAvailable || kerr == keychain.ErrorInteractionNotAllowed
+}
+
+// withRetry calls fn until it succeeds, returns an error which is not
+// transient, or the retries are exhausted
+func withRetry(ctx context.Context, fn func() error) error {
+	backoff := keychainBackoff
+	for i := 0; ; i++ {
+		err := fn()
+		if err == nil || i >= keychainRetries || !isTransient(err) {
+			return err
+		}
+		log.G(ctx).WithError(err).WithField("backoff", backoff).Debug("keychain busy, retrying")
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// queryItems queries the keychain, retrying transient errors
+func queryItems(ctx context.Context, query keychain.Item) (items []keychain.QueryResult, err error) {
+	err = withRetry(ctx, func() error {
+		items, err = keychainQuery(query)
+		return err
+	})
+	return
+}
+
+// checkSystemStore always succeeds, the keychain is available on all macOS
+// systems
+func checkSystemStore() error {
+	return nil
+}
+
 func storeCredentials(ctx context.Context, host string, creds registry.Credentials) error {
 	b, err := json.Marshal(creds)
 	if err != nil {
@@ -47,12 +113,73 @@ func storeCredentials(ctx context.Context, host string, creds registry.Credentia
 	item.SetAccessible(keychain.AccessibleAlways)
 	item.SetData(b)
 
-	err = keychain.AddItem(item)
+	err = withRetry(ctx, func() error {
+		return keychainAdd(item)
+	})
 	if err == keychain.ErrorDuplicateItem {
 		log.G(ctx).WithError(err).WithField("service", sid).Debug("key found, updating")
-		return keychain.UpdateItem(item, item)
+		// The query must only identify the item, including the new data
+		// would never match the stored item
+		query := keychain.NewItem()
+		query.SetSecClass(keychain.SecClassGenericPassword)
+		query.SetService(sid)
+		if creds.Username != "" {
+			query.SetAccount(creds.Username)
+		}
+		update := keychain.NewItem()
+		update.SetData(b)
+		err = withRetry(ctx, func() error {
+			return keychainUpdate(query, update)
+		})
 	}
+	if err != nil {
+		return err
+	}
+
+	return removeStaleItems(ctx, sid, creds.Username)
+}
+
+// removeStaleItems removes items for the service stored under a different
+// account so that only one credential is kept per host
+func removeStaleItems(ctx context.Context, sid, account string) error {
+	query := keychain.NewItem()
+	query.SetSecClass(keychain.SecClassGenericPassword)
+	query.SetService(sid)
+	query.SetReturnAttributes(true)
+	query.SetMatchLimit(keychain.MatchLimitAll)
 
+	items, err := queryItems(ctx, query)
+	if err != nil {
+		return fmt.Errorf("keychain query failed: %w", err)
+	}
+	for _, item := range items {
+		if item.Account == account {
+			continue
+		}
+		log.G(ctx).WithField("service", sid).WithField("account", item.Account).Debug("removing stale key")
+		stale := keychain.NewItem()
+		stale.SetSecClass(keychain.SecClassGenericPassword)
+		stale.SetService(sid)
+		stale.SetAccount(item.Account)
+		if err := keychain.DeleteItem(stale); err != nil && err != keychain.ErrorItemNotFound {
+			return fmt.Errorf("failed to remove stale key for %q: %w", item.Account, err)
+		}
+	}
+	return nil
+}
+
+// deleteCredentials removes the items for the host under every account
+func deleteCredentials(ctx context.Context, host string) error {
+	item := keychain.NewItem()
+	item.SetSecClass(keychain.SecClassGenericPassword)
+	item.SetService(id(host))
+
+	err := withRetry(ctx, func() error {
+		return keychain.DeleteItem(item)
+	})
+	if err == keychain.ErrorItemNotFound {
+		return fmt.Errorf("no credentials for %s: %w", host, errdefs.ErrNotFound)
+	}
 	return err
 }
 
@@ -65,7 +192,7 @@ func getCredentials(ctx context.Context, host, user string) (registry.Credential
 	item.SetMatchLimit(keychain.MatchLimitAll)
 
 	// Query all and choose best match
-	items, err := keychain.QueryItem(item)
+	items, err := queryItems(ctx, item)
 	if err != nil {
 		return registry.Credentials{}, fmt.Errorf("keychain query failed: %w", err)
 	}
@@ -97,7 +224,7 @@ func getCredentials(ctx context.Context, host, user string) (registry.Credential
 	item.SetReturnData(true)
 
 	// Get single result
-	items, err = keychain.QueryItem(item)
+	items, err = queryItems(ctx, item)
 	if err != nil {
 		return registry.Credentials{}, fmt.Errorf("keychain query failed: %w", err)
 	}
@@ -113,7 +240,3 @@ func getCredentials(ctx context.Context, host, user string) (registry.Credential
 
 	return creds, nil
 }
-
-func id(host string) string {
-	return fmt.Sprintf("containerd login: %s", host)
-}
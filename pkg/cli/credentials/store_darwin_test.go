@@ -0,0 +1,139 @@
+//go:build cgo
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package credentials
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	registry "github.com/containerd/containerd/pkg/transfer/registry"
+	"github.com/keybase/go-keychain"
+)
+
+func TestStoreCredentialsKeychainReplaces(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping keychain test in short mode")
+	}
+	var (
+		ctx  = context.Background()
+		host = "lctr-test.registry.example.com"
+		sid  = id(host)
+	)
+	query := keychain.NewItem()
+	query.SetSecClass(keychain.SecClassGenericPassword)
+	query.SetService(sid)
+	query.SetReturnAttributes(true)
+	query.SetMatchLimit(keychain.MatchLimitAll)
+
+	t.Cleanup(func() {
+		items, _ := keychain.QueryItem(query)
+		for _, item := range items {
+			keychain.DeleteGenericPasswordItem(sid, item.Account)
+		}
+	})
+
+	for _, creds := range []registry.Credentials{
+		{Username: "user1", Secret: "first"},
+		{Username: "user1", Secret: "second"},
+		{Username: "user2", Secret: "third"},
+	} {
+		if err := storeCredentials(ctx, host, creds); err != nil {
+			t.Fatal(err)
+		}
+		items, err := keychain.QueryItem(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != 1 || items[0].Account != creds.Username {
+			t.Fatalf("expected single item for %q, got %v", creds.Username, items)
+		}
+		stored, err := getCredentials(ctx, host, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored != creds {
+			t.Fatalf("expected %v, got %v", creds, stored)
+		}
+	}
+}
+
+func TestKeychainRetry(t *testing.T) {
+	var (
+		ctx                       = context.Background()
+		adds, queries             int
+		add, update, query, retry = keychainAdd, keychainUpdate, keychainQuery, keychainBackoff
+	)
+	t.Cleanup(func() {
+		keychainAdd, keychainUpdate, keychainQuery, keychainBackoff = add, update, query, retry
+	})
+	keychainBackoff = time.Millisecond
+	// The stub keychain is busy for the first call of each kind
+	keychainAdd = func(keychain.Item) error {
+		adds++
+		if adds == 1 {
+			return keychain.ErrorInteractionNotAllowed
+		}
+		return nil
+	}
+	keychainUpdate = func(keychain.Item, keychain.Item) error {
+		return errors.New("unexpected update")
+	}
+	keychainQuery = func(keychain.Item) ([]keychain.QueryResult, error) {
+		queries++
+		if queries == 1 {
+			return nil, keychain.ErrorNotAvailable
+		}
+		return []keychain.QueryResult{{Account: "user1"}}, nil
+	}
+
+	if err := storeCredentials(ctx, "lctr-test.registry.example.com", registry.Credentials{Username: "user1", Secret: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if adds != 2 || queries != 2 {
+		t.Fatalf("expected each call retried once, got %d adds and %d queries", adds, queries)
+	}
+
+	// Errors which are not transient fail without retrying
+	queries = 0
+	keychainQuery = func(keychain.Item) ([]keychain.QueryResult, error) {
+		queries++
+		return nil, keychain.ErrorUserCanceled
+	}
+	if _, err := getCredentials(ctx, "lctr-test.registry.example.com", ""); !errors.Is(err, keychain.ErrorUserCanceled) {
+		t.Fatalf("expected user canceled error, got %v", err)
+	}
+	if queries != 1 {
+		t.Fatalf("expected no retries, got %d queries", queries)
+	}
+
+	// Retries are bounded
+	queries = 0
+	keychainQuery = func(keychain.Item) ([]keychain.QueryResult, error) {
+		queries++
+		return nil, keychain.ErrorInteractionNotAllowed
+	}
+	if _, err := getCredentials(ctx, "lctr-test.registry.example.com", ""); !errors.Is(err, keychain.ErrorInteractionNotAllowed) {
+		t.Fatalf("expected interaction not allowed error, got %v", err)
+	}
+	if queries != keychainRetries+1 {
+		t.Fatalf("expected %d attempts, got %d", keychainRetries+1, queries)
+	}
+}
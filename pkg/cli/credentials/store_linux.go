@@ -21,11 +21,27 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/log"
 	registry "github.com/containerd/containerd/pkg/transfer/registry"
 	"github.com/keybase/go-keychain/secretservice"
 )
 
+// checkSystemStore opens a session with the secret service, which fails when
+// there is no session bus or no secret service daemon
+func checkSystemStore() error {
+	s, err := secretservice.NewService()
+	if err != nil {
+		return err
+	}
+	session, err := s.OpenSession(secretservice.AuthenticationDHAES)
+	if err != nil {
+		return err
+	}
+	s.CloseSession(session)
+	return nil
+}
+
 func storeCredentials(ctx context.Context, host string, creds registry.Credentials) error {
 	attributes := map[string]string{
 		"registry": host,
@@ -100,3 +116,31 @@ func getCredentials(ctx context.Context, host, user string) (registry.Credential
 
 	return registry.Credentials{}, nil
 }
+
+func deleteCredentials(ctx context.Context, host string) error {
+	attributes := map[string]string{
+		"registry": host,
+	}
+
+	s, err := secretservice.NewService()
+	if err != nil {
+		return err
+	}
+
+	items, err := s.SearchCollection(secretservice.DefaultCollection, attributes)
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return fmt.Errorf("no credentials for %s: %w", host, errdefs.ErrNotFound)
+	}
+	for _, item := range items {
+		if err := s.DeleteItem(item); err != nil {
+			return err
+		}
+	}
+
+	log.G(ctx).WithField("registry", host).Debug("credentials removed from secret service")
+
+	return nil
+}
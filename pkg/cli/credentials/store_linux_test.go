@@ -0,0 +1,92 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package credentials
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	registry "github.com/containerd/containerd/pkg/transfer/registry"
+	"github.com/keybase/go-keychain/secretservice"
+)
+
+func TestStoreCredentialsSecretServiceReplaces(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping secret service test in short mode")
+	}
+	s, err := secretservice.NewService()
+	if err != nil {
+		t.Skipf("secret service not available: %v", err)
+	}
+	session, err := s.OpenSession(secretservice.AuthenticationDHAES)
+	if err != nil {
+		t.Skipf("secret service not available: %v", err)
+	}
+	defer s.CloseSession(session)
+
+	var (
+		ctx        = context.Background()
+		host       = "lctr-test.registry.example.com"
+		attributes = map[string]string{"registry": host}
+	)
+	t.Cleanup(func() {
+		items, _ := s.SearchCollection(secretservice.DefaultCollection, attributes)
+		for _, item := range items {
+			s.DeleteItem(item)
+		}
+	})
+
+	for _, creds := range []registry.Credentials{
+		{Username: "user1", Secret: "first"},
+		{Username: "user1", Secret: "second"},
+		{Username: "user2", Secret: "third"},
+	} {
+		if err := storeCredentials(ctx, host, creds); err != nil {
+			t.Fatal(err)
+		}
+		items, err := s.SearchCollection(secretservice.DefaultCollection, attributes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != 1 {
+			t.Fatalf("expected 1 item for host, got %d", len(items))
+		}
+		stored, err := getCredentials(ctx, host, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored != creds {
+			t.Fatalf("expected %v, got %v", creds, stored)
+		}
+	}
+
+	if err := deleteCredentials(ctx, host); err != nil {
+		t.Fatal(err)
+	}
+	if err := deleteCredentials(ctx, host); !errdefs.IsNotFound(err) {
+		t.Fatalf("expected not found deleting again, got %v", err)
+	}
+}
+
+func TestCheckSystemStoreNoSessionBus(t *testing.T) {
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", "unix:path="+filepath.Join(t.TempDir(), "missing"))
+	if err := CheckSystemStore(context.Background()); err == nil {
+		t.Fatal("expected secret service to be unavailable without a session bus")
+	}
+}
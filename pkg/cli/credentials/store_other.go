@@ -0,0 +1,43 @@
+//go:build !linux && !windows && !(darwin && cgo)
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package credentials
+
+import (
+	"context"
+
+	registry "github.com/containerd/containerd/pkg/transfer/registry"
+)
+
+// checkSystemStore fails on platforms without a supported system credential
+// store, such as macOS builds without cgo, so the file store is used
+func checkSystemStore() error {
+	return ErrNoSystemStore
+}
+
+func storeCredentials(ctx context.Context, host string, creds registry.Credentials) error {
+	return ErrNoSystemStore
+}
+
+func getCredentials(ctx context.Context, host, user string) (registry.Credentials, error) {
+	return registry.Credentials{}, ErrNoSystemStore
+}
+
+func deleteCredentials(ctx context.Context, host string) error {
+	return ErrNoSystemStore
+}
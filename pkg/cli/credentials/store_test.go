@@ -0,0 +1,251 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package credentials
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/pkg/transfer/registry"
+)
+
+func blockingKeychain(t *testing.T) {
+	unblock := make(chan struct{})
+	origStore, origGet, origDelete := keychainStore, keychainGet, keychainDelete
+	keychainStore = func(context.Context, string, registry.Credentials) error {
+		<-unblock
+		return nil
+	}
+	keychainGet = func(context.Context, string, string) (registry.Credentials, error) {
+		<-unblock
+		return registry.Credentials{Secret: "late"}, nil
+	}
+	keychainDelete = func(context.Context, string) error {
+		<-unblock
+		return nil
+	}
+	t.Cleanup(func() {
+		close(unblock)
+		keychainStore, keychainGet, keychainDelete = origStore, origGet, origDelete
+	})
+}
+
+func TestKeychainTimeout(t *testing.T) {
+	blockingKeychain(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := StoreCredentialsInKeychain(ctx, "registry.example.com", registry.Credentials{Secret: "secret"})
+	if !errors.Is(err, ErrCredentialStoreTimeout) {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("store did not respect timeout, took %s", d)
+	}
+
+	ch, err := NewKeychainCredentialHelper("registry.example.com/test", "", WithTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start = time.Now()
+	creds, err := ch.GetCredentials(context.Background(), "registry.example.com/test", "registry.example.com")
+	if !errors.Is(err, ErrCredentialStoreTimeout) {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	if creds.Secret != "" {
+		t.Fatalf("unexpected credentials returned on timeout: %v", creds)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("get did not respect timeout, took %s", d)
+	}
+
+	start = time.Now()
+	if err := DeleteCredentialsInKeychain(ctx, "registry.example.com"); !errors.Is(err, ErrCredentialStoreTimeout) {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("delete did not respect timeout, took %s", d)
+	}
+}
+
+func TestResolveStore(t *testing.T) {
+	ctx := context.Background()
+	orig := keychainCheck
+	t.Cleanup(func() {
+		keychainCheck = orig
+	})
+
+	for _, tc := range []struct {
+		name     string
+		kind     string
+		check    error
+		expected string
+	}{
+		{name: "Available", kind: StoreAuto, expected: StoreKeychain},
+		{name: "Unavailable", kind: StoreAuto, check: ErrNoSystemStore, expected: StoreFile},
+		{name: "Default", check: ErrNoSystemStore, expected: StoreFile},
+		{name: "Keychain", kind: StoreKeychain, check: ErrNoSystemStore, expected: StoreKeychain},
+		{name: "File", kind: StoreFile, expected: StoreFile},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			keychainCheck = func() error {
+				return tc.check
+			}
+			kind, err := ResolveStore(ctx, tc.kind)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kind != tc.expected {
+				t.Fatalf("expected %s store, got %s", tc.expected, kind)
+			}
+		})
+	}
+
+	if _, err := ResolveStore(ctx, "vault"); err == nil {
+		t.Fatal("expected error for unknown store")
+	}
+
+	// A system store which does not respond is unavailable
+	unblock := make(chan struct{})
+	defer close(unblock)
+	keychainCheck = func() error {
+		<-unblock
+		return nil
+	}
+	tctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if kind, err := ResolveStore(tctx, StoreAuto); err != nil || kind != StoreFile {
+		t.Fatalf("expected file store when system store blocks, got %s: %v", kind, err)
+	}
+}
+
+func TestStoreCredentialsLocalReplaces(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		dir  = t.TempDir()
+		host = "registry.example.com"
+		ref  = host + "/test"
+		enc  = NewUnencryptedJSON()
+	)
+	// Credentials for another host sharing a suffix must be left alone
+	other := registry.Credentials{Username: "user1", Secret: "other"}
+	if err := StoreCredentialsLocal(ctx, dir, "sub."+host, other, enc); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		creds registry.Credentials
+		name  string
+	}{
+		{registry.Credentials{Secret: "token"}, host},
+		{registry.Credentials{Username: "user1", Secret: "first"}, "user1@" + host},
+		{registry.Credentials{Username: "user1", Secret: "second"}, "user1@" + host},
+		{registry.Credentials{Username: "user2", Secret: "third"}, "user2@" + host},
+	} {
+		if err := StoreCredentialsLocal(ctx, dir, host, tc.creds, enc); err != nil {
+			t.Fatal(err)
+		}
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, f := range files {
+			if f.Name() != "user1@sub."+host {
+				names = append(names, f.Name())
+			}
+		}
+		if len(names) != 1 || names[0] != tc.name {
+			t.Fatalf("expected only %q stored for host, got %v", tc.name, names)
+		}
+
+		// Lookups without a user must return the latest login
+		ch, err := NewLocalCredentialHelper(ref, "", dir, enc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		creds, err := ch.GetCredentials(ctx, ref, host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if creds != tc.creds {
+			t.Fatalf("expected %v, got %v", tc.creds, creds)
+		}
+	}
+
+	ch, err := NewLocalCredentialHelper("sub."+host+"/test", "user1", dir, enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := ch.GetCredentials(ctx, "sub."+host+"/test", "sub."+host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds != other {
+		t.Fatalf("credentials for other host changed: %v", creds)
+	}
+}
+
+func TestDeleteCredentialsLocal(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		dir  = t.TempDir()
+		host = "registry.example.com"
+		enc  = NewUnencryptedJSON()
+	)
+	if err := DeleteCredentialsLocal(ctx, filepath.Join(dir, "missing"), host); !errdefs.IsNotFound(err) {
+		t.Fatalf("expected not found for missing directory, got %v", err)
+	}
+	other := registry.Credentials{Username: "user1", Secret: "other"}
+	if err := StoreCredentialsLocal(ctx, dir, "sub."+host, other, enc); err != nil {
+		t.Fatal(err)
+	}
+	if err := StoreCredentialsLocal(ctx, dir, host, registry.Credentials{Username: "user1", Secret: "secret"}, enc); err != nil {
+		t.Fatal(err)
+	}
+	// A file left for the host without a username is removed as well
+	if err := os.WriteFile(filepath.Join(dir, host), []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := DeleteCredentialsLocal(ctx, dir, host); err != nil {
+		t.Fatal(err)
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name() != "user1@sub."+host {
+		var names []string
+		for _, f := range files {
+			names = append(names, f.Name())
+		}
+		t.Fatalf("expected only credentials for other host to remain, got %v", names)
+	}
+
+	// Deleting again is not an error beyond reporting nothing was found
+	if err := DeleteCredentialsLocal(ctx, dir, host); !errdefs.IsNotFound(err) {
+		t.Fatalf("expected not found deleting again, got %v", err)
+	}
+}
@@ -0,0 +1,161 @@
+//go:build windows
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package credentials
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	registry "github.com/containerd/containerd/pkg/transfer/registry"
+	"golang.org/x/sys/windows"
+)
+
+// Credential Manager calls, golang.org/x/sys/windows does not wrap these
+var (
+	advapi32        = windows.NewLazySystemDLL("advapi32.dll")
+	procCredReadW   = advapi32.NewProc("CredReadW")
+	procCredWriteW  = advapi32.NewProc("CredWriteW")
+	procCredFree    = advapi32.NewProc("CredFree")
+	procCredDeleteW = advapi32.NewProc("CredDeleteW")
+)
+
+const (
+	credTypeGeneric         = 1
+	credPersistLocalMachine = 2
+	// credMaxBlobSize is the largest secret a generic credential may hold
+	credMaxBlobSize = 5 * 512
+)
+
+// credential is the CREDENTIALW structure
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        windows.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// checkSystemStore fails when the Credential Manager calls cannot be found
+func checkSystemStore() error {
+	for _, proc := range []*windows.LazyProc{procCredReadW, procCredWriteW, procCredFree, procCredDeleteW} {
+		if err := proc.Find(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storeCredentials writes the credentials as a generic credential named by
+// the host, replacing any credentials previously stored for the host
+func storeCredentials(ctx context.Context, host string, creds registry.Credentials) error {
+	b, err := json.Marshal(creds)
+	if err != nil {
+		return err
+	}
+	if len(b) > credMaxBlobSize {
+		return fmt.Errorf("credentials for %s are %d bytes, the credential manager holds at most %d", host, len(b), credMaxBlobSize)
+	}
+
+	target, err := windows.UTF16PtrFromString(id(host))
+	if err != nil {
+		return err
+	}
+	comment, err := windows.UTF16PtrFromString(fmt.Sprintf("login for registry at %s", host))
+	if err != nil {
+		return err
+	}
+	user, err := windows.UTF16PtrFromString(creds.Username)
+	if err != nil {
+		return err
+	}
+	cred := credential{
+		Type:               credTypeGeneric,
+		TargetName:         target,
+		Comment:            comment,
+		CredentialBlobSize: uint32(len(b)),
+		Persist:            credPersistLocalMachine,
+		UserName:           user,
+	}
+	if len(b) > 0 {
+		cred.CredentialBlob = &b[0]
+	}
+	if r, _, err := procCredWriteW.Call(uintptr(unsafe.Pointer(&cred)), 0); r == 0 {
+		return fmt.Errorf("failed to write credentials: %w", err)
+	}
+
+	log.G(ctx).WithField("target", id(host)).Debug("credentials saved to credential manager")
+
+	return nil
+}
+
+func getCredentials(ctx context.Context, host, user string) (registry.Credentials, error) {
+	target, err := windows.UTF16PtrFromString(id(host))
+	if err != nil {
+		return registry.Credentials{}, err
+	}
+
+	var cred *credential
+	if r, _, err := procCredReadW.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred))); r == 0 {
+		if errors.Is(err, windows.ERROR_NOT_FOUND) {
+			return registry.Credentials{}, errdefs.ErrNotFound
+		}
+		return registry.Credentials{}, fmt.Errorf("failed to read credentials: %w", err)
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+
+	// Only one credential is stored per host, it must match a requested user
+	if user != "" && cred.UserName != nil && windows.UTF16PtrToString(cred.UserName) != user {
+		return registry.Credentials{}, errdefs.ErrNotFound
+	}
+
+	var creds registry.Credentials
+	if err := json.Unmarshal(unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize), &creds); err != nil {
+		return registry.Credentials{}, err
+	}
+	return creds, nil
+}
+
+func deleteCredentials(ctx context.Context, host string) error {
+	target, err := windows.UTF16PtrFromString(id(host))
+	if err != nil {
+		return err
+	}
+	if r, _, err := procCredDeleteW.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0); r == 0 {
+		if errors.Is(err, windows.ERROR_NOT_FOUND) {
+			return fmt.Errorf("no credentials for %s: %w", host, errdefs.ErrNotFound)
+		}
+		return fmt.Errorf("failed to delete credentials: %w", err)
+	}
+
+	log.G(ctx).WithField("target", id(host)).Debug("credentials removed from credential manager")
+
+	return nil
+}
@@ -0,0 +1,72 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package credentials
+
+import (
+	"context"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	registry "github.com/containerd/containerd/pkg/transfer/registry"
+)
+
+func TestStoreCredentialsManagerReplaces(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping credential manager test in short mode")
+	}
+	if err := checkSystemStore(); err != nil {
+		t.Skipf("credential manager not available: %v", err)
+	}
+
+	var (
+		ctx  = context.Background()
+		host = "lctr-test.registry.example.com"
+	)
+	t.Cleanup(func() {
+		deleteCredentials(ctx, host)
+	})
+
+	if _, err := getCredentials(ctx, host, ""); !errdefs.IsNotFound(err) {
+		t.Fatalf("expected not found before storing, got %v", err)
+	}
+	for _, creds := range []registry.Credentials{
+		{Username: "user1", Secret: "first"},
+		{Username: "user1", Secret: "second"},
+		{Username: "user2", Secret: "third"},
+	} {
+		if err := storeCredentials(ctx, host, creds); err != nil {
+			t.Fatal(err)
+		}
+		stored, err := getCredentials(ctx, host, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored != creds {
+			t.Fatalf("expected %v, got %v", creds, stored)
+		}
+	}
+	if _, err := getCredentials(ctx, host, "user1"); !errdefs.IsNotFound(err) {
+		t.Fatalf("expected not found for replaced user, got %v", err)
+	}
+
+	if err := deleteCredentials(ctx, host); err != nil {
+		t.Fatal(err)
+	}
+	if err := deleteCredentials(ctx, host); !errdefs.IsNotFound(err) {
+		t.Fatalf("expected not found deleting again, got %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package display
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/lcontainerd/pkg/iobuf"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Annotations returns the annotations set in the manifest or index content,
+// rather than the annotations of the descriptor
+func Annotations(ctx context.Context, store content.Provider, desc ocispec.Descriptor) (map[string]string, error) {
+	if !images.IsManifestType(desc.MediaType) && !images.IsIndexType(desc.MediaType) {
+		return nil, fmt.Errorf("media type %q is not a manifest or index", desc.MediaType)
+	}
+	b, err := iobuf.ReadBlob(ctx, store, desc)
+	if err != nil {
+		return nil, err
+	}
+	// Manifests and indexes have annotations in the same field
+	var v struct {
+		Annotations map[string]string `json:"annotations,omitempty"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	return v.Annotations, nil
+}
+
+// PrintAnnotations writes the annotations of the manifest or index, sorted
+// by key, or as a JSON object when asJSON is set
+func (p *Printer) PrintAnnotations(ctx context.Context, desc ocispec.Descriptor, store content.Provider, asJSON bool) error {
+	annotations, err := Annotations(ctx, store, desc)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		enc := json.NewEncoder(p.w)
+		enc.SetIndent("", "   ")
+		return enc.Encode(annotations)
+	}
+
+	keys := make([]string, 0, len(annotations))
+	for k := range annotations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	tw := tabwriter.NewWriter(p.w, 8, 3, 1, ' ', 0)
+	fmt.Fprintf(tw, "Key\tValue\n")
+	fmt.Fprintf(tw, "---\t-----\n")
+	for _, k := range keys {
+		fmt.Fprintf(tw, "%s\t%s\n", k, annotations[k])
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,104 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package display
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/lcontainerd/pkg/index"
+	"github.com/google/go-cmp/cmp"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestPrintAnnotations(t *testing.T) {
+	ctx := context.Background()
+	cs, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Annotations as set with --manifest-annotation, the descriptor
+	// annotations are not shown
+	annotations := map[string]string{
+		ocispec.AnnotationSource:  "https://example.com/source",
+		ocispec.AnnotationVersion: "1.0",
+	}
+	mb, err := json.Marshal(ocispec.Manifest{
+		Versioned:   specs.Versioned{SchemaVersion: 2},
+		MediaType:   ocispec.MediaTypeImageManifest,
+		Config:      writeBlob(ctx, t, cs, index.MediaTypeEmptyJSON, []byte("{}")),
+		Layers:      []ocispec.Descriptor{},
+		Annotations: annotations,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := writeBlob(ctx, t, cs, ocispec.MediaTypeImageManifest, mb)
+	manifest.Annotations = map[string]string{"descriptor": "only"}
+
+	var b bytes.Buffer
+	if err := NewPrinter(WithWriter(&b)).PrintAnnotations(ctx, manifest, cs, false); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for k, v := range annotations {
+		if !strings.Contains(out, k) || !strings.Contains(out, v) {
+			t.Errorf("expected %s=%s in output:\n%s", k, v, out)
+		}
+	}
+	if strings.Contains(out, "descriptor") {
+		t.Errorf("unexpected descriptor annotation in output:\n%s", out)
+	}
+	if strings.Index(out, ocispec.AnnotationSource) > strings.Index(out, ocispec.AnnotationVersion) {
+		t.Errorf("expected annotations sorted by key:\n%s", out)
+	}
+
+	b.Reset()
+	if err := NewPrinter(WithWriter(&b)).PrintAnnotations(ctx, manifest, cs, true); err != nil {
+		t.Fatal(err)
+	}
+	var actual map[string]string
+	if err := json.Unmarshal(b.Bytes(), &actual); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(annotations, actual); diff != "" {
+		t.Fatalf("unexpected annotations (-want +got):\n%s", diff)
+	}
+
+	// An index without annotations is an empty object
+	ib, err := json.Marshal(ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{manifest},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Reset()
+	if err := NewPrinter(WithWriter(&b)).PrintAnnotations(ctx, writeBlob(ctx, t, cs, ocispec.MediaTypeImageIndex, ib), cs, true); err != nil {
+		t.Fatal(err)
+	}
+	if out := strings.TrimSpace(b.String()); out != "{}" {
+		t.Fatalf("expected empty object, got %s", out)
+	}
+}
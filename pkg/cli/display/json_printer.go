@@ -0,0 +1,170 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package display
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/lcontainerd/pkg/index"
+	"github.com/containerd/lcontainerd/pkg/iobuf"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// jsonImage is the JSON form of an image tree
+type jsonImage struct {
+	Name      string            `json:"name"`
+	CreatedAt time.Time         `json:"createdAt"`
+	UpdatedAt time.Time         `json:"updatedAt"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Target    *jsonNode         `json:"target"`
+}
+
+// jsonNode is the JSON form of a descriptor in the tree, the children of a
+// manifest are its config and layers and the children of an index are its
+// manifests
+type jsonNode struct {
+	ocispec.Descriptor
+
+	// Labels are the content labels, only set for content which is read
+	Labels map[string]string `json:"labels,omitempty"`
+	// Content is the JSON content, only set when verbose
+	Content json.RawMessage `json:"content,omitempty"`
+
+	Config    *jsonNode  `json:"config,omitempty"`
+	Layers    []jsonNode `json:"layers,omitempty"`
+	Manifests []jsonNode `json:"manifests,omitempty"`
+
+	// ElidedLayers and ElidedSize are the count and total size of the
+	// layers beyond the layer limit which are not included in Layers
+	ElidedLayers int   `json:"elidedLayers,omitempty"`
+	ElidedSize   int64 `json:"elidedSize,omitempty"`
+}
+
+func (p *Printer) printImageJSON(ctx context.Context, img images.Image, store ContentReader) error {
+	target, err := p.jsonTree(ctx, img.Target, store, 0)
+	if err != nil {
+		return err
+	}
+	return p.writeJSON(jsonImage{
+		Name:      img.Name,
+		CreatedAt: img.CreatedAt,
+		UpdatedAt: img.UpdatedAt,
+		Labels:    img.Labels,
+		Target:    target,
+	})
+}
+
+func (p *Printer) printManifestJSON(ctx context.Context, desc ocispec.Descriptor, store ContentReader) error {
+	node, err := p.jsonTree(ctx, desc, store, 0)
+	if err != nil {
+		return err
+	}
+	return p.writeJSON(node)
+}
+
+func (p *Printer) writeJSON(v interface{}) error {
+	enc := json.NewEncoder(p.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// jsonTree resolves the descriptor and its children, reading the same
+// content as the line drawing tree
+func (p *Printer) jsonTree(ctx context.Context, desc ocispec.Descriptor, store ContentReader, level int) (*jsonNode, error) {
+	node := &jsonNode{Descriptor: desc}
+	if p.depth > 0 && level >= p.depth {
+		return node, nil
+	}
+
+	var b []byte
+	if images.IsManifestType(desc.MediaType) || images.IsIndexType(desc.MediaType) {
+		var err error
+		if b, err = iobuf.ReadBlob(ctx, store, desc); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.addContent(ctx, store, node, b); err != nil {
+		return nil, err
+	}
+
+	switch desc.MediaType {
+	case images.MediaTypeDockerSchema2Manifest, ocispec.MediaTypeImageManifest:
+		var manifest ocispec.Manifest
+		if err := json.Unmarshal(b, &manifest); err != nil {
+			return nil, err
+		}
+
+		node.Config = &jsonNode{Descriptor: manifest.Config}
+		// The empty config holds no information, it may not be stored locally
+		if manifest.Config.MediaType != index.MediaTypeEmptyJSON {
+			if err := p.addContent(ctx, store, node.Config, nil); err != nil {
+				return nil, err
+			}
+		}
+		// Layers are never read
+		layers, elided := p.limitLayers(manifest.Layers)
+		for _, layer := range layers {
+			node.Layers = append(node.Layers, jsonNode{Descriptor: layer})
+		}
+		node.ElidedLayers, node.ElidedSize = len(elided), totalSize(elided)
+
+	case images.MediaTypeDockerSchema2ManifestList, ocispec.MediaTypeImageIndex:
+		var idx ocispec.Index
+		if err := json.Unmarshal(b, &idx); err != nil {
+			return nil, err
+		}
+
+		for _, m := range idx.Manifests {
+			child, err := p.jsonTree(ctx, m, store, level+1)
+			if err != nil {
+				return nil, err
+			}
+			node.Manifests = append(node.Manifests, *child)
+		}
+	}
+
+	return node, nil
+}
+
+// addContent sets the labels of the content and, when verbose, the JSON
+// content, the content is only read from the store when cb was not already
+// read by the caller
+func (p *Printer) addContent(ctx context.Context, store ContentReader, node *jsonNode, cb []byte) error {
+	info, err := store.Info(ctx, node.Digest)
+	if err != nil {
+		return err
+	}
+	node.Labels = info.Labels
+
+	if p.verbose && strings.HasSuffix(node.MediaType, "json") {
+		if cb == nil {
+			if cb, err = iobuf.ReadBlob(ctx, store, node.Descriptor); err != nil {
+				return err
+			}
+		}
+		if !json.Valid(cb) {
+			return fmt.Errorf("content %s is not valid JSON", node.Digest)
+		}
+		node.Content = cb
+	}
+	return nil
+}
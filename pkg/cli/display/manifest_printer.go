@@ -26,7 +26,10 @@ import (
 	"strings"
 
 	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/images"
+	"github.com/containerd/lcontainerd/pkg/index"
+	"github.com/containerd/lcontainerd/pkg/iobuf"
 	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 )
@@ -67,8 +70,12 @@ type ContentReader interface {
 
 type Printer struct {
 	verbose bool
+	json    bool
 	w       io.Writer
 	format  TreeFormat
+	depth   int
+
+	layerLimit int
 }
 
 type PrintOpt func(*Printer)
@@ -89,6 +96,30 @@ func WithFormat(format TreeFormat) PrintOpt {
 	}
 }
 
+// WithJSON prints trees as a single JSON document instead of line drawing,
+// the nesting of the document follows the nesting of the tree
+func WithJSON(p *Printer) {
+	p.json = true
+}
+
+// WithDepth limits how many levels below the root descriptor are expanded,
+// descriptors at the limit are printed without reading their content.
+// A depth of 0 expands the whole tree.
+func WithDepth(depth int) PrintOpt {
+	return func(p *Printer) {
+		p.depth = depth
+	}
+}
+
+// WithLayerLimit shows at most limit layers of each manifest, the remaining
+// layers are collapsed into a single entry with their count and total size.
+// A limit of 0 shows every layer.
+func WithLayerLimit(limit int) PrintOpt {
+	return func(p *Printer) {
+		p.layerLimit = limit
+	}
+}
+
 func NewPrinter(opts ...PrintOpt) *Printer {
 	p := &Printer{
 		verbose: false,
@@ -104,6 +135,9 @@ func NewPrinter(opts ...PrintOpt) *Printer {
 
 // PrintImageTree prints an image and all its sub elements
 func (p *Printer) PrintImageTree(ctx context.Context, img images.Image, store ContentReader) error {
+	if p.json {
+		return p.printImageJSON(ctx, img, store)
+	}
 	fmt.Fprintln(p.w, img.Name)
 	subchild := p.format.SkipLine
 	fmt.Fprintf(p.w, "%s Created: %s\n", subchild, img.CreatedAt)
@@ -111,29 +145,65 @@ func (p *Printer) PrintImageTree(ctx context.Context, img images.Image, store Co
 	for k, v := range img.Labels {
 		fmt.Fprintf(p.w, "%s Label %q: %q\n", subchild, k, v)
 	}
-	return p.printManifestTree(ctx, img.Target, store, p.format.LastDrop, p.format.Spacer)
+	return p.printManifestTree(ctx, img.Target, store, p.format.LastDrop, p.format.Spacer, 0)
 }
 
 // PrintManifestTree prints a manifest and all its sub elements
 func (p *Printer) PrintManifestTree(ctx context.Context, desc ocispec.Descriptor, store ContentReader) error {
+	if p.json {
+		return p.printManifestJSON(ctx, desc, store)
+	}
 	// start displaying tree from the root descriptor perspective, which is a single child view
-	return p.printManifestTree(ctx, desc, store, p.format.LastDrop, p.format.Spacer)
+	return p.printManifestTree(ctx, desc, store, p.format.LastDrop, p.format.Spacer, 0)
 }
 
-func (p *Printer) printManifestTree(ctx context.Context, desc ocispec.Descriptor, store ContentReader, prefix, childprefix string) error {
+// PrintRaw writes the unformatted bytes of the content, verifying the
+// bytes match the descriptor before writing
+func (p *Printer) PrintRaw(ctx context.Context, desc ocispec.Descriptor, store content.Provider) error {
+	b, err := iobuf.ReadBlob(ctx, store, desc)
+	if err != nil {
+		return err
+	}
+	if actual := desc.Digest.Algorithm().FromBytes(b); actual != desc.Digest {
+		return fmt.Errorf("content %s does not match its digest, got %s", desc.Digest, actual)
+	}
+	_, err = p.w.Write(b)
+	return err
+}
+
+// printManifestTree writes each descriptor as it is visited, only manifest and
+// index blobs are read to find children, layers are never read
+func (p *Printer) printManifestTree(ctx context.Context, desc ocispec.Descriptor, store ContentReader, prefix, childprefix string, level int) error {
 	subprefix := childprefix + p.format.MiddleDrop
 	subchild := childprefix + p.format.SkipLine
 	fmt.Fprintf(p.w, "%s%s @%s (%d bytes)\n", prefix, desc.MediaType, desc.Digest, desc.Size)
 
-	if desc.Platform != nil && desc.Platform.Architecture != "" {
-		// TODO: Use containerd platform library to format
-		fmt.Fprintf(p.w, "%s Platform: %s/%s\n", subchild, desc.Platform.OS, desc.Platform.Architecture)
+	expand := p.depth <= 0 || level < p.depth
+	var b []byte
+	if expand && (images.IsManifestType(desc.MediaType) || images.IsIndexType(desc.MediaType)) {
+		var err error
+		if b, err = iobuf.ReadBlob(ctx, store, desc); err != nil {
+			return err
+		}
 	}
-	b, err := content.ReadBlob(ctx, store, desc)
-	if err != nil {
-		return err
+
+	platform := desc.Platform
+	if platform == nil && b != nil && images.IsManifestType(desc.MediaType) {
+		// A manifest outside of an index only has the platform in its config,
+		// the config may not be stored locally
+		var err error
+		if platform, err = configPlatform(ctx, store, b); err != nil && !errdefs.IsNotFound(err) {
+			return err
+		}
 	}
-	if err := p.showContent(ctx, store, desc, subchild); err != nil {
+	if platform != nil && platform.Architecture != "" {
+		fmt.Fprintf(p.w, "%s Platform: %s\n", subchild, formatPlatform(*platform))
+	}
+	p.printURLs(desc, subchild)
+	if !expand {
+		return nil
+	}
+	if err := p.showContent(ctx, store, desc, b, subchild); err != nil {
 		return err
 	}
 
@@ -144,22 +214,37 @@ func (p *Printer) printManifestTree(ctx context.Context, desc ocispec.Descriptor
 			return err
 		}
 
+		if manifest.ArtifactType != "" {
+			fmt.Fprintf(p.w, "%s Artifact Type: %s\n", subchild, manifest.ArtifactType)
+		}
 		if len(manifest.Layers) == 0 {
 			subprefix = childprefix + p.format.LastDrop
 			subchild = childprefix + p.format.Spacer
 		}
-		fmt.Fprintf(p.w, "%s%s @%s (%d bytes)\n", subprefix, manifest.Config.MediaType, manifest.Config.Digest, manifest.Config.Size)
+		if manifest.Config.MediaType == index.MediaTypeEmptyJSON {
+			// The empty config holds no information, it may not be stored locally
+			fmt.Fprintf(p.w, "%s%s @%s (empty)\n", subprefix, manifest.Config.MediaType, manifest.Config.Digest)
+		} else {
+			fmt.Fprintf(p.w, "%s%s @%s (%d bytes)\n", subprefix, manifest.Config.MediaType, manifest.Config.Digest, manifest.Config.Size)
 
-		if err := p.showContent(ctx, store, manifest.Config, subchild); err != nil {
-			return err
+			if err := p.showContent(ctx, store, manifest.Config, nil, subchild); err != nil {
+				return err
+			}
 		}
 
-		for i := range manifest.Layers {
-			if len(manifest.Layers) == i+1 {
+		layers, elided := p.limitLayers(manifest.Layers)
+		for i := range layers {
+			layerchild := childprefix + p.format.SkipLine
+			if len(layers) == i+1 && len(elided) == 0 {
 				subprefix = childprefix + p.format.LastDrop
-				//subchild = childprefix + p.format.Spacer
+				layerchild = childprefix + p.format.Spacer
 			}
-			fmt.Fprintf(p.w, "%s%s @%s (%d bytes)\n", subprefix, manifest.Layers[i].MediaType, manifest.Layers[i].Digest, manifest.Layers[i].Size)
+			fmt.Fprintf(p.w, "%s%s @%s (%d bytes)\n", subprefix, layers[i].MediaType, layers[i].Digest, layers[i].Size)
+			// Layers are never read, foreign layers may only be available from their urls
+			p.printURLs(layers[i], layerchild+p.format.SkipLine)
+		}
+		if len(elided) > 0 {
+			fmt.Fprintf(p.w, "%s… %d more layers (%d bytes)\n", childprefix+p.format.LastDrop, len(elided), totalSize(elided))
 		}
 
 	case images.MediaTypeDockerSchema2ManifestList, ocispec.MediaTypeImageIndex:
@@ -173,7 +258,7 @@ func (p *Printer) printManifestTree(ctx context.Context, desc ocispec.Descriptor
 				subprefix = childprefix + p.format.LastDrop
 				subchild = childprefix + p.format.Spacer
 			}
-			if err := p.printManifestTree(ctx, idx.Manifests[i], store, subprefix, subchild); err != nil {
+			if err := p.printManifestTree(ctx, idx.Manifests[i], store, subprefix, subchild, level+1); err != nil {
 				return err
 			}
 		}
@@ -182,7 +267,34 @@ func (p *Printer) printManifestTree(ctx context.Context, desc ocispec.Descriptor
 	return nil
 }
 
-func (p *Printer) showContent(ctx context.Context, store ContentReader, desc ocispec.Descriptor, prefix string) error {
+// limitLayers splits the layers into those to show and those to collapse
+// beyond the layer limit
+func (p *Printer) limitLayers(layers []ocispec.Descriptor) (shown, elided []ocispec.Descriptor) {
+	if p.layerLimit > 0 && len(layers) > p.layerLimit {
+		return layers[:p.layerLimit], layers[p.layerLimit:]
+	}
+	return layers, nil
+}
+
+// totalSize returns the combined size of the descriptors
+func totalSize(descs []ocispec.Descriptor) int64 {
+	var size int64
+	for _, desc := range descs {
+		size += desc.Size
+	}
+	return size
+}
+
+// printURLs prints the urls the descriptor's content may be fetched from
+func (p *Printer) printURLs(desc ocispec.Descriptor, prefix string) {
+	for _, u := range desc.URLs {
+		fmt.Fprintf(p.w, "%s URL: %s\n", prefix, u)
+	}
+}
+
+// showContent prints the labels and JSON content when verbose, the content is
+// only read from the store when cb was not already read by the caller
+func (p *Printer) showContent(ctx context.Context, store ContentReader, desc ocispec.Descriptor, cb []byte, prefix string) error {
 	if p.verbose {
 		info, err := store.Info(ctx, desc.Digest)
 		if err != nil {
@@ -198,9 +310,11 @@ func (p *Printer) showContent(ctx context.Context, store ContentReader, desc oci
 	}
 	if p.verbose && strings.HasSuffix(desc.MediaType, "json") {
 		// Print content for config
-		cb, err := content.ReadBlob(ctx, store, desc)
-		if err != nil {
-			return err
+		if cb == nil {
+			var err error
+			if cb, err = iobuf.ReadBlob(ctx, store, desc); err != nil {
+				return err
+			}
 		}
 		dst := bytes.NewBuffer(nil)
 		json.Indent(dst, cb, prefix+"│", "   ")
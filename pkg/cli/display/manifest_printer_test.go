@@ -0,0 +1,500 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package display
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/lcontainerd/pkg/index"
+	"github.com/containerd/lcontainerd/pkg/iobuf"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestPrintEmptyConfig(t *testing.T) {
+	ctx := context.Background()
+	cs, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	empty := []byte("{}")
+	config := writeBlob(ctx, t, cs, index.MediaTypeEmptyJSON, empty)
+	layer := writeBlob(ctx, t, cs, "application/vnd.example.data", []byte("artifact data"))
+	mb, err := json.Marshal(ocispec.Manifest{
+		Versioned:    specs.Versioned{SchemaVersion: 2},
+		MediaType:    ocispec.MediaTypeImageManifest,
+		ArtifactType: "application/vnd.example.artifact",
+		Config:       config,
+		Layers:       []ocispec.Descriptor{layer},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := writeBlob(ctx, t, cs, ocispec.MediaTypeImageManifest, mb)
+
+	for _, verbose := range []bool{false, true} {
+		var b bytes.Buffer
+		opts := []PrintOpt{WithWriter(&b)}
+		if verbose {
+			opts = append(opts, Verbose)
+		}
+		if err := NewPrinter(opts...).PrintImageTree(ctx, images.Image{Name: "artifact", Target: manifest}, cs); err != nil {
+			t.Fatal(err)
+		}
+		out := b.String()
+		for _, expected := range []string{
+			"Artifact Type: application/vnd.example.artifact",
+			index.MediaTypeEmptyJSON + " @" + config.Digest.String() + " (empty)",
+			layer.MediaType + " @" + layer.Digest.String(),
+		} {
+			if !strings.Contains(out, expected) {
+				t.Errorf("expected %q in output:\n%s", expected, out)
+			}
+		}
+	}
+}
+
+func writeBlob(ctx context.Context, t *testing.T, cs content.Store, mediaType string, b []byte) ocispec.Descriptor {
+	t.Helper()
+	desc := ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(b),
+		Size:      int64(len(b)),
+	}
+	if err := content.WriteBlob(ctx, cs, desc.Digest.String(), bytes.NewReader(b), desc); err != nil {
+		t.Fatal(err)
+	}
+	return desc
+}
+
+func TestPrintRaw(t *testing.T) {
+	ctx := context.Background()
+	cs, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := writeBlob(ctx, t, cs, index.MediaTypeEmptyJSON, []byte("{}"))
+	mb, err := json.MarshalIndent(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []ocispec.Descriptor{},
+	}, "", "   ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := writeBlob(ctx, t, cs, ocispec.MediaTypeImageManifest, mb)
+
+	var b bytes.Buffer
+	if err := NewPrinter(WithWriter(&b)).PrintRaw(ctx, manifest, cs); err != nil {
+		t.Fatal(err)
+	}
+	if actual := digest.FromBytes(b.Bytes()); actual != manifest.Digest {
+		t.Fatalf("raw output digest %s does not match target %s", actual, manifest.Digest)
+	}
+}
+
+type readRecorder struct {
+	content.Store
+	read map[digest.Digest]int
+}
+
+func (r *readRecorder) ReaderAt(ctx context.Context, desc ocispec.Descriptor) (content.ReaderAt, error) {
+	r.read[desc.Digest]++
+	return r.Store.ReaderAt(ctx, desc)
+}
+
+func TestPrintTreeReads(t *testing.T) {
+	ctx := context.Background()
+	cs, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		layers    []ocispec.Descriptor
+		manifests []ocispec.Descriptor
+	)
+	for _, arch := range []string{"amd64", "arm64"} {
+		config := writeBlob(ctx, t, cs, ocispec.MediaTypeImageConfig, []byte(`{"architecture":"`+arch+`","os":"linux"}`))
+		layer := writeBlob(ctx, t, cs, ocispec.MediaTypeImageLayerGzip, []byte("layer "+arch))
+		mb, err := json.Marshal(ocispec.Manifest{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: ocispec.MediaTypeImageManifest,
+			Config:    config,
+			Layers:    []ocispec.Descriptor{layer},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		manifest := writeBlob(ctx, t, cs, ocispec.MediaTypeImageManifest, mb)
+		manifest.Platform = &ocispec.Platform{OS: "linux", Architecture: arch}
+		manifests = append(manifests, manifest)
+		layers = append(layers, layer)
+	}
+	ib, err := json.Marshal(ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: manifests,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx := writeBlob(ctx, t, cs, ocispec.MediaTypeImageIndex, ib)
+
+	for _, tc := range []struct {
+		name     string
+		opts     []PrintOpt
+		expected []ocispec.Descriptor
+		skipped  []ocispec.Descriptor
+	}{
+		{"full", nil, manifests, nil},
+		{"verbose", []PrintOpt{Verbose}, manifests, nil},
+		{"depth", []PrintOpt{WithDepth(1)}, nil, manifests},
+		{"json", []PrintOpt{WithJSON}, manifests, nil},
+		{"json-verbose", []PrintOpt{WithJSON, Verbose}, manifests, nil},
+		{"json-depth", []PrintOpt{WithJSON, WithDepth(1)}, nil, manifests},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				b  bytes.Buffer
+				rr = &readRecorder{Store: cs, read: map[digest.Digest]int{}}
+			)
+			opts := append([]PrintOpt{WithWriter(&b)}, tc.opts...)
+			if err := NewPrinter(opts...).PrintManifestTree(ctx, idx, rr); err != nil {
+				t.Fatal(err)
+			}
+			if rr.read[idx.Digest] != 1 {
+				t.Errorf("expected index read once, read %d times", rr.read[idx.Digest])
+			}
+			for _, layer := range layers {
+				if n := rr.read[layer.Digest]; n != 0 {
+					t.Errorf("layer %s read %d times", layer.Digest, n)
+				}
+			}
+			for _, m := range tc.expected {
+				if n := rr.read[m.Digest]; n != 1 {
+					t.Errorf("expected manifest %s read once, read %d times", m.Digest, n)
+				}
+			}
+			for _, m := range tc.skipped {
+				if n := rr.read[m.Digest]; n != 0 {
+					t.Errorf("manifest %s beyond depth read %d times", m.Digest, n)
+				}
+				if !strings.Contains(b.String(), m.Digest.String()) {
+					t.Errorf("expected manifest %s in output:\n%s", m.Digest, b.String())
+				}
+			}
+		})
+	}
+}
+
+func TestPrintJSON(t *testing.T) {
+	ctx := context.Background()
+	cs, err := local.NewLabeledStore(t.TempDir(), labelStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := writeBlob(ctx, t, cs, ocispec.MediaTypeImageConfig, []byte(`{"architecture":"amd64","os":"linux"}`))
+	layer := writeBlob(ctx, t, cs, ocispec.MediaTypeImageLayerGzip, []byte("layer"))
+	mb, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []ocispec.Descriptor{layer},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := writeBlob(ctx, t, cs, ocispec.MediaTypeImageManifest, mb)
+	if _, err := cs.Update(ctx, content.Info{Digest: manifest.Digest, Labels: map[string]string{"example": "label"}}, "labels.example"); err != nil {
+		t.Fatal(err)
+	}
+	manifest.Platform = &ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	ib, err := json.Marshal(ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{manifest},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx := writeBlob(ctx, t, cs, ocispec.MediaTypeImageIndex, ib)
+
+	for _, verbose := range []bool{false, true} {
+		var b bytes.Buffer
+		opts := []PrintOpt{WithWriter(&b), WithJSON}
+		if verbose {
+			opts = append(opts, Verbose)
+		}
+		if err := NewPrinter(opts...).PrintImageTree(ctx, images.Image{Name: "example", Target: idx}, cs); err != nil {
+			t.Fatal(err)
+		}
+		var img jsonImage
+		if err := json.Unmarshal(b.Bytes(), &img); err != nil {
+			t.Fatalf("invalid JSON output: %v\n%s", err, b.String())
+		}
+		if img.Name != "example" || img.Target == nil || img.Target.Digest != idx.Digest {
+			t.Fatalf("unexpected image:\n%s", b.String())
+		}
+		if len(img.Target.Manifests) != 1 {
+			t.Fatalf("expected manifest nested under index:\n%s", b.String())
+		}
+		m := img.Target.Manifests[0]
+		if m.Digest != manifest.Digest || m.Platform == nil || m.Platform.Architecture != "amd64" {
+			t.Errorf("unexpected manifest %+v", m.Descriptor)
+		}
+		if m.Labels["example"] != "label" {
+			t.Errorf("expected manifest labels, got %v", m.Labels)
+		}
+		if m.Config == nil || m.Config.Digest != config.Digest || len(m.Layers) != 1 || m.Layers[0].Digest != layer.Digest {
+			t.Fatalf("unexpected manifest children:\n%s", b.String())
+		}
+		if verbose {
+			var content bytes.Buffer
+			if err := json.Compact(&content, m.Content); err != nil || !bytes.Equal(content.Bytes(), mb) || m.Config.Content == nil {
+				t.Errorf("expected content inlined:\n%s", b.String())
+			}
+		} else if m.Content != nil || m.Config.Content != nil {
+			t.Errorf("unexpected content inlined:\n%s", b.String())
+		}
+	}
+}
+
+// labelStore holds content labels in memory
+type labelStore map[digest.Digest]map[string]string
+
+func (s labelStore) Get(dgst digest.Digest) (map[string]string, error) {
+	return s[dgst], nil
+}
+
+func (s labelStore) Set(dgst digest.Digest, labels map[string]string) error {
+	s[dgst] = labels
+	return nil
+}
+
+func (s labelStore) Update(dgst digest.Digest, update map[string]string) (map[string]string, error) {
+	labels := s[dgst]
+	if labels == nil {
+		labels = map[string]string{}
+		s[dgst] = labels
+	}
+	for k, v := range update {
+		if v == "" {
+			delete(labels, k)
+		} else {
+			labels[k] = v
+		}
+	}
+	return labels, nil
+}
+
+func TestPrintForeignLayer(t *testing.T) {
+	ctx := context.Background()
+	cs, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The foreign layer is only available from its url and is not stored
+	foreign := ocispec.Descriptor{
+		MediaType: images.MediaTypeDockerSchema2LayerForeignGzip,
+		Digest:    digest.FromString("foreign layer"),
+		Size:      13,
+		URLs:      []string{"https://example.com/layers/base.tar.gz"},
+	}
+	config := writeBlob(ctx, t, cs, ocispec.MediaTypeImageConfig, []byte(`{"architecture":"amd64","os":"windows"}`))
+	layer := writeBlob(ctx, t, cs, ocispec.MediaTypeImageLayerGzip, []byte("local layer"))
+	mb, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []ocispec.Descriptor{foreign, layer},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := writeBlob(ctx, t, cs, ocispec.MediaTypeImageManifest, mb)
+
+	var (
+		b  bytes.Buffer
+		rr = &readRecorder{Store: cs, read: map[digest.Digest]int{}}
+	)
+	if err := NewPrinter(WithWriter(&b), Verbose).PrintImageTree(ctx, images.Image{Name: "foreign", Target: manifest}, rr); err != nil {
+		t.Fatal(err)
+	}
+	if n := rr.read[foreign.Digest]; n != 0 {
+		t.Errorf("foreign layer read %d times", n)
+	}
+	out := b.String()
+	for _, expected := range []string{
+		foreign.MediaType + " @" + foreign.Digest.String(),
+		"URL: " + foreign.URLs[0],
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in output:\n%s", expected, out)
+		}
+	}
+}
+
+func TestPrintOversizedManifest(t *testing.T) {
+	ctx := context.Background()
+	cs, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := iobuf.SetMaxBlobSize(1024); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		iobuf.SetMaxBlobSize(iobuf.DefaultMaxBlobSize)
+	})
+
+	// A large blob given the manifest media type is never parsed
+	manifest := writeBlob(ctx, t, cs, ocispec.MediaTypeImageManifest, bytes.Repeat([]byte{0}, 4096))
+	var b bytes.Buffer
+	err = NewPrinter(WithWriter(&b)).PrintImageTree(ctx, images.Image{Name: "large", Target: manifest}, cs)
+	if !errors.Is(err, iobuf.ErrBlobTooLarge) {
+		t.Fatalf("expected blob too large error, got %v", err)
+	}
+}
+
+func TestPrintTreePlatform(t *testing.T) {
+	ctx := context.Background()
+	cs, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeManifest := func(config []byte) ocispec.Descriptor {
+		mb, err := json.Marshal(ocispec.Manifest{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: ocispec.MediaTypeImageManifest,
+			Config:    writeBlob(ctx, t, cs, ocispec.MediaTypeImageConfig, config),
+			Layers:    []ocispec.Descriptor{},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return writeBlob(ctx, t, cs, ocispec.MediaTypeImageManifest, mb)
+	}
+
+	arm := writeManifest([]byte(`{"architecture":"arm","variant":"v7","os":"linux"}`))
+	arm.Platform = &ocispec.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}
+	windows := writeManifest([]byte(`{"architecture":"amd64","os":"windows"}`))
+	windows.Platform = &ocispec.Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.19041"}
+	ib, err := json.Marshal(ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{arm, windows},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx := writeBlob(ctx, t, cs, ocispec.MediaTypeImageIndex, ib)
+
+	var b bytes.Buffer
+	if err := NewPrinter(WithWriter(&b)).PrintManifestTree(ctx, idx, cs); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"Platform: linux/arm/v7\n", "Platform: windows/amd64:10.0.19041\n"} {
+		if !strings.Contains(b.String(), expected) {
+			t.Errorf("expected %q in output:\n%s", expected, b.String())
+		}
+	}
+
+	// A manifest without a platform in its descriptor uses its config
+	arm.Platform = nil
+	b.Reset()
+	if err := NewPrinter(WithWriter(&b)).PrintManifestTree(ctx, arm, cs); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "Platform: linux/arm/v7\n") {
+		t.Errorf("expected config platform in output:\n%s", b.String())
+	}
+}
+
+func TestPrintLayerLimit(t *testing.T) {
+	ctx := context.Background()
+	cs, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var layers []ocispec.Descriptor
+	for i := 0; i < 5; i++ {
+		layers = append(layers, writeBlob(ctx, t, cs, ocispec.MediaTypeImageLayerGzip, []byte(strings.Repeat("l", 10*(i+1)))))
+	}
+	mb, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    writeBlob(ctx, t, cs, ocispec.MediaTypeImageConfig, []byte(`{"architecture":"amd64","os":"linux"}`)),
+		Layers:    layers,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := writeBlob(ctx, t, cs, ocispec.MediaTypeImageManifest, mb)
+
+	var b bytes.Buffer
+	if err := NewPrinter(WithWriter(&b), WithLayerLimit(2)).PrintManifestTree(ctx, manifest, cs); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for i, layer := range layers {
+		if shown := strings.Contains(out, layer.Digest.String()); shown != (i < 2) {
+			t.Errorf("layer %d shown %t:\n%s", i, shown, out)
+		}
+	}
+	// The collapsed layers are the last child with their combined size
+	if expected := "    └── … 3 more layers (120 bytes)\n"; !strings.HasSuffix(out, expected) {
+		t.Errorf("expected output to end with %q:\n%s", expected, out)
+	}
+
+	b.Reset()
+	if err := NewPrinter(WithWriter(&b), WithLayerLimit(2), WithJSON).PrintManifestTree(ctx, manifest, cs); err != nil {
+		t.Fatal(err)
+	}
+	var node jsonNode
+	if err := json.Unmarshal(b.Bytes(), &node); err != nil {
+		t.Fatal(err)
+	}
+	if len(node.Layers) != 2 || node.ElidedLayers != 3 || node.ElidedSize != 120 {
+		t.Fatalf("unexpected layers %d with %d elided of %d bytes", len(node.Layers), node.ElidedLayers, node.ElidedSize)
+	}
+
+	// A limit which is not exceeded shows every layer
+	b.Reset()
+	if err := NewPrinter(WithWriter(&b), WithLayerLimit(5)).PrintManifestTree(ctx, manifest, cs); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(b.String(), "more layers") {
+		t.Errorf("unexpected collapsed layers:\n%s", b.String())
+	}
+}
@@ -0,0 +1,139 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package display
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/lcontainerd/pkg/iobuf"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ManifestPlatform is a manifest and the platform it was built for
+type ManifestPlatform struct {
+	// Platform is nil when the platform of the manifest is not known
+	Platform *ocispec.Platform
+	Digest   digest.Digest
+}
+
+// Platforms returns the platform of each manifest referenced from the
+// descriptor. Manifests in an index use the platform given by the index,
+// nested indexes are walked, and a single manifest uses the platform from
+// its config.
+func Platforms(ctx context.Context, store content.Provider, desc ocispec.Descriptor) ([]ManifestPlatform, error) {
+	switch {
+	case images.IsIndexType(desc.MediaType):
+		b, err := iobuf.ReadBlob(ctx, store, desc)
+		if err != nil {
+			return nil, err
+		}
+		var idx ocispec.Index
+		if err := json.Unmarshal(b, &idx); err != nil {
+			return nil, err
+		}
+		var mps []ManifestPlatform
+		for _, m := range idx.Manifests {
+			if images.IsIndexType(m.MediaType) {
+				nested, err := Platforms(ctx, store, m)
+				if err != nil {
+					return nil, err
+				}
+				mps = append(mps, nested...)
+				continue
+			}
+			mps = append(mps, ManifestPlatform{Platform: m.Platform, Digest: m.Digest})
+		}
+		return mps, nil
+	case images.IsManifestType(desc.MediaType):
+		mp := ManifestPlatform{Digest: desc.Digest}
+		if desc.Platform != nil {
+			mp.Platform = desc.Platform
+			return []ManifestPlatform{mp}, nil
+		}
+		b, err := iobuf.ReadBlob(ctx, store, desc)
+		if err != nil {
+			return nil, err
+		}
+		if mp.Platform, err = configPlatform(ctx, store, b); err != nil {
+			return nil, err
+		}
+		return []ManifestPlatform{mp}, nil
+	}
+	return nil, fmt.Errorf("media type %q is not a manifest or index", desc.MediaType)
+}
+
+// configPlatform returns the platform from the config referenced by the
+// manifest, nil is returned when the config does not give a platform, such
+// as for artifacts
+func configPlatform(ctx context.Context, store content.Provider, manifest []byte) (*ocispec.Platform, error) {
+	var m ocispec.Manifest
+	if err := json.Unmarshal(manifest, &m); err != nil {
+		return nil, err
+	}
+	if !images.IsConfigType(m.Config.MediaType) {
+		return nil, nil
+	}
+	b, err := iobuf.ReadBlob(ctx, store, m.Config)
+	if err != nil {
+		return nil, err
+	}
+	var config ocispec.Image
+	if err := json.Unmarshal(b, &config); err != nil {
+		return nil, err
+	}
+	if config.Architecture == "" {
+		return nil, nil
+	}
+	return &config.Platform, nil
+}
+
+// formatPlatform formats the platform with the platforms library, adding
+// the OS version when given, such as "windows/amd64:10.0.19041"
+func formatPlatform(p ocispec.Platform) string {
+	s := platforms.Format(p)
+	if p.OSVersion != "" {
+		s += ":" + p.OSVersion
+	}
+	return s
+}
+
+// PrintPlatforms writes the platform and digest of each manifest referenced
+// from the descriptor, the platform is shown as "unknown" when not given
+func (p *Printer) PrintPlatforms(ctx context.Context, desc ocispec.Descriptor, store content.Provider) error {
+	mps, err := Platforms(ctx, store, desc)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(p.w, 8, 3, 1, ' ', 0)
+	fmt.Fprintf(tw, "Platform\tDigest\n")
+	fmt.Fprintf(tw, "--------\t------\n")
+	for _, mp := range mps {
+		platform := "unknown"
+		if mp.Platform != nil && mp.Platform.Architecture != "" {
+			platform = formatPlatform(*mp.Platform)
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", platform, mp.Digest)
+	}
+	return tw.Flush()
+}
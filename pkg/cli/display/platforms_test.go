@@ -0,0 +1,137 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package display
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/lcontainerd/pkg/index"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestPrintPlatforms(t *testing.T) {
+	ctx := context.Background()
+	cs, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeManifest := func(p ocispec.Platform) ocispec.Descriptor {
+		cb, err := json.Marshal(ocispec.Image{Platform: p, RootFS: ocispec.RootFS{Type: "layers"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		mb, err := json.Marshal(ocispec.Manifest{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: ocispec.MediaTypeImageManifest,
+			Config:    writeBlob(ctx, t, cs, ocispec.MediaTypeImageConfig, cb),
+			Layers:    []ocispec.Descriptor{},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return writeBlob(ctx, t, cs, ocispec.MediaTypeImageManifest, mb)
+	}
+	writeIndex := func(manifests ...ocispec.Descriptor) ocispec.Descriptor {
+		b, err := json.Marshal(ocispec.Index{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: ocispec.MediaTypeImageIndex,
+			Manifests: manifests,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return writeBlob(ctx, t, cs, ocispec.MediaTypeImageIndex, b)
+	}
+	withPlatform := func(desc ocispec.Descriptor, s string) ocispec.Descriptor {
+		p := platforms.MustParse(s)
+		desc.Platform = &p
+		return desc
+	}
+
+	var (
+		amd64   = withPlatform(writeManifest(platforms.MustParse("linux/amd64")), "linux/amd64")
+		arm64   = withPlatform(writeManifest(platforms.MustParse("linux/arm64/v8")), "linux/arm64/v8")
+		arm     = withPlatform(writeManifest(platforms.MustParse("linux/arm/v7")), "linux/arm/v7")
+		unknown = writeManifest(platforms.MustParse("linux/s390x"))
+		nested  = writeIndex(arm)
+		idx     = writeIndex(amd64, arm64, nested, unknown)
+	)
+
+	mps, err := Platforms(ctx, cs, idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mps) != 4 {
+		t.Fatalf("expected 4 manifests, got %d", len(mps))
+	}
+
+	var b bytes.Buffer
+	if err := NewPrinter(WithWriter(&b)).PrintPlatforms(ctx, idx, cs); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, expected := range []string{
+		"linux/amd64 ",
+		"linux/arm64/v8 ",
+		"linux/arm/v7 ",
+		"unknown ",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in output:\n%s", expected, out)
+		}
+	}
+	for _, desc := range []ocispec.Descriptor{amd64, arm64, arm, unknown} {
+		if !strings.Contains(out, desc.Digest.String()) {
+			t.Errorf("expected %s in output:\n%s", desc.Digest, out)
+		}
+	}
+
+	// A single manifest uses the platform from its config
+	b.Reset()
+	if err := NewPrinter(WithWriter(&b)).PrintPlatforms(ctx, unknown, cs); err != nil {
+		t.Fatal(err)
+	}
+	if out := b.String(); !strings.Contains(out, "linux/s390x") {
+		t.Errorf("expected config platform in output:\n%s", out)
+	}
+
+	// Manifests for artifacts have no platform
+	mb, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    writeBlob(ctx, t, cs, index.MediaTypeEmptyJSON, []byte("{}")),
+		Layers:    []ocispec.Descriptor{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mps, err = Platforms(ctx, cs, writeBlob(ctx, t, cs, ocispec.MediaTypeImageManifest, mb))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mps) != 1 || mps[0].Platform != nil {
+		t.Fatalf("expected unknown platform for artifact, got %v", mps)
+	}
+}
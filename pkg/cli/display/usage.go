@@ -0,0 +1,190 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package display
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/pkg/progress"
+	"github.com/containerd/lcontainerd/pkg/iobuf"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ImageUsage is the size of the locally stored content of an image
+type ImageUsage struct {
+	Name string
+
+	// Unique is the size of the content only used by this image
+	Unique int64
+
+	// Shared is the size of the content also used by other images
+	Shared int64
+
+	// Missing is set when the target of the image is not stored locally,
+	// no content is attributed to the image
+	Missing bool
+
+	blobs []digest.Digest
+}
+
+// Total returns the size of all content used by the image
+func (u ImageUsage) Total() int64 {
+	return u.Unique + u.Shared
+}
+
+// DiskUsage is the size of the content store attributed to images
+type DiskUsage struct {
+	Images []ImageUsage
+
+	// Total is the size of all content in the store
+	Total int64
+
+	// Unreferenced is the size of the content not used by any image
+	Unreferenced int64
+}
+
+// GetDiskUsage attributes the size of the content in the store to the
+// images using it. The manifest tree of each image is walked as when
+// printing the tree, content which is not stored locally, such as the
+// manifests for platforms which were not pulled, is not counted.
+func GetDiskUsage(ctx context.Context, store content.Store, imgs []images.Image) (DiskUsage, error) {
+	var (
+		du    DiskUsage
+		sizes = map[digest.Digest]int64{}
+		users = map[digest.Digest]int{}
+	)
+	if err := store.Walk(ctx, func(info content.Info) error {
+		sizes[info.Digest] = info.Size
+		du.Total += info.Size
+		return nil
+	}); err != nil {
+		return DiskUsage{}, err
+	}
+
+	for _, img := range imgs {
+		u := ImageUsage{Name: img.Name}
+		if _, ok := sizes[img.Target.Digest]; !ok {
+			u.Missing = true
+			du.Images = append(du.Images, u)
+			continue
+		}
+		seen := map[digest.Digest]struct{}{}
+		if err := walkStoredTree(ctx, store, img.Target, sizes, func(dgst digest.Digest) {
+			if _, ok := seen[dgst]; !ok {
+				seen[dgst] = struct{}{}
+				u.blobs = append(u.blobs, dgst)
+				users[dgst]++
+			}
+		}); err != nil {
+			return DiskUsage{}, fmt.Errorf("failed to walk image %s: %w", img.Name, err)
+		}
+		du.Images = append(du.Images, u)
+	}
+
+	du.Unreferenced = du.Total
+	for dgst := range users {
+		du.Unreferenced -= sizes[dgst]
+	}
+	for i := range du.Images {
+		u := &du.Images[i]
+		for _, dgst := range u.blobs {
+			if users[dgst] > 1 {
+				u.Shared += sizes[dgst]
+			} else {
+				u.Unique += sizes[dgst]
+			}
+		}
+		u.blobs = nil
+	}
+	return du, nil
+}
+
+// walkStoredTree calls fn with the digest of the descriptor and each
+// descriptor below it which is in the store
+func walkStoredTree(ctx context.Context, store content.Provider, desc ocispec.Descriptor, sizes map[digest.Digest]int64, fn func(digest.Digest)) error {
+	if _, ok := sizes[desc.Digest]; !ok {
+		return nil
+	}
+	fn(desc.Digest)
+
+	var children []ocispec.Descriptor
+	switch desc.MediaType {
+	case images.MediaTypeDockerSchema2Manifest, ocispec.MediaTypeImageManifest:
+		b, err := iobuf.ReadBlob(ctx, store, desc)
+		if err != nil {
+			return err
+		}
+		var manifest ocispec.Manifest
+		if err := json.Unmarshal(b, &manifest); err != nil {
+			return err
+		}
+		children = append([]ocispec.Descriptor{manifest.Config}, manifest.Layers...)
+	case images.MediaTypeDockerSchema2ManifestList, ocispec.MediaTypeImageIndex:
+		b, err := iobuf.ReadBlob(ctx, store, desc)
+		if err != nil {
+			return err
+		}
+		var idx ocispec.Index
+		if err := json.Unmarshal(b, &idx); err != nil {
+			return err
+		}
+		children = idx.Manifests
+	}
+	for _, child := range children {
+		if err := walkStoredTree(ctx, store, child, sizes, fn); err != nil {
+			// Manifests with missing data are not walked further
+			if errdefs.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// PrintDiskUsage writes the size of the content used by each image, the
+// largest first, followed by the total size of the content store
+func (p *Printer) PrintDiskUsage(du DiskUsage) error {
+	usage := append([]ImageUsage(nil), du.Images...)
+	sort.SliceStable(usage, func(i, j int) bool {
+		return usage[i].Total() > usage[j].Total()
+	})
+
+	tw := tabwriter.NewWriter(p.w, 8, 3, 1, ' ', 0)
+	fmt.Fprintf(tw, "Image Name\tTotal\tUnique\tShared\n")
+	fmt.Fprintf(tw, "----------\t-----\t------\t------\n")
+	for _, u := range usage {
+		if u.Missing {
+			fmt.Fprintf(tw, "%s\ttarget missing\t-\t-\n", u.Name)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%v\t%v\t%v\n", u.Name, progress.Bytes(u.Total()), progress.Bytes(u.Unique), progress.Bytes(u.Shared))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(p.w, "\nTotal: %v, %v not used by any image\n", progress.Bytes(du.Total), progress.Bytes(du.Unreferenced))
+	return nil
+}
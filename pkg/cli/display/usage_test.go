@@ -0,0 +1,106 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package display
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/images"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestDiskUsage(t *testing.T) {
+	ctx := context.Background()
+	cs, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeManifest := func(config string, layers ...ocispec.Descriptor) ocispec.Descriptor {
+		b, err := json.Marshal(ocispec.Manifest{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: ocispec.MediaTypeImageManifest,
+			Config:    writeBlob(ctx, t, cs, ocispec.MediaTypeImageConfig, []byte(config)),
+			Layers:    layers,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return writeBlob(ctx, t, cs, ocispec.MediaTypeImageManifest, b)
+	}
+	var (
+		shared = writeBlob(ctx, t, cs, ocispec.MediaTypeImageLayer, bytes.Repeat([]byte("s"), 1000))
+		first  = writeManifest(`{"first":1}`, shared, writeBlob(ctx, t, cs, ocispec.MediaTypeImageLayer, bytes.Repeat([]byte("a"), 100)))
+		second = writeManifest(`{"second":2}`, shared, writeBlob(ctx, t, cs, ocispec.MediaTypeImageLayer, bytes.Repeat([]byte("b"), 10)))
+		orphan = writeBlob(ctx, t, cs, ocispec.MediaTypeImageLayer, bytes.Repeat([]byte("o"), 5))
+	)
+	// The index refers to a manifest which is not stored locally
+	b, err := json.Marshal(ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{second, {MediaType: ocispec.MediaTypeImageManifest, Digest: digest.FromString("absent"), Size: 7}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx := writeBlob(ctx, t, cs, ocispec.MediaTypeImageIndex, b)
+
+	du, err := GetDiskUsage(ctx, cs, []images.Image{
+		{Name: "first", Target: first},
+		{Name: "second", Target: idx},
+		{Name: "missing", Target: ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: digest.FromString("missing")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []ImageUsage{
+		{Name: "first", Unique: first.Size + 11 + 100, Shared: 1000},
+		{Name: "second", Unique: idx.Size + second.Size + 12 + 10, Shared: 1000},
+		{Name: "missing", Missing: true},
+	}
+	if len(du.Images) != len(expected) {
+		t.Fatalf("unexpected usage %+v", du.Images)
+	}
+	for i := range expected {
+		if actual := du.Images[i]; actual.Name != expected[i].Name || actual.Unique != expected[i].Unique || actual.Shared != expected[i].Shared || actual.Missing != expected[i].Missing {
+			t.Errorf("unexpected usage %+v, expected %+v", du.Images[i], expected[i])
+		}
+	}
+	if du.Unreferenced != orphan.Size {
+		t.Errorf("unexpected unreferenced size %d, expected %d", du.Unreferenced, orphan.Size)
+	}
+	if total := expected[0].Total() + expected[1].Total() - 1000 + orphan.Size; du.Total != total {
+		t.Errorf("unexpected total size %d, expected %d", du.Total, total)
+	}
+
+	var out bytes.Buffer
+	if err := NewPrinter(WithWriter(&out)).PrintDiskUsage(du); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(out.String(), "\n")
+	if !strings.HasPrefix(lines[2], "second") || !strings.HasPrefix(lines[4], "missing") || !strings.Contains(lines[4], "target missing") {
+		t.Fatalf("unexpected output:\n%s", out.String())
+	}
+}
@@ -0,0 +1,158 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package progress
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/containerd/containerd/pkg/transfer"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	// EventError is the progress event for an object which encountered
+	// an error during transfer. The error summary may be appended to the
+	// event after a colon, such as "error: connection reset".
+	EventError = "error"
+
+	// EventFailed is the progress event for an object which failed to
+	// transfer, it may carry an error summary in the same way as EventError.
+	EventFailed = "failed"
+
+	// EventAborted is the progress event for an object which was still in
+	// flight when the transfer failed because of an error in another object.
+	EventAborted = "aborted"
+)
+
+// maxSummaryLength is the longest error summary shown for a failed object
+const maxSummaryLength = 80
+
+// FailedEvent returns the progress event string for a failure with
+// the summary of the provided error
+func FailedEvent(err error) string {
+	if err == nil {
+		return EventFailed
+	}
+	summary := strings.TrimSpace(strings.SplitN(err.Error(), "\n", 2)[0])
+	if len(summary) > maxSummaryLength {
+		summary = summary[:maxSummaryLength-3] + "..."
+	}
+	return EventFailed + ": " + summary
+}
+
+// failureSummary returns whether the event is a failure event along with
+// any error summary included in the event
+func failureSummary(event string) (string, bool) {
+	for _, prefix := range []string{EventError, EventFailed} {
+		if event == prefix {
+			return "", true
+		}
+		if strings.HasPrefix(event, prefix+":") {
+			return strings.TrimSpace(event[len(prefix)+1:]), true
+		}
+	}
+	return "", false
+}
+
+// FailureTracker forwards progress events while tracking which objects
+// have not yet completed so a transfer error can be reported against
+// the objects which were still in flight.
+type FailureTracker struct {
+	pf transfer.ProgressFunc
+
+	mu      sync.Mutex
+	order   []string
+	pending map[string]transfer.Progress
+}
+
+// NewFailureTracker returns a tracker forwarding events to the provided
+// progress function
+func NewFailureTracker(pf transfer.ProgressFunc) *FailureTracker {
+	return &FailureTracker{
+		pf:      pf,
+		pending: map[string]transfer.Progress{},
+	}
+}
+
+// Progress records and forwards a progress event, it may be used as a
+// transfer.ProgressFunc
+func (ft *FailureTracker) Progress(p transfer.Progress) {
+	if p.Name != "" {
+		ft.mu.Lock()
+		switch p.Event {
+		case "complete", "done", "already exists", "saved":
+			delete(ft.pending, p.Name)
+		default:
+			if _, ok := ft.pending[p.Name]; !ok {
+				ft.order = append(ft.order, p.Name)
+			}
+			ft.pending[p.Name] = p
+		}
+		ft.mu.Unlock()
+	}
+	ft.pf(p)
+}
+
+// Fail reports the error against the objects which had not completed along
+// with a failed status line. Only objects whose digest is referenced by the
+// error are marked failed, the other objects are marked aborted.
+func (ft *FailureTracker) Fail(err error) {
+	var (
+		event = FailedEvent(err)
+		msg   string
+	)
+	if err != nil {
+		msg = err.Error()
+	}
+
+	ft.mu.Lock()
+	var failed []transfer.Progress
+	for _, name := range ft.order {
+		p, ok := ft.pending[name]
+		if !ok {
+			continue
+		}
+		if dgst := objectDigest(name); dgst != "" && strings.Contains(msg, dgst.String()) {
+			p.Event = event
+		} else {
+			p.Event = EventAborted
+		}
+		failed = append(failed, p)
+		delete(ft.pending, name)
+	}
+	ft.order = nil
+	ft.mu.Unlock()
+
+	for _, p := range failed {
+		ft.pf(p)
+	}
+	ft.pf(transfer.Progress{
+		Event: event,
+	})
+}
+
+// objectDigest returns the digest in a progress object name, such as
+// "layer-sha256:...", or an empty digest when the name has none
+func objectDigest(name string) digest.Digest {
+	for _, part := range strings.Split(name, "-") {
+		if dgst, err := digest.Parse(part); err == nil {
+			return dgst
+		}
+	}
+	return ""
+}
@@ -18,6 +18,7 @@ package progress
 
 import (
 	"context"
+	"encoding/json"
 	"io"
 
 	transfertypes "github.com/containerd/containerd/api/types/transfer"
@@ -44,3 +45,31 @@ func ForwardProto(ctx context.Context, out io.Writer) transfer.ProgressFunc {
 		}
 	}
 }
+
+type jsonProgress struct {
+	Event    string   `json:"event"`
+	Name     string   `json:"name,omitempty"`
+	Parents  []string `json:"parents,omitempty"`
+	Progress int64    `json:"progress,omitempty"`
+	Total    int64    `json:"total,omitempty"`
+}
+
+// ForwardJSON writes each progress event as a single line of JSON
+func ForwardJSON(ctx context.Context, out io.Writer) transfer.ProgressFunc {
+	return func(p transfer.Progress) {
+		b, err := json.Marshal(jsonProgress{
+			Event:    p.Event,
+			Name:     p.Name,
+			Parents:  p.Parents,
+			Progress: p.Progress,
+			Total:    p.Total,
+		})
+		if err != nil {
+			log.G(ctx).WithError(err).Warnf("event could not be marshaled: %v/%v", p.Event, p.Name)
+			return
+		}
+		if _, err := out.Write(append(b, '\n')); err != nil {
+			log.G(ctx).WithError(err).Warnf("event could not be written: %v/%v", p.Event, p.Name)
+		}
+	}
+}
@@ -18,156 +18,240 @@ package progress
 
 import (
 	"context"
+	_ "crypto/sha512" // register sha512 for shortening digests
 	"fmt"
 	"io"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/containerd/containerd/pkg/progress"
 	"github.com/containerd/containerd/pkg/transfer"
+	"github.com/opencontainers/go-digest"
 )
 
 type progressNode struct {
 	transfer.Progress
 	children []*progressNode
 	root     bool
+
+	// baseline is the progress already present when the node was first
+	// seen, such as resumed or existing content, which was not transferred
+	baseline int64
+}
+
+// hierarchy tracks the progress nodes and the current status line
+// built up from a stream of progress events.
+type hierarchy struct {
+	statuses map[string]*progressNode
+	roots    []*progressNode
+	status   string
+}
+
+func newHierarchy() *hierarchy {
+	return &hierarchy{
+		statuses: map[string]*progressNode{},
+	}
+}
+
+// update applies a progress event to the hierarchy
+func (h *hierarchy) update(p transfer.Progress) {
+	if p.Name == "" {
+		h.status = p.Event
+		return
+	}
+	if node, ok := h.statuses[p.Name]; !ok {
+		node = &progressNode{
+			Progress: p,
+			root:     true,
+			baseline: p.Progress,
+		}
+
+		if len(p.Parents) == 0 {
+			h.roots = append(h.roots, node)
+		} else {
+			var parents []string
+			for _, parent := range p.Parents {
+				pStatus, ok := h.statuses[parent]
+				if ok {
+					parents = append(parents, parent)
+					pStatus.children = append(pStatus.children, node)
+					node.root = false
+				}
+			}
+			node.Progress.Parents = parents
+			if node.root {
+				h.roots = append(h.roots, node)
+			}
+		}
+		h.statuses[p.Name] = node
+	} else {
+		if len(node.Progress.Parents) != len(p.Parents) {
+			var parents []string
+			var removeRoot bool
+			for _, parent := range p.Parents {
+				pStatus, ok := h.statuses[parent]
+				if ok {
+					parents = append(parents, parent)
+					var found bool
+					for _, child := range pStatus.children {
+
+						if child.Progress.Name == p.Name {
+							found = true
+							break
+						}
+					}
+					if !found {
+						pStatus.children = append(pStatus.children, node)
+
+					}
+					if node.root {
+						removeRoot = true
+					}
+					node.root = false
+				}
+			}
+			p.Parents = parents
+			// Check if needs to remove from root
+			if removeRoot {
+				for i := range h.roots {
+					if h.roots[i] == node {
+						h.roots = append(h.roots[:i], h.roots[i+1:]...)
+						break
+					}
+				}
+			}
+
+		}
+		if p.Event == "already exists" {
+			node.baseline = p.Progress
+		}
+		node.Progress = p
+	}
+}
+
+// totals returns the progress of all nodes, counting each node once even
+// when it has multiple parents, along with the progress transferred since
+// each node was first seen
+func (h *hierarchy) totals() (total, transferred int64) {
+	for _, node := range h.statuses {
+		total += node.Progress.Progress
+		if n := node.Progress.Progress - node.baseline; n > 0 {
+			transferred += n
+		}
+	}
+	return total, transferred
+}
+
+// Renderer displays progress events as a hierarchy, see Hierarchical
+type Renderer struct {
+	pc     chan transfer.Progress
+	done   chan struct{}
+	closed chan struct{}
+	once   sync.Once
 }
 
 // Hierarchical continuously updates the output with job progress
 // by checking status in the content store.
 // Displays the progress events as a hierarchy based on the parent
 // information provided through the progress stream.
-func Hierarchical(ctx context.Context, out io.Writer) transfer.ProgressFunc {
+// The output is redrawn at most once per interval with the latest state
+// of all events received since the last redraw, or after every event when
+// the interval is not positive. Close renders the final state.
+func Hierarchical(ctx context.Context, out io.Writer, interval time.Duration) *Renderer {
 	var (
-		fw       = progress.NewWriter(out)
-		start    = time.Now()
-		statuses = map[string]*progressNode{}
-		roots    = []*progressNode{}
-		progress transfer.ProgressFunc
-		pc       = make(chan transfer.Progress, 1)
-		status   string
+		fw    = progress.NewWriter(out)
+		start = time.Now()
+		tick  <-chan time.Time
+		stop  = func() {}
 	)
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		tick, stop = ticker.C, ticker.Stop
+	}
+	return newRenderer(ctx, tick, stop, func(h *hierarchy) {
+		DisplayHierarchy(fw, h, start)
+		fw.Flush()
+	})
+}
 
-	progress = func(p transfer.Progress) {
-		select {
-		case pc <- p:
-		case <-ctx.Done():
-		}
+// newRenderer returns a renderer which draws on every event when tick is
+// nil, otherwise on the first tick following any events
+func newRenderer(ctx context.Context, tick <-chan time.Time, stop func(), draw func(*hierarchy)) *Renderer {
+	r := &Renderer{
+		pc:     make(chan transfer.Progress),
+		done:   make(chan struct{}),
+		closed: make(chan struct{}),
 	}
 	go func() {
+		defer close(r.closed)
+		defer stop()
+
+		var (
+			h     = newHierarchy()
+			dirty bool
+		)
 		for {
 			select {
-			case p := <-pc:
-				if p.Name == "" {
-					status = p.Event
-					continue
-				}
-				if node, ok := statuses[p.Name]; !ok {
-					node = &progressNode{
-						Progress: p,
-						root:     true,
-					}
-
-					if len(p.Parents) == 0 {
-						roots = append(roots, node)
-					} else {
-						var parents []string
-						for _, parent := range p.Parents {
-							pStatus, ok := statuses[parent]
-							if ok {
-								parents = append(parents, parent)
-								pStatus.children = append(pStatus.children, node)
-								node.root = false
-							}
-						}
-						node.Progress.Parents = parents
-						if node.root {
-							roots = append(roots, node)
-						}
-					}
-					statuses[p.Name] = node
+			case p := <-r.pc:
+				h.update(p)
+				if tick == nil {
+					draw(h)
 				} else {
-					if len(node.Progress.Parents) != len(p.Parents) {
-						var parents []string
-						var removeRoot bool
-						for _, parent := range p.Parents {
-							pStatus, ok := statuses[parent]
-							if ok {
-								parents = append(parents, parent)
-								var found bool
-								for _, child := range pStatus.children {
-
-									if child.Progress.Name == p.Name {
-										found = true
-										break
-									}
-								}
-								if !found {
-									pStatus.children = append(pStatus.children, node)
-
-								}
-								if node.root {
-									removeRoot = true
-								}
-								node.root = false
-							}
-						}
-						p.Parents = parents
-						// Check if needs to remove from root
-						if removeRoot {
-							for i := range roots {
-								if roots[i] == node {
-									roots = append(roots[:i], roots[i+1:]...)
-									break
-								}
-							}
-						}
-
-					}
-					node.Progress = p
+					dirty = true
 				}
-
-				/*
-					all := make([]transfer.Progress, 0, len(statuses))
-					for _, p := range statuses {
-						all = append(all, p.Progress)
-					}
-					sort.Slice(all, func(i, j int) bool {
-						return all[i].Name < all[j].Name
-					})
-					Display(fw, status, all, start)
-				*/
-				DisplayHierarchy(fw, status, roots, start)
-				fw.Flush()
+			case <-tick:
+				if dirty {
+					draw(h)
+					dirty = false
+				}
+			case <-r.done:
+				if dirty {
+					draw(h)
+				}
+				return
 			case <-ctx.Done():
 				return
 			}
 		}
 	}()
+	return r
+}
+
+// Progress applies the progress event, it is a transfer.ProgressFunc
+func (r *Renderer) Progress(p transfer.Progress) {
+	select {
+	case r.pc <- p:
+	case <-r.done:
+	case <-r.closed:
+	}
+}
 
-	return progress
+// Close renders any events not yet displayed and stops rendering
+func (r *Renderer) Close() {
+	r.once.Do(func() {
+		close(r.done)
+	})
+	<-r.closed
 }
 
-func DisplayHierarchy(w io.Writer, status string, roots []*progressNode, start time.Time) {
-	total := displayNode(w, "", roots)
+// DisplayHierarchy writes the progress tree followed by a status line with
+// the total size of the content and the rate at which it was transferred,
+// content which was present before the transfer is not included in the rate
+func DisplayHierarchy(w io.Writer, h *hierarchy, start time.Time) {
+	displayNode(w, "", h.roots)
+	total, transferred := h.totals()
 	// Print the Status line
 	fmt.Fprintf(w, "%s\telapsed: %-4.1fs\ttotal: %7.6v\t(%v)\t\n",
-		status,
+		h.status,
 		time.Since(start).Seconds(),
-		// TODO(stevvooe): These calculations are actually way off.
-		// Need to account for previously downloaded data. These
-		// will basically be right for a download the first time
-		// but will be skewed if restarting, as it includes the
-		// data into the start time before.
 		progress.Bytes(total),
-		progress.NewBytesPerSecond(total, time.Since(start)))
+		progress.NewBytesPerSecond(transferred, time.Since(start)))
 }
 
-func displayNode(w io.Writer, prefix string, nodes []*progressNode) int64 {
-	var total int64
+func displayNode(w io.Writer, prefix string, nodes []*progressNode) {
 	for i, node := range nodes {
 		status := node.Progress
-		total += status.Progress
 		pf, cpf := prefixes(i, len(nodes))
 		if node.root {
 			pf, cpf = "", ""
@@ -199,13 +283,19 @@ func displayNode(w io.Writer, prefix string, nodes []*progressNode) int64 {
 				status.Event,
 				bar)
 		default:
+			if summary, ok := failureSummary(status.Event); ok {
+				fmt.Fprintf(w, "%-40.40s\t%-11s\t%s\t\n",
+					name,
+					EventFailed,
+					summary)
+				break
+			}
 			fmt.Fprintf(w, "%-40.40s\t%s\t\n",
 				name,
 				status.Event)
 		}
-		total += displayNode(w, prefix+cpf, node.children)
+		displayNode(w, prefix+cpf, node.children)
 	}
-	return total
 }
 
 func prefixes(index, length int) (prefix string, childPrefix string) {
@@ -228,57 +318,8 @@ func displayName(name string) string {
 }
 
 func shortenName(name string) string {
-	if strings.HasPrefix(name, "sha256:") && len(name) == 71 {
-		return "(" + name[7:19] + ")"
+	if dgst, err := digest.Parse(name); err == nil && len(dgst.Encoded()) > 12 {
+		return "(" + dgst.Encoded()[:12] + ")"
 	}
 	return name
 }
-
-// Display pretty prints out the download or upload progress
-// Status tree
-func Display(w io.Writer, status string, statuses []transfer.Progress, start time.Time) {
-	var total int64
-	for _, status := range statuses {
-		total += status.Progress
-		switch status.Event {
-		case "downloading", "uploading":
-			var bar progress.Bar
-			if status.Total > 0.0 {
-				bar = progress.Bar(float64(status.Progress) / float64(status.Total))
-			}
-			fmt.Fprintf(w, "%s:\t%s\t%40r\t%8.8s/%s\t\n",
-				status.Name,
-				status.Event,
-				bar,
-				progress.Bytes(status.Progress), progress.Bytes(status.Total))
-		case "resolving", "waiting":
-			bar := progress.Bar(0.0)
-			fmt.Fprintf(w, "%s:\t%s\t%40r\t\n",
-				status.Name,
-				status.Event,
-				bar)
-		case "complete", "done":
-			bar := progress.Bar(1.0)
-			fmt.Fprintf(w, "%s:\t%s\t%40r\t\n",
-				status.Name,
-				status.Event,
-				bar)
-		default:
-			fmt.Fprintf(w, "%s:\t%s\t\n",
-				status.Name,
-				status.Event)
-		}
-	}
-
-	// Print the Status line
-	fmt.Fprintf(w, "%s\telapsed: %-4.1fs\ttotal: %7.6v\t(%v)\t\n",
-		status,
-		time.Since(start).Seconds(),
-		// TODO(stevvooe): These calculations are actually way off.
-		// Need to account for previously downloaded data. These
-		// will basically be right for a download the first time
-		// but will be skewed if restarting, as it includes the
-		// data into the start time before.
-		progress.Bytes(total),
-		progress.NewBytesPerSecond(total, time.Since(start)))
-}
@@ -0,0 +1,233 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package progress
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/pkg/transfer"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestHierarchicalFailure(t *testing.T) {
+	var (
+		h      = newHierarchy()
+		ft     = NewFailureTracker(h.update)
+		root   = "docker.io/library/test:latest"
+		index  = "index-sha256:1111111111111111111111111111111111111111111111111111111111111111"
+		layer1 = "layer-sha256:2222222222222222222222222222222222222222222222222222222222222222"
+		layer2 = "layer-sha256:3333333333333333333333333333333333333333333333333333333333333333"
+		layer3 = "layer-sha256:4444444444444444444444444444444444444444444444444444444444444444"
+	)
+
+	for _, p := range []transfer.Progress{
+		{Event: "Pulling from test"},
+		{Event: "fetching image content", Name: root},
+		{Event: "waiting", Name: index, Parents: []string{root}, Total: 10},
+		{Event: "complete", Name: index, Parents: []string{root}, Progress: 10, Total: 10},
+		{Event: "waiting", Name: layer1, Parents: []string{index}, Total: 100},
+		{Event: "waiting", Name: layer2, Parents: []string{index}, Total: 100},
+		{Event: "waiting", Name: layer3, Parents: []string{index}, Total: 100},
+		{Event: "complete", Name: layer1, Parents: []string{index}, Progress: 100, Total: 100},
+		{Event: "downloading", Name: layer2, Parents: []string{index}, Progress: 50, Total: 100},
+	} {
+		ft.Progress(p)
+	}
+	ft.Fail(errors.New("failed to copy sha256:3333333333333333333333333333333333333333333333333333333333333333: unexpected EOF\nmore detail"))
+
+	if h.statuses[index].Event != "complete" {
+		t.Fatalf("expected index to remain complete, got %q", h.statuses[index].Event)
+	}
+	if h.statuses[layer1].Event != "complete" {
+		t.Fatalf("expected first layer to remain complete, got %q", h.statuses[layer1].Event)
+	}
+	if expected := "failed: failed to copy sha256:3333"; !strings.HasPrefix(h.statuses[layer2].Event, expected) {
+		t.Fatalf("unexpected event for failed layer %q, expected prefix %q", h.statuses[layer2].Event, expected)
+	}
+	if h.statuses[layer3].Event != EventAborted {
+		t.Fatalf("expected waiting layer to be aborted, got %q", h.statuses[layer3].Event)
+	}
+	if h.statuses[root].Event != EventAborted {
+		t.Fatalf("expected root to be aborted, got %q", h.statuses[root].Event)
+	}
+	if h.status != h.statuses[layer2].Event {
+		t.Fatalf("unexpected status %q", h.status)
+	}
+
+	var b bytes.Buffer
+	DisplayHierarchy(&b, h, time.Now())
+
+	var found bool
+	for _, line := range strings.Split(b.String(), "\n") {
+		if strings.Contains(line, "(333333333333)") {
+			found = true
+			if !strings.Contains(line, "failed") || !strings.Contains(line, "failed to copy") {
+				t.Fatalf("expected failed layer line with error summary, got %q", line)
+			}
+		} else if strings.Contains(line, "(444444444444)") && !strings.Contains(line, EventAborted) {
+			t.Fatalf("expected aborted layer line, got %q", line)
+		} else if strings.Contains(line, "(222222222222)") && strings.Contains(line, "failed") {
+			t.Fatalf("completed layer shown as failed: %q", line)
+		}
+	}
+	if !found {
+		t.Fatalf("failed layer not displayed:\n%s", b.String())
+	}
+}
+
+func TestFailedEvent(t *testing.T) {
+	for _, tc := range []struct {
+		event   string
+		summary string
+		failed  bool
+	}{
+		{"failed", "", true},
+		{"error", "", true},
+		{"failed: not found", "not found", true},
+		{"error: connection reset", "connection reset", true},
+		{"downloading", "", false},
+		{"errored", "", false},
+	} {
+		summary, failed := failureSummary(tc.event)
+		if failed != tc.failed || summary != tc.summary {
+			t.Errorf("%q: got (%q, %t), expected (%q, %t)", tc.event, summary, failed, tc.summary, tc.failed)
+		}
+	}
+
+	if event := FailedEvent(errors.New(strings.Repeat("x", 200))); len(event) > len(EventFailed)+2+maxSummaryLength {
+		t.Fatalf("summary not truncated: %d", len(event))
+	}
+}
+
+func TestShortenName(t *testing.T) {
+	sha256 := digest.FromString("content")
+	sha512 := digest.SHA512.FromString("content")
+	for _, tc := range []struct {
+		name     string
+		expected string
+	}{
+		{sha256.String(), "(" + sha256.Encoded()[:12] + ")"},
+		{sha512.String(), "(" + sha512.Encoded()[:12] + ")"},
+		{"sha512:abc", "sha512:abc"},
+		{"docker.io/library/alpine:latest", "docker.io/library/alpine:latest"},
+	} {
+		if actual := shortenName(tc.name); actual != tc.expected {
+			t.Errorf("%q: got %q, expected %q", tc.name, actual, tc.expected)
+		}
+	}
+}
+
+func TestRendererInterval(t *testing.T) {
+	var (
+		tick  = make(chan time.Time)
+		draws int
+		last  int64
+	)
+	r := newRenderer(context.Background(), tick, func() {}, func(h *hierarchy) {
+		draws++
+		last = h.statuses["layer"].Progress.Progress
+	})
+	burst := func(from, to int64) {
+		for i := from; i <= to; i++ {
+			r.Progress(transfer.Progress{Event: "downloading", Name: "layer", Progress: i, Total: 1000})
+		}
+	}
+
+	// Events are only drawn on a tick, draws are done before the next
+	// event is received
+	burst(1, 500)
+	if draws != 0 {
+		t.Fatalf("expected no draws before a tick, got %d", draws)
+	}
+	tick <- time.Now()
+	burst(501, 999)
+	if draws != 1 || last != 500 {
+		t.Fatalf("expected a single draw of the burst, got %d draws with progress %d", draws, last)
+	}
+
+	// Ticks without events do not draw
+	tick <- time.Now()
+	tick <- time.Now()
+	burst(1000, 1000)
+	if draws != 2 {
+		t.Fatalf("expected a single draw for ticks after the burst, got %d", draws)
+	}
+
+	// The events since the last tick are drawn on close
+	r.Close()
+	if draws != 3 || last != 1000 {
+		t.Fatalf("expected final draw of latest progress, got %d draws with progress %d", draws, last)
+	}
+	r.Progress(transfer.Progress{Event: "complete", Name: "layer"})
+	r.Close()
+	if draws != 3 {
+		t.Fatalf("expected no draws after close, got %d", draws)
+	}
+}
+
+func TestHierarchyTotals(t *testing.T) {
+	var (
+		h         = newHierarchy()
+		root      = "docker.io/library/test:latest"
+		index     = "index-sha256:1111111111111111111111111111111111111111111111111111111111111111"
+		manifest1 = "manifest-sha256:2222222222222222222222222222222222222222222222222222222222222222"
+		manifest2 = "manifest-sha256:3333333333333333333333333333333333333333333333333333333333333333"
+		shared    = "layer-sha256:4444444444444444444444444444444444444444444444444444444444444444"
+		existing  = "layer-sha256:5555555555555555555555555555555555555555555555555555555555555555"
+		resumed   = "layer-sha256:6666666666666666666666666666666666666666666666666666666666666666"
+	)
+	for _, p := range []transfer.Progress{
+		{Event: "Pulling from test"},
+		{Event: "fetching image content", Name: root},
+		{Event: "waiting", Name: index, Parents: []string{root}, Total: 10},
+		{Event: "complete", Name: index, Parents: []string{root}, Progress: 10, Total: 10},
+		{Event: "waiting", Name: manifest1, Parents: []string{index}, Total: 20},
+		{Event: "waiting", Name: manifest2, Parents: []string{index}, Total: 30},
+		{Event: "complete", Name: manifest1, Parents: []string{index}, Progress: 20, Total: 20},
+		{Event: "complete", Name: manifest2, Parents: []string{index}, Progress: 30, Total: 30},
+		// The shared layer is a child of both manifests
+		{Event: "waiting", Name: shared, Parents: []string{manifest1, manifest2}, Total: 100},
+		{Event: "downloading", Name: shared, Parents: []string{manifest1, manifest2}, Progress: 40, Total: 100},
+		{Event: "complete", Name: shared, Parents: []string{manifest1, manifest2}, Progress: 100, Total: 100},
+		{Event: "waiting", Name: existing, Parents: []string{manifest1}, Total: 200},
+		{Event: "already exists", Name: existing, Progress: 200, Total: 200},
+		// Half of the layer was downloaded by an earlier pull
+		{Event: "downloading", Name: resumed, Parents: []string{manifest2}, Progress: 150, Total: 300},
+		{Event: "complete", Name: resumed, Parents: []string{manifest2}, Progress: 300, Total: 300},
+	} {
+		h.update(p)
+	}
+
+	total, transferred := h.totals()
+	if expected := int64(10 + 20 + 30 + 100 + 200 + 300); total != expected {
+		t.Fatalf("unexpected total %d, expected the sum of blob sizes %d", total, expected)
+	}
+	if expected := int64(10 + 20 + 30 + 100 + 150); transferred != expected {
+		t.Fatalf("unexpected transferred %d, expected %d", transferred, expected)
+	}
+
+	var b bytes.Buffer
+	DisplayHierarchy(&b, h, time.Now())
+	if lines := strings.Split(strings.TrimSpace(b.String()), "\n"); !strings.Contains(lines[len(lines)-1], "total:  660.0") {
+		t.Fatalf("unexpected status line %q", lines[len(lines)-1])
+	}
+}
@@ -0,0 +1,80 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package progress
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/containerd/console"
+)
+
+// Auto displays progress with Hierarchical when out is a terminal,
+// otherwise with Plain so output sent to a pipe or file, such as CI logs,
+// is not filled with redrawn trees.
+func Auto(ctx context.Context, out io.Writer, interval time.Duration) *Renderer {
+	if isTerminal(out) {
+		return Hierarchical(ctx, out, interval)
+	}
+	return Plain(ctx, out)
+}
+
+// Plain displays progress events as a log, writing a line whenever the
+// status or the event of an object changes. Previous output is never
+// redrawn and events which only update the progress of an object are not
+// written, such as repeated "downloading" events.
+func Plain(ctx context.Context, out io.Writer) *Renderer {
+	var (
+		status string
+		events = map[string]string{}
+	)
+	return newRenderer(ctx, nil, func() {}, func(h *hierarchy) {
+		if h.status != status {
+			status = h.status
+			fmt.Fprintln(out, status)
+		}
+		logTransitions(out, h.roots, events)
+	})
+}
+
+// logTransitions writes a line for each node with an event different from
+// the last one logged for it, events holds the last logged events by name
+func logTransitions(w io.Writer, nodes []*progressNode, events map[string]string) {
+	for _, node := range nodes {
+		name, event := node.Progress.Name, node.Progress.Event
+		if last, ok := events[name]; !ok {
+			fmt.Fprintf(w, "%s: %s\n", displayName(name), event)
+		} else if last != event {
+			fmt.Fprintf(w, "%s: %s -> %s\n", displayName(name), last, event)
+		}
+		events[name] = event
+		logTransitions(w, node.children, events)
+	}
+}
+
+// isTerminal returns whether the writer is a terminal which can be redrawn
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	_, err := console.ConsoleFromFile(f)
+	return err == nil
+}
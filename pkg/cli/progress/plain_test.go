@@ -0,0 +1,89 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package progress
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/pkg/transfer"
+)
+
+func TestPlain(t *testing.T) {
+	var (
+		b      bytes.Buffer
+		r      = Plain(context.Background(), &b)
+		ft     = NewFailureTracker(r.Progress)
+		root   = "docker.io/library/test:latest"
+		index  = "index-sha256:1111111111111111111111111111111111111111111111111111111111111111"
+		layer1 = "layer-sha256:2222222222222222222222222222222222222222222222222222222222222222"
+		layer2 = "layer-sha256:3333333333333333333333333333333333333333333333333333333333333333"
+	)
+	for _, p := range []transfer.Progress{
+		{Event: "Pulling from test"},
+		{Event: "fetching image content", Name: root},
+		{Event: "waiting", Name: index, Parents: []string{root}, Total: 10},
+		{Event: "complete", Name: index, Parents: []string{root}, Progress: 10, Total: 10},
+		{Event: "waiting", Name: layer1, Parents: []string{index}, Total: 100},
+		{Event: "waiting", Name: layer2, Parents: []string{index}, Total: 100},
+		{Event: "downloading", Name: layer1, Parents: []string{index}, Progress: 20, Total: 100},
+		{Event: "downloading", Name: layer1, Parents: []string{index}, Progress: 60, Total: 100},
+		{Event: "complete", Name: layer1, Parents: []string{index}, Progress: 100, Total: 100},
+		{Event: "downloading", Name: layer2, Parents: []string{index}, Progress: 50, Total: 100},
+	} {
+		ft.Progress(p)
+	}
+	ft.Fail(errors.New("unexpected EOF"))
+	r.Close()
+
+	expected := []string{
+		"Pulling from test",
+		"docker.io/library/test:latest: fetching image content",
+		"index (111111111111): waiting",
+		"index (111111111111): waiting -> complete",
+		"layer (222222222222): waiting",
+		"layer (333333333333): waiting",
+		"layer (222222222222): waiting -> downloading",
+		"layer (222222222222): downloading -> complete",
+		"layer (333333333333): waiting -> downloading",
+		"docker.io/library/test:latest: fetching image content -> aborted",
+		"layer (333333333333): downloading -> aborted",
+		"failed: unexpected EOF",
+	}
+	if actual := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n"); strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected output:\n%s\nexpected:\n%s", strings.Join(actual, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestIsTerminal(t *testing.T) {
+	if isTerminal(&bytes.Buffer{}) {
+		t.Fatal("buffer should not be a terminal")
+	}
+	f, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if isTerminal(f) {
+		t.Fatal("regular file should not be a terminal")
+	}
+}
@@ -0,0 +1,128 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package progress
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/log"
+)
+
+const (
+	// socketWriteTimeout is the longest a write to the client may block
+	// before the client is considered disconnected
+	socketWriteTimeout = 10 * time.Second
+
+	// socketBufferSize is the maximum amount of progress held until a
+	// client connects, later progress is dropped
+	socketBufferSize = 1 << 20
+)
+
+// Socket serves progress written to it to a single client connected to a
+// unix socket. Progress written before the client connects is buffered and
+// sent once the client connects. When the client disconnects, further
+// progress is discarded without returning an error so that the transfer
+// is not interrupted.
+type Socket struct {
+	ctx context.Context
+	l   net.Listener
+
+	mu           sync.Mutex
+	conn         net.Conn
+	buf          bytes.Buffer
+	disconnected bool
+	closed       bool
+}
+
+// ListenSocket creates a unix socket at the path and accepts a single
+// client to serve progress to.
+func ListenSocket(ctx context.Context, path string) (*Socket, error) {
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	s := &Socket{
+		ctx: ctx,
+		l:   l,
+	}
+	go s.accept()
+	return s, nil
+}
+
+func (s *Socket) accept() {
+	conn, err := s.l.Accept()
+	// Only a single client is served
+	s.l.Close()
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		conn.Close()
+		return
+	}
+	s.conn = conn
+	if s.buf.Len() > 0 {
+		s.write(s.buf.Bytes())
+		s.buf = bytes.Buffer{}
+	}
+}
+
+// Write sends the progress to the client or buffers it until a client
+// connects. Write never returns an error.
+func (s *Socket) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.conn != nil:
+		s.write(p)
+	case !s.disconnected && !s.closed && s.buf.Len()+len(p) <= socketBufferSize:
+		s.buf.Write(p)
+	}
+	return len(p), nil
+}
+
+// write writes to the connected client, dropping the client on failure
+func (s *Socket) write(p []byte) {
+	s.conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+	if _, err := s.conn.Write(p); err != nil {
+		log.G(s.ctx).WithError(err).Debug("progress client disconnected")
+		s.conn.Close()
+		s.conn = nil
+		s.disconnected = true
+	}
+}
+
+// Close closes the client connection and removes the socket
+func (s *Socket) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.l.Close()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
@@ -0,0 +1,82 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package progress
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/pkg/transfer"
+)
+
+func TestSocket(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "progress.sock")
+	s, err := ListenSocket(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pf := ForwardJSON(ctx, s)
+
+	// Progress before the client connects is buffered
+	pf(transfer.Progress{Event: "resolving", Name: "docker.io/library/alpine:latest"})
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	pf(transfer.Progress{Event: "downloading", Name: "layer", Parents: []string{"manifest"}, Progress: 10, Total: 100})
+
+	sc := bufio.NewScanner(conn)
+	for _, expected := range []jsonProgress{
+		{Event: "resolving", Name: "docker.io/library/alpine:latest"},
+		{Event: "downloading", Name: "layer", Parents: []string{"manifest"}, Progress: 10, Total: 100},
+	} {
+		if !sc.Scan() {
+			t.Fatalf("expected event %s: %v", expected.Event, sc.Err())
+		}
+		var actual jsonProgress
+		if err := json.Unmarshal(sc.Bytes(), &actual); err != nil {
+			t.Fatal(err)
+		}
+		if actual.Event != expected.Event || actual.Name != expected.Name || actual.Progress != expected.Progress || actual.Total != expected.Total || len(actual.Parents) != len(expected.Parents) {
+			t.Fatalf("unexpected event %+v, expected %+v", actual, expected)
+		}
+	}
+
+	// Progress continues without error once the client disconnects
+	conn.Close()
+	for i := 0; i < 100; i++ {
+		if _, err := s.Write([]byte("{}\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected socket to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package progress
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/containerd/containerd/log"
+	"github.com/containerd/containerd/pkg/transfer"
+)
+
+// statusSummary tracks the overall progress of the descriptors in a
+// transfer from a stream of progress events. Descriptors are the objects
+// with parents or a known size, such as manifests and layers.
+type statusSummary struct {
+	descriptors map[string]transfer.Progress
+	order       []string
+	current     string
+	status      string
+}
+
+func newStatusSummary() *statusSummary {
+	return &statusSummary{
+		descriptors: map[string]transfer.Progress{},
+	}
+}
+
+// update applies a progress event to the summary
+func (s *statusSummary) update(p transfer.Progress) {
+	if p.Name == "" {
+		s.status = p.Event
+		return
+	}
+	if _, ok := s.descriptors[p.Name]; !ok {
+		if len(p.Parents) == 0 && p.Total == 0 {
+			return
+		}
+		s.order = append(s.order, p.Name)
+	}
+	s.descriptors[p.Name] = p
+
+	switch {
+	case p.Event == "downloading" || p.Event == "uploading":
+		s.current = p.Name
+	case p.Name == s.current:
+		s.current = ""
+	}
+}
+
+// counts returns the number of completed descriptors and the total
+// number of descriptors
+func (s *statusSummary) counts() (complete, total int) {
+	for _, p := range s.descriptors {
+		if isComplete(p.Event) {
+			complete++
+		}
+	}
+	return complete, len(s.descriptors)
+}
+
+// percent returns the overall percent complete by size, only once the size
+// of every descriptor is known
+func (s *statusSummary) percent() (float64, bool) {
+	var progress, total int64
+	for _, p := range s.descriptors {
+		if p.Total <= 0 {
+			return 0, false
+		}
+		if isComplete(p.Event) {
+			progress += p.Total
+		} else {
+			progress += p.Progress
+		}
+		total += p.Total
+	}
+	if total == 0 {
+		return 0, false
+	}
+	return float64(progress) * 100 / float64(total), true
+}
+
+// line returns the single line summary of the transfer
+func (s *statusSummary) line() string {
+	var parts []string
+	if s.status != "" {
+		parts = append(parts, s.status)
+	}
+	if len(s.order) > 0 {
+		if pct, ok := s.percent(); ok {
+			parts = append(parts, fmt.Sprintf("%.1f%%", pct))
+		}
+		complete, total := s.counts()
+		parts = append(parts, fmt.Sprintf("%d/%d complete", complete, total))
+		if s.current != "" {
+			p := s.descriptors[s.current]
+			parts = append(parts, fmt.Sprintf("%s %s", p.Event, displayName(p.Name)))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func isComplete(event string) bool {
+	switch event {
+	case "complete", "done", "already exists":
+		return true
+	}
+	return false
+}
+
+// StatusLine displays the progress as a single line which is rewritten
+// in place using a carriage return, showing the overall percent complete
+// and the number of completed descriptors. The percent is only shown once
+// the size of every descriptor is known. The line is written after each
+// event without redrawing, leaving the output readable in logs.
+func StatusLine(ctx context.Context, out io.Writer) transfer.ProgressFunc {
+	var (
+		mu      sync.Mutex
+		s       = newStatusSummary()
+		lastLen int
+	)
+	return func(p transfer.Progress) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		s.update(p)
+		line := s.line()
+		// Clear any remainder of the previous line
+		n := len(line)
+		if pad := lastLen - n; pad > 0 {
+			line += strings.Repeat(" ", pad)
+		}
+		lastLen = n
+		if _, err := fmt.Fprintf(out, "\r%s", line); err != nil {
+			log.G(ctx).WithError(err).Warnf("status could not be written: %v/%v", p.Event, p.Name)
+		}
+	}
+}
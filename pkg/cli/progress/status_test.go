@@ -0,0 +1,122 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package progress
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/pkg/transfer"
+)
+
+func TestStatusLine(t *testing.T) {
+	var (
+		root   = "docker.io/library/test:latest"
+		index  = "index-sha256:1111111111111111111111111111111111111111111111111111111111111111"
+		layer1 = "layer-sha256:2222222222222222222222222222222222222222222222222222222222222222"
+		layer2 = "layer-sha256:3333333333333333333333333333333333333333333333333333333333333333"
+	)
+
+	type expected struct {
+		percent  float64
+		known    bool
+		complete int
+		total    int
+		line     string
+	}
+	s := newStatusSummary()
+	for i, tc := range []struct {
+		p        transfer.Progress
+		expected expected
+	}{
+		{
+			p:        transfer.Progress{Event: "Pulling from test"},
+			expected: expected{line: "Pulling from test"},
+		},
+		{
+			// The image is not a descriptor
+			p:        transfer.Progress{Event: "fetching image content", Name: root},
+			expected: expected{line: "Pulling from test"},
+		},
+		{
+			p:        transfer.Progress{Event: "resolving", Name: index, Parents: []string{root}},
+			expected: expected{total: 1, line: "Pulling from test 0/1 complete"},
+		},
+		{
+			p:        transfer.Progress{Event: "complete", Name: index, Parents: []string{root}, Progress: 100, Total: 100},
+			expected: expected{percent: 100, known: true, complete: 1, total: 1, line: "Pulling from test 100.0% 1/1 complete"},
+		},
+		{
+			// Size of the second layer is not yet known
+			p:        transfer.Progress{Event: "waiting", Name: layer1, Parents: []string{index}, Total: 300},
+			expected: expected{percent: 25, known: true, complete: 1, total: 2, line: "Pulling from test 25.0% 1/2 complete"},
+		},
+		{
+			p:        transfer.Progress{Event: "resolving", Name: layer2, Parents: []string{index}},
+			expected: expected{complete: 1, total: 3, line: "Pulling from test 1/3 complete"},
+		},
+		{
+			p:        transfer.Progress{Event: "downloading", Name: layer2, Parents: []string{index}, Progress: 100, Total: 600},
+			expected: expected{percent: 20, known: true, complete: 1, total: 3, line: "Pulling from test 20.0% 1/3 complete downloading layer (333333333333)"},
+		},
+		{
+			p:        transfer.Progress{Event: "downloading", Name: layer1, Parents: []string{index}, Progress: 300, Total: 300},
+			expected: expected{percent: 50, known: true, complete: 1, total: 3, line: "Pulling from test 50.0% 1/3 complete downloading layer (222222222222)"},
+		},
+		{
+			p:        transfer.Progress{Event: "complete", Name: layer1, Parents: []string{index}, Progress: 300, Total: 300},
+			expected: expected{percent: 50, known: true, complete: 2, total: 3, line: "Pulling from test 50.0% 2/3 complete"},
+		},
+		{
+			p:        transfer.Progress{Event: "complete", Name: layer2, Parents: []string{index}, Progress: 600, Total: 600},
+			expected: expected{percent: 100, known: true, complete: 3, total: 3, line: "Pulling from test 100.0% 3/3 complete"},
+		},
+		{
+			p:        transfer.Progress{Event: "saved", Name: root},
+			expected: expected{percent: 100, known: true, complete: 3, total: 3, line: "Pulling from test 100.0% 3/3 complete"},
+		},
+	} {
+		s.update(tc.p)
+		percent, known := s.percent()
+		complete, total := s.counts()
+		actual := expected{percent: percent, known: known, complete: complete, total: total, line: s.line()}
+		if actual != tc.expected {
+			t.Fatalf("event %d %q: expected %+v, got %+v", i, tc.p.Event, tc.expected, actual)
+		}
+	}
+}
+
+func TestStatusLineOutput(t *testing.T) {
+	var b bytes.Buffer
+	pf := StatusLine(context.Background(), &b)
+	pf(transfer.Progress{Event: "Resolving from test"})
+	pf(transfer.Progress{Event: "Done"})
+
+	lines := strings.Split(b.String(), "\r")
+	if len(lines) != 3 || lines[1] != "Resolving from test" {
+		t.Fatalf("unexpected output %q", b.String())
+	}
+	// Shorter lines clear the remainder of the previous line
+	if lines[2] != "Done"+strings.Repeat(" ", len("Resolving from test")-len("Done")) {
+		t.Fatalf("expected previous line to be cleared, got %q", lines[2])
+	}
+	if strings.Contains(b.String(), "\n") {
+		t.Fatalf("expected a single line, got %q", b.String())
+	}
+}
@@ -0,0 +1,319 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/lcontainerd/pkg/iobuf"
+	digest "github.com/opencontainers/go-digest"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	backupMetadata = "meta.db"
+	backupBlobs    = "content/blobs/"
+)
+
+// Backup writes a tar archive holding a snapshot of the metadata database
+// along with the committed content. Garbage collection is blocked while the
+// backup is written so all content referenced by the snapshot is included.
+func (m *DB) Backup(ctx context.Context, w io.Writer) error {
+	m.wlock.RLock()
+	defer m.wlock.RUnlock()
+	m.cs.l.RLock()
+	defer m.cs.l.RUnlock()
+
+	tw := tar.NewWriter(w)
+	if err := m.db.View(func(tx *bolt.Tx) error {
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     backupMetadata,
+			Mode:     0600,
+			Size:     tx.Size(),
+			ModTime:  time.Now().UTC(),
+		}); err != nil {
+			return err
+		}
+		_, err := tx.WriteTo(tw)
+		return err
+	}); err != nil {
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+
+	blobs := filepath.Join(m.root, filepath.FromSlash(backupBlobs))
+	if err := filepath.Walk(blobs, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if p == blobs && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(m.root, p)
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = iobuf.Copy(tw, f)
+		return err
+	}); err != nil {
+		return fmt.Errorf("failed to write content: %w", err)
+	}
+
+	return tw.Close()
+}
+
+// Restore restores a backup written by Backup to the root directory. The
+// backup is extracted to a temporary directory next to root and every image
+// is checked for missing content before replacing the existing files. When
+// any content is missing, the restore is aborted and root is left unchanged.
+//
+// With content, the metadata database and content directory are replaced.
+// Otherwise only the metadata database is replaced and the images are checked
+// against the existing content. Other files in root, such as credentials and
+// snapshots, are left in place.
+//
+// The lock on an existing metadata database is held until the restore is
+// complete, the timeout and directory mode options are used when acquiring
+// the lock and creating a new root.
+func Restore(ctx context.Context, root string, r io.Reader, withContent bool, opts ...DBOpt) error {
+	var dbo dbOptions
+	for _, opt := range opts {
+		opt(&dbo)
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+	mode := dbo.dirMode
+	if mode == 0 {
+		mode = 0700
+	}
+	if fi, err := os.Stat(root); err == nil {
+		mode = fi.Mode().Perm()
+	} else if !os.IsNotExist(err) || !withContent {
+		return err
+	}
+
+	// Hold the lock on the existing database so no writer commits changes
+	// which would be lost when the database is replaced
+	metadb := filepath.Join(root, backupMetadata)
+	if _, err := os.Stat(metadb); err == nil {
+		bdb, err := openBolt(metadb, 0, &bolt.Options{Timeout: dbo.boltOptions.Timeout})
+		if err != nil {
+			if errors.Is(err, bolt.ErrTimeout) {
+				return busyError(metadb)
+			}
+			return err
+		}
+		defer bdb.Close()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	tmp, err := os.MkdirTemp(filepath.Dir(root), "."+filepath.Base(root)+"-restore-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := os.Chmod(tmp, mode); err != nil {
+		return err
+	}
+
+	if err := extractBackup(r, tmp, withContent); err != nil {
+		return fmt.Errorf("failed to extract backup: %w", err)
+	}
+	if !withContent {
+		if err := os.Symlink(filepath.Join(root, "content"), filepath.Join(tmp, "content")); err != nil {
+			return err
+		}
+	}
+	if err := checkRestore(ctx, tmp); err != nil {
+		return err
+	}
+
+	if !withContent {
+		return os.Rename(filepath.Join(tmp, backupMetadata), metadb)
+	}
+	if err := mkdirMode(root, mode); err != nil {
+		return err
+	}
+	for _, dir := range []string{filepath.Join(tmp, "content"), filepath.Join(tmp, "content", "ingest")} {
+		if err := os.Chmod(dir, mode); err != nil {
+			return err
+		}
+	}
+	return swapContent(root, tmp)
+}
+
+// extractBackup extracts the metadata database and, when requested, the
+// content blobs. Blobs are verified against their digest as they are written.
+func extractBackup(r io.Reader, dir string, withContent bool) error {
+	var (
+		tr       = tar.NewReader(r)
+		metadata bool
+	)
+	if withContent {
+		if err := os.MkdirAll(filepath.Join(dir, "content", "ingest"), 0700); err != nil {
+			return err
+		}
+	}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		var expected digest.Digest
+		name := path.Clean(hdr.Name)
+		switch {
+		case name == backupMetadata:
+			metadata = true
+		case strings.HasPrefix(name, backupBlobs):
+			if !withContent {
+				continue
+			}
+			dgst := digest.Digest(strings.Replace(strings.TrimPrefix(name, backupBlobs), "/", ":", 1))
+			if err := dgst.Validate(); err != nil {
+				return fmt.Errorf("invalid blob %s in backup: %w", hdr.Name, err)
+			}
+			expected = dgst
+		default:
+			return fmt.Errorf("unexpected file %s in backup: %w", hdr.Name, errdefs.ErrInvalidArgument)
+		}
+
+		if err := extractFile(tr, filepath.Join(dir, filepath.FromSlash(name)), expected); err != nil {
+			return err
+		}
+	}
+	if !metadata {
+		return fmt.Errorf("backup does not contain %s: %w", backupMetadata, errdefs.ErrInvalidArgument)
+	}
+	return nil
+}
+
+func extractFile(r io.Reader, p string, expected digest.Digest) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = f
+	var digester digest.Digester
+	if expected != "" {
+		digester = expected.Algorithm().Digester()
+		w = io.MultiWriter(f, digester.Hash())
+	}
+	if _, err := iobuf.Copy(w, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if digester != nil && digester.Digest() != expected {
+		return fmt.Errorf("blob %s does not match its digest, got %s: %w", expected, digester.Digest(), errdefs.ErrFailedPrecondition)
+	}
+	return nil
+}
+
+// checkRestore checks that the images of the restored database at root do
+// not refer to missing content
+func checkRestore(ctx context.Context, root string) error {
+	m, err := NewDB(root, WithReadOnly)
+	if err != nil {
+		return err
+	}
+	// Close the database directly, the restored database is not collected
+	defer m.db.Close()
+
+	broken, err := m.CheckImages(ctx)
+	if err != nil {
+		return err
+	}
+	if len(broken) > 0 {
+		names := make([]string, len(broken))
+		for i, b := range broken {
+			names[i] = b.Image.Name
+		}
+		return fmt.Errorf("restored images are missing content: %s: %w", strings.Join(names, ", "), errdefs.ErrFailedPrecondition)
+	}
+	return nil
+}
+
+// swapContent replaces the content directory and metadata database of root
+// with those extracted to dir. The existing content directory is moved into
+// dir and removed along with it, it is moved back if the metadata database
+// could not be replaced.
+func swapContent(root, dir string) error {
+	var (
+		content    = filepath.Join(root, "content")
+		newContent = filepath.Join(dir, "content")
+		oldContent = filepath.Join(dir, "content-old")
+	)
+	if err := os.Rename(content, oldContent); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	restore := func(err error) error {
+		if rerr := os.Rename(content, newContent); rerr != nil && !errors.Is(rerr, os.ErrNotExist) {
+			return fmt.Errorf("failed to restore %s from %s after %v: %w", content, oldContent, err, rerr)
+		}
+		if rerr := os.Rename(oldContent, content); rerr != nil && !errors.Is(rerr, os.ErrNotExist) {
+			return fmt.Errorf("failed to restore %s from %s after %v: %w", content, oldContent, err, rerr)
+		}
+		return err
+	}
+	if err := os.Rename(newContent, content); err != nil {
+		return restore(err)
+	}
+	if err := os.Rename(filepath.Join(dir, backupMetadata), filepath.Join(root, backupMetadata)); err != nil {
+		return restore(err)
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/leases"
+	"github.com/containerd/containerd/namespaces"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestBackupRestore(t *testing.T) {
+	ctx := namespaces.WithNamespace(context.Background(), "testing")
+	var (
+		dir     = t.TempDir()
+		root    = filepath.Join(dir, "root")
+		restore = filepath.Join(dir, "restored")
+	)
+
+	// Write a backup with two images
+	src, err := NewDB(root, WithDirMode(0750))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := createTestImage(ctx, t, src, "first", "first content")
+	second := createTestImage(ctx, t, src, "second", "second content")
+	var backup bytes.Buffer
+	if err := src.Backup(ctx, &backup); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restore to a new root
+	if err := Restore(ctx, restore, bytes.NewReader(backup.Bytes()), true); err != nil {
+		t.Fatal(err)
+	}
+	checkRestoredImages(ctx, t, restore, map[string]ocispec.Descriptor{"first": first, "second": second})
+
+	// Restore over the existing root after removing an image
+	if err := NewImageStore(src).Delete(ctx, "second"); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := Restore(ctx, root, bytes.NewReader(backup.Bytes()), true); err != nil {
+		t.Fatal(err)
+	}
+	checkRestoredImages(ctx, t, root, map[string]ocispec.Descriptor{"first": first, "second": second})
+	if fi, err := os.Stat(root); err != nil {
+		t.Fatal(err)
+	} else if fi.Mode().Perm() != 0750 {
+		t.Fatalf("expected root mode to be kept, got %o", fi.Mode().Perm())
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, ".*")); len(matches) > 0 {
+		t.Fatalf("temporary restore directories left behind: %v", matches)
+	}
+
+	// Metadata is restored on its own when the content is present
+	if err := Restore(ctx, root, bytes.NewReader(backup.Bytes()), false); err != nil {
+		t.Fatal(err)
+	}
+	checkRestoredImages(ctx, t, root, map[string]ocispec.Descriptor{"first": first, "second": second})
+
+	// A backup missing content is refused and the existing root is unchanged
+	if err := Restore(ctx, restore, metadataOnly(t, backup.Bytes()), true); !errdefs.IsFailedPrecondition(err) {
+		t.Fatalf("expected failed precondition restoring without content, got %v", err)
+	}
+	checkRestoredImages(ctx, t, restore, map[string]ocispec.Descriptor{"first": first, "second": second})
+
+	// Restoring only the metadata is refused when the existing content was
+	// collected after the image was removed
+	mdb, err := NewDB(restore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewImageStore(mdb).Delete(ctx, "second"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mdb.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := Restore(ctx, restore, bytes.NewReader(backup.Bytes()), false); !errdefs.IsFailedPrecondition(err) {
+		t.Fatalf("expected failed precondition restoring metadata with collected content, got %v", err)
+	}
+	checkRestoredImages(ctx, t, restore, map[string]ocispec.Descriptor{"first": first})
+}
+
+func TestRestoreKeepsRoot(t *testing.T) {
+	ctx := namespaces.WithNamespace(context.Background(), "testing")
+	var (
+		dir     = t.TempDir()
+		root    = filepath.Join(dir, "root")
+		restore = filepath.Join(dir, "restored")
+	)
+
+	src, err := NewDB(root, WithDirMode(0700))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := createTestImage(ctx, t, src, "first", "first content")
+	var backup bytes.Buffer
+	if err := src.Backup(ctx, &backup); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restoring is refused while the database is open
+	if err := Restore(ctx, root, bytes.NewReader(backup.Bytes()), true, WithTimeout(10*time.Millisecond)); !errdefs.IsUnavailable(err) {
+		t.Fatalf("expected unavailable restoring an open database, got %v", err)
+	}
+	if err := src.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Files which are not part of the backup are kept
+	unrelated := filepath.Join(root, "credentials", "registry")
+	if err := os.MkdirAll(filepath.Dir(unrelated), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(unrelated, []byte("saved"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Restore(ctx, root, bytes.NewReader(backup.Bytes()), true); err != nil {
+		t.Fatal(err)
+	}
+	checkRestoredImages(ctx, t, root, map[string]ocispec.Descriptor{"first": first})
+	if b, err := os.ReadFile(unrelated); err != nil {
+		t.Fatalf("unrelated file not kept: %v", err)
+	} else if string(b) != "saved" {
+		t.Fatalf("unrelated file changed, got %q", b)
+	}
+
+	// A new root is created with the directory mode
+	if err := Restore(ctx, restore, bytes.NewReader(backup.Bytes()), true, WithDirMode(0750)); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(restore); err != nil {
+		t.Fatal(err)
+	} else if fi.Mode().Perm() != 0750 {
+		t.Fatalf("expected new root mode 0750, got %o", fi.Mode().Perm())
+	}
+	checkRestoredImages(ctx, t, restore, map[string]ocispec.Descriptor{"first": first})
+}
+
+func createTestImage(ctx context.Context, t *testing.T, db *DB, name, data string) ocispec.Descriptor {
+	t.Helper()
+	lm := NewLeaseManager(db)
+	l, err := lm.Create(ctx, leases.WithRandomID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lm.Delete(ctx, l)
+
+	desc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Digest:    digest.FromString(data),
+		Size:      int64(len(data)),
+	}
+	if err := content.WriteBlob(leases.WithLease(ctx, l.ID), db.ContentStore(), name, bytes.NewReader([]byte(data)), desc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewImageStore(db).Create(ctx, images.Image{Name: name, Target: desc}); err != nil {
+		t.Fatal(err)
+	}
+	return desc
+}
+
+func checkRestoredImages(ctx context.Context, t *testing.T, root string, expected map[string]ocispec.Descriptor) {
+	t.Helper()
+	mdb, err := NewDB(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mdb.Close(ctx)
+
+	imgs, err := NewImageStore(mdb).List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imgs) != len(expected) {
+		t.Fatalf("expected %d images, got %d", len(expected), len(imgs))
+	}
+	for _, img := range imgs {
+		desc, ok := expected[img.Name]
+		if !ok || img.Target.Digest != desc.Digest {
+			t.Fatalf("unexpected image %s with target %s", img.Name, img.Target.Digest)
+		}
+		if _, err := content.ReadBlob(ctx, mdb.ContentStore(), desc); err != nil {
+			t.Fatalf("failed to read %s: %v", img.Name, err)
+		}
+	}
+}
+
+// metadataOnly returns a copy of the backup without content
+func metadataOnly(t *testing.T, backup []byte) io.Reader {
+	var (
+		b  bytes.Buffer
+		tr = tar.NewReader(bytes.NewReader(backup))
+		tw = tar.NewWriter(&b)
+	)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name != backupMetadata {
+			continue
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &b
+}
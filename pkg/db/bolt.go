@@ -29,6 +29,13 @@ type transactionKey struct{}
 // bolt transaction. Functions which require a bolt transaction will
 // first check to see if a transaction is already created on the
 // context before creating their own.
+//
+// This allows multiple store operations, such as creating an image and
+// adding a lease, to be composed atomically. The transaction should be
+// started with DB.Update or DB.View to coordinate with garbage collection
+// and is committed or rolled back only by its creator, the store operations
+// never commit a transaction taken from the context. Operations which modify
+// the store return an error when the transaction is not writable.
 func WithTransactionContext(ctx context.Context, tx *bolt.Tx) context.Context {
 	return context.WithValue(ctx, transactionKey{}, tx)
 }
@@ -14,7 +14,8 @@
    limitations under the License.
 */
 
-// Package metadata stores all labels and object specific metadata by namespace.
+// Package db stores all labels and object specific metadata in a single store
+// without namespaces.
 // This package also contains the main garbage collection logic for cleaning up
 // resources consistently and atomically. Resources used by backends will be
 // tracked in the metadata store to be exposed to consumers of this package.
@@ -26,18 +27,17 @@
 //
 // Generically, we try to do the following:
 //
-// 	<version>/<namespace>/<object>/<key> -> <field>
+//	<version>/<object>/<key> -> <field>
 //
 // version: Currently, this is "v1". Additions can be made to v1 in a backwards
 // compatible way. If the layout changes, a new version must be made, along
 // with a migration.
 //
-// namespace: the namespace to which this object belongs.
+// Objects are stored directly under the version bucket, there is no
+// namespace level as in the containerd metadata store.
 //
-// object: defines which object set is stored in the bucket. There are two
-// special objects, "labels" and "indexes". The "labels" bucket stores the
-// labels for the parent namespace. The "indexes" object is reserved for
-// indexing objects, if we require in the future.
+// object: defines which object set is stored in the bucket. The "indexes"
+// object is reserved for indexing objects, if we require in the future.
 //
 // key: object-specific key identifying the storage bucket for the objects
 // contents.
@@ -45,75 +45,80 @@
 // Below is the current database schema. This should be updated each time
 // the structure is changed in addition to adding a migration and incrementing
 // the database version.
-// Notes:
-//  * `╘══*...*` refers to maps with arbitrary keys
-//  * `version` is a key to a numeric value identifying the minor revisions
-//    of schema version
-//  * a namespace in a schema bucket cannot be named "version"
 //
-//  └──v1                                        - Schema version bucket
-//     ├──version : <varint>                     - Latest version, see migrations
-//     ├──image
-//     │  ╘══*image name*
-//     │     ├──createdat : <binary time>     - Created at
-//     │     ├──updatedat : <binary time>     - Updated at
-//     │     ├──target
-//     │     │  ├──digest : <digest>          - Descriptor digest
-//     │     │  ├──mediatype : <string>       - Descriptor media type
-//     │     │  └──size : <varint>            - Descriptor size
-//     │     └──labels
-//     │        ╘══*key* : <string>           - Label value
-//     ├──containers
-//     │  ╘══*container id*
-//     │     ├──createdat : <binary time>     - Created at
-//     │     ├──updatedat : <binary time>     - Updated at
-//     │     ├──spec : <binary>               - Proto marshaled spec
-//     │     ├──image : <string>              - Image name
-//     │     ├──snapshotter : <string>        - Snapshotter name
-//     │     ├──snapshotKey : <string>        - Snapshot key
-//     │     ├──runtime
-//     │     │  ├──name : <string>            - Runtime name
-//     │     │  ├──extensions
-//     │     │  │  ╘══*name* : <binary>       - Proto marshaled extension
-//     │     │  └──options : <binary>         - Proto marshaled options
-//     │     └──labels
-//     │        ╘══*key* : <string>           - Label value
-//     ├──snapshots
-//     │  ╘══*snapshotter*
-//     │     ╘══*snapshot key*
-//     │        ├──name : <string>            - Snapshot name in backend
-//     │        ├──createdat : <binary time>  - Created at
-//     │        ├──updatedat : <binary time>  - Updated at
-//     │        ├──parent : <string>          - Parent snapshot name
-//     │        ├──children
-//     │        │  ╘══*snapshot key* : <nil>  - Child snapshot reference
-//     │        └──labels
-//     │           ╘══*key* : <string>        - Label value
-//     ├──content
-//     │  ├──blob
-//     │  │  ╘══*blob digest*
-//     │  │     ├──createdat : <binary time>  - Created at
-//     │  │     ├──updatedat : <binary time>  - Updated at
-//     │  │     ├──size : <varint>            - Blob size
-//     │  │     └──labels
-//     │  │        ╘══*key* : <string>        - Label value
-//     │  └──ingests
-//     │     ╘══*ingest reference*
-//     │        ├──ref : <string>             - Ingest reference in backend
-//     │        ├──expireat : <binary time>   - Time to expire ingest
-//     │        └──expected : <digest>        - Expected commit digest
-//     └──leases
-//        ╘══*lease id*
-//           ├──createdat : <binary time>     - Created at
-//           ├──labels
-//           │  ╘══*key* : <string>           - Label value
-//           ├──snapshots
-//           │  ╘══*snapshotter*
-//           │     ╘══*snapshot key* : <nil>  - Snapshot reference
-//           ├──content
-//           │  ╘══*blob digest* : <nil>      - Content blob reference
-//           └──ingests
-//              ╘══*ingest reference* : <nil> - Content ingest reference
+//	└──v1                                        - Schema version bucket
+//	   ├──version : <varint>                     - Latest version, see migrations
+//	   ├──summary : <json>                       - Last recorded integrity summary
+//	   ├──contentcleanup : <empty>               - Removed content awaiting blob cleanup
+//	   ├──images
+//	   │  ╘══*image name*
+//	   │     ├──createdat : <binary time>     - Created at
+//	   │     ├──updatedat : <binary time>     - Updated at
+//	   │     ├──target
+//	   │     │  ├──digest : <digest>          - Descriptor digest
+//	   │     │  ├──mediatype : <string>       - Descriptor media type
+//	   │     │  └──size : <varint>            - Descriptor size
+//	   │     └──labels
+//	   │        ╘══*key* : <string>           - Label value
+//	   ├──imagelog
+//	   │  ╘══*sequence* : <json>              - Image event, oldest removed past log size
+//	   ├──containers
+//	   │  ╘══*container id*
+//	   │     ├──createdat : <binary time>     - Created at
+//	   │     ├──updatedat : <binary time>     - Updated at
+//	   │     ├──spec : <binary>               - Proto marshaled spec
+//	   │     ├──image : <string>              - Image name
+//	   │     ├──snapshotter : <string>        - Snapshotter name
+//	   │     ├──snapshotKey : <string>        - Snapshot key
+//	   │     ├──runtime
+//	   │     │  ├──name : <string>            - Runtime name
+//	   │     │  ├──extensions
+//	   │     │  │  ╘══*name* : <binary>       - Proto marshaled extension
+//	   │     │  └──options : <binary>         - Proto marshaled options
+//	   │     └──labels
+//	   │        ╘══*key* : <string>           - Label value
+//	   ├──snapshots
+//	   │  ╘══*snapshotter*
+//	   │     ╘══*snapshot key*
+//	   │        ├──name : <string>            - Snapshot name in backend
+//	   │        ├──createdat : <binary time>  - Created at
+//	   │        ├──updatedat : <binary time>  - Updated at
+//	   │        ├──parent : <string>          - Parent snapshot name
+//	   │        ├──children
+//	   │        │  ╘══*snapshot key* : <nil>  - Child snapshot reference
+//	   │        └──labels
+//	   │           ╘══*key* : <string>        - Label value
+//	   ├──content
+//	   │  ├──blob
+//	   │  │  ╘══*blob digest*
+//	   │  │     ├──createdat : <binary time>  - Created at
+//	   │  │     ├──updatedat : <binary time>  - Updated at
+//	   │  │     ├──size : <varint>            - Blob size
+//	   │  │     └──labels
+//	   │  │        ╘══*key* : <string>        - Label value
+//	   │  └──ingests
+//	   │     ╘══*ingest reference*
+//	   │        ├──ref : <string>             - Ingest reference in backend
+//	   │        ├──expireat : <binary time>   - Time to expire ingest
+//	   │        └──expected : <digest>        - Expected commit digest
+//	   └──leases
+//	      ╘══*lease id*
+//	         ├──createdat : <binary time>     - Created at
+//	         ├──labels
+//	         │  ╘══*key* : <string>           - Label value
+//	         ├──snapshots
+//	         │  ╘══*snapshotter*
+//	         │     ╘══*snapshot key* : <nil>  - Snapshot reference
+//	         ├──content
+//	         │  ╘══*blob digest* : <nil>      - Content blob reference
+//	         └──ingests
+//	            ╘══*ingest reference* : <nil> - Content ingest reference
+//
+// Notes:
+//   - `╘══*...*` refers to maps with arbitrary keys
+//   - `version` is a key to a numeric value identifying the minor revisions
+//     of schema version
+//   - an object in a schema bucket cannot be named "version"
 package db
 
 import (
@@ -122,14 +127,20 @@ import (
 )
 
 var (
-	bucketKeyVersion       = []byte(schemaVersion)
-	bucketKeyDBVersion     = []byte("version") // stores the version of the schema
-	bucketKeyObjectLabels  = []byte("labels")  // stores the labels for a namespace.
-	bucketKeyObjectImages  = []byte("images")  // stores image objects
-	bucketKeyObjectContent = []byte("content") // stores content references
-	bucketKeyObjectBlob    = []byte("blob")    // stores content links
-	bucketKeyObjectIngests = []byte("ingests") // stores ingest objects
-	bucketKeyObjectLeases  = []byte("leases")  // stores leases
+	bucketKeyVersion          = []byte(schemaVersion)
+	bucketKeyDBVersion        = []byte("version")        // stores the version of the schema
+	bucketKeyObjectLabels     = []byte("labels")         // stores the labels of an object
+	bucketKeyObjectImages     = []byte("images")         // stores image objects
+	bucketKeyObjectContainers = []byte("containers")     // stores container objects
+	bucketKeyObjectContent    = []byte("content")        // stores content references
+	bucketKeyObjectBlob       = []byte("blob")           // stores content links
+	bucketKeyObjectIngests    = []byte("ingests")        // stores ingest objects
+	bucketKeyObjectLeases     = []byte("leases")         // stores leases
+	bucketKeyObjectSnapshots  = []byte("snapshots")      // stores snapshot references
+	bucketKeySummary          = []byte("summary")        // stores the last recorded integrity summary
+	bucketKeyContentCleanup   = []byte("contentcleanup") // marks removed content awaiting blob cleanup
+
+	bucketKeyObjectImageLog = []byte("imagelog") // stores image events
 
 	bucketKeyDigest      = []byte("digest")
 	bucketKeyMediaType   = []byte("mediatype")
@@ -147,6 +158,7 @@ var (
 	bucketKeyExtensions  = []byte("extensions")
 	bucketKeyCreatedAt   = []byte("createdat")
 	bucketKeyExpected    = []byte("expected")
+	bucketKeyCanonical   = []byte("canonical")
 	bucketKeyRef         = []byte("ref")
 	bucketKeyExpireAt    = []byte("expireat")
 	bucketKeySandboxID   = []byte("sandboxid")
@@ -183,6 +195,26 @@ func createBucketIfNotExists(tx *bolt.Tx, keys ...[]byte) (*bolt.Bucket, error)
 	return bkt, nil
 }
 
+func getSnapshotterBucket(tx *bolt.Tx, snapshotter string) *bolt.Bucket {
+	return getBucket(tx, bucketKeyVersion, bucketKeyObjectSnapshots, []byte(snapshotter))
+}
+
+func createSnapshotterBucket(tx *bolt.Tx, snapshotter string) (*bolt.Bucket, error) {
+	return createBucketIfNotExists(tx, bucketKeyVersion, bucketKeyObjectSnapshots, []byte(snapshotter))
+}
+
+func getContainersBucket(tx *bolt.Tx) *bolt.Bucket {
+	return getBucket(tx, bucketKeyVersion, bucketKeyObjectContainers)
+}
+
+func createContainersBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	return createBucketIfNotExists(tx, bucketKeyVersion, bucketKeyObjectContainers)
+}
+
+func getContainerBucket(tx *bolt.Tx, id string) *bolt.Bucket {
+	return getBucket(tx, bucketKeyVersion, bucketKeyObjectContainers, []byte(id))
+}
+
 func imagesBucketPath() [][]byte {
 	return [][]byte{bucketKeyVersion, bucketKeyObjectImages}
 }
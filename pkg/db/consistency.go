@@ -0,0 +1,185 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	"github.com/containerd/containerd/metadata/boltutil"
+	digest "github.com/opencontainers/go-digest"
+	bolt "go.etcd.io/bbolt"
+)
+
+// DanglingRef is content referencing other content through a garbage
+// collection label when the referenced content does not exist. This is
+// expected for content such as an index when only some platforms were
+// pulled.
+type DanglingRef struct {
+	Digest digest.Digest
+	Label  string
+	Ref    digest.Digest
+}
+
+// ConsistencyReport holds the problems found by CheckConsistency
+type ConsistencyReport struct {
+	// ExpiredIngests are the refs of ingests past their expiration
+	ExpiredIngests []string
+
+	// PrunedIngests are the refs of the expired ingests which were removed
+	PrunedIngests []string
+
+	// DanglingRefs are the labels referencing missing content
+	DanglingRefs []DanglingRef
+
+	// MissingTargets are the names of images whose target is missing
+	MissingTargets []string
+}
+
+// WithConsistencyCheck checks the consistency of the database when it is
+// opened, logging a warning for each problem found. When prune is set the
+// expired ingests are removed, unless the database is opened read-only.
+// The check reads all content and images so it is not run by default.
+func WithConsistencyCheck(prune bool) DBOpt {
+	return func(dbo *dbOptions) {
+		dbo.consistencyCheck = true
+		dbo.pruneIngests = prune
+	}
+}
+
+// checkOnOpen runs the consistency check configured for opening the
+// database, problems are logged rather than failing the open
+func (m *DB) checkOnOpen(ctx context.Context) {
+	prune := m.dbopts.pruneIngests && !m.dbopts.boltOptions.ReadOnly
+	r, err := m.CheckConsistency(ctx, prune)
+	if err != nil {
+		log.G(ctx).WithError(err).Warn("database consistency check failed")
+		return
+	}
+	for _, ref := range r.ExpiredIngests {
+		log.G(ctx).WithField("ref", ref).Warn("ingest past its expiration")
+	}
+	for _, ref := range r.PrunedIngests {
+		log.G(ctx).WithField("ref", ref).Warn("removed expired ingest")
+	}
+	for _, d := range r.DanglingRefs {
+		log.G(ctx).WithField("digest", d.Digest).WithField("label", d.Label).WithField("ref", d.Ref).Warn("content label references missing content")
+	}
+	for _, name := range r.MissingTargets {
+		log.G(ctx).WithField("image", name).Warn("image target is missing")
+	}
+}
+
+// CheckConsistency returns the ingests past their expiration, the content
+// labels referencing missing content, and the images whose target is
+// missing. When prune is set the expired ingests are aborted.
+func (m *DB) CheckConsistency(ctx context.Context, prune bool) (ConsistencyReport, error) {
+	var r ConsistencyReport
+	now := time.Now()
+	if err := view(ctx, m, func(tx *bolt.Tx) error {
+		if ibkt := getIngestsBucket(tx); ibkt != nil {
+			if err := ibkt.ForEach(func(k, v []byte) error {
+				if v != nil {
+					return nil
+				}
+				ea, err := readExpireAt(ibkt.Bucket(k))
+				if err != nil {
+					return err
+				}
+				if ea != nil && now.After(*ea) {
+					r.ExpiredIngests = append(r.ExpiredIngests, string(k))
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+
+		bbkt := getBlobsBucket(tx)
+		if bbkt == nil {
+			return nil
+		}
+		return bbkt.ForEach(func(k, v []byte) error {
+			if v != nil {
+				return nil
+			}
+			labels, err := boltutil.ReadLabels(bbkt.Bucket(k))
+			if err != nil {
+				return err
+			}
+			for label, value := range labels {
+				if !strings.HasPrefix(label, string(labelGCContentRef)) {
+					continue
+				}
+				ref, err := digest.Parse(value)
+				if err != nil {
+					continue
+				}
+				if bbkt.Bucket([]byte(ref.String())) == nil {
+					r.DanglingRefs = append(r.DanglingRefs, DanglingRef{
+						Digest: digest.Digest(k),
+						Label:  label,
+						Ref:    ref,
+					})
+				}
+			}
+			return nil
+		})
+	}); err != nil {
+		return ConsistencyReport{}, err
+	}
+	sort.Slice(r.DanglingRefs, func(i, j int) bool {
+		if r.DanglingRefs[i].Digest != r.DanglingRefs[j].Digest {
+			return r.DanglingRefs[i].Digest < r.DanglingRefs[j].Digest
+		}
+		return r.DanglingRefs[i].Label < r.DanglingRefs[j].Label
+	})
+
+	imgs, err := NewImageStore(m).List(ctx)
+	if err != nil {
+		return ConsistencyReport{}, err
+	}
+	for _, img := range imgs {
+		canonical, err := m.cs.checkAccess(ctx, img.Target.Digest)
+		if err == nil {
+			if canonical == "" {
+				canonical = img.Target.Digest
+			}
+			_, err = m.cs.Store.Info(ctx, canonical)
+		}
+		if errdefs.IsNotFound(err) {
+			r.MissingTargets = append(r.MissingTargets, img.Name)
+		} else if err != nil {
+			return ConsistencyReport{}, fmt.Errorf("failed to check image %s: %w", img.Name, err)
+		}
+	}
+
+	if prune {
+		for _, ref := range r.ExpiredIngests {
+			if err := m.cs.Abort(ctx, ref); err != nil && !errdefs.IsNotFound(err) {
+				return r, fmt.Errorf("failed to remove expired ingest %s: %w", ref, err)
+			}
+			r.PrunedIngests = append(r.PrunedIngests, ref)
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,117 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestCheckConsistency(t *testing.T) {
+	ctx, db := testDB(t)
+	cs := db.ContentStore()
+
+	write := func(data string, labels map[string]string) ocispec.Descriptor {
+		t.Helper()
+		b := []byte(data)
+		desc := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: digest.FromBytes(b), Size: int64(len(b))}
+		if err := content.WriteBlob(ctx, cs, desc.Digest.String(), bytes.NewReader(b), desc, content.WithLabels(labels)); err != nil {
+			t.Fatal(err)
+		}
+		return desc
+	}
+	ingest := func(ref string) {
+		t.Helper()
+		w, err := cs.Writer(ctx, content.WithRef(ref))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(ref)); err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+	}
+
+	var (
+		missing = digest.FromString("missing")
+		layer   = write("layer", nil)
+		target  = write("manifest", map[string]string{
+			string(labelGCRoot):                "always",
+			string(labelGCContentRef) + ".l.0": layer.Digest.String(),
+			string(labelGCContentRef) + ".l.1": missing.String(),
+		})
+	)
+
+	is := NewImageStore(db)
+	for name, desc := range map[string]ocispec.Descriptor{
+		"present": target,
+		"missing": {MediaType: ocispec.MediaTypeImageManifest, Digest: missing, Size: 7},
+	} {
+		if _, err := is.Create(ctx, images.Image{Name: name, Target: desc}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ingest("stale")
+	ingest("fresh")
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return writeExpireAt(time.Now().Add(-time.Hour), getIngestsBucket(tx).Bucket([]byte("stale")))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := db.CheckConsistency(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ConsistencyReport{
+		ExpiredIngests: []string{"stale"},
+		DanglingRefs: []DanglingRef{
+			{Digest: target.Digest, Label: string(labelGCContentRef) + ".l.1", Ref: missing},
+		},
+		MissingTargets: []string{"missing"},
+	}
+	if !reflect.DeepEqual(r, expected) {
+		t.Fatalf("unexpected report\n%#v\nexpected\n%#v", r, expected)
+	}
+	if _, err := cs.Status(ctx, "stale"); err != nil {
+		t.Fatalf("expected ingest to remain when not pruning: %v", err)
+	}
+
+	r, err = db.CheckConsistency(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r.PrunedIngests, []string{"stale"}) {
+		t.Fatalf("expected stale ingest to be pruned, got %v", r.PrunedIngests)
+	}
+	if _, err := cs.Status(ctx, "stale"); !errdefs.IsNotFound(err) {
+		t.Fatalf("expected pruned ingest to be removed, got %v", err)
+	}
+	if _, err := cs.Status(ctx, "fresh"); err != nil {
+		t.Fatalf("expected unexpired ingest to remain: %v", err)
+	}
+}
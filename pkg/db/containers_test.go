@@ -0,0 +1,109 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"testing"
+
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/protobuf/types"
+)
+
+func TestContainerStore(t *testing.T) {
+	ctx, db := testEnv(t)
+	store := NewContainerStore(db)
+
+	container := containers.Container{
+		ID:          "c1",
+		Image:       "docker.io/library/alpine:latest",
+		Snapshotter: "native",
+		SnapshotKey: "c1-snapshot",
+		Labels:      map[string]string{"app": "test"},
+		Runtime: containers.RuntimeInfo{
+			Name: "io.containerd.runc.v2",
+		},
+		Spec: &types.Any{TypeUrl: "test", Value: []byte("{}")},
+	}
+
+	created, err := store.Create(ctx, container)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.CreatedAt.IsZero() {
+		t.Fatal("expected created timestamp to be set")
+	}
+	if _, err := store.Create(ctx, container); !errdefs.IsAlreadyExists(err) {
+		t.Fatalf("expected already exists, got %v", err)
+	}
+
+	invalid := container
+	invalid.ID = "c2"
+	invalid.Runtime.Name = ""
+	if _, err := store.Create(ctx, invalid); !errdefs.IsInvalidArgument(err) {
+		t.Fatalf("expected invalid argument without a runtime, got %v", err)
+	}
+
+	c, err := store.Get(ctx, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Runtime.Name != container.Runtime.Name || c.SnapshotKey != container.SnapshotKey || c.Image != container.Image || c.Labels["app"] != "test" {
+		t.Fatalf("unexpected container %+v", c)
+	}
+	if string(c.Spec.GetValue()) != "{}" {
+		t.Fatalf("unexpected spec %q", c.Spec.GetValue())
+	}
+
+	listed, err := store.List(ctx, `labels.app==test`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0].ID != "c1" {
+		t.Fatalf("unexpected list %v", listed)
+	}
+	listed, err = store.List(ctx, `runtime.name==other`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 0 {
+		t.Fatalf("expected no containers, got %v", listed)
+	}
+
+	c.Labels = map[string]string{"app": "updated"}
+	c.Snapshotter = "overlayfs"
+	if _, err := store.Update(ctx, c); !errdefs.IsInvalidArgument(err) {
+		t.Fatalf("expected snapshotter to be immutable, got %v", err)
+	}
+	updated, err := store.Update(ctx, c, "labels.app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Labels["app"] != "updated" || updated.Snapshotter != "native" {
+		t.Fatalf("unexpected update %+v", updated)
+	}
+
+	if err := store.Delete(ctx, "c1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(ctx, "c1"); !errdefs.IsNotFound(err) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	if err := store.Delete(ctx, "c1"); !errdefs.IsNotFound(err) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
@@ -18,6 +18,7 @@ package db
 
 import (
 	"context"
+	_ "crypto/sha512" // register sha512 for digest algorithms
 	"encoding/binary"
 	"fmt"
 	"strings"
@@ -42,21 +43,8 @@ type contentStore struct {
 	l  sync.RWMutex
 }
 
-// newContentStore returns a namespaced content store using an existing
-// content store interface.
-// policy defines the sharing behavior for content between namespaces. Both
-// modes will result in shared storage in the backend for committed. Choose
-// "shared" to prevent separate namespaces from having to pull the same content
-// twice.  Choose "isolated" if the content must not be shared between
-// namespaces.
-//
-// If the policy is "shared", writes will try to resolve the "expected" digest
-// against the backend, allowing imports of content from other namespaces. In
-// "isolated" mode, the client must prove they have the content by providing
-// the entire blob before the content can be added to another namespace.
-//
-// Since we have only two policies right now, it's simpler using bool to
-// represent it internally.
+// newContentStore returns a content store recording its content in the
+// metadata database using an existing content store interface.
 func newContentStore(db *DB, cs content.Store) *contentStore {
 	return &contentStore{
 		Store: cs,
@@ -163,7 +151,7 @@ func (cs *contentStore) Walk(ctx context.Context, fn content.WalkFunc, fs ...str
 			if err := readInfo(&info, bkt.Bucket(k)); err != nil {
 				return err
 			}
-			if filter.Match(content.AdaptInfo(info)) {
+			if filter.Match(adaptContentInfo(info)) {
 				infos = append(infos, info)
 			}
 			return nil
@@ -191,6 +179,12 @@ func (cs *contentStore) Delete(ctx context.Context, dgst digest.Digest) error {
 			return fmt.Errorf("content digest %v: %w", dgst, errdefs.ErrNotFound)
 		}
 
+		// Mark content store as dirty for triggering garbage collection
+		if err := cs.db.markContentRemoved(tx, dgst); err != nil {
+			return err
+		}
+		atomic.AddUint32(&cs.db.dirty, 1)
+
 		if err := getBlobsBucket(tx).DeleteBucket([]byte(dgst.String())); err != nil {
 			return err
 		}
@@ -198,10 +192,6 @@ func (cs *contentStore) Delete(ctx context.Context, dgst digest.Digest) error {
 			return err
 		}
 
-		// Mark content store as dirty for triggering garbage collection
-		atomic.AddUint32(&cs.db.dirty, 1)
-		cs.db.dirtyCS = true
-
 		return nil
 	})
 }
@@ -309,9 +299,12 @@ func (cs *contentStore) Abort(ctx context.Context, ref string) error {
 			return err
 		}
 
-		// if not shared content, delete active ingest on backend
+		// if not shared content, delete active ingest on backend, the
+		// ingest may already be missing from the backend when dangling
 		if expected == "" {
-			return cs.Store.Abort(ctx, bref)
+			if err := cs.Store.Abort(ctx, bref); err != nil && !errdefs.IsNotFound(err) {
+				return err
+			}
 		}
 
 		return nil
@@ -331,6 +324,11 @@ func (cs *contentStore) Writer(ctx context.Context, opts ...content.WriterOpt) (
 	if wOpts.Ref == "" {
 		return nil, fmt.Errorf("ref must not be empty: %w", errdefs.ErrInvalidArgument)
 	}
+	if wOpts.Desc.Digest != "" {
+		if err := wOpts.Desc.Digest.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid descriptor digest %v: %v: %w", wOpts.Desc.Digest, err, errdefs.ErrInvalidArgument)
+		}
+	}
 
 	cs.l.RLock()
 	defer cs.l.RUnlock()
@@ -401,8 +399,7 @@ func (cs *contentStore) Writer(ctx context.Context, opts ...content.WriterOpt) (
 		} else {
 			// Do not use the passed in expected value here since it was
 			// already checked against the user metadata. The content must
-			// be committed in the namespace before it will be seen as
-			// available in the current namespace.
+			// be committed before it will be seen as available.
 			desc := wOpts.Desc
 			desc.Digest = ""
 			w, err = cs.Store.Writer(ctx, content.WithRef(bref), content.WithDescriptor(desc))
@@ -558,7 +555,7 @@ func (nw *namespacedWriter) commit(ctx context.Context, tx *bolt.Tx, size int64,
 		return "", err
 	}
 
-	var actual digest.Digest
+	var actual, canonical digest.Digest
 	if nw.w == nil {
 		if size != 0 && size != nw.desc.Size {
 			return "", fmt.Errorf("%q failed size validation: %v != %v: %w", nw.ref, nw.desc.Size, size, errdefs.ErrFailedPrecondition)
@@ -580,10 +577,36 @@ func (nw *namespacedWriter) commit(ctx context.Context, tx *bolt.Tx, size int64,
 		}
 		size = status.Offset
 
-		if err := nw.w.Commit(ctx, size, expected); err != nil && !errdefs.IsAlreadyExists(err) {
-			return "", err
+		// The backend only stores content by the canonical digest, content
+		// using another algorithm is stored by its canonical digest and
+		// referenced from the metadata
+		alg := digest.Canonical
+		if expected != "" {
+			alg = expected.Algorithm()
+		} else if nw.desc.Digest != "" {
+			alg = nw.desc.Digest.Algorithm()
+		}
+		if alg == digest.Canonical {
+			if err := nw.w.Commit(ctx, size, expected); err != nil && !errdefs.IsAlreadyExists(err) {
+				return "", err
+			}
+			actual = nw.w.Digest()
+		} else {
+			if !alg.Available() {
+				nw.w.Close()
+				return "", fmt.Errorf("unsupported digest algorithm %q: %w", alg, errdefs.ErrInvalidArgument)
+			}
+			if err := nw.w.Commit(ctx, size, ""); err != nil && !errdefs.IsAlreadyExists(err) {
+				return "", err
+			}
+			canonical = nw.w.Digest()
+			if actual, err = digestContent(ctx, nw.provider, canonical, size, alg); err != nil {
+				return "", err
+			}
+			if expected != "" && expected != actual {
+				return "", fmt.Errorf("unexpected commit digest %s, expected %s: %w", actual, expected, errdefs.ErrFailedPrecondition)
+			}
 		}
-		actual = nw.w.Digest()
 	}
 
 	bkt, err := createBlobBucket(tx, actual)
@@ -607,9 +630,25 @@ func (nw *namespacedWriter) commit(ctx context.Context, tx *bolt.Tx, size int64,
 	if err := boltutil.WriteLabels(bkt, base.Labels); err != nil {
 		return "", err
 	}
+	if canonical != "" {
+		if err := bkt.Put(bucketKeyCanonical, []byte(canonical)); err != nil {
+			return "", err
+		}
+	}
 	return actual, bkt.Put(bucketKeySize, sizeEncoded)
 }
 
+// digestContent digests the stored content using the algorithm
+func digestContent(ctx context.Context, provider content.Provider, dgst digest.Digest, size int64, alg digest.Algorithm) (digest.Digest, error) {
+	ra, err := provider.ReaderAt(ctx, ocispec.Descriptor{Digest: dgst, Size: size})
+	if err != nil {
+		return "", err
+	}
+	defer ra.Close()
+
+	return alg.FromReader(content.NewReader(ra))
+}
+
 func (nw *namespacedWriter) Status() (st content.Status, err error) {
 	if nw.w != nil {
 		st, err = nw.w.Status()
@@ -627,20 +666,28 @@ func (nw *namespacedWriter) Status() (st content.Status, err error) {
 }
 
 func (cs *contentStore) ReaderAt(ctx context.Context, desc ocispec.Descriptor) (content.ReaderAt, error) {
-	if err := cs.checkAccess(ctx, desc.Digest); err != nil {
+	canonical, err := cs.checkAccess(ctx, desc.Digest)
+	if err != nil {
 		return nil, err
 	}
+	if canonical != "" {
+		desc.Digest = canonical
+	}
 	return cs.Store.ReaderAt(ctx, desc)
 }
 
-func (cs *contentStore) checkAccess(ctx context.Context, dgst digest.Digest) error {
-	return view(ctx, cs.db, func(tx *bolt.Tx) error {
+// checkAccess checks the content exists and returns the canonical digest
+// used to store the content when the digest uses another algorithm
+func (cs *contentStore) checkAccess(ctx context.Context, dgst digest.Digest) (canonical digest.Digest, err error) {
+	err = view(ctx, cs.db, func(tx *bolt.Tx) error {
 		bkt := getBlobBucket(tx, dgst)
 		if bkt == nil {
 			return fmt.Errorf("content digest %v: %w", dgst, errdefs.ErrNotFound)
 		}
+		canonical = digest.Digest(bkt.Get(bucketKeyCanonical))
 		return nil
 	})
+	return
 }
 
 func validateInfo(info *content.Info) error {
@@ -709,8 +756,9 @@ func writeExpireAt(expire time.Time, bkt *bolt.Bucket) error {
 	return bkt.Put(bucketKeyExpireAt, expireAt)
 }
 
-// garbageCollect removes all contents that are no longer used.
-func (cs *contentStore) garbageCollect(ctx context.Context) (d time.Duration, err error) {
+// garbageCollect removes the given blobs and the ingests which are no longer
+// referenced by the metadata, all blobs are walked when blobs is nil.
+func (cs *contentStore) garbageCollect(ctx context.Context, blobs map[digest.Digest]struct{}) (d time.Duration, err error) {
 	cs.l.Lock()
 	t1 := time.Now()
 	defer func() {
@@ -737,6 +785,9 @@ func (cs *contentStore) garbageCollect(ctx context.Context) (d time.Duration, er
 			if err := bbkt.ForEach(func(ck, cv []byte) error {
 				if cv == nil {
 					contentSeen[string(ck)] = struct{}{}
+					if canonical := bbkt.Bucket(ck).Get(bucketKeyCanonical); len(canonical) > 0 {
+						contentSeen[string(canonical)] = struct{}{}
+					}
 				}
 				return nil
 			}); err != nil {
@@ -749,9 +800,8 @@ func (cs *contentStore) garbageCollect(ctx context.Context) (d time.Duration, er
 			if err := ibkt.ForEach(func(ref, v []byte) error {
 				if v == nil {
 					bkt := ibkt.Bucket(ref)
-					// expected here may be from a different namespace
-					// so much be explicitly retained from the ingest
-					// in case it was removed from the other namespace
+					// expected must be explicitly retained from the
+					// ingest in case its content was removed
 					expected := bkt.Get(bucketKeyExpected)
 					if len(expected) > 0 {
 						contentSeen[string(expected)] = struct{}{}
@@ -772,17 +822,33 @@ func (cs *contentStore) garbageCollect(ctx context.Context) (d time.Duration, er
 		return 0, err
 	}
 
-	err = cs.Store.Walk(ctx, func(info content.Info) error {
-		if _, ok := contentSeen[info.Digest.String()]; !ok {
-			if err := cs.Store.Delete(ctx, info.Digest); err != nil {
-				return err
+	if blobs == nil {
+		err = cs.Store.Walk(ctx, func(info content.Info) error {
+			if _, ok := contentSeen[info.Digest.String()]; !ok {
+				if err := cs.Store.Delete(ctx, info.Digest); err != nil {
+					return err
+				}
+				log.G(ctx).WithField("digest", info.Digest).Debug("removed content")
 			}
-			log.G(ctx).WithField("digest", info.Digest).Debug("removed content")
+			return nil
+		})
+		if err != nil {
+			return
+		}
+	} else {
+		for dgst := range blobs {
+			if _, ok := contentSeen[dgst.String()]; ok {
+				continue
+			}
+			if err = cs.Store.Delete(ctx, dgst); err != nil {
+				if !errdefs.IsNotFound(err) {
+					return
+				}
+				err = nil
+				continue
+			}
+			log.G(ctx).WithField("digest", dgst).Debug("removed content")
 		}
-		return nil
-	})
-	if err != nil {
-		return
 	}
 
 	// If the content store has implemented a more efficient walk function
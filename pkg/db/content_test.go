@@ -97,6 +97,65 @@ func TestContentLeased(t *testing.T) {
 	}
 }
 
+func TestContentSHA512(t *testing.T) {
+	ctx, db := testDB(t)
+
+	cs := db.ContentStore()
+
+	blob := []byte("sha512 content")
+	expected := digest.SHA512.FromBytes(blob)
+	desc := ocispec.Descriptor{Size: int64(len(blob)), Digest: expected}
+
+	lctx, remove, err := createLease(ctx, db, "lease-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := content.WriteBlob(lctx, cs, "test-1", bytes.NewReader(blob), desc); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkContentLeased(lctx, db, expected); err != nil {
+		t.Fatal("lease checked failed:", err)
+	}
+
+	info, err := cs.Info(ctx, expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Digest != expected || info.Size != desc.Size {
+		t.Fatalf("unexpected info %v", info)
+	}
+	if _, err := cs.Info(ctx, digest.FromBytes(blob)); !errdefs.IsNotFound(err) {
+		t.Fatalf("content should only be available by sha512 digest, got %v", err)
+	}
+
+	// Stored content must survive garbage collection while leased
+	if _, err := db.GarbageCollect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	b, err := content.ReadBlob(ctx, cs, desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, blob) {
+		t.Fatalf("unexpected content %q", b)
+	}
+
+	bad := digest.SHA512.FromString("other content")
+	if err := content.WriteBlob(lctx, cs, "test-2", bytes.NewReader(blob), ocispec.Descriptor{Size: int64(len(blob)), Digest: bad}); !errdefs.IsFailedPrecondition(err) {
+		t.Fatalf("expected failed precondition for mismatched digest, got %v", err)
+	}
+
+	if err := remove(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GarbageCollect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.Info(ctx, expected); !errdefs.IsNotFound(err) {
+		t.Fatalf("expected content to be collected, got %v", err)
+	}
+}
+
 func TestIngestLeased(t *testing.T) {
 	ctx, db := testDB(t)
 
@@ -19,8 +19,13 @@ package db
 import (
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -29,6 +34,8 @@ import (
 	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/gc"
 	"github.com/containerd/containerd/log"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/opencontainers/go-digest"
 	"go.etcd.io/bbolt"
 	bolt "go.etcd.io/bbolt"
 )
@@ -53,21 +60,99 @@ type DBOpt func(*dbOptions)
 
 // dbOptions configure db options.
 type dbOptions struct {
-	boltOptions bbolt.Options
+	boltOptions   bbolt.Options
+	dirMode       os.FileMode
+	minContentAge time.Duration
+	imageLogSize  int
+	gcConcurrency int
+	snapshotters  map[string]snapshots.Snapshotter
+
+	consistencyCheck bool
+	pruneIngests     bool
+	noCollectOnClose bool
 }
 
 func WithReadOnly(dbo *dbOptions) {
 	dbo.boltOptions.ReadOnly = true
 }
 
+// WithNoSync skips syncing the metadata database to disk after every
+// write, speeding up bulk writes at the risk of losing recent writes on
+// power loss or a system crash. Call Sync to make prior writes durable.
+func WithNoSync(dbo *dbOptions) {
+	dbo.boltOptions.NoSync = true
+}
+
+// WithNoCollectOnClose closes the database without garbage collecting, such
+// as when the caller has already run a collection and reported its result.
+func WithNoCollectOnClose(dbo *dbOptions) {
+	dbo.noCollectOnClose = true
+}
+
+// WithTimeout sets how long opening the database waits for the file lock
+// held by another process before failing with an unavailable error. Only
+// read-only opens may hold the lock at the same time, a writer holds the
+// lock exclusively for as long as its database is open so writers always
+// serialize. Zero waits indefinitely.
+func WithTimeout(d time.Duration) DBOpt {
+	return func(dbo *dbOptions) {
+		dbo.boltOptions.Timeout = d
+	}
+}
+
+// WithDirMode sets the mode used when creating the root directory and
+// content directory. The metadata database file is created with the same
+// mode without the execute bits. The mode is applied regardless of the
+// process umask, existing directories are left unchanged.
+func WithDirMode(mode os.FileMode) DBOpt {
+	return func(dbo *dbOptions) {
+		dbo.dirMode = mode.Perm()
+	}
+}
+
+// WithMinContentAge prevents garbage collection from removing content
+// created within the given duration, even when nothing references it. This
+// keeps content written ahead of the manifest or image which will reference
+// it, such as an intermediate blob during a multi-step build.
+func WithMinContentAge(d time.Duration) DBOpt {
+	return func(dbo *dbOptions) {
+		dbo.minContentAge = d
+	}
+}
+
+// WithGCConcurrency sets how many resources have their references resolved
+// at once during the mark phase of garbage collection, which may shorten
+// collection of large stores. Values less than 2 mark serially and values
+// greater than GOMAXPROCS are limited to GOMAXPROCS.
+func WithGCConcurrency(n int) DBOpt {
+	return func(dbo *dbOptions) {
+		dbo.gcConcurrency = n
+	}
+}
+
+// WithSnapshotter registers a snapshotter under the given name. Snapshots
+// created through the snapshotter returned by DB.Snapshotter are recorded
+// in the metadata database and the backend snapshots which are no longer
+// referenced are removed during garbage collection.
+func WithSnapshotter(name string, sn snapshots.Snapshotter) DBOpt {
+	return func(dbo *dbOptions) {
+		if dbo.snapshotters == nil {
+			dbo.snapshotters = map[string]snapshots.Snapshotter{}
+		}
+		dbo.snapshotters[name] = sn
+	}
+}
+
 // DB represents a metadata database backed by a bolt
-// database. The database is fully namespaced and stores
-// image, container, namespace, snapshot, and content data
-// while proxying data shared across namespaces to backend
-// datastores for content and snapshots.
+// database. The database stores image, container, lease,
+// snapshot, and content data in a single store without
+// namespaces while proxying data to backend datastores for
+// content and snapshots.
 type DB struct {
-	db *bolt.DB
-	cs *contentStore
+	db   *bolt.DB
+	ss   map[string]*snapshotter
+	cs   *contentStore
+	root string
 
 	// wlock is used to protect access to the data structures during garbage
 	// collection. While the wlock is held no writable transactions can be
@@ -81,12 +166,21 @@ type DB struct {
 	// atomically if outside of wlock.Lock.
 	dirty uint32
 
-	// dirtyCS flags keeps track of datastores which have had
+	// dirtySS and dirtyCS flags keeps track of datastores which have had
 	// deletions since the last garbage collection. These datastores will
 	// be garbage collected during the next garbage collection. These
 	// should only be updated inside of a write transaction or wlock.Lock.
+	dirtySS map[string]struct{}
 	dirtyCS bool
 
+	// dirtyBlobs holds the digests of the content removed since the last
+	// garbage collection so only their blobs are checked during content
+	// cleanup. When dirtyWalk is set the removed content is not known, such
+	// as after a process exited before cleaning up, and all blobs are
+	// walked. These follow the same rules as dirtyCS.
+	dirtyBlobs map[digest.Digest]struct{}
+	dirtyWalk  bool
+
 	// collectible resources
 	collectors map[gc.ResourceType]Collector
 
@@ -96,34 +190,125 @@ type DB struct {
 // NewDB creates a new metadata database using the provided
 // bolt database, content store, and snapshotters.
 func NewDB(root string, opts ...DBOpt) (*DB, error) {
-	var dbo dbOptions
+	dbo := dbOptions{
+		imageLogSize: DefaultImageLogSize,
+	}
 	for _, opt := range opts {
 		opt(&dbo)
 	}
 
-	metadb := filepath.Join(root, "meta.db")
-	bdb, err := bbolt.Open(metadb, 0600, &dbo.boltOptions)
+	var (
+		metadb      = filepath.Join(root, "meta.db")
+		contentpath = filepath.Join(root, "content")
+		fileMode    os.FileMode
+	)
+	if dbo.dirMode != 0 && !dbo.boltOptions.ReadOnly {
+		for _, dir := range []string{root, contentpath, filepath.Join(contentpath, "ingest")} {
+			if err := mkdirMode(dir, dbo.dirMode); err != nil {
+				return nil, err
+			}
+		}
+		fileMode = dbo.dirMode &^ 0111
+	}
+
+	bdb, err := openBolt(metadb, fileMode, &dbo.boltOptions)
 	if err != nil {
+		if errors.Is(err, bolt.ErrTimeout) {
+			return nil, busyError(metadb)
+		}
 		return nil, err
 	}
 
-	contentpath := filepath.Join(root, "content")
 	cs, err := localcontent.NewStore(contentpath)
 	if err != nil {
 		return nil, err
 	}
 
 	m := &DB{
-		db:     bdb,
-		dbopts: dbo,
+		db:         bdb,
+		ss:         make(map[string]*snapshotter, len(dbo.snapshotters)),
+		root:       root,
+		dirtySS:    map[string]struct{}{},
+		dirtyBlobs: map[digest.Digest]struct{}{},
+		dbopts:     dbo,
 	}
 
+	// A cleanup mark left by a previous writer means blobs of removed
+	// content may remain without knowing which
+	if !dbo.boltOptions.ReadOnly {
+		if err := bdb.View(func(tx *bolt.Tx) error {
+			if v1bkt := tx.Bucket(bucketKeyVersion); v1bkt != nil && v1bkt.Get(bucketKeyContentCleanup) != nil {
+				m.dirtyCS = true
+				m.dirtyWalk = true
+			}
+			return nil
+		}); err != nil {
+			bdb.Close()
+			return nil, err
+		}
+	}
+
+	// Initialize data stores
 	m.cs = newContentStore(m, cs)
+	for name, sn := range dbo.snapshotters {
+		m.ss[name] = newSnapshotter(m, name, sn)
+	}
+
+	if dbo.consistencyCheck {
+		m.checkOnOpen(context.Background())
+	}
 
 	return m, nil
 }
 
+// busyError returns the error for a database locked by another process
+func busyError(metadb string) error {
+	return fmt.Errorf("database busy, %s is locked by another process: %w", metadb, errdefs.ErrUnavailable)
+}
+
+// mkdirMode creates the directory with exactly the provided mode if it
+// does not already exist
+func mkdirMode(dir string, mode os.FileMode) error {
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.MkdirAll(dir, mode); err != nil {
+		return err
+	}
+	// Chmod after creation since the mode given to mkdir is masked by umask
+	return os.Chmod(dir, mode)
+}
+
+// openBolt opens the bolt database, when a mode is provided a newly created
+// database file is given exactly that mode
+func openBolt(path string, mode os.FileMode, options *bbolt.Options) (*bolt.DB, error) {
+	if mode == 0 {
+		return bbolt.Open(path, 0600, options)
+	}
+	_, err := os.Stat(path)
+	created := os.IsNotExist(err)
+	bdb, err := bbolt.Open(path, mode, options)
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		if err := os.Chmod(path, mode); err != nil {
+			bdb.Close()
+			return nil, err
+		}
+	}
+	return bdb, nil
+}
+
+// Close garbage collects the database before closing it, a read-only
+// database or one opened with WithNoCollectOnClose is closed without
+// collecting
 func (m *DB) Close(ctx context.Context) error {
+	if m.dbopts.boltOptions.ReadOnly || m.dbopts.noCollectOnClose {
+		return m.db.Close()
+	}
 	_, gcerr := m.GarbageCollect(ctx)
 	cerr := m.db.Close()
 	if gcerr != nil {
@@ -132,8 +317,54 @@ func (m *DB) Close(ctx context.Context) error {
 	return cerr
 }
 
-// ContentStore returns a namespaced content store
-// proxied to a content store.
+// Sync flushes the metadata database and the content store to disk, making
+// all completed writes durable. This allows a batch of writes made with
+// WithNoSync to be made durable at once.
+func (m *DB) Sync(ctx context.Context) error {
+	if err := m.db.Sync(); err != nil {
+		return fmt.Errorf("failed to sync metadata database: %w", err)
+	}
+
+	// Blob data is synced on commit but the directory entries of the
+	// committed blobs are not
+	blobs := filepath.Join(m.root, "content", "blobs")
+	dirs := []string{filepath.Join(m.root, "content"), blobs}
+	entries, err := os.ReadDir(blobs)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			dirs = append(dirs, filepath.Join(blobs, e.Name()))
+		}
+	}
+	for _, dir := range dirs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := syncDir(dir); err != nil {
+			return fmt.Errorf("failed to sync content store: %w", err)
+		}
+	}
+	return nil
+}
+
+// syncDir syncs the entries of the directory to disk, ignoring a missing
+// directory
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// ContentStore returns a content store proxied to the
+// backend content store.
 func (m *DB) ContentStore() content.Store {
 	if m.cs == nil {
 		return nil
@@ -141,7 +372,19 @@ func (m *DB) ContentStore() content.Store {
 	return m.cs
 }
 
-// View runs a readonly transaction on the metadata store.
+// Snapshotter returns the snapshotter registered with the given name which
+// records its snapshots in the metadata database, nil if no snapshotter was
+// registered with the name.
+func (m *DB) Snapshotter(name string) snapshots.Snapshotter {
+	sn, ok := m.ss[name]
+	if !ok {
+		return nil
+	}
+	return sn
+}
+
+// View runs a readonly transaction on the metadata store. Read transactions
+// do not take the wlock and may run concurrently with garbage collection.
 func (m *DB) View(fn func(*bolt.Tx) error) error {
 	return m.db.View(fn)
 }
@@ -198,6 +441,10 @@ type GCStats struct {
 	MetaD     time.Duration
 	ContentD  time.Duration
 	SnapshotD map[string]time.Duration
+
+	// ContentErr is the error removing the collected content from disk,
+	// the metadata is still collected when the removal fails
+	ContentErr error
 }
 
 // Elapsed returns the duration which elapsed during a collection
@@ -210,6 +457,10 @@ func (m *DB) GarbageCollect(ctx context.Context) (gc.Stats, error) {
 	m.wlock.Lock()
 	t1 := time.Now()
 	c := startGCContext(ctx, m.collectors)
+	c.contentCreatedAfter = m.minContentCreated(t1)
+	// Marking may only read from multiple transactions while the wlock
+	// prevents writes between them
+	c.concurrency = m.gcConcurrency()
 
 	marked, err := m.getMarked(ctx, c) // Pass in gc context
 	if err != nil {
@@ -227,10 +478,14 @@ func (m *DB) GarbageCollect(ctx context.Context) (gc.Stats, error) {
 			}
 
 			if n.Type == ResourceSnapshot {
-				//if idx := strings.IndexRune(n.Key, '/'); idx > 0 {
-				//	m.dirtySS[n.Key[:idx]] = struct{}{}
-				//}
-			} else if n.Type == ResourceContent || n.Type == ResourceIngest {
+				if idx := strings.IndexRune(n.Key, '/'); idx > 0 {
+					m.dirtySS[n.Key[:idx]] = struct{}{}
+				}
+			} else if n.Type == ResourceContent {
+				if err := m.markContentRemoved(tx, digest.Digest(n.Key)); err != nil {
+					return err
+				}
+			} else if n.Type == ResourceIngest {
 				m.dirtyCS = true
 			}
 			return c.remove(ctx, tx, n) // From gc context
@@ -253,38 +508,43 @@ func (m *DB) GarbageCollect(ctx context.Context) (gc.Stats, error) {
 	// reset dirty, no need for atomic inside of wlock.Lock
 	m.dirty = 0
 
-	/*
-		if len(m.dirtySS) > 0 {
-			var sl sync.Mutex
-			stats.SnapshotD = map[string]time.Duration{}
-			wg.Add(len(m.dirtySS))
-			for snapshotterName := range m.dirtySS {
-				log.G(ctx).WithField("snapshotter", snapshotterName).Debug("schedule snapshotter cleanup")
-				go func(snapshotterName string) {
-					st1 := time.Now()
-					m.cleanupSnapshotter(snapshotterName)
-
-					sl.Lock()
-					stats.SnapshotD[snapshotterName] = time.Since(st1)
-					sl.Unlock()
-
-					wg.Done()
-				}(snapshotterName)
-			}
-			m.dirtySS = map[string]struct{}{}
+	if len(m.dirtySS) > 0 {
+		var sl sync.Mutex
+		stats.SnapshotD = map[string]time.Duration{}
+		wg.Add(len(m.dirtySS))
+		for snapshotterName := range m.dirtySS {
+			log.G(ctx).WithField("snapshotter", snapshotterName).Debug("schedule snapshotter cleanup")
+			go func(snapshotterName string) {
+				st1 := time.Now()
+				m.cleanupSnapshotter(snapshotterName)
+
+				sl.Lock()
+				stats.SnapshotD[snapshotterName] = time.Since(st1)
+				sl.Unlock()
+
+				wg.Done()
+			}(snapshotterName)
 		}
-	*/
+		m.dirtySS = map[string]struct{}{}
+	}
 
 	if m.dirtyCS {
+		// A nil set of blobs walks all blobs
+		var blobs map[digest.Digest]struct{}
+		if !m.dirtyWalk {
+			blobs = m.dirtyBlobs
+		}
 		wg.Add(1)
-		log.G(ctx).Debug("schedule content cleanup")
+		log.G(ctx).WithField("blobs", len(blobs)).WithField("walk", m.dirtyWalk).Debug("schedule content cleanup")
 		go func() {
 			ct1 := time.Now()
-			m.cleanupContent()
+			_, stats.ContentErr = m.cleanupContent(blobs)
 			stats.ContentD = time.Since(ct1)
 			wg.Done()
 		}()
 		m.dirtyCS = false
+		m.dirtyWalk = false
+		m.dirtyBlobs = map[digest.Digest]struct{}{}
 	}
 
 	stats.MetaD = time.Since(t1)
@@ -297,9 +557,69 @@ func (m *DB) GarbageCollect(ctx context.Context) (gc.Stats, error) {
 	return stats, err
 }
 
+// GarbageCollectDryRun returns the resources which a garbage collection would
+// remove without removing them. The wlock is held while marking and scanning
+// the same as a collection so no writes occur between the two, the dirty
+// flags are left unchanged so the next collection is not affected.
+func (m *DB) GarbageCollectDryRun(ctx context.Context) ([]gc.Node, error) {
+	m.wlock.Lock()
+	defer m.wlock.Unlock()
+
+	c := startGCContext(ctx, m.collectors)
+	defer c.cancel(ctx)
+	c.contentCreatedAfter = m.minContentCreated(time.Now())
+	c.concurrency = m.gcConcurrency()
+
+	marked, err := m.getMarked(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []gc.Node
+	if err := m.db.View(func(tx *bolt.Tx) error {
+		return c.scanAll(ctx, tx, func(ctx context.Context, n gc.Node) error {
+			if _, ok := marked[n]; !ok {
+				nodes = append(nodes, n)
+			}
+			return nil
+		})
+	}); err != nil {
+		return nil, fmt.Errorf("failed to scan: %w", err)
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Type != nodes[j].Type {
+			return nodes[i].Type < nodes[j].Type
+		}
+		return nodes[i].Key < nodes[j].Key
+	})
+	return nodes, nil
+}
+
+// minContentCreated returns the creation time after which content is retained
+// regardless of references, zero when no minimum age is configured
+func (m *DB) minContentCreated(now time.Time) time.Time {
+	if m.dbopts.minContentAge <= 0 {
+		return time.Time{}
+	}
+	return now.Add(-m.dbopts.minContentAge)
+}
+
+// gcConcurrency returns the number of resources which may have their
+// references resolved at once, bounded by GOMAXPROCS
+func (m *DB) gcConcurrency() int {
+	if n := runtime.GOMAXPROCS(0); m.dbopts.gcConcurrency > n {
+		return n
+	}
+	return m.dbopts.gcConcurrency
+}
+
 // getMarked returns all resources that are used.
 func (m *DB) getMarked(ctx context.Context, c *gcContext) (map[gc.Node]struct{}, error) {
-	var marked map[gc.Node]struct{}
+	var (
+		marked  map[gc.Node]struct{}
+		scanned []gc.Node
+	)
 	if err := m.db.View(func(tx *bolt.Tx) error {
 		ctx, cancel := context.WithCancel(ctx)
 		defer cancel()
@@ -324,6 +644,11 @@ func (m *DB) getMarked(ctx context.Context, c *gcContext) (map[gc.Node]struct{},
 		close(roots)
 		wg.Wait()
 
+		if c.concurrency > 1 {
+			scanned = nodes
+			return nil
+		}
+
 		refs := func(n gc.Node) ([]gc.Node, error) {
 			var sn []gc.Node
 			if err := c.references(ctx, tx, n, func(nn gc.Node) { // From gc context
@@ -343,10 +668,87 @@ func (m *DB) getMarked(ctx context.Context, c *gcContext) (map[gc.Node]struct{},
 	}); err != nil {
 		return nil, err
 	}
+	if c.concurrency > 1 {
+		return m.markConcurrent(ctx, c, scanned)
+	}
+	return marked, nil
+}
+
+// markConcurrent returns the resources reachable from the roots, resolving
+// the references of up to c.concurrency resources at once. Bolt transactions
+// must not be shared between goroutines so each worker reads from its own
+// transaction, the caller must hold the wlock so all read the same data.
+func (m *DB) markConcurrent(ctx context.Context, c *gcContext, roots []gc.Node) (map[gc.Node]struct{}, error) {
+	txs := make([]*bolt.Tx, c.concurrency)
+	defer func() {
+		for _, tx := range txs {
+			if tx != nil {
+				tx.Rollback()
+			}
+		}
+	}()
+	for i := range txs {
+		tx, err := m.db.Begin(false)
+		if err != nil {
+			return nil, err
+		}
+		txs[i] = tx
+	}
+
+	marked := make(map[gc.Node]struct{}, len(roots))
+	var frontier []gc.Node
+	for _, n := range roots {
+		if _, ok := marked[n]; !ok {
+			marked[n] = struct{}{}
+			frontier = append(frontier, n)
+		}
+	}
+	// Each pass resolves the references of the resources first marked by
+	// the previous pass, the marked set does not depend on the order
+	for len(frontier) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var (
+			refs = make([][]gc.Node, len(frontier))
+			errs = make([]error, len(txs))
+			wg   sync.WaitGroup
+		)
+		for w := range txs {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := w; i < len(frontier); i += len(txs) {
+					if err := c.references(ctx, txs[w], frontier[i], func(n gc.Node) {
+						refs[i] = append(refs[i], n)
+					}); err != nil {
+						errs[w] = err
+						return
+					}
+				}
+			}(w)
+		}
+		wg.Wait()
+		for _, err := range errs {
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		var next []gc.Node
+		for _, nodes := range refs {
+			for _, n := range nodes {
+				if _, ok := marked[n]; !ok {
+					marked[n] = struct{}{}
+					next = append(next, n)
+				}
+			}
+		}
+		frontier = next
+	}
 	return marked, nil
 }
 
-/*
 func (m *DB) cleanupSnapshotter(name string) (time.Duration, error) {
 	ctx := context.Background()
 	sn, ok := m.ss[name]
@@ -363,20 +765,52 @@ func (m *DB) cleanupSnapshotter(name string) (time.Duration, error) {
 	}
 	return d, err
 }
-*/
 
-func (m *DB) cleanupContent() (time.Duration, error) {
+// cleanupContent removes the blobs and ingests no longer referenced by the
+// metadata. Only the given blobs are checked, all blobs are walked when nil.
+func (m *DB) cleanupContent(blobs map[digest.Digest]struct{}) (time.Duration, error) {
 	ctx := context.Background()
 	if m.cs == nil {
 		return 0, nil
 	}
 
-	d, err := m.cs.garbageCollect(ctx)
+	d, err := m.cs.garbageCollect(ctx, blobs)
 	if err != nil {
 		log.G(ctx).WithError(err).Warn("content garbage collection failed")
-	} else {
-		log.G(ctx).WithField("d", d).Debugf("content garbage collected")
+		return d, err
 	}
+	log.G(ctx).WithField("d", d).Debugf("content garbage collected")
 
-	return d, err
+	// Clear the cleanup mark unless more content was removed since
+	if err := m.Update(func(tx *bolt.Tx) error {
+		if m.dirtyCS {
+			return nil
+		}
+		if v1bkt := tx.Bucket(bucketKeyVersion); v1bkt != nil && v1bkt.Get(bucketKeyContentCleanup) != nil {
+			return v1bkt.Delete(bucketKeyContentCleanup)
+		}
+		return nil
+	}); err != nil {
+		return d, fmt.Errorf("failed to clear content cleanup mark: %w", err)
+	}
+	return d, nil
+}
+
+// markContentRemoved records that the content is being removed in the write
+// transaction so the next content cleanup checks its blob. The cleanup mark
+// is stored with the removal so blobs left by a process exiting before the
+// cleanup are found by walking all blobs on the next open.
+func (m *DB) markContentRemoved(tx *bolt.Tx, dgst digest.Digest) error {
+	m.dirtyCS = true
+	m.dirtyBlobs[dgst] = struct{}{}
+	if bkt := getBlobBucket(tx, dgst); bkt != nil {
+		if canonical := bkt.Get(bucketKeyCanonical); len(canonical) > 0 {
+			m.dirtyBlobs[digest.Digest(canonical)] = struct{}{}
+		}
+	}
+	v1bkt := tx.Bucket(bucketKeyVersion)
+	if v1bkt == nil {
+		return nil
+	}
+	return v1bkt.Put(bucketKeyContentCleanup, []byte{})
 }
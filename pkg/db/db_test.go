@@ -17,15 +17,23 @@
 package db
 
 import (
+	"bytes"
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/containerd/containerd/content"
 	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/gc"
+	"github.com/containerd/containerd/images"
 	"github.com/containerd/containerd/leases"
 	"github.com/containerd/containerd/log/logtest"
 	"github.com/containerd/containerd/namespaces"
@@ -35,10 +43,17 @@ import (
 )
 
 type testOptions struct {
+	dbOpts []DBOpt
 }
 
 type testOpt func(*testOptions)
 
+func withDBOpts(opts ...DBOpt) testOpt {
+	return func(topts *testOptions) {
+		topts.dbOpts = append(topts.dbOpts, opts...)
+	}
+}
+
 func testDB(t *testing.T, opt ...testOpt) (context.Context, *DB) {
 	ctx, cancel := context.WithCancel(context.Background())
 	ctx = namespaces.WithNamespace(ctx, "testing")
@@ -52,7 +67,7 @@ func testDB(t *testing.T, opt ...testOpt) (context.Context, *DB) {
 
 	dirname := t.TempDir()
 
-	db, err := NewDB(dirname)
+	db, err := NewDB(dirname, topts.dbOpts...)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -83,6 +98,135 @@ func TestInit(t *testing.T) {
 	}
 }
 
+func TestSync(t *testing.T) {
+	ctx, db := testDB(t, withDBOpts(WithNoSync))
+	if !db.db.NoSync {
+		t.Fatal("expected database to be opened without sync")
+	}
+
+	if err := db.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		blob := []byte(fmt.Sprintf("blob %d", i))
+		desc := ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageLayer,
+			Digest:    digest.FromBytes(blob),
+			Size:      int64(len(blob)),
+		}
+		if err := content.WriteBlob(ctx, db.ContentStore(), fmt.Sprintf("sync-%d", i), bytes.NewReader(blob), desc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNoCollectOnClose(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		root = filepath.Join(t.TempDir(), "data")
+		blob = []byte("unreferenced")
+		desc = ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageLayer,
+			Digest:    digest.FromBytes(blob),
+			Size:      int64(len(blob)),
+		}
+	)
+
+	db, err := NewDB(root, WithDirMode(0700), WithNoCollectOnClose)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := content.WriteBlob(ctx, db.ContentStore(), "unreferenced", bytes.NewReader(blob), desc); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, collect := range []bool{false, true} {
+		if collect {
+			// Closing without the option collects the unreferenced blob
+			if db, err = NewDB(root); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Close(ctx); err != nil {
+				t.Fatal(err)
+			}
+		}
+		reader, err := NewDB(root, WithReadOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = reader.ContentStore().Info(ctx, desc.Digest)
+		reader.Close(ctx)
+		if collect && !errdefs.IsNotFound(err) {
+			t.Fatalf("expected blob to be collected, got %v", err)
+		} else if !collect && err != nil {
+			t.Fatalf("expected blob to remain after close without collection: %v", err)
+		}
+	}
+}
+
+func TestDirMode(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "data")
+
+	db, err := NewDB(root, WithDirMode(0750))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, expected := range map[string]os.FileMode{
+		root:                                     0750,
+		filepath.Join(root, "content"):           0750,
+		filepath.Join(root, "content", "ingest"): 0750,
+		filepath.Join(root, "meta.db"):           0640,
+	} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := fi.Mode().Perm(); mode != expected {
+			t.Errorf("unexpected mode for %s: %v, expected %v", path, mode, expected)
+		}
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	root := t.TempDir()
+
+	writer, err := NewDB(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close(context.Background())
+
+	start := time.Now()
+	if _, err := NewDB(root, WithReadOnly, WithTimeout(50*time.Millisecond)); !errdefs.IsUnavailable(err) {
+		t.Fatalf("expected unavailable error opening a locked database, got %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("open took %v, expected to fail after the timeout", d)
+	}
+
+	if err := writer.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := NewDB(root, WithReadOnly, WithTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reader.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
 /*
 func TestMigrations(t *testing.T) {
 	testRefs := []struct {
@@ -462,3 +606,387 @@ func testEnv(t *testing.T) (context.Context, *DB) {
 
 	return ctx, db
 }
+
+func TestGCConcurrentReads(t *testing.T) {
+	ctx, db := testDB(t)
+	cs := db.ContentStore()
+	is := NewImageStore(db)
+
+	lctx, remove, err := createLease(ctx, db, "concurrent-setup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const imageCount = 20
+	for i := 0; i < imageCount; i++ {
+		b := []byte(fmt.Sprintf(`{"image":%d}`, i))
+		desc := ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageManifest,
+			Digest:    digest.FromBytes(b),
+			Size:      int64(len(b)),
+		}
+		if err := content.WriteBlob(lctx, cs, desc.Digest.String(), bytes.NewReader(b), desc); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := is.Create(ctx, images.Image{Name: fmt.Sprintf("image-%d", i), Target: desc}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := remove(); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		stop = make(chan struct{})
+		wg   sync.WaitGroup
+		errs = make(chan error, 16)
+	)
+	running := func(fn func(i int) error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if err := fn(i); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	// Collect continuously while unreferenced content is written, giving
+	// each collection content to remove
+	running(func(int) error {
+		_, err := db.GarbageCollect(ctx)
+		return err
+	})
+	running(func(i int) error {
+		b := []byte(fmt.Sprintf("unreferenced %d", i))
+		desc := ocispec.Descriptor{Digest: digest.FromBytes(b), Size: int64(len(b))}
+		return content.WriteBlob(ctx, cs, desc.Digest.String(), bytes.NewReader(b), desc)
+	})
+
+	for r := 0; r < 8; r++ {
+		running(func(int) error {
+			imgs, _, err := db.ListImages(ctx, Page{})
+			if err != nil {
+				return err
+			}
+			if len(imgs) != imageCount {
+				return fmt.Errorf("listed %d images, expected %d", len(imgs), imageCount)
+			}
+			for _, img := range imgs {
+				if _, err := content.ReadBlob(ctx, cs, img.Target); err != nil {
+					return fmt.Errorf("failed to read %s: %w", img.Name, err)
+				}
+			}
+			if _, _, err := db.ListContent(ctx, Page{}); err != nil {
+				return err
+			}
+			return cs.Walk(ctx, func(content.Info) error { return nil })
+		})
+	}
+
+	time.Sleep(time.Second)
+	close(stop)
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("timed out waiting for reads and garbage collection, possible deadlock")
+	}
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestGCMinContentAge(t *testing.T) {
+	ctx, db := testDB(t, withDBOpts(WithMinContentAge(time.Hour)))
+	cs := db.ContentStore()
+
+	b := []byte("intermediate content")
+	desc := ocispec.Descriptor{Digest: digest.FromBytes(b), Size: int64(len(b))}
+	if err := content.WriteBlob(ctx, cs, desc.Digest.String(), bytes.NewReader(b), desc); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.GarbageCollect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.Info(ctx, desc.Digest); err != nil {
+		t.Fatalf("recently written content was not retained: %v", err)
+	}
+
+	// Content older than the minimum age is collected once unreferenced
+	db.dbopts.minContentAge = time.Nanosecond
+	if _, err := db.GarbageCollect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.Info(ctx, desc.Digest); !errdefs.IsNotFound(err) {
+		t.Fatalf("expected content to be collected, got %v", err)
+	}
+}
+
+func TestGCDryRun(t *testing.T) {
+	ctx, db := testDB(t)
+	cs := db.ContentStore()
+
+	var descs []ocispec.Descriptor
+	for i := 0; i < 2; i++ {
+		b := []byte(fmt.Sprintf("dry run %d", i))
+		desc := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayer, Digest: digest.FromBytes(b), Size: int64(len(b))}
+		if err := content.WriteBlob(ctx, cs, desc.Digest.String(), bytes.NewReader(b), desc); err != nil {
+			t.Fatal(err)
+		}
+		descs = append(descs, desc)
+	}
+	if _, err := NewImageStore(db).Create(ctx, images.Image{Name: "kept", Target: descs[0]}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewLeaseManager(db).Create(ctx, leases.WithID("expired"), leases.WithExpiration(time.Nanosecond)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+
+	dirty := db.dirty
+	nodes, err := db.GarbageCollectDryRun(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []gc.Node{
+		{Type: ResourceContent, Key: descs[1].Digest.String()},
+		{Type: ResourceLease, Key: "expired"},
+	}
+	if len(nodes) != len(expected) {
+		t.Fatalf("unexpected nodes %v, expected %v", nodes, expected)
+	}
+	for i := range nodes {
+		if nodes[i] != expected[i] {
+			t.Fatalf("unexpected nodes %v, expected %v", nodes, expected)
+		}
+	}
+
+	// Nothing is removed by the dry run
+	if _, err := cs.Info(ctx, descs[1].Digest); err != nil {
+		t.Fatalf("dry run removed content: %v", err)
+	}
+	if db.dirty != dirty || db.dirtyCS {
+		t.Fatal("dry run changed the dirty flags")
+	}
+
+	if _, err := db.GarbageCollect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if nodes, err = db.GarbageCollectDryRun(ctx); err != nil {
+		t.Fatal(err)
+	} else if len(nodes) != 0 {
+		t.Fatalf("expected nothing to collect after collection, got %v", nodes)
+	}
+}
+
+func TestGCContentErr(t *testing.T) {
+	ctx, db := testDB(t)
+	cs := db.ContentStore()
+
+	write := func(data string) {
+		t.Helper()
+		b := []byte(data)
+		desc := ocispec.Descriptor{Digest: digest.FromBytes(b), Size: int64(len(b))}
+		if err := content.WriteBlob(ctx, cs, desc.Digest.String(), bytes.NewReader(b), desc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("collected content")
+	stats, err := db.GarbageCollect(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stats.(GCStats).ContentErr; err != nil {
+		t.Fatalf("unexpected content error: %v", err)
+	}
+
+	// Failing to remove the blobs does not fail the collection
+	write("more collected content")
+	blobs := filepath.Join(db.root, "content", "blobs")
+	if err := os.RemoveAll(blobs); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(blobs, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	stats, err = db.GarbageCollect(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.(GCStats).ContentErr == nil {
+		t.Fatal("expected content error when the blobs cannot be removed")
+	}
+}
+
+func TestGCContentCleanup(t *testing.T) {
+	ctx := logtest.WithT(context.Background(), t)
+	dir := t.TempDir()
+
+	db, err := NewDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(cs content.Ingester, data string) digest.Digest {
+		t.Helper()
+		b := []byte(data)
+		desc := ocispec.Descriptor{Digest: digest.FromBytes(b), Size: int64(len(b))}
+		if err := content.WriteBlob(ctx, cs, desc.Digest.String(), bytes.NewReader(b), desc); err != nil {
+			t.Fatal(err)
+		}
+		return desc.Digest
+	}
+	exists := func(db *DB, dgst digest.Digest) bool {
+		t.Helper()
+		_, err := db.cs.Store.Info(ctx, dgst)
+		if err != nil && !errdefs.IsNotFound(err) {
+			t.Fatal(err)
+		}
+		return err == nil
+	}
+	marked := func(db *DB) (ok bool) {
+		t.Helper()
+		if err := db.View(func(tx *bolt.Tx) error {
+			ok = getBucket(tx, bucketKeyVersion).Get(bucketKeyContentCleanup) != nil
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	// Only the blobs of removed content are checked, the blob written
+	// without metadata is not found without walking all blobs
+	orphan := write(db.cs.Store, "orphaned blob")
+	collected := write(db.ContentStore(), "collected content")
+	if _, err := db.GarbageCollect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if exists(db, collected) {
+		t.Fatal("expected blob of collected content to be removed")
+	}
+	if !exists(db, orphan) {
+		t.Fatal("expected orphaned blob to only be removed by walking all blobs")
+	}
+	if marked(db) {
+		t.Fatal("expected cleanup mark to be cleared")
+	}
+
+	// Exit before the cleanup, leaving the blob of the deleted content
+	deleted := write(db.ContentStore(), "deleted content")
+	if err := db.ContentStore().Delete(ctx, deleted); err != nil {
+		t.Fatal(err)
+	}
+	if !marked(db) {
+		t.Fatal("expected cleanup mark after deleting content")
+	}
+	if err := db.db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = NewDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close(ctx)
+	if _, err := db.GarbageCollect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, dgst := range []digest.Digest{orphan, deleted} {
+		if exists(db, dgst) {
+			t.Fatalf("expected blob %s to be removed by walking all blobs", dgst)
+		}
+	}
+	if marked(db) {
+		t.Fatal("expected cleanup mark to be cleared")
+	}
+}
+
+func TestTransactionContext(t *testing.T) {
+	ctx, db := testDB(t)
+	var (
+		is = NewImageStore(db)
+		lm = NewLeaseManager(db)
+	)
+
+	create := func(name string, img images.Image, fail error) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			tctx := WithTransactionContext(ctx, tx)
+			if _, err := lm.Create(tctx, leases.WithID(name)); err != nil {
+				return err
+			}
+			img.Name = name
+			if _, err := is.Create(tctx, img); err != nil {
+				return err
+			}
+			return fail
+		})
+	}
+	check := func(name string, exists bool) {
+		t.Helper()
+		if _, err := is.Get(ctx, name); exists && err != nil {
+			t.Fatalf("expected image %s: %v", name, err)
+		} else if !exists && !errdefs.IsNotFound(err) {
+			t.Fatalf("expected image %s to not exist, got %v", name, err)
+		}
+		ls, err := lm.List(ctx, "id=="+name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists && len(ls) != 1 {
+			t.Fatalf("expected lease %s, got %v", name, ls)
+		} else if !exists && len(ls) != 0 {
+			t.Fatalf("expected lease %s to not exist, got %v", name, ls)
+		}
+	}
+	img := images.Image{
+		Target: ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageManifest,
+			Digest:    digest.FromString("target"),
+			Size:      6,
+		},
+	}
+
+	// Both are committed together
+	if err := create("committed", img, nil); err != nil {
+		t.Fatal(err)
+	}
+	check("committed", true)
+
+	// Both are rolled back when the transaction fails after the operations
+	rollback := errors.New("rollback")
+	if err := create("rolled-back", img, rollback); err != rollback {
+		t.Fatalf("expected rollback error, got %v", err)
+	}
+	check("rolled-back", false)
+
+	// The lease is rolled back when the image fails to be created
+	if err := create("invalid", images.Image{}, nil); !errdefs.IsInvalidArgument(err) {
+		t.Fatalf("expected invalid argument, got %v", err)
+	}
+	check("invalid", false)
+
+	// Store operations cannot modify using a read only transaction
+	if err := db.View(func(tx *bolt.Tx) error {
+		_, err := is.Create(WithTransactionContext(ctx, tx), images.Image{Name: "readonly", Target: img.Target})
+		return err
+	}); !errors.Is(err, bolt.ErrTxNotWritable) {
+		t.Fatalf("expected not writable error, got %v", err)
+	}
+	check("readonly", false)
+}
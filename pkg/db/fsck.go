@@ -0,0 +1,111 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// BrokenImage is an image which refers to content missing from the
+// content store
+type BrokenImage struct {
+	Image images.Image
+
+	// TargetMissing is set when the target of the image is missing
+	TargetMissing bool
+
+	// Missing holds the missing target or manifests referenced by the image
+	Missing []ocispec.Descriptor
+}
+
+// CheckImages returns the images whose target or referenced manifests are
+// missing from the content store. Content is considered missing when either
+// the content record or the blob itself is not found.
+func (m *DB) CheckImages(ctx context.Context) ([]BrokenImage, error) {
+	imgs, err := NewImageStore(m).List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var broken []BrokenImage
+	for _, img := range imgs {
+		missing, err := m.missingManifests(ctx, img.Target)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check image %s: %w", img.Name, err)
+		}
+		if len(missing) > 0 {
+			broken = append(broken, BrokenImage{
+				Image:         img,
+				TargetMissing: missing[0].Digest == img.Target.Digest,
+				Missing:       missing,
+			})
+		}
+	}
+	return broken, nil
+}
+
+// missingManifests returns the descriptor when it is missing, otherwise the
+// missing manifests referenced by the descriptor when it is an index
+func (m *DB) missingManifests(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+	ra, err := m.cs.ReaderAt(ctx, desc)
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return []ocispec.Descriptor{desc}, nil
+		}
+		return nil, err
+	}
+	ra.Close()
+
+	if !images.IsIndexType(desc.MediaType) {
+		return nil, nil
+	}
+	children, err := images.Children(ctx, m.cs, desc)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		missing, absent []ocispec.Descriptor
+		manifests       int
+	)
+	for _, child := range children {
+		if !images.IsManifestType(child.MediaType) && !images.IsIndexType(child.MediaType) {
+			continue
+		}
+		manifests++
+		cm, err := m.missingManifests(ctx, child)
+		if err != nil {
+			return nil, err
+		}
+		if len(cm) > 0 && cm[0].Digest == child.Digest {
+			absent = append(absent, child)
+			continue
+		}
+		missing = append(missing, cm...)
+	}
+	// Pulling a subset of platforms leaves the other manifests of an index
+	// absent, only report them when none of the manifests are present
+	if len(absent) == manifests {
+		missing = append(missing, absent...)
+	}
+	return missing, nil
+}
@@ -0,0 +1,127 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestCheckImages(t *testing.T) {
+	ctx, db := testDB(t)
+	cs := db.ContentStore()
+	is := NewImageStore(db)
+
+	lctx, remove, err := createLease(ctx, db, "fsck-lease")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remove()
+
+	writeJSON := func(mediaType string, v interface{}) ocispec.Descriptor {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		desc := ocispec.Descriptor{MediaType: mediaType, Digest: digest.FromBytes(b), Size: int64(len(b))}
+		if err := content.WriteBlob(lctx, cs, desc.Digest.String(), bytes.NewReader(b), desc); err != nil {
+			t.Fatal(err)
+		}
+		return desc
+	}
+	manifest := writeJSON(ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+	})
+	absent := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Digest:    digest.FromString("absent manifest"),
+		Size:      15,
+	}
+	absent2 := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Digest:    digest.FromString("another absent manifest"),
+		Size:      23,
+	}
+	index := func(manifests ...ocispec.Descriptor) ocispec.Descriptor {
+		return writeJSON(ocispec.MediaTypeImageIndex, ocispec.Index{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: ocispec.MediaTypeImageIndex,
+			Manifests: manifests,
+		})
+	}
+
+	for name, target := range map[string]ocispec.Descriptor{
+		"complete":         manifest,
+		"partial-index":    index(manifest, absent),
+		"missing-target":   absent,
+		"missing-manifest": index(absent, absent2),
+		"nested-missing":   index(index(absent)),
+	} {
+		if _, err := is.Create(ctx, images.Image{Name: name, Target: target}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	broken, err := db.CheckImages(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(broken, func(i, j int) bool { return broken[i].Image.Name < broken[j].Image.Name })
+
+	expected := []struct {
+		name          string
+		targetMissing bool
+		missing       []digest.Digest
+	}{
+		{"missing-manifest", false, []digest.Digest{absent.Digest, absent2.Digest}},
+		{"missing-target", true, []digest.Digest{absent.Digest}},
+		{"nested-missing", false, []digest.Digest{absent.Digest}},
+	}
+	if len(broken) != len(expected) {
+		t.Fatalf("expected %d broken images, got %d: %v", len(expected), len(broken), broken)
+	}
+	for i, e := range expected {
+		b := broken[i]
+		if b.Image.Name != e.name {
+			t.Fatalf("expected broken image %s, got %s", e.name, b.Image.Name)
+		}
+		if b.TargetMissing != e.targetMissing {
+			t.Errorf("%s: expected target missing %t", e.name, e.targetMissing)
+		}
+		var missing []digest.Digest
+		for _, desc := range b.Missing {
+			missing = append(missing, desc.Digest)
+		}
+		if len(missing) != len(e.missing) {
+			t.Fatalf("%s: expected missing %v, got %v", e.name, e.missing, missing)
+		}
+		for j := range missing {
+			if missing[j] != e.missing[j] {
+				t.Fatalf("%s: expected missing %v, got %v", e.name, e.missing, missing)
+			}
+		}
+	}
+}
@@ -19,12 +19,14 @@ package db
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"sort"
 	"strings"
 	"time"
 
 	"github.com/containerd/containerd/gc"
 	"github.com/containerd/containerd/log"
+	digest "github.com/opencontainers/go-digest"
 	bolt "go.etcd.io/bbolt"
 )
 
@@ -54,8 +56,12 @@ const (
 	resourceSnapshotFlat = ResourceSnapshot | 0x20
 )
 
+// LabelGCRoot marks an object as a garbage collection root, the value is
+// the time the object was marked
+const LabelGCRoot = "containerd.io/gc.root"
+
 var (
-	labelGCRoot       = []byte("containerd.io/gc.root")
+	labelGCRoot       = []byte(LabelGCRoot)
 	labelGCRef        = []byte("containerd.io/gc.ref.")
 	labelGCSnapRef    = []byte("containerd.io/gc.ref.snapshot.")
 	labelGCContentRef = []byte("containerd.io/gc.ref.content")
@@ -103,6 +109,18 @@ type Collector interface {
 type gcContext struct {
 	labelHandlers []referenceLabelHandler
 	contexts      map[gc.ResourceType]CollectionContext
+
+	// excludeImages holds the names of images not used as roots, used to
+	// determine what would be collected if the images were removed
+	excludeImages map[string]struct{}
+
+	// contentCreatedAfter is the time after which created content is used
+	// as a root, protecting content not yet referenced. Zero when unset.
+	contentCreatedAfter time.Time
+
+	// concurrency is the number of resources which may have their
+	// references resolved at once while marking
+	concurrency int
 }
 
 type referenceLabelHandler struct {
@@ -126,18 +144,16 @@ func startGCContext(ctx context.Context, collectors map[gc.ResourceType]Collecto
 				fn(gcnode(ResourceContent, string(v)))
 			},
 		},
-		/*
-			{
-				key: labelGCSnapRef,
-				fn: func(ns string, k, v []byte, fn func(gc.Node)) {
-					snapshotter := k[len(labelGCSnapRef):]
-					if i := bytes.IndexByte(snapshotter, '/'); i >= 0 {
-						snapshotter = snapshotter[:i]
-					}
-					fn(gcnode(ResourceSnapshot, fmt.Sprintf("%s/%s", snapshotter, v)))
-				},
+		{
+			key: labelGCSnapRef,
+			fn: func(k, v []byte, fn func(gc.Node)) {
+				snapshotter := k[len(labelGCSnapRef):]
+				if i := bytes.IndexByte(snapshotter, '/'); i >= 0 {
+					snapshotter = snapshotter[:i]
+				}
+				fn(gcnode(ResourceSnapshot, fmt.Sprintf("%s/%s", snapshotter, v)))
 			},
-		*/
+		},
 	}
 	if len(collectors) > 0 {
 		contexts = map[gc.ResourceType]CollectionContext{}
@@ -211,6 +227,22 @@ func (c *gcContext) finish(ctx context.Context) {
 	}
 }
 
+// isRecent returns whether the content in the bucket was created after the
+// minimum retention threshold
+func (c *gcContext) isRecent(bkt *bolt.Bucket) bool {
+	if c.contentCreatedAfter.IsZero() {
+		return false
+	}
+	var created time.Time
+	if v := bkt.Get(bucketKeyCreatedAt); v != nil {
+		if err := created.UnmarshalBinary(v); err != nil {
+			// Retain content which cannot be verified as old enough
+			return true
+		}
+	}
+	return created.After(c.contentCreatedAfter)
+}
+
 // scanRoots sends the given channel "root" resources that are certainly used.
 // The caller could look the references of the resources to find all resources that are used.
 func (c *gcContext) scanRoots(ctx context.Context, tx *bolt.Tx, nc chan<- gc.Node) error {
@@ -283,29 +315,27 @@ func (c *gcContext) scanRoots(ctx context.Context, tx *bolt.Tx, nc chan<- gc.Nod
 				}
 			}
 
-			/*
-				stype := ResourceSnapshot
-				if flat {
-					stype = resourceSnapshotFlat
-				}
+			stype := ResourceSnapshot
+			if flat {
+				stype = resourceSnapshotFlat
+			}
 
-				sbkt := libkt.Bucket(bucketKeyObjectSnapshots)
-				if sbkt != nil {
-					if err := sbkt.ForEach(func(sk, sv []byte) error {
-						if sv != nil {
-							return nil
-						}
-						snbkt := sbkt.Bucket(sk)
-
-						return snbkt.ForEach(func(k, v []byte) error {
-							fn(gcnode(stype, ns, fmt.Sprintf("%s/%s", sk, k)))
-							return nil
-						})
-					}); err != nil {
-						return err
+			sbkt := libkt.Bucket(bucketKeyObjectSnapshots)
+			if sbkt != nil {
+				if err := sbkt.ForEach(func(sk, sv []byte) error {
+					if sv != nil {
+						return nil
 					}
+					snbkt := sbkt.Bucket(sk)
+
+					return snbkt.ForEach(func(k, v []byte) error {
+						fn(gcnode(stype, fmt.Sprintf("%s/%s", sk, k)))
+						return nil
+					})
+				}); err != nil {
+					return err
 				}
-			*/
+			}
 
 			ibkt := libkt.Bucket(bucketKeyObjectIngests)
 			if ibkt != nil {
@@ -331,6 +361,9 @@ func (c *gcContext) scanRoots(ctx context.Context, tx *bolt.Tx, nc chan<- gc.Nod
 			if v != nil {
 				return nil
 			}
+			if _, ok := c.excludeImages[string(k)]; ok {
+				return nil
+			}
 
 			target := ibkt.Bucket(k).Bucket(bucketKeyTarget)
 			if target != nil {
@@ -371,7 +404,7 @@ func (c *gcContext) scanRoots(ctx context.Context, tx *bolt.Tx, nc chan<- gc.Nod
 					return nil
 				}
 
-				if isRootRef(cbkt.Bucket(k)) {
+				if isRootRef(cbkt.Bucket(k)) || c.isRecent(cbkt.Bucket(k)) {
 					fn(gcnode(ResourceContent, string(k)))
 				}
 
@@ -382,49 +415,47 @@ func (c *gcContext) scanRoots(ctx context.Context, tx *bolt.Tx, nc chan<- gc.Nod
 		}
 	}
 
-	/*
-		cbkt = nbkt.Bucket(bucketKeyObjectContainers)
-		if cbkt != nil {
-			if err := cbkt.ForEach(func(k, v []byte) error {
-				if v != nil {
-					return nil
-				}
-
-				cibkt := cbkt.Bucket(k)
-				snapshotter := string(cibkt.Get(bucketKeySnapshotter))
-				if snapshotter != "" {
-					ss := string(cibkt.Get(bucketKeySnapshotKey))
-					fn(gcnode(ResourceSnapshot, ns, fmt.Sprintf("%s/%s", snapshotter, ss)))
-				}
+	cbkt = nbkt.Bucket(bucketKeyObjectContainers)
+	if cbkt != nil {
+		if err := cbkt.ForEach(func(k, v []byte) error {
+			if v != nil {
+				return nil
+			}
 
-				return c.sendLabelRefs(ns, cibkt, fn)
-			}); err != nil {
-				return err
+			cibkt := cbkt.Bucket(k)
+			snapshotter := string(cibkt.Get(bucketKeySnapshotter))
+			if snapshotter != "" {
+				ss := string(cibkt.Get(bucketKeySnapshotKey))
+				fn(gcnode(ResourceSnapshot, fmt.Sprintf("%s/%s", snapshotter, ss)))
 			}
+
+			return c.sendLabelRefs(cibkt, fn)
+		}); err != nil {
+			return err
 		}
+	}
 
-		sbkt := nbkt.Bucket(bucketKeyObjectSnapshots)
-		if sbkt != nil {
-			if err := sbkt.ForEach(func(sk, sv []byte) error {
-				if sv != nil {
-					return nil
-				}
-				snbkt := sbkt.Bucket(sk)
+	sbkt := nbkt.Bucket(bucketKeyObjectSnapshots)
+	if sbkt != nil {
+		if err := sbkt.ForEach(func(sk, sv []byte) error {
+			if sv != nil {
+				return nil
+			}
+			snbkt := sbkt.Bucket(sk)
 
-				return snbkt.ForEach(func(k, v []byte) error {
-					if v != nil {
-						return nil
-					}
-					if isRootRef(snbkt.Bucket(k)) {
-						fn(gcnode(ResourceSnapshot, ns, fmt.Sprintf("%s/%s", sk, k)))
-					}
+			return snbkt.ForEach(func(k, v []byte) error {
+				if v != nil {
 					return nil
-				})
-			}); err != nil {
-				return err
-			}
+				}
+				if isRootRef(snbkt.Bucket(k)) {
+					fn(gcnode(ResourceSnapshot, fmt.Sprintf("%s/%s", sk, k)))
+				}
+				return nil
+			})
+		}); err != nil {
+			return err
 		}
-	*/
+	}
 
 	c.active(fn)
 	return cerr
@@ -441,32 +472,30 @@ func (c *gcContext) references(ctx context.Context, tx *bolt.Tx, node gc.Node, f
 		}
 
 		return c.sendLabelRefs(bkt, fn)
-		/*
-			case ResourceSnapshot, resourceSnapshotFlat:
-				parts := strings.SplitN(node.Key, "/", 2)
-				if len(parts) != 2 {
-					return fmt.Errorf("invalid snapshot gc key %s", node.Key)
-				}
-				ss := parts[0]
-				name := parts[1]
+	case ResourceSnapshot, resourceSnapshotFlat:
+		parts := strings.SplitN(node.Key, "/", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid snapshot gc key %s", node.Key)
+		}
+		ss := parts[0]
+		name := parts[1]
 
-				bkt := getBucket(tx, bucketKeyVersion, bucketKeyObjectSnapshots, []byte(ss), []byte(name))
-				if bkt == nil {
-					// Node may be created from dead edge
-					return nil
-				}
+		bkt := getBucket(tx, bucketKeyVersion, bucketKeyObjectSnapshots, []byte(ss), []byte(name))
+		if bkt == nil {
+			// Node may be created from dead edge
+			return nil
+		}
 
-				if pv := bkt.Get(bucketKeyParent); len(pv) > 0 {
-					fn(gcnode(node.Type, fmt.Sprintf("%s/%s", ss, pv)))
-				}
+		if pv := bkt.Get(bucketKeyParent); len(pv) > 0 {
+			fn(gcnode(node.Type, fmt.Sprintf("%s/%s", ss, pv)))
+		}
 
-				// Do not send labeled references for flat snapshot refs
-				if node.Type == resourceSnapshotFlat {
-					return nil
-				}
+		// Do not send labeled references for flat snapshot refs
+		if node.Type == resourceSnapshotFlat {
+			return nil
+		}
 
-				return c.sendLabelRefs(node.Namespace, bkt, fn)
-		*/
+		return c.sendLabelRefs(bkt, fn)
 	case ResourceIngest:
 		// Send expected value
 		bkt := getBucket(tx, bucketKeyVersion, bucketKeyObjectContent, bucketKeyObjectIngests, []byte(node.Key))
@@ -506,26 +535,24 @@ func (c *gcContext) scanAll(ctx context.Context, tx *bolt.Tx, fn func(ctx contex
 		}
 	}
 
-	/*
-		sbkt := nbkt.Bucket(bucketKeyObjectSnapshots)
-		if sbkt != nil {
-			if err := sbkt.ForEach(func(sk, sv []byte) error {
-				if sv != nil {
+	sbkt := nbkt.Bucket(bucketKeyObjectSnapshots)
+	if sbkt != nil {
+		if err := sbkt.ForEach(func(sk, sv []byte) error {
+			if sv != nil {
+				return nil
+			}
+			snbkt := sbkt.Bucket(sk)
+			return snbkt.ForEach(func(k, v []byte) error {
+				if v != nil {
 					return nil
 				}
-				snbkt := sbkt.Bucket(sk)
-				return snbkt.ForEach(func(k, v []byte) error {
-					if v != nil {
-						return nil
-					}
-					node := gcnode(ResourceSnapshot, fmt.Sprintf("%s/%s", sk, k))
-					return fn(ctx, node)
-				})
-			}); err != nil {
-				return err
-			}
+				node := gcnode(ResourceSnapshot, fmt.Sprintf("%s/%s", sk, k))
+				return fn(ctx, node)
+			})
+		}); err != nil {
+			return err
 		}
-	*/
+	}
 
 	cbkt := nbkt.Bucket(bucketKeyObjectContent)
 	if cbkt != nil {
@@ -586,21 +613,22 @@ func (c *gcContext) remove(ctx context.Context, tx *bolt.Tx, node gc.Node) error
 			log.G(ctx).WithField("key", node.Key).Debug("remove content")
 			return cbkt.DeleteBucket([]byte(node.Key))
 		}
-		/*
-			case ResourceSnapshot:
-				sbkt := nsbkt.Bucket(bucketKeyObjectSnapshots)
-				if sbkt != nil {
-					parts := strings.SplitN(node.Key, "/", 2)
-					if len(parts) != 2 {
-						return fmt.Errorf("invalid snapshot gc key %s", node.Key)
-					}
-					ssbkt := sbkt.Bucket([]byte(parts[0]))
-					if ssbkt != nil {
-						log.G(ctx).WithField("key", parts[1]).WithField("snapshotter", parts[0]).Debug("remove snapshot")
-						return ssbkt.DeleteBucket([]byte(parts[1]))
-					}
+	case ResourceSnapshot:
+		sbkt := nsbkt.Bucket(bucketKeyObjectSnapshots)
+		if sbkt != nil {
+			parts := strings.SplitN(node.Key, "/", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("invalid snapshot gc key %s", node.Key)
+			}
+			ssbkt := sbkt.Bucket([]byte(parts[0]))
+			if ssbkt != nil {
+				log.G(ctx).WithField("key", parts[1]).WithField("snapshotter", parts[0]).Debug("remove snapshot")
+				if err := removeChildLink(ssbkt, parts[1]); err != nil {
+					return err
 				}
-		*/
+				return ssbkt.DeleteBucket([]byte(parts[1]))
+			}
+		}
 	case ResourceLease:
 		lbkt := nsbkt.Bucket(bucketKeyObjectLeases)
 		if lbkt != nil {
@@ -642,6 +670,27 @@ func (c *gcContext) sendLabelRefs(bkt *bolt.Bucket, fn func(gc.Node)) error {
 	return nil
 }
 
+// removeChildLink removes the snapshot from the children of its parent so
+// the parent may be removed once the snapshot is collected
+func removeChildLink(ssbkt *bolt.Bucket, name string) error {
+	sbkt := ssbkt.Bucket([]byte(name))
+	if sbkt == nil {
+		return nil
+	}
+	parent := sbkt.Get(bucketKeyParent)
+	if len(parent) == 0 {
+		return nil
+	}
+	pbkt := ssbkt.Bucket(parent)
+	if pbkt == nil {
+		return nil
+	}
+	if cbkt := pbkt.Bucket(bucketKeyChildren); cbkt != nil {
+		return cbkt.Delete([]byte(name))
+	}
+	return nil
+}
+
 func isRootRef(bkt *bolt.Bucket) bool {
 	lbkt := bkt.Bucket(bucketKeyObjectLabels)
 	if lbkt != nil {
@@ -654,6 +703,16 @@ func isRootRef(bkt *bolt.Bucket) bool {
 	return false
 }
 
+// contentMarked returns whether the content is in the marked set either as
+// content or flat content
+func contentMarked(marked map[gc.Node]struct{}, dgst digest.Digest) bool {
+	if _, ok := marked[gcnode(ResourceContent, dgst.String())]; ok {
+		return true
+	}
+	_, ok := marked[gcnode(resourceContentFlat, dgst.String())]
+	return ok
+}
+
 func gcnode(t gc.ResourceType, key string) gc.Node {
 	return gc.Node{
 		Type: t,
@@ -17,17 +17,22 @@
 package db
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"math/rand"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"testing"
 	"time"
 
+	"github.com/containerd/containerd/content"
 	"github.com/containerd/containerd/gc"
 	"github.com/containerd/containerd/metadata/boltutil"
 	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	bolt "go.etcd.io/bbolt"
@@ -48,19 +53,17 @@ func TestGCRoots(t *testing.T) {
 
 	alters := []alterFunc{
 		addImage("image1", dgst(1), nil),
-		//addImage("image2", dgst(2), labelmap(string(labelGCSnapRef)+"overlay", "sn2")),
+		addImage("image2", dgst(2), labelmap(string(labelGCSnapRef)+"overlay", "sn2")),
 		addImage("image3", dgst(10), labelmap(string(labelGCContentRef), dgst(11).String())),
-		/*
-			addContainer("container1", "overlay", "sn4", nil),
-			addContainer("container2", "overlay", "sn5", labelmap(string(labelGCSnapRef)+"overlay", "sn6")),
-			addContainer("container3", "overlay", "sn7", labelmap(
-				string(labelGCSnapRef)+"overlay/anything-1", "sn8",
-				string(labelGCSnapRef)+"overlay/anything-2", "sn9",
-				string(labelGCContentRef), dgst(7).String())),
-			addContainer("container4", "", "", labelmap(
-				string(labelGCContentRef)+".0", dgst(8).String(),
-				string(labelGCContentRef)+".1", dgst(9).String())),
-		*/
+		addContainer("container1", "overlay", "sn4", nil),
+		addContainer("container2", "overlay", "sn5", labelmap(string(labelGCSnapRef)+"overlay", "sn6")),
+		addContainer("container3", "overlay", "sn7", labelmap(
+			string(labelGCSnapRef)+"overlay/anything-1", "sn8",
+			string(labelGCSnapRef)+"overlay/anything-2", "sn9",
+			string(labelGCContentRef), dgst(7).String())),
+		addContainer("container4", "", "", labelmap(
+			string(labelGCContentRef)+".0", dgst(8).String(),
+			string(labelGCContentRef)+".1", dgst(9).String())),
 		addContent(dgst(1), nil),
 		addContent(dgst(2), nil),
 		addContent(dgst(3), nil),
@@ -76,57 +79,59 @@ func TestGCRoots(t *testing.T) {
 		addIngest("ingest-5", dgst(8), nil),
 		addIngest("ingest-6", "", nil),      // added to expired lease
 		addIngest("ingest-7", dgst(9), nil), // added to expired lease
-		//addSnapshot("overlay", "sn1", "", nil),
-		//addSnapshot("overlay", "sn2", "", nil),
-		//addSnapshot("overlay", "sn3", "", labelmap(string(labelGCRoot), "always")),
-		//addSnapshot("overlay", "sn4", "", nil),
-		//addSnapshot("overlay", "sn5", "", nil),
-		//addSnapshot("overlay", "sn6", "", nil),
-		//addSnapshot("overlay", "sn7", "", nil),
-		//addSnapshot("overlay", "sn8", "", nil),
-		//addSnapshot("overlay", "sn9", "", nil),
-		//addLeaseSnapshot("l1", "overlay", "sn5"),
-		//addLeaseSnapshot("l2", "overlay", "sn6"),
+		addSnapshot("overlay", "sn1", "", nil),
+		addSnapshot("overlay", "sn2", "", nil),
+		addSnapshot("overlay", "sn3", "", labelmap(string(labelGCRoot), "always")),
+		addSnapshot("overlay", "sn4", "", nil),
+		addSnapshot("overlay", "sn5", "", nil),
+		addSnapshot("overlay", "sn6", "", nil),
+		addSnapshot("overlay", "sn7", "", nil),
+		addSnapshot("overlay", "sn8", "", nil),
+		addSnapshot("overlay", "sn9", "", nil),
+		addLeaseSnapshot("l1", "overlay", "sn5"),
+		addLeaseSnapshot("l2", "overlay", "sn6"),
 		addLeaseContent("l1", dgst(4)),
 		addLeaseContent("l2", dgst(5)),
 		addLease("l3", labelmap(string(labelGCExpire), time.Now().Add(time.Hour).Format(time.RFC3339))),
 		addLeaseContent("l3", dgst(6)),
-		//addLeaseSnapshot("l3", "overlay", "sn7"),
+		addLeaseSnapshot("l3", "overlay", "sn7"),
 		addLeaseIngest("l3", "ingest-4"),
 		addLeaseIngest("l3", "ingest-5"),
 		addLease("l4", labelmap(string(labelGCExpire), time.Now().Format(time.RFC3339))),
 		addLeaseContent("l4", dgst(7)),
-		//addLeaseSnapshot("l4", "overlay", "sn8"),
+		addLeaseSnapshot("l4", "overlay", "sn8"),
 		addLeaseIngest("l4", "ingest-6"),
 		addLeaseIngest("l4", "ingest-7"),
 
 		addLease("l5", labelmap(string(labelGCFlat), time.Now().Add(time.Hour).Format(time.RFC3339))),
 		addLeaseContent("l5", dgst(12)),
-		//addLeaseSnapshot("l5", "overlay", "sn1"),
+		addLeaseSnapshot("l5", "overlay", "sn1"),
 		addLeaseIngest("l5", "ingest-8"),
 	}
 
 	expected := []gc.Node{
 		gcnode(ResourceContent, dgst(1).String()),
+		gcnode(ResourceContent, dgst(2).String()),
 		gcnode(ResourceContent, dgst(4).String()),
 		gcnode(ResourceContent, dgst(5).String()),
 		gcnode(ResourceContent, dgst(6).String()),
+		gcnode(ResourceContent, dgst(7).String()),
+		gcnode(ResourceContent, dgst(8).String()),
+		gcnode(ResourceContent, dgst(9).String()),
 		gcnode(ResourceContent, dgst(10).String()),
 		gcnode(ResourceContent, dgst(11).String()),
 		gcnode(ResourceContent, dgst(13).String()),
-		/*
-			gcnode(ResourceSnapshot, "overlay/sn2"),
-			gcnode(ResourceSnapshot, "overlay/sn3"),
-			gcnode(ResourceSnapshot, "overlay/sn4"),
-			gcnode(ResourceSnapshot, "overlay/sn5"),
-			gcnode(ResourceSnapshot, "overlay/sn6"),
-			gcnode(ResourceSnapshot, "overlay/sn7"),
-			gcnode(ResourceSnapshot, "overlay/sn8"),
-			gcnode(ResourceSnapshot, "overlay/sn9"),
-			gcnode(ResourceSnapshot, "overlay/sn5"),
-			gcnode(ResourceSnapshot, "overlay/sn6"),
-			gcnode(ResourceSnapshot, "overlay/sn7"),
-		*/
+		gcnode(ResourceSnapshot, "overlay/sn2"),
+		gcnode(ResourceSnapshot, "overlay/sn3"),
+		gcnode(ResourceSnapshot, "overlay/sn4"),
+		gcnode(ResourceSnapshot, "overlay/sn5"),
+		gcnode(ResourceSnapshot, "overlay/sn6"),
+		gcnode(ResourceSnapshot, "overlay/sn7"),
+		gcnode(ResourceSnapshot, "overlay/sn8"),
+		gcnode(ResourceSnapshot, "overlay/sn9"),
+		gcnode(ResourceSnapshot, "overlay/sn5"),
+		gcnode(ResourceSnapshot, "overlay/sn6"),
+		gcnode(ResourceSnapshot, "overlay/sn7"),
 		gcnode(ResourceLease, "l1"),
 		gcnode(ResourceLease, "l2"),
 		gcnode(ResourceLease, "l3"),
@@ -136,7 +141,7 @@ func TestGCRoots(t *testing.T) {
 		gcnode(ResourceLease, "l5"),
 		gcnode(ResourceIngest, "ingest-8"),
 		gcnode(resourceContentFlat, dgst(12).String()),
-		//gcnode(resourceSnapshotFlat, "overlay/sn1"),
+		gcnode(resourceSnapshotFlat, "overlay/sn1"),
 	}
 
 	if err := db.Update(func(tx *bolt.Tx) error {
@@ -167,8 +172,8 @@ func TestGCRemove(t *testing.T) {
 
 	alters := []alterFunc{
 		addImage("image1", dgst(1), nil),
-		//addImage("image2", dgst(2), labelmap(string(labelGCSnapRef)+"overlay", "sn2")),
-		//addContainer("container1", "overlay", "sn4", nil),
+		addImage("image2", dgst(2), labelmap(string(labelGCSnapRef)+"overlay", "sn2")),
+		addContainer("container1", "overlay", "sn4", nil),
 		addContent(dgst(1), nil),
 		addContent(dgst(2), nil),
 		addContent(dgst(3), nil),
@@ -176,13 +181,10 @@ func TestGCRemove(t *testing.T) {
 		addContent(dgst(5), labelmap(string(labelGCRoot), "always")),
 		addIngest("ingest-1", "", nil),
 		addIngest("ingest-2", "", timeIn(0)),
-		/*
-			addSnapshot("overlay", "sn1", "", nil),
-			addSnapshot("overlay", "sn2", "", nil),
-			addSnapshot("overlay", "sn3", "", labelmap(string(labelGCRoot), "always")),
-			addSnapshot("overlay", "sn4", "", nil),
-			addSnapshot("overlay", "sn1", "", nil),
-		*/
+		addSnapshot("overlay", "sn1", "", nil),
+		addSnapshot("overlay", "sn2", "", nil),
+		addSnapshot("overlay", "sn3", "", labelmap(string(labelGCRoot), "always")),
+		addSnapshot("overlay", "sn4", "", nil),
 		addLease("l1", labelmap(string(labelGCExpire), time.Now().Add(time.Hour).Format(time.RFC3339))),
 		addLease("l2", labelmap(string(labelGCExpire), time.Now().Format(time.RFC3339))),
 	}
@@ -193,13 +195,10 @@ func TestGCRemove(t *testing.T) {
 		gcnode(ResourceContent, dgst(3).String()),
 		gcnode(ResourceContent, dgst(4).String()),
 		gcnode(ResourceContent, dgst(5).String()),
-		/*
-			gcnode(ResourceSnapshot, "overlay/sn1"),
-			gcnode(ResourceSnapshot, "overlay/sn2"),
-			gcnode(ResourceSnapshot, "overlay/sn3"),
-			gcnode(ResourceSnapshot, "overlay/sn4"),
-			gcnode(ResourceSnapshot, "overlay/sn1"),
-		*/
+		gcnode(ResourceSnapshot, "overlay/sn1"),
+		gcnode(ResourceSnapshot, "overlay/sn2"),
+		gcnode(ResourceSnapshot, "overlay/sn3"),
+		gcnode(ResourceSnapshot, "overlay/sn4"),
 		gcnode(ResourceLease, "l1"),
 		gcnode(ResourceLease, "l2"),
 		gcnode(ResourceIngest, "ingest-1"),
@@ -272,29 +271,20 @@ func TestGCRefs(t *testing.T) {
 		addContent(dgst(12), nil),
 		addIngest("ingest-1", "", nil),
 		addIngest("ingest-2", dgst(8), nil),
-		/*
-			addSnapshot("overlay", "sn1", "", nil),
-			addSnapshot("overlay", "sn2", "sn1", nil),
-			addSnapshot("overlay", "sn3", "sn2", nil),
-			addSnapshot("overlay", "sn4", "", labelmap(string(labelGCSnapRef)+"btrfs", "sn1", string(labelGCSnapRef)+"overlay", "sn1")),
-			addSnapshot("overlay", "sn5", "", labelmap(string(labelGCSnapRef)+"overlay/anything-1", "sn1", string(labelGCSnapRef)+"overlay/anything-2", "sn2")),
-			addSnapshot("btrfs", "sn1", "", nil),
-			addSnapshot("overlay", "sn1", "", nil),
-			addSnapshot("overlay", "sn2", "sn1", nil),
-			addSnapshot("overlay", "sn3", "", labelmap(
-				string(labelGCContentRef), dgst(1).String(),
-				string(labelGCContentRef)+".keep-me", dgst(6).String())),
-		*/
+		addSnapshot("overlay", "sn1", "", nil),
+		addSnapshot("overlay", "sn2", "sn1", nil),
+		addSnapshot("overlay", "sn3", "sn2", nil),
+		addSnapshot("overlay", "sn4", "", labelmap(string(labelGCSnapRef)+"btrfs", "sn1", string(labelGCSnapRef)+"overlay", "sn1")),
+		addSnapshot("overlay", "sn5", "", labelmap(string(labelGCSnapRef)+"overlay/anything-1", "sn1", string(labelGCSnapRef)+"overlay/anything-2", "sn2")),
+		addSnapshot("btrfs", "sn1", "", nil),
+		addSnapshot("overlay", "sn6", "", labelmap(
+			string(labelGCContentRef), dgst(1).String(),
+			string(labelGCContentRef)+".keep-me", dgst(6).String())),
 
 		// Test flat references don't follow label references
 		addContent(dgst(21), nil),
 		addContent(dgst(22), labelmap(string(labelGCContentRef)+".0", dgst(1).String())),
-
-		/*
-			addSnapshot("overlay", "sn1", "", nil),
-			addSnapshot("overlay", "sn2", "sn1", nil),
-			addSnapshot("overlay", "sn3", "", labelmap(string(labelGCSnapRef)+"btrfs", "sn1", string(labelGCSnapRef)+"overlay", "sn1")),
-		*/
+		addSnapshot("overlay", "sn7", "", labelmap(string(labelGCSnapRef)+"btrfs", "sn1", string(labelGCSnapRef)+"overlay", "sn1")),
 	}
 
 	refs := map[gc.Node][]gc.Node{
@@ -315,50 +305,39 @@ func TestGCRefs(t *testing.T) {
 		},
 		gcnode(ResourceContent, dgst(11).String()): nil,
 		gcnode(ResourceContent, dgst(12).String()): nil,
-		/*
-			gcnode(ResourceSnapshot, "overlay/sn1"):   nil,
-			gcnode(ResourceSnapshot, "overlay/sn2"): {
-				gcnode(ResourceSnapshot, "overlay/sn1"),
-			},
-			gcnode(ResourceSnapshot, "overlay/sn3"): {
-				gcnode(ResourceSnapshot, "overlay/sn2"),
-			},
-			gcnode(ResourceSnapshot, "overlay/sn4"): {
-				gcnode(ResourceSnapshot, "btrfs/sn1"),
-				gcnode(ResourceSnapshot, "overlay/sn1"),
-			},
-			gcnode(ResourceSnapshot, "overlay/sn5"): {
-				gcnode(ResourceSnapshot, "overlay/sn1"),
-				gcnode(ResourceSnapshot, "overlay/sn2"),
-			},
-			gcnode(ResourceSnapshot, "btrfs/sn1"):   nil,
-			gcnode(ResourceSnapshot, "overlay/sn1"): nil,
-			gcnode(ResourceSnapshot, "overlay/sn2"): {
-				gcnode(ResourceSnapshot, "overlay/sn1"),
-			},
-			gcnode(ResourceSnapshot, "overlay/sn3"): {
-				gcnode(ResourceContent, dgst(1).String()),
-				gcnode(ResourceContent, dgst(6).String()),
-			},
-		*/
+		gcnode(ResourceSnapshot, "overlay/sn1"):    nil,
+		gcnode(ResourceSnapshot, "overlay/sn2"): {
+			gcnode(ResourceSnapshot, "overlay/sn1"),
+		},
+		gcnode(ResourceSnapshot, "overlay/sn3"): {
+			gcnode(ResourceSnapshot, "overlay/sn2"),
+		},
+		gcnode(ResourceSnapshot, "overlay/sn4"): {
+			gcnode(ResourceSnapshot, "btrfs/sn1"),
+			gcnode(ResourceSnapshot, "overlay/sn1"),
+		},
+		gcnode(ResourceSnapshot, "overlay/sn5"): {
+			gcnode(ResourceSnapshot, "overlay/sn1"),
+			gcnode(ResourceSnapshot, "overlay/sn2"),
+		},
+		gcnode(ResourceSnapshot, "btrfs/sn1"): nil,
+		gcnode(ResourceSnapshot, "overlay/sn6"): {
+			gcnode(ResourceContent, dgst(1).String()),
+			gcnode(ResourceContent, dgst(6).String()),
+		},
 		gcnode(ResourceIngest, "ingest-1"): nil,
 		gcnode(ResourceIngest, "ingest-2"): {
 			gcnode(ResourceContent, dgst(8).String()),
 		},
-		/*
-			gcnode(resourceSnapshotFlat, "overlay/sn2"): {
-				gcnode(resourceSnapshotFlat, "overlay/sn1"),
-			},
-			gcnode(ResourceSnapshot, "overlay/sn2"): {
-				gcnode(ResourceSnapshot, "overlay/sn1"),
-			},
-			gcnode(resourceSnapshotFlat, "overlay/sn1"): nil,
-			gcnode(resourceSnapshotFlat, "overlay/sn3"): nil,
-			gcnode(ResourceSnapshot, "overlay/sn3"): {
-				gcnode(ResourceSnapshot, "btrfs/sn1"),
-				gcnode(ResourceSnapshot, "overlay/sn1"),
-			},
-		*/
+		gcnode(resourceSnapshotFlat, "overlay/sn2"): {
+			gcnode(resourceSnapshotFlat, "overlay/sn1"),
+		},
+		gcnode(resourceSnapshotFlat, "overlay/sn1"): nil,
+		gcnode(resourceSnapshotFlat, "overlay/sn7"): nil,
+		gcnode(ResourceSnapshot, "overlay/sn7"): {
+			gcnode(ResourceSnapshot, "btrfs/sn1"),
+			gcnode(ResourceSnapshot, "overlay/sn1"),
+		},
 	}
 
 	if err := db.Update(func(tx *bolt.Tx) error {
@@ -673,7 +652,6 @@ func addImage(name string, dgst digest.Digest, labels map[string]string) alterFu
 	}
 }
 
-/*
 func addSnapshot(snapshotter, name, parent string, labels map[string]string) alterFunc {
 	return func(bkt *bolt.Bucket) error {
 		sbkt, err := createBuckets(bkt, string(bucketKeyObjectSnapshots), snapshotter, name)
@@ -688,7 +666,6 @@ func addSnapshot(snapshotter, name, parent string, labels map[string]string) alt
 		return boltutil.WriteLabels(sbkt, labels)
 	}
 }
-*/
 
 func addContent(dgst digest.Digest, labels map[string]string) alterFunc {
 	return func(bkt *bolt.Bucket) error {
@@ -730,7 +707,6 @@ func addLease(lid string, labels map[string]string) alterFunc {
 	}
 }
 
-/*
 func addLeaseSnapshot(lid, snapshotter, name string) alterFunc {
 	return func(bkt *bolt.Bucket) error {
 		sbkt, err := createBuckets(bkt, string(bucketKeyObjectLeases), lid, string(bucketKeyObjectSnapshots), snapshotter)
@@ -740,7 +716,6 @@ func addLeaseSnapshot(lid, snapshotter, name string) alterFunc {
 		return sbkt.Put([]byte(name), nil)
 	}
 }
-*/
 
 func addLeaseContent(lid string, dgst digest.Digest) alterFunc {
 	return func(bkt *bolt.Bucket) error {
@@ -762,7 +737,6 @@ func addLeaseIngest(lid, ref string) alterFunc {
 	}
 }
 
-/*
 func addContainer(name, snapshotter, snapshot string, labels map[string]string) alterFunc {
 	return func(bkt *bolt.Bucket) error {
 		cbkt, err := createBuckets(bkt, string(bucketKeyObjectContainers), name)
@@ -778,7 +752,6 @@ func addContainer(name, snapshotter, snapshot string, labels map[string]string)
 		return boltutil.WriteLabels(cbkt, labels)
 	}
 }
-*/
 
 func createBuckets(bkt *bolt.Bucket, names ...string) (*bolt.Bucket, error) {
 	for _, name := range names {
@@ -815,3 +788,173 @@ func timeIn(d time.Duration) *time.Time {
 	t := time.Now().UTC().Add(d)
 	return &t
 }
+
+func TestGCConcurrentMark(t *testing.T) {
+	ctx, db := testDB(t, withDBOpts(WithGCConcurrency(4)))
+	if err := db.db.Update(func(tx *bolt.Tx) error {
+		return addGCStore(tx, 20, 10)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	c := startGCContext(ctx, nil)
+	expected, err := db.getMarked(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each image marks its index, 4 manifests and their layers
+	if n := len(expected); n != 20*(1+4*11) {
+		t.Fatalf("unexpected number of marked resources %d", n)
+	}
+
+	for _, concurrency := range []int{2, 4, 16} {
+		c := startGCContext(ctx, nil)
+		c.concurrency = concurrency
+		marked, err := db.getMarked(ctx, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(marked) != len(expected) {
+			t.Fatalf("concurrency %d marked %d resources, expected %d", concurrency, len(marked), len(expected))
+		}
+		for n := range expected {
+			if _, ok := marked[n]; !ok {
+				t.Fatalf("concurrency %d did not mark %v", concurrency, n)
+			}
+		}
+	}
+
+	if _, err := db.GarbageCollect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var remaining int
+	if err := db.db.View(func(tx *bolt.Tx) error {
+		return getBucket(tx, bucketKeyVersion, bucketKeyObjectContent, bucketKeyObjectBlob).ForEach(func(k, v []byte) error {
+			if _, ok := expected[gcnode(ResourceContent, string(k))]; !ok {
+				return fmt.Errorf("unmarked content %s not removed", k)
+			}
+			remaining++
+			return nil
+		})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if remaining != len(expected) {
+		t.Fatalf("%d content remaining after garbage collection, expected %d", remaining, len(expected))
+	}
+}
+
+func TestGCConcurrencyBound(t *testing.T) {
+	_, db := testDB(t, withDBOpts(WithGCConcurrency(runtime.GOMAXPROCS(0)+1)))
+	if n := db.gcConcurrency(); n != runtime.GOMAXPROCS(0) {
+		t.Fatalf("expected concurrency bounded to %d, got %d", runtime.GOMAXPROCS(0), n)
+	}
+}
+
+func BenchmarkGCMark(b *testing.B) {
+	ctx := context.Background()
+	db, err := NewDB(b.TempDir())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() {
+		db.Close(ctx)
+	})
+	if err := db.db.Update(func(tx *bolt.Tx) error {
+		return addGCStore(tx, 200, 25)
+	}); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, concurrency := range []int{1, 8} {
+		b.Run(fmt.Sprintf("concurrency-%d", concurrency), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				c := startGCContext(ctx, nil)
+				c.concurrency = concurrency
+				if _, err := db.getMarked(ctx, c); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkContentCleanup(b *testing.B) {
+	ctx := context.Background()
+	db, err := NewDB(b.TempDir(), WithNoSync)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() {
+		db.Close(ctx)
+	})
+	cs := db.ContentStore()
+	for i := 0; i < 2000; i++ {
+		data := []byte(fmt.Sprintf("blob %d", i))
+		desc := ocispec.Descriptor{Digest: digest.FromBytes(data), Size: int64(len(data))}
+		if err := content.WriteBlob(ctx, cs, desc.Digest.String(), bytes.NewReader(data), desc, content.WithLabels(map[string]string{
+			string(labelGCRoot): "true",
+		})); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	// A single removed blob is checked when the removed content is known,
+	// otherwise all blobs are walked
+	removed := map[digest.Digest]struct{}{digest.FromString("removed"): {}}
+	b.Run("targeted", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := db.cs.garbageCollect(ctx, removed); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("walk", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := db.cs.garbageCollect(ctx, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// addGCStore adds images each referencing an index of 4 manifests, each
+// manifest referencing layers, along with as much unreferenced content.
+func addGCStore(tx *bolt.Tx, images, layers int) error {
+	v1bkt, err := tx.CreateBucketIfNotExists(bucketKeyVersion)
+	if err != nil {
+		return err
+	}
+	var (
+		alters []alterFunc
+		next   int64
+	)
+	blob := func() digest.Digest {
+		next++
+		return dgst(next)
+	}
+	for i := 0; i < images; i++ {
+		il := map[string]string{}
+		for j := 0; j < 4; j++ {
+			ml := map[string]string{}
+			for k := 0; k < layers; k++ {
+				layer := blob()
+				ml[fmt.Sprintf("%s.l.%d", labelGCContentRef, k)] = layer.String()
+				alters = append(alters, addContent(layer, nil), addContent(blob(), nil))
+			}
+			manifest := blob()
+			il[fmt.Sprintf("%s.m.%d", labelGCContentRef, j)] = manifest.String()
+			alters = append(alters, addContent(manifest, ml))
+		}
+		index := blob()
+		alters = append(alters,
+			addContent(index, il),
+			addImage(fmt.Sprintf("registry.test/image-%d:latest", i), index, nil))
+	}
+	for _, alter := range alters {
+		if err := alter(v1bkt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/gc"
+	digest "github.com/opencontainers/go-digest"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// HolderLease is the holder type for a lease
+	HolderLease = "lease"
+	// HolderImage is the holder type for an image
+	HolderImage = "image"
+)
+
+// ContentHolder is a lease or image which prevents content from being
+// garbage collected, either by referencing it directly or through the
+// references of other content.
+type ContentHolder struct {
+	Type string
+	Name string
+}
+
+// ContentHolders returns all the leases and images holding the content.
+// Content is collected once the last holder is removed unless the content
+// is itself a garbage collection root.
+func (m *DB) ContentHolders(ctx context.Context, dgst digest.Digest) ([]ContentHolder, error) {
+	var (
+		holders []ContentHolder
+		c       = startGCContext(ctx, nil)
+		target  = gcnode(ResourceContent, dgst.String())
+		flat    = gcnode(resourceContentFlat, dgst.String())
+	)
+
+	if err := view(ctx, m, func(tx *bolt.Tx) error {
+		refs := func(n gc.Node) ([]gc.Node, error) {
+			var sn []gc.Node
+			if err := c.references(ctx, tx, n, func(nn gc.Node) {
+				sn = append(sn, nn)
+			}); err != nil {
+				return nil, err
+			}
+			return sn, nil
+		}
+		holds := func(nodes []gc.Node) (bool, error) {
+			if len(nodes) == 0 {
+				return false, nil
+			}
+			reachable, err := gc.Tricolor(nodes, refs)
+			if err != nil {
+				return false, err
+			}
+			_, ok := reachable[target]
+			if !ok {
+				_, ok = reachable[flat]
+			}
+			return ok, nil
+		}
+
+		if lbkt := getBucket(tx, bucketKeyVersion, bucketKeyObjectLeases); lbkt != nil {
+			if err := lbkt.ForEach(func(k, v []byte) error {
+				if v != nil {
+					return nil
+				}
+				libkt := lbkt.Bucket(k)
+
+				ctype := ResourceContent
+				if lblbkt := libkt.Bucket(bucketKeyObjectLabels); lblbkt != nil && lblbkt.Get(labelGCFlat) != nil {
+					ctype = resourceContentFlat
+				}
+
+				var nodes []gc.Node
+				if cbkt := libkt.Bucket(bucketKeyObjectContent); cbkt != nil {
+					if err := cbkt.ForEach(func(k, _ []byte) error {
+						nodes = append(nodes, gcnode(ctype, string(k)))
+						return nil
+					}); err != nil {
+						return err
+					}
+				}
+				ok, err := holds(nodes)
+				if err != nil {
+					return err
+				}
+				if ok {
+					holders = append(holders, ContentHolder{Type: HolderLease, Name: string(k)})
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+
+		if ibkt := getImagesBucket(tx); ibkt != nil {
+			if err := ibkt.ForEach(func(k, v []byte) error {
+				if v != nil {
+					return nil
+				}
+				var nodes []gc.Node
+				if tbkt := ibkt.Bucket(k).Bucket(bucketKeyTarget); tbkt != nil {
+					nodes = append(nodes, gcnode(ResourceContent, string(tbkt.Get(bucketKeyDigest))))
+				}
+				if err := c.sendLabelRefs(ibkt.Bucket(k), func(n gc.Node) {
+					nodes = append(nodes, n)
+				}); err != nil {
+					return err
+				}
+				ok, err := holds(nodes)
+				if err != nil {
+					return err
+				}
+				if ok {
+					holders = append(holders, ContentHolder{Type: HolderImage, Name: string(k)})
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return holders, nil
+}
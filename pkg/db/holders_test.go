@@ -0,0 +1,123 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestSharedLeaseContent(t *testing.T) {
+	ctx, db := testDB(t)
+	cs := db.ContentStore()
+
+	blob := []byte("shared content")
+	desc := ocispec.Descriptor{Size: int64(len(blob)), Digest: digest.FromBytes(blob)}
+
+	var removes []func() error
+	for _, name := range []string{"lease-1", "lease-2"} {
+		lctx, remove, err := createLease(ctx, db, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := content.WriteBlob(lctx, cs, "ref-"+name, bytes.NewReader(blob), desc); err != nil {
+			t.Fatal(err)
+		}
+		removes = append(removes, remove)
+	}
+
+	checkHolders(ctx, t, db, desc.Digest, ContentHolder{HolderLease, "lease-1"}, ContentHolder{HolderLease, "lease-2"})
+
+	if err := removes[0](); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GarbageCollect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.Info(ctx, desc.Digest); err != nil {
+		t.Fatalf("content should remain while held by second lease: %v", err)
+	}
+	if _, err := content.ReadBlob(ctx, cs, desc); err != nil {
+		t.Fatalf("content data should remain while held by second lease: %v", err)
+	}
+	checkHolders(ctx, t, db, desc.Digest, ContentHolder{HolderLease, "lease-2"})
+
+	if err := removes[1](); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GarbageCollect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.Info(ctx, desc.Digest); !errdefs.IsNotFound(err) {
+		t.Fatalf("expected content to be collected, got %v", err)
+	}
+	checkHolders(ctx, t, db, desc.Digest)
+}
+
+func TestContentHolders(t *testing.T) {
+	ctx, db := testDB(t)
+	cs := db.ContentStore()
+
+	layer := []byte("layer content")
+	layerDesc := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayer, Size: int64(len(layer)), Digest: digest.FromBytes(layer)}
+	manifest := []byte("manifest content")
+	manifestDesc := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Size: int64(len(manifest)), Digest: digest.FromBytes(manifest)}
+
+	lctx, remove, err := createLease(ctx, db, "lease-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := content.WriteBlob(lctx, cs, "layer", bytes.NewReader(layer), layerDesc); err != nil {
+		t.Fatal(err)
+	}
+	if err := content.WriteBlob(lctx, cs, "manifest", bytes.NewReader(manifest), manifestDesc,
+		content.WithLabels(map[string]string{"containerd.io/gc.ref.content.l.0": layerDesc.Digest.String()})); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewImageStore(db).Create(ctx, images.Image{Name: "image-1", Target: manifestDesc}); err != nil {
+		t.Fatal(err)
+	}
+	if err := remove(); err != nil {
+		t.Fatal(err)
+	}
+
+	checkHolders(ctx, t, db, manifestDesc.Digest, ContentHolder{HolderImage, "image-1"})
+	checkHolders(ctx, t, db, layerDesc.Digest, ContentHolder{HolderImage, "image-1"})
+}
+
+func checkHolders(ctx context.Context, t *testing.T, db *DB, dgst digest.Digest, expected ...ContentHolder) {
+	t.Helper()
+	holders, err := db.ContentHolders(ctx, dgst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(holders) != len(expected) {
+		t.Fatalf("unexpected holders %v, expected %v", holders, expected)
+	}
+	for i := range holders {
+		if holders[i] != expected[i] {
+			t.Fatalf("unexpected holders %v, expected %v", holders, expected)
+		}
+	}
+}
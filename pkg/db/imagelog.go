@@ -0,0 +1,135 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	digest "github.com/opencontainers/go-digest"
+	bolt "go.etcd.io/bbolt"
+)
+
+// DefaultImageLogSize is the number of image events kept when no size is
+// configured with WithImageLogSize
+const DefaultImageLogSize = 1000
+
+// Image event actions
+const (
+	ImageEventCreate = "create"
+	ImageEventUpdate = "update"
+	ImageEventDelete = "delete"
+)
+
+// ImageEvent records a change to an image, the target is the image target
+// after the change or at the time of deletion
+type ImageEvent struct {
+	Action string        `json:"action"`
+	Name   string        `json:"name"`
+	Target digest.Digest `json:"target"`
+	Time   time.Time     `json:"time"`
+}
+
+// WithImageLogSize sets the number of image events kept in the image log,
+// the oldest events are removed once the size is reached. A size of 0
+// disables the image log.
+func WithImageLogSize(n int) DBOpt {
+	return func(dbo *dbOptions) {
+		dbo.imageLogSize = n
+	}
+}
+
+// logImageEvent appends the event to the image log within the transaction
+func (m *DB) logImageEvent(tx *bolt.Tx, action, name string, target digest.Digest) error {
+	size := m.dbopts.imageLogSize
+	if size <= 0 {
+		return nil
+	}
+	bkt, err := createBucketIfNotExists(tx, bucketKeyVersion, bucketKeyObjectImageLog)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(ImageEvent{
+		Action: action,
+		Name:   name,
+		Target: target,
+		Time:   time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	seq, err := bkt.NextSequence()
+	if err != nil {
+		return err
+	}
+	if err := bkt.Put(imageLogKey(seq), b); err != nil {
+		return err
+	}
+
+	// Keys are ordered by sequence, remove the oldest beyond the size
+	if seq <= uint64(size) {
+		return nil
+	}
+	var (
+		oldest  = imageLogKey(seq - uint64(size))
+		expired [][]byte
+		c       = bkt.Cursor()
+	)
+	for k, _ := c.First(); k != nil && bytes.Compare(k, oldest) <= 0; k, _ = c.Next() {
+		expired = append(expired, k)
+	}
+	for _, k := range expired {
+		if err := bkt.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func imageLogKey(seq uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, seq)
+	return k
+}
+
+// ImageLog returns the logged image events from oldest to newest, only
+// returning events for the named image when name is not empty
+func (m *DB) ImageLog(ctx context.Context, name string) ([]ImageEvent, error) {
+	var events []ImageEvent
+	if err := view(ctx, m, func(tx *bolt.Tx) error {
+		bkt := getBucket(tx, bucketKeyVersion, bucketKeyObjectImageLog)
+		if bkt == nil {
+			return nil
+		}
+		return bkt.ForEach(func(k, v []byte) error {
+			var event ImageEvent
+			if err := json.Unmarshal(v, &event); err != nil {
+				return err
+			}
+			if name == "" || event.Name == name {
+				events = append(events, event)
+			}
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
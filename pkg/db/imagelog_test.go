@@ -0,0 +1,110 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"testing"
+
+	digest "github.com/opencontainers/go-digest"
+)
+
+func TestImageLog(t *testing.T) {
+	ctx, db := testDB(t, withDBOpts(WithImageLogSize(4)))
+	store := NewImageStore(db)
+
+	img := imageBase()
+	img.Name = "image-a"
+	img.Target.Digest = digest.FromString("first")
+	if _, err := store.Create(ctx, img); err != nil {
+		t.Fatal(err)
+	}
+	img.Target.Digest = digest.FromString("second")
+	if _, err := store.Update(ctx, img, "target"); err != nil {
+		t.Fatal(err)
+	}
+	other := imageBase()
+	other.Name = "image-b"
+	other.Target.Digest = digest.FromString("other")
+	if _, err := store.Create(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, img.Name); err != nil {
+		t.Fatal(err)
+	}
+
+	checkEvents := func(name string, expected []ImageEvent) {
+		t.Helper()
+		events, err := db.ImageLog(ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != len(expected) {
+			t.Fatalf("expected %d events, got %v", len(expected), events)
+		}
+		for i, e := range expected {
+			if events[i].Action != e.Action || events[i].Name != e.Name || events[i].Target != e.Target {
+				t.Errorf("event %d: expected %v, got %v", i, e, events[i])
+			}
+			if events[i].Time.IsZero() {
+				t.Errorf("event %d: time not set", i)
+			}
+		}
+	}
+	checkEvents("image-a", []ImageEvent{
+		{Action: ImageEventCreate, Name: "image-a", Target: digest.FromString("first")},
+		{Action: ImageEventUpdate, Name: "image-a", Target: digest.FromString("second")},
+		{Action: ImageEventDelete, Name: "image-a", Target: digest.FromString("second")},
+	})
+
+	// The oldest events are removed once the log size is reached
+	if err := store.Delete(ctx, other.Name); err != nil {
+		t.Fatal(err)
+	}
+	checkEvents("", []ImageEvent{
+		{Action: ImageEventUpdate, Name: "image-a", Target: digest.FromString("second")},
+		{Action: ImageEventCreate, Name: "image-b", Target: digest.FromString("other")},
+		{Action: ImageEventDelete, Name: "image-a", Target: digest.FromString("second")},
+		{Action: ImageEventDelete, Name: "image-b", Target: digest.FromString("other")},
+	})
+
+	// A failed change is not logged
+	if err := store.Delete(ctx, other.Name); err == nil {
+		t.Fatal("expected delete of missing image to fail")
+	}
+	checkEvents("image-b", []ImageEvent{
+		{Action: ImageEventCreate, Name: "image-b", Target: digest.FromString("other")},
+		{Action: ImageEventDelete, Name: "image-b", Target: digest.FromString("other")},
+	})
+}
+
+func TestImageLogDisabled(t *testing.T) {
+	ctx, db := testDB(t, withDBOpts(WithImageLogSize(0)))
+
+	img := imageBase()
+	img.Name = "image-a"
+	img.Target.Digest = digest.FromString("first")
+	if _, err := NewImageStore(db).Create(ctx, img); err != nil {
+		t.Fatal(err)
+	}
+	events, err := db.ImageLog(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("expected no events with the log disabled, got %v", events)
+	}
+}
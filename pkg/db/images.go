@@ -130,7 +130,10 @@ func (s *imageStore) Create(ctx context.Context, image images.Image) (images.Ima
 
 		image.CreatedAt = time.Now().UTC()
 		image.UpdatedAt = image.CreatedAt
-		return writeImage(ibkt, &image)
+		if err := writeImage(ibkt, &image); err != nil {
+			return err
+		}
+		return s.db.logImageEvent(tx, ImageEventCreate, image.Name, image.Target.Digest)
 	}); err != nil {
 		return images.Image{}, err
 	}
@@ -208,7 +211,10 @@ func (s *imageStore) Update(ctx context.Context, image images.Image, fieldpaths
 
 		updated.CreatedAt = createdat
 		updated.UpdatedAt = time.Now().UTC()
-		return writeImage(ibkt, &updated)
+		if err := writeImage(ibkt, &updated); err != nil {
+			return err
+		}
+		return s.db.logImageEvent(tx, ImageEventUpdate, updated.Name, updated.Target.Digest)
 	}); err != nil {
 		return images.Image{}, err
 	}
@@ -224,6 +230,11 @@ func (s *imageStore) Delete(ctx context.Context, name string, opts ...images.Del
 			return fmt.Errorf("image %q: %w", name, errdefs.ErrNotFound)
 		}
 
+		var target digest.Digest
+		if ibkt := bkt.Bucket([]byte(name)); ibkt != nil && ibkt.Bucket(bucketKeyTarget) != nil {
+			target = digest.Digest(ibkt.Bucket(bucketKeyTarget).Get(bucketKeyDigest))
+		}
+
 		if err := bkt.DeleteBucket([]byte(name)); err != nil {
 			if err == bolt.ErrBucketNotFound {
 				err = fmt.Errorf("image %q: %w", name, errdefs.ErrNotFound)
@@ -233,7 +244,7 @@ func (s *imageStore) Delete(ctx context.Context, name string, opts ...images.Del
 
 		atomic.AddUint32(&s.db.dirty, 1)
 
-		return nil
+		return s.db.logImageEvent(tx, ImageEventDelete, name, target)
 	})
 }
 
@@ -20,9 +20,11 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/containerd/containerd/content"
 	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/filters"
 	"github.com/containerd/containerd/images"
@@ -588,3 +590,143 @@ func checkImagesEqual(t *testing.T, a, b *images.Image, format string, args ...i
 		t.Fatalf("images not equal \n\t%v != \n\t%v: "+format, append([]interface{}{a, b}, args...)...)
 	}
 }
+
+func TestRemoveImages(t *testing.T) {
+	ctx, db := testEnv(t)
+	store := NewImageStore(db)
+
+	for _, name := range []string{"image-a", "image-b"} {
+		img := imageBase()
+		img.Name = name
+		img.Target.Digest = digest.FromString(name)
+		if _, err := store.Create(ctx, img); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names := []string{"image-a", "missing", "image-b"}
+
+	// Stops at the first failure without keep going
+	results := db.RemoveImages(ctx, names, false)
+	if len(results) != 2 || results[0].Err != nil || !errdefs.IsNotFound(results[1].Err) {
+		t.Fatalf("unexpected results without keep going: %v", results)
+	}
+	if _, err := store.Get(ctx, "image-b"); err != nil {
+		t.Fatalf("image-b should not be removed: %v", err)
+	}
+
+	// Continues past failures with keep going
+	results = db.RemoveImages(ctx, names, true)
+	if len(results) != len(names) {
+		t.Fatalf("expected result for each name, got %v", results)
+	}
+	for i, result := range results {
+		if result.Name != names[i] {
+			t.Fatalf("unexpected result order: %v", results)
+		}
+	}
+	if !errdefs.IsNotFound(results[0].Err) || !errdefs.IsNotFound(results[1].Err) || results[2].Err != nil {
+		t.Fatalf("unexpected results with keep going: %v", results)
+	}
+	if imgs, err := store.List(ctx); err != nil {
+		t.Fatal(err)
+	} else if len(imgs) != 0 {
+		t.Fatalf("expected all images removed, got %v", imgs)
+	}
+}
+
+func TestReclaimable(t *testing.T) {
+	ctx, db := testEnv(t)
+	cs := db.ContentStore()
+
+	lctx, remove, err := createLease(ctx, db, "lease-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(data string, labels map[string]string) ocispec.Descriptor {
+		t.Helper()
+		desc := ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageManifest,
+			Digest:    digest.FromString(data),
+			Size:      int64(len(data)),
+		}
+		if err := content.WriteBlob(lctx, cs, data, strings.NewReader(data), desc, content.WithLabels(labels)); err != nil {
+			t.Fatal(err)
+		}
+		return desc
+	}
+	var (
+		shared   = write("shared layer", nil)
+		configA  = write("config a", nil)
+		configB  = write("config b", nil)
+		orphan   = write("orphan", nil)
+		manifest = func(config ocispec.Descriptor) ocispec.Descriptor {
+			return write("manifest "+config.Digest.String(), map[string]string{
+				"containerd.io/gc.ref.content.config": config.Digest.String(),
+				"containerd.io/gc.ref.content.l.0":    shared.Digest.String(),
+			})
+		}
+		manifestA = manifest(configA)
+		manifestB = manifest(configB)
+	)
+	is := NewImageStore(db)
+	for name, target := range map[string]ocispec.Descriptor{"image-a": manifestA, "image-b": manifestB} {
+		if _, err := is.Create(ctx, images.Image{Name: name, Target: target}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := remove(); err != nil {
+		t.Fatal(err)
+	}
+
+	checkReclaimable := func(names []string, expected ...ocispec.Descriptor) {
+		t.Helper()
+		infos, err := db.Reclaimable(ctx, names...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual := map[digest.Digest]struct{}{}
+		for _, info := range infos {
+			actual[info.Digest] = struct{}{}
+		}
+		if len(actual) != len(expected) {
+			t.Fatalf("expected %d reclaimable for %v, got %v", len(expected), names, infos)
+		}
+		for _, desc := range expected {
+			if _, ok := actual[desc.Digest]; !ok {
+				t.Fatalf("expected %s to be reclaimable for %v", desc.Digest, names)
+			}
+		}
+	}
+
+	// The shared layer is only reclaimable when both images are removed
+	checkReclaimable([]string{"image-a"}, manifestA, configA)
+	checkReclaimable([]string{"image-a", "image-b"}, manifestA, configA, manifestB, configB, shared)
+
+	if _, err := db.Reclaimable(ctx, "image-a", "missing"); !errdefs.IsNotFound(err) {
+		t.Fatalf("expected not found for missing image, got %v", err)
+	}
+
+	// Nothing is removed by a dry run
+	if _, err := is.Get(ctx, "image-a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Garbage collection removes exactly the reclaimable content along with
+	// content which was already unreferenced
+	if err := is.Delete(ctx, "image-a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GarbageCollect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, desc := range []ocispec.Descriptor{manifestA, configA, orphan} {
+		if _, err := cs.Info(ctx, desc.Digest); !errdefs.IsNotFound(err) {
+			t.Fatalf("expected %s to be collected, got %v", desc.Digest, err)
+		}
+	}
+	for _, desc := range []ocispec.Descriptor{manifestB, configB, shared} {
+		if _, err := cs.Info(ctx, desc.Digest); err != nil {
+			t.Fatalf("expected %s to be retained: %v", desc.Digest, err)
+		}
+	}
+}
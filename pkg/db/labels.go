@@ -0,0 +1,61 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	digest "github.com/opencontainers/go-digest"
+)
+
+// labelGCContentRefExplicit prefixes content reference labels added
+// explicitly rather than derived from the media type of a descriptor
+const labelGCContentRefExplicit = "containerd.io/gc.ref.content.ref."
+
+// AddContentRefLabels adds labels referencing each digest, preventing the
+// referenced content from being garbage collected while the labeled content
+// is retained. Digests already referenced by an explicit reference label are
+// not added again. The updated labels are returned.
+func AddContentRefLabels(labels map[string]string, dgsts ...digest.Digest) map[string]string {
+	if len(dgsts) == 0 {
+		return labels
+	}
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	referenced := map[string]struct{}{}
+	for k, v := range labels {
+		if strings.HasPrefix(k, labelGCContentRefExplicit) {
+			referenced[v] = struct{}{}
+		}
+	}
+	var i int
+	for _, dgst := range dgsts {
+		if _, ok := referenced[dgst.String()]; ok {
+			continue
+		}
+		key := fmt.Sprintf("%s%d", labelGCContentRefExplicit, i)
+		for _, ok := labels[key]; ok; _, ok = labels[key] {
+			i++
+			key = fmt.Sprintf("%s%d", labelGCContentRefExplicit, i)
+		}
+		labels[key] = dgst.String()
+		referenced[dgst.String()] = struct{}{}
+	}
+	return labels
+}
@@ -0,0 +1,84 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/google/go-cmp/cmp"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestAddContentRefLabels(t *testing.T) {
+	labels := AddContentRefLabels(map[string]string{
+		"containerd.io/gc.ref.content.l.0":   dgst(1).String(),
+		"containerd.io/gc.ref.content.ref.0": dgst(2).String(),
+	}, dgst(2), dgst(3), dgst(3), dgst(4))
+
+	expected := map[string]string{
+		"containerd.io/gc.ref.content.l.0":   dgst(1).String(),
+		"containerd.io/gc.ref.content.ref.0": dgst(2).String(),
+		"containerd.io/gc.ref.content.ref.1": dgst(3).String(),
+		"containerd.io/gc.ref.content.ref.2": dgst(4).String(),
+	}
+	if diff := cmp.Diff(expected, labels); diff != "" {
+		t.Fatalf("unexpected labels (-want +got):\n%s", diff)
+	}
+}
+
+func TestContentRefLabelRetained(t *testing.T) {
+	ctx, db := testDB(t)
+	cs := db.ContentStore()
+
+	lctx, remove, err := createLease(ctx, db, "ref-lease")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write := func(mediaType string, b []byte, labels map[string]string) ocispec.Descriptor {
+		desc := ocispec.Descriptor{MediaType: mediaType, Digest: digest.FromBytes(b), Size: int64(len(b))}
+		if err := content.WriteBlob(lctx, cs, desc.Digest.String(), bytes.NewReader(b), desc, content.WithLabels(labels)); err != nil {
+			t.Fatal(err)
+		}
+		return desc
+	}
+	custom := write("application/vnd.example.custom", []byte("custom layer"), nil)
+	unreferenced := write("application/vnd.example.custom", []byte("unreferenced layer"), nil)
+	manifest := write(ocispec.MediaTypeImageManifest, []byte(`{"custom":true}`), AddContentRefLabels(nil, custom.Digest))
+
+	if _, err := NewImageStore(db).Create(ctx, images.Image{Name: "custom", Target: manifest}); err != nil {
+		t.Fatal(err)
+	}
+	if err := remove(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GarbageCollect(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cs.Info(ctx, custom.Digest); err != nil {
+		t.Fatalf("explicitly referenced content should be retained: %v", err)
+	}
+	if _, err := cs.Info(ctx, unreferenced.Digest); !errdefs.IsNotFound(err) {
+		t.Fatalf("expected unreferenced content to be collected, got %v", err)
+	}
+}
@@ -248,6 +248,26 @@ func (lm *leaseManager) ListResources(ctx context.Context, lease leases.Lease) (
 			}
 		}
 
+		// snapshot resources
+		if sbkt := topbkt.Bucket(bucketKeyObjectSnapshots); sbkt != nil {
+			if err := sbkt.ForEach(func(sk, sv []byte) error {
+				if sv != nil {
+					return nil
+				}
+
+				snbkt := sbkt.Bucket(sk)
+				return snbkt.ForEach(func(k, _ []byte) error {
+					rs = append(rs, leases.Resource{
+						ID:   string(k),
+						Type: fmt.Sprintf("%s/%s", bucketKeyObjectSnapshots, sk),
+					})
+					return nil
+				})
+			}); err != nil {
+				return err
+			}
+		}
+
 		// ingest resources
 		if lbkt := topbkt.Bucket(bucketKeyObjectIngests); lbkt != nil {
 			if err := lbkt.ForEach(func(k, _ []byte) error {
@@ -269,6 +289,85 @@ func (lm *leaseManager) ListResources(ctx context.Context, lease leases.Lease) (
 	return rs, nil
 }
 
+// UpdateLease replaces the labels of an existing lease with the labels of
+// the provided lease, such as to change the lease expiration.
+func (m *DB) UpdateLease(ctx context.Context, lease leases.Lease) (leases.Lease, error) {
+	if err := update(ctx, m, func(tx *bolt.Tx) error {
+		bkt := getBucket(tx, bucketKeyVersion, bucketKeyObjectLeases, []byte(lease.ID))
+		if bkt == nil {
+			return fmt.Errorf("lease %q: %w", lease.ID, errdefs.ErrNotFound)
+		}
+
+		if v := bkt.Get(bucketKeyCreatedAt); v != nil {
+			if err := lease.CreatedAt.UnmarshalBinary(v); err != nil {
+				return err
+			}
+		}
+
+		if err := boltutil.WriteLabels(bkt, lease.Labels); err != nil {
+			return err
+		}
+
+		// An earlier expiration may allow resources to be collected
+		atomic.AddUint32(&m.dirty, 1)
+
+		return nil
+	}); err != nil {
+		return leases.Lease{}, err
+	}
+	return lease, nil
+}
+
+// WithLease returns a context which holds resources created with it in the
+// lease with the given id, creating the lease if it does not yet exist. The
+// lease is not removed when the context is done and holds its resources
+// until it is deleted.
+func WithLease(ctx context.Context, lm leases.Manager, id string) (context.Context, error) {
+	if _, err := lm.Create(ctx, leases.WithID(id)); err != nil && !errdefs.IsAlreadyExists(err) {
+		return nil, err
+	}
+	return leases.WithLease(ctx, id), nil
+}
+
+func addSnapshotLease(ctx context.Context, tx *bolt.Tx, snapshotter, key string) error {
+	lid, ok := leases.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	bkt := getBucket(tx, bucketKeyVersion, bucketKeyObjectLeases, []byte(lid))
+	if bkt == nil {
+		return fmt.Errorf("lease does not exist: %w", errdefs.ErrNotFound)
+	}
+
+	bkt, err := bkt.CreateBucketIfNotExists(bucketKeyObjectSnapshots)
+	if err != nil {
+		return err
+	}
+
+	bkt, err = bkt.CreateBucketIfNotExists([]byte(snapshotter))
+	if err != nil {
+		return err
+	}
+
+	return bkt.Put([]byte(key), nil)
+}
+
+func removeSnapshotLease(ctx context.Context, tx *bolt.Tx, snapshotter, key string) error {
+	lid, ok := leases.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	bkt := getBucket(tx, bucketKeyVersion, bucketKeyObjectLeases, []byte(lid), bucketKeyObjectSnapshots, []byte(snapshotter))
+	if bkt == nil {
+		// Key does not exist so we return nil
+		return nil
+	}
+
+	return bkt.Delete([]byte(key))
+}
+
 func addContentLease(ctx context.Context, tx *bolt.Tx, dgst digest.Digest) error {
 	lid, ok := leases.FromContext(ctx)
 	if !ok {
@@ -363,6 +462,10 @@ func parseLeaseResource(r leases.Resource) ([]string, string, error) {
 			}
 			ref = dgst.String()
 		}
+	case string(bucketKeyObjectSnapshots):
+		if len(keys) != 2 {
+			return nil, "", fmt.Errorf("invalid snapshot resource type %s: %w", typ, errdefs.ErrInvalidArgument)
+		}
 	default:
 		return nil, "", fmt.Errorf("resource type %s not supported yet: %w", typ, errdefs.ErrNotImplemented)
 	}
@@ -21,6 +21,7 @@ import (
 	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/leases"
@@ -336,7 +337,15 @@ func TestLeaseResource(t *testing.T) {
 				ID:   "RstMI3X8vguKoPFkmIStZ5fQFI7F1L0o",
 				Type: "snapshots/overlayfs",
 			},
-			err: errdefs.ErrNotImplemented,
+		},
+		{
+			// snapshot resources must name only the snapshotter
+			lease: lease,
+			resource: leases.Resource{
+				ID:   "HMemOhlygombYhkhHhAZj5aRbDy2a3z2",
+				Type: "snapshots/overlayfs/type1",
+			},
+			err: errdefs.ErrInvalidArgument,
 		},
 	}
 
@@ -398,3 +407,65 @@ func TestLeaseResource(t *testing.T) {
 		t.Fatalf("expected (%d) resources, but got (%d)", len(idxList)-1, len(gotList))
 	}
 }
+
+func TestUpdateLease(t *testing.T) {
+	ctx, db := testEnv(t)
+
+	lm := NewLeaseManager(db)
+
+	if _, err := db.UpdateLease(ctx, leases.Lease{ID: "missing"}); !errors.Is(err, errdefs.ErrNotFound) {
+		t.Fatalf("expected not found updating missing lease, got %v", err)
+	}
+
+	created, err := lm.Create(ctx, leases.WithID("l1"), leases.WithExpiration(time.Minute), leases.WithLabels(map[string]string{"a": "b"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := time.Parse(time.RFC3339, created.Labels[string(labelGCExpire)])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updated, err := db.UpdateLease(ctx, leases.Lease{
+		ID: "l1",
+		Labels: map[string]string{
+			string(labelGCExpire): time.Now().Add(time.Hour).Format(time.RFC3339),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !updated.CreatedAt.Equal(created.CreatedAt) {
+		t.Fatalf("expected created at %s, got %s", created.CreatedAt, updated.CreatedAt)
+	}
+
+	listed, err := lm.List(ctx, "id==l1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 {
+		t.Fatalf("expected 1 lease, got %d", len(listed))
+	}
+	if _, ok := listed[0].Labels["a"]; ok {
+		t.Fatal("expected label a to be removed")
+	}
+	after, err := time.Parse(time.RFC3339, listed[0].Labels[string(labelGCExpire)])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !after.After(before) {
+		t.Fatalf("expected expiration after %s, got %s", before, after)
+	}
+
+	// Removing the expire label makes the lease permanent
+	if _, err := db.UpdateLease(ctx, leases.Lease{ID: "l1"}); err != nil {
+		t.Fatal(err)
+	}
+	listed, err = lm.List(ctx, "id==l1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed[0].Labels) != 0 {
+		t.Fatalf("expected no labels, got %v", listed[0].Labels)
+	}
+}
@@ -0,0 +1,203 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/filters"
+	"github.com/containerd/containerd/images"
+	digest "github.com/opencontainers/go-digest"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Page selects a window of a listing. Listing starts after the entry
+// identified by Token, or from the beginning when no token is given,
+// skips Offset matching entries and returns at most Limit entries.
+// A Limit of zero returns all remaining entries. Only entries last updated
+// within the Updated range are listed.
+type Page struct {
+	Limit   int
+	Offset  int
+	Token   string
+	Updated TimeRange
+}
+
+// TimeRange matches times from Since, inclusive, until Until, exclusive.
+// A zero Since or Until leaves the range unbounded on that side.
+type TimeRange struct {
+	Since time.Time
+	Until time.Time
+}
+
+// Match returns whether the time is within the range
+func (r TimeRange) Match(t time.Time) bool {
+	if !r.Since.IsZero() && t.Before(r.Since) {
+		return false
+	}
+	if !r.Until.IsZero() && !t.Before(r.Until) {
+		return false
+	}
+	return true
+}
+
+// ListImages returns a page of images matching the filters, ordered by
+// name, along with the token to resume listing from. The returned token
+// is empty once there are no more matching images.
+func (m *DB) ListImages(ctx context.Context, page Page, fs ...string) ([]images.Image, string, error) {
+	filter, err := filters.ParseAll(fs...)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", err.Error(), errdefs.ErrInvalidArgument)
+	}
+
+	var (
+		imgs []images.Image
+		next string
+	)
+	if err := view(ctx, m, func(tx *bolt.Tx) error {
+		bkt := getImagesBucket(tx)
+		if bkt == nil {
+			return nil // empty store
+		}
+
+		next, err = paginate(bkt, page, func(k []byte) (func(), error) {
+			image := images.Image{
+				Name: string(k),
+			}
+			if err := readImage(&image, bkt.Bucket(k)); err != nil {
+				return nil, err
+			}
+			if !page.Updated.Match(image.UpdatedAt) || !filter.Match(adaptImage(image)) {
+				return nil, nil
+			}
+			return func() { imgs = append(imgs, image) }, nil
+		})
+		return err
+	}); err != nil {
+		return nil, "", err
+	}
+
+	return imgs, next, nil
+}
+
+// ListContent returns a page of content info matching the filters, ordered
+// by digest, along with the token to resume listing from. The returned token
+// is empty once there is no more matching content.
+func (m *DB) ListContent(ctx context.Context, page Page, fs ...string) ([]content.Info, string, error) {
+	filter, err := filters.ParseAll(fs...)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", err.Error(), errdefs.ErrInvalidArgument)
+	}
+
+	var (
+		infos []content.Info
+		next  string
+	)
+	if err := view(ctx, m, func(tx *bolt.Tx) error {
+		bkt := getBlobsBucket(tx)
+		if bkt == nil {
+			return nil
+		}
+
+		next, err = paginate(bkt, page, func(k []byte) (func(), error) {
+			dgst, err := digest.Parse(string(k))
+			if err != nil {
+				// Not a digest, skip
+				return nil, nil
+			}
+			info := content.Info{
+				Digest: dgst,
+			}
+			if err := readInfo(&info, bkt.Bucket(k)); err != nil {
+				return nil, err
+			}
+			if !page.Updated.Match(info.UpdatedAt) || !filter.Match(adaptContentInfo(info)) {
+				return nil, nil
+			}
+			return func() { infos = append(infos, info) }, nil
+		})
+		return err
+	}); err != nil {
+		return nil, "", err
+	}
+
+	return infos, next, nil
+}
+
+// paginate walks the child buckets of bkt in key order for the page. The
+// match function is called for each key and returns a function to add the
+// entry when it matches. Keys are only read until the page is filled and
+// the next match is found, the returned token identifies the last added
+// key when more matching keys remain.
+func paginate(bkt *bolt.Bucket, page Page, match func(k []byte) (func(), error)) (string, error) {
+	if page.Limit < 0 || page.Offset < 0 {
+		return "", fmt.Errorf("invalid page limit %d or offset %d: %w", page.Limit, page.Offset, errdefs.ErrInvalidArgument)
+	}
+
+	var (
+		c    = bkt.Cursor()
+		k, v []byte
+	)
+	if page.Token != "" {
+		after, err := base64.RawURLEncoding.DecodeString(page.Token)
+		if err != nil || len(after) == 0 {
+			return "", fmt.Errorf("invalid page token %q: %w", page.Token, errdefs.ErrInvalidArgument)
+		}
+		k, v = c.Seek(after)
+		if bytes.Equal(k, after) {
+			k, v = c.Next()
+		}
+	} else {
+		k, v = c.First()
+	}
+
+	var (
+		skip  = page.Offset
+		count int
+		last  []byte
+	)
+	for ; k != nil; k, v = c.Next() {
+		if v != nil {
+			continue // not a bucket
+		}
+		add, err := match(k)
+		if err != nil {
+			return "", err
+		}
+		if add == nil {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		if page.Limit > 0 && count == page.Limit {
+			return base64.RawURLEncoding.EncodeToString(last), nil
+		}
+		add()
+		count++
+		last = append(last[:0], k...)
+	}
+
+	return "", nil
+}
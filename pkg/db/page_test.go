@@ -0,0 +1,382 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/metadata/boltutil"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestListImagesPage(t *testing.T) {
+	ctx, db := testDB(t)
+	is := NewImageStore(db)
+
+	const count = 250
+	var names []string
+	for i := 0; i < count; i++ {
+		name := fmt.Sprintf("registry.io/image-%d:latest", i)
+		labels := map[string]string{}
+		if i%3 == 0 {
+			labels["page"] = "third"
+		}
+		if _, err := is.Create(ctx, images.Image{
+			Name:   name,
+			Labels: labels,
+			Target: ocispec.Descriptor{
+				MediaType: ocispec.MediaTypeImageManifest,
+				Digest:    digest.FromString(name),
+				Size:      10,
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var (
+		listed []string
+		token  string
+		pages  int
+	)
+	for {
+		imgs, next, err := db.ListImages(ctx, Page{Limit: 40, Token: token})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next != "" && len(imgs) != 40 {
+			t.Fatalf("expected full page before end, got %d", len(imgs))
+		}
+		for _, img := range imgs {
+			listed = append(listed, img.Name)
+		}
+		pages++
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	if pages != 7 {
+		t.Fatalf("expected 7 pages, got %d", pages)
+	}
+	checkNames(t, listed, names)
+
+	// Offset skips entries from the start of the listing
+	imgs, next, err := db.ListImages(ctx, Page{Limit: 10, Offset: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next == "" {
+		t.Fatal("expected next token")
+	}
+	listed = listed[:0]
+	for _, img := range imgs {
+		listed = append(listed, img.Name)
+	}
+	checkNames(t, listed, names[100:110])
+
+	// Filtered listing should end without a token after the last match
+	var filtered []string
+	token = ""
+	for {
+		imgs, next, err := db.ListImages(ctx, Page{Limit: 21, Token: token}, "labels.page==third")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, img := range imgs {
+			filtered = append(filtered, img.Name)
+		}
+		if next == "" {
+			break
+		}
+		if len(imgs) == 0 {
+			t.Fatal("unexpected empty page with next token")
+		}
+		token = next
+	}
+	if len(filtered) != 84 {
+		t.Fatalf("expected 84 filtered images, got %d", len(filtered))
+	}
+
+	if _, _, err := db.ListImages(ctx, Page{Token: "!invalid"}); !errdefs.IsInvalidArgument(err) {
+		t.Fatalf("expected invalid argument for bad token, got %v", err)
+	}
+}
+
+func TestListImagesFilter(t *testing.T) {
+	ctx, db := testDB(t)
+	is := NewImageStore(db)
+
+	for _, img := range []struct {
+		name      string
+		mediaType string
+	}{
+		{"docker.io/library/alpine:latest", ocispec.MediaTypeImageIndex},
+		{"docker.io/library/busybox:latest", ocispec.MediaTypeImageManifest},
+		{"registry.io/example:latest", ocispec.MediaTypeImageIndex},
+		{"registry.io/other:latest", ocispec.MediaTypeImageManifest},
+	} {
+		if _, err := is.Create(ctx, images.Image{
+			Name: img.name,
+			Target: ocispec.Descriptor{
+				MediaType: img.mediaType,
+				Digest:    digest.FromString(img.name),
+				Size:      10,
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		filters  []string
+		expected []string
+	}{
+		{
+			[]string{`name~=^docker.io/library/`},
+			[]string{"docker.io/library/alpine:latest", "docker.io/library/busybox:latest"},
+		},
+		{
+			[]string{"target.mediatype==" + ocispec.MediaTypeImageIndex},
+			[]string{"docker.io/library/alpine:latest", "registry.io/example:latest"},
+		},
+		{
+			// Images matching any filter are listed
+			[]string{`name~=^docker.io/library/`, "target.mediatype==" + ocispec.MediaTypeImageIndex},
+			[]string{"docker.io/library/alpine:latest", "docker.io/library/busybox:latest", "registry.io/example:latest"},
+		},
+	} {
+		imgs, _, err := db.ListImages(ctx, Page{}, tc.filters...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var listed []string
+		for _, img := range imgs {
+			listed = append(listed, img.Name)
+		}
+		if fmt.Sprint(listed) != fmt.Sprint(tc.expected) {
+			t.Errorf("filters %v: listed %v, expected %v", tc.filters, listed, tc.expected)
+		}
+	}
+}
+
+func TestListContentPage(t *testing.T) {
+	ctx, db := testDB(t)
+	cs := db.ContentStore()
+
+	lctx, remove, err := createLease(ctx, db, "page-lease")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remove()
+
+	const count = 120
+	var dgsts []string
+	for i := 0; i < count; i++ {
+		b := []byte(fmt.Sprintf("content %d", i))
+		desc := ocispec.Descriptor{Size: int64(len(b)), Digest: digest.FromBytes(b)}
+		if err := content.WriteBlob(lctx, cs, desc.Digest.String(), bytes.NewReader(b), desc); err != nil {
+			t.Fatal(err)
+		}
+		dgsts = append(dgsts, desc.Digest.String())
+	}
+	sort.Strings(dgsts)
+
+	var (
+		listed []string
+		token  string
+	)
+	for {
+		infos, next, err := db.ListContent(ctx, Page{Limit: 25, Token: token})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, info := range infos {
+			listed = append(listed, info.Digest.String())
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	checkNames(t, listed, dgsts)
+
+	infos, next, err := db.ListContent(ctx, Page{Offset: count - 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != "" {
+		t.Fatalf("unexpected next token %q", next)
+	}
+	listed = listed[:0]
+	for _, info := range infos {
+		listed = append(listed, info.Digest.String())
+	}
+	checkNames(t, listed, dgsts[count-5:])
+}
+
+func TestListContentFilter(t *testing.T) {
+	ctx, db := testDB(t)
+	cs := db.ContentStore()
+
+	lctx, remove, err := createLease(ctx, db, "filter-lease")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remove()
+
+	var dgsts []string
+	for _, data := range []string{"a", "bb", "cc"} {
+		b := []byte(data)
+		desc := ocispec.Descriptor{Size: int64(len(b)), Digest: digest.FromBytes(b)}
+		if err := content.WriteBlob(lctx, cs, desc.Digest.String(), bytes.NewReader(b), desc); err != nil {
+			t.Fatal(err)
+		}
+		dgsts = append(dgsts, desc.Digest.String())
+	}
+	if err := db.AddContentRoot(ctx, digest.Digest(dgsts[2])); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		filters  []string
+		expected []string
+	}{
+		{[]string{`labels."containerd.io/gc.root"`}, dgsts[2:]},
+		{[]string{"size==2"}, dgsts[1:]},
+		{[]string{"size==1", "digest==" + dgsts[2]}, []string{dgsts[0], dgsts[2]}},
+		{[]string{"size==2,digest==" + dgsts[1]}, dgsts[1:2]},
+	} {
+		infos, _, err := db.ListContent(ctx, Page{}, tc.filters...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var listed []string
+		for _, info := range infos {
+			listed = append(listed, info.Digest.String())
+		}
+		expected := append([]string(nil), tc.expected...)
+		sort.Strings(listed)
+		sort.Strings(expected)
+		checkNames(t, listed, expected)
+	}
+}
+
+func checkNames(t *testing.T, actual, expected []string) {
+	t.Helper()
+	if len(actual) != len(expected) {
+		t.Fatalf("listed %d entries, expected %d", len(actual), len(expected))
+	}
+	for i := range actual {
+		if actual[i] != expected[i] {
+			t.Fatalf("entry %d is %q, expected %q", i, actual[i], expected[i])
+		}
+	}
+}
+
+func TestTimeRange(t *testing.T) {
+	var (
+		start = time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+		end   = start.Add(time.Hour)
+	)
+	for _, tc := range []struct {
+		name     string
+		r        TimeRange
+		t        time.Time
+		expected bool
+	}{
+		{"Unbounded", TimeRange{}, start, true},
+		{"SinceInclusive", TimeRange{Since: start}, start, true},
+		{"BeforeSince", TimeRange{Since: start}, start.Add(-time.Nanosecond), false},
+		{"UntilExclusive", TimeRange{Until: end}, end, false},
+		{"BeforeUntil", TimeRange{Until: end}, end.Add(-time.Nanosecond), true},
+		{"Within", TimeRange{Since: start, Until: end}, start.Add(time.Minute), true},
+		{"After", TimeRange{Since: start, Until: end}, end.Add(time.Minute), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := tc.r.Match(tc.t); actual != tc.expected {
+				t.Fatalf("expected match %t for %s in [%s, %s)", tc.expected, tc.t, tc.r.Since, tc.r.Until)
+			}
+		})
+	}
+}
+
+func TestListImagesUpdated(t *testing.T) {
+	ctx, db := testDB(t)
+	is := NewImageStore(db)
+
+	base := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	var names []string
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("registry.io/image-%d:latest", i)
+		if _, err := is.Create(ctx, images.Image{
+			Name: name,
+			Target: ocispec.Descriptor{
+				MediaType: ocispec.MediaTypeImageManifest,
+				Digest:    digest.FromString(name),
+				Size:      10,
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		// Set controlled timestamps one hour apart
+		updated := base.Add(time.Duration(i) * time.Hour)
+		if err := update(ctx, db, func(tx *bolt.Tx) error {
+			return boltutil.WriteTimestamps(getImagesBucket(tx).Bucket([]byte(name)), base, updated)
+		}); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		r        TimeRange
+		expected []string
+	}{
+		{"Since", TimeRange{Since: base.Add(2 * time.Hour)}, names[2:]},
+		{"Until", TimeRange{Until: base.Add(2 * time.Hour)}, names[:2]},
+		{"Between", TimeRange{Since: base.Add(time.Hour), Until: base.Add(3 * time.Hour)}, names[1:3]},
+		{"Empty", TimeRange{Since: base.Add(5 * time.Hour)}, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			imgs, next, err := db.ListImages(ctx, Page{Updated: tc.r})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if next != "" {
+				t.Fatalf("unexpected next token %q", next)
+			}
+			var listed []string
+			for _, img := range imgs {
+				listed = append(listed, img.Name)
+			}
+			checkNames(t, listed, tc.expected)
+		})
+	}
+}
@@ -0,0 +1,76 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"io"
+
+	"github.com/containerd/containerd/pkg/transfer"
+)
+
+// ProgressInterval is the number of bytes read between progress events
+const ProgressInterval = 1 << 20
+
+type progressReader struct {
+	r     io.Reader
+	ref   string
+	total int64
+	pf    transfer.ProgressFunc
+
+	n    int64
+	next int64
+	done bool
+}
+
+// NewProgressReader returns a reader which reports the bytes read from r to
+// the progress function, such as for content copied to a content.Writer
+// without the transfer service. A "writing" event is sent each time another
+// ProgressInterval bytes are read and a "complete" event is sent once r
+// returns io.EOF. The total may be 0 when the size is not known.
+func NewProgressReader(r io.Reader, ref string, total int64, pf transfer.ProgressFunc) io.Reader {
+	return &progressReader{
+		r:     r,
+		ref:   ref,
+		total: total,
+		pf:    pf,
+		next:  ProgressInterval,
+	}
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	pr.n += int64(n)
+	if pr.n >= pr.next {
+		pr.pf(transfer.Progress{
+			Event:    "writing",
+			Name:     pr.ref,
+			Progress: pr.n,
+			Total:    pr.total,
+		})
+		pr.next = (pr.n/ProgressInterval + 1) * ProgressInterval
+	}
+	if err == io.EOF && !pr.done {
+		pr.done = true
+		pr.pf(transfer.Progress{
+			Event:    "complete",
+			Name:     pr.ref,
+			Progress: pr.n,
+			Total:    pr.total,
+		})
+	}
+	return n, err
+}
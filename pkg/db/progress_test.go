@@ -0,0 +1,77 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/pkg/transfer"
+	"github.com/google/go-cmp/cmp"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestProgressReader(t *testing.T) {
+	ctx, db := testDB(t)
+	cs := db.ContentStore()
+
+	b := bytes.Repeat([]byte("lctr"), (2*ProgressInterval+ProgressInterval/2)/4)
+	desc := ocispec.Descriptor{
+		MediaType: "application/octet-stream",
+		Digest:    digest.FromBytes(b),
+		Size:      int64(len(b)),
+	}
+
+	var events []transfer.Progress
+	pf := func(p transfer.Progress) {
+		events = append(events, p)
+	}
+	w, err := content.OpenWriter(ctx, cs, content.WithRef("progress"), content.WithDescriptor(desc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	// Copy with a buffer which does not evenly divide the interval
+	r := NewProgressReader(bytes.NewReader(b), "progress", desc.Size, pf)
+	if _, err := io.CopyBuffer(w, struct{ io.Reader }{r}, make([]byte, 3000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Commit(ctx, desc.Size, desc.Digest); err != nil {
+		t.Fatal(err)
+	}
+
+	// Events are sent once each interval is crossed, reporting the bytes
+	// read so far
+	crossed := func(offset int64) int64 {
+		return (offset/3000 + 1) * 3000
+	}
+	expected := []transfer.Progress{
+		{Event: "writing", Name: "progress", Progress: crossed(ProgressInterval - 1), Total: desc.Size},
+		{Event: "writing", Name: "progress", Progress: crossed(2*ProgressInterval - 1), Total: desc.Size},
+		{Event: "complete", Name: "progress", Progress: desc.Size, Total: desc.Size},
+	}
+	if diff := cmp.Diff(expected, events); diff != "" {
+		t.Fatalf("unexpected progress events (-want +got):\n%s", diff)
+	}
+
+	if _, err := cs.Info(ctx, desc.Digest); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,123 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/lcontainerd/pkg/iobuf"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// WriteTarget writes the manifest or index blob used as the target of an
+// image along with its labels. A blob already in the store, such as one left
+// by an interrupted create which wrote the blob but did not create the image,
+// is reused and the labels are added to it. Writing the blob again would not
+// apply the labels to the existing blob, leaving content referenced only by
+// the new labels unprotected from garbage collection.
+func WriteTarget(ctx context.Context, cs content.Store, b []byte, desc ocispec.Descriptor, labels map[string]string) error {
+	info, err := cs.Info(ctx, desc.Digest)
+	if err != nil {
+		if !errdefs.IsNotFound(err) {
+			return err
+		}
+		return content.WriteBlob(ctx, cs, desc.Digest.String()+"-ingest", bytes.NewReader(b), desc, content.WithLabels(labels))
+	}
+	if info.Size != desc.Size {
+		return fmt.Errorf("existing content %s has size %d, expected %d: %w", desc.Digest, info.Size, desc.Size, errdefs.ErrFailedPrecondition)
+	}
+
+	var fieldpaths []string
+	for k, v := range labels {
+		if info.Labels[k] == v {
+			continue
+		}
+		if info.Labels == nil {
+			info.Labels = map[string]string{}
+		}
+		info.Labels[k] = v
+		fieldpaths = append(fieldpaths, "labels."+k)
+	}
+	if len(fieldpaths) == 0 {
+		return nil
+	}
+	_, err = cs.Update(ctx, info, fieldpaths...)
+	return err
+}
+
+// OrphanedManifests returns the manifests and indexes which are not
+// referenced by any root, image, lease, or other content. These are most
+// likely left by an interrupted image create or update and are removed by
+// the next garbage collection. The media type of each is detected from its
+// content, blobs too large to be a manifest are not read.
+func (m *DB) OrphanedManifests(ctx context.Context) ([]ocispec.Descriptor, error) {
+	orphans, err := m.Orphans(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifests []ocispec.Descriptor
+	for _, info := range orphans {
+		desc := ocispec.Descriptor{
+			Digest: info.Digest,
+			Size:   info.Size,
+		}
+		b, err := iobuf.ReadBlob(ctx, m.cs, desc)
+		if err != nil {
+			if errors.Is(err, iobuf.ErrBlobTooLarge) || errdefs.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		if desc.MediaType = manifestMediaType(b); desc.MediaType != "" {
+			manifests = append(manifests, desc)
+		}
+	}
+	return manifests, nil
+}
+
+// manifestMediaType returns the media type of the manifest or index, or an
+// empty string when the blob is not a manifest or index
+func manifestMediaType(b []byte) string {
+	var mt struct {
+		SchemaVersion int             `json:"schemaVersion"`
+		MediaType     string          `json:"mediaType"`
+		Config        json.RawMessage `json:"config"`
+		Manifests     json.RawMessage `json:"manifests"`
+	}
+	if err := json.Unmarshal(b, &mt); err != nil || mt.SchemaVersion != 2 {
+		return ""
+	}
+	switch {
+	case images.IsManifestType(mt.MediaType), images.IsIndexType(mt.MediaType):
+		return mt.MediaType
+	case mt.MediaType != "":
+		return ""
+	case mt.Manifests != nil:
+		return ocispec.MediaTypeImageIndex
+	case mt.Config != nil:
+		return ocispec.MediaTypeImageManifest
+	}
+	return ""
+}
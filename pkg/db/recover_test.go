@@ -0,0 +1,99 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestInterruptedCreate(t *testing.T) {
+	ctx, db := testDB(t)
+	cs := db.ContentStore()
+
+	writeBlob := func(data []byte) ocispec.Descriptor {
+		t.Helper()
+		desc := ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageConfig,
+			Size:      int64(len(data)),
+			Digest:    digest.FromBytes(data),
+		}
+		if err := content.WriteBlob(ctx, cs, desc.Digest.String(), bytes.NewReader(data), desc); err != nil {
+			t.Fatal(err)
+		}
+		return desc
+	}
+	config := writeBlob([]byte(`{"architecture":"amd64","os":"linux"}`))
+	extra := writeBlob([]byte("extra content"))
+
+	b, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []ocispec.Descriptor{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Size:      int64(len(b)),
+		Digest:    digest.FromBytes(b),
+	}
+	labels := map[string]string{"containerd.io/gc.ref.content.config": config.Digest.String()}
+
+	// A create interrupted after writing the manifest leaves it orphaned
+	if err := WriteTarget(ctx, cs, b, target, labels); err != nil {
+		t.Fatal(err)
+	}
+	orphaned, err := db.OrphanedManifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphaned) != 1 || orphaned[0].Digest != target.Digest || orphaned[0].MediaType != ocispec.MediaTypeImageManifest {
+		t.Fatalf("expected orphaned manifest %s, got %v", target.Digest, orphaned)
+	}
+
+	// Retrying the create with an additional reference reuses the manifest
+	if err := WriteTarget(ctx, cs, b, target, AddContentRefLabels(labels, extra.Digest)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewImageStore(db).Create(ctx, images.Image{Name: "image", Target: target}); err != nil {
+		t.Fatal(err)
+	}
+	if orphaned, err := db.OrphanedManifests(ctx); err != nil {
+		t.Fatal(err)
+	} else if len(orphaned) != 0 {
+		t.Fatalf("expected no orphaned manifests, got %v", orphaned)
+	}
+
+	if _, err := db.GarbageCollect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, desc := range []ocispec.Descriptor{target, config, extra} {
+		if _, err := cs.Info(ctx, desc.Digest); err != nil {
+			t.Fatalf("expected %s to be retained: %v", desc.Digest, err)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/containerd/containerd/content"
+)
+
+// ImageRemoval is the result of removing a single image
+type ImageRemoval struct {
+	Name string
+	Err  error
+}
+
+// RemoveImages deletes the named images in order, returning the result for
+// each attempted name. Unless keepGoing is set, removal stops at the first
+// error. Removed content is not collected until the next garbage collection,
+// allowing a batch of removals to be followed by a single collection.
+func (m *DB) RemoveImages(ctx context.Context, names []string, keepGoing bool) []ImageRemoval {
+	var (
+		is      = NewImageStore(m)
+		results = make([]ImageRemoval, 0, len(names))
+	)
+	for _, name := range names {
+		err := is.Delete(ctx, name)
+		results = append(results, ImageRemoval{Name: name, Err: err})
+		if err != nil && !keepGoing {
+			break
+		}
+	}
+	return results
+}
+
+// Reclaimable returns the content which would be removed by garbage
+// collection if the named images were removed. Content which remains
+// referenced by another image, lease, or root is not included, nor is
+// content which is already unreferenced.
+func (m *DB) Reclaimable(ctx context.Context, names ...string) ([]content.Info, error) {
+	is := NewImageStore(m)
+	exclude := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		if _, err := is.Get(ctx, name); err != nil {
+			return nil, err
+		}
+		exclude[name] = struct{}{}
+	}
+
+	c := startGCContext(ctx, nil)
+	c.contentCreatedAfter = m.minContentCreated(time.Now())
+	marked, err := m.getMarked(ctx, c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check content references: %w", err)
+	}
+	c.excludeImages = exclude
+	remaining, err := m.getMarked(ctx, c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check content references: %w", err)
+	}
+
+	var reclaimable []content.Info
+	if err := m.cs.Walk(ctx, func(info content.Info) error {
+		if contentMarked(marked, info.Digest) && !contentMarked(remaining, info.Digest) {
+			reclaimable = append(reclaimable, info)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return reclaimable, nil
+}
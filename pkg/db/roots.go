@@ -0,0 +1,88 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/containerd/containerd/content"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// AddContentRoot marks the content as a garbage collection root, preventing
+// the content and anything it references from being collected
+func (m *DB) AddContentRoot(ctx context.Context, dgst digest.Digest) error {
+	_, err := m.cs.Update(ctx, content.Info{
+		Digest: dgst,
+		Labels: map[string]string{
+			string(labelGCRoot): time.Now().UTC().Format(time.RFC3339),
+		},
+	}, "labels."+string(labelGCRoot))
+	return err
+}
+
+// RemoveContentRoot removes the garbage collection root mark from the
+// content. Returns whether the content is now collectible, meaning it is
+// no longer referenced by any other root, image, or lease.
+func (m *DB) RemoveContentRoot(ctx context.Context, dgst digest.Digest) (bool, error) {
+	if _, err := m.cs.Update(ctx, content.Info{
+		Digest: dgst,
+	}, "labels."+string(labelGCRoot)); err != nil {
+		return false, err
+	}
+
+	marked, err := m.getMarked(ctx, startGCContext(ctx, nil))
+	if err != nil {
+		return false, fmt.Errorf("failed to check content references: %w", err)
+	}
+	return !contentMarked(marked, dgst), nil
+}
+
+// ContentRoots returns the info for all content marked as a garbage
+// collection root
+func (m *DB) ContentRoots(ctx context.Context) ([]content.Info, error) {
+	var roots []content.Info
+	if err := m.cs.Walk(ctx, func(info content.Info) error {
+		roots = append(roots, info)
+		return nil
+	}, fmt.Sprintf("labels.%q", labelGCRoot)); err != nil {
+		return nil, err
+	}
+	return roots, nil
+}
+
+// Orphans returns the info for all content which is not referenced by any
+// root, image, or lease and would be removed by the next garbage collection
+func (m *DB) Orphans(ctx context.Context) ([]content.Info, error) {
+	marked, err := m.getMarked(ctx, startGCContext(ctx, nil))
+	if err != nil {
+		return nil, fmt.Errorf("failed to check content references: %w", err)
+	}
+
+	var orphans []content.Info
+	if err := m.cs.Walk(ctx, func(info content.Info) error {
+		if !contentMarked(marked, info.Digest) {
+			orphans = append(orphans, info)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return orphans, nil
+}
@@ -0,0 +1,193 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestContentRoots(t *testing.T) {
+	ctx, db := testDB(t)
+	cs := db.ContentStore()
+
+	child := []byte("child content")
+	childDesc := ocispec.Descriptor{Size: int64(len(child)), Digest: digest.FromBytes(child)}
+	parent := []byte("parent content")
+	parentDesc := ocispec.Descriptor{Size: int64(len(parent)), Digest: digest.FromBytes(parent)}
+
+	lctx, remove, err := createLease(ctx, db, "lease-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := content.WriteBlob(lctx, cs, "child", bytes.NewReader(child), childDesc); err != nil {
+		t.Fatal(err)
+	}
+	if err := content.WriteBlob(lctx, cs, "parent", bytes.NewReader(parent), parentDesc,
+		content.WithLabels(map[string]string{"containerd.io/gc.ref.content.0": childDesc.Digest.String()})); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dgst := range []digest.Digest{parentDesc.Digest, childDesc.Digest} {
+		if err := db.AddContentRoot(ctx, dgst); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.AddContentRoot(ctx, digest.FromString("missing")); !errdefs.IsNotFound(err) {
+		t.Fatalf("expected not found adding missing root, got %v", err)
+	}
+	checkRoots(ctx, t, db, parentDesc.Digest, childDesc.Digest)
+
+	if err := remove(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GarbageCollect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, dgst := range []digest.Digest{parentDesc.Digest, childDesc.Digest} {
+		if _, err := cs.Info(ctx, dgst); err != nil {
+			t.Fatalf("root content should not be collected: %v", err)
+		}
+	}
+
+	// Child is still referenced by the parent root
+	collectible, err := db.RemoveContentRoot(ctx, childDesc.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if collectible {
+		t.Fatal("child should not be collectible while referenced by a root")
+	}
+	checkRoots(ctx, t, db, parentDesc.Digest)
+
+	collectible, err = db.RemoveContentRoot(ctx, parentDesc.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !collectible {
+		t.Fatal("parent should be collectible after removing last root")
+	}
+	checkRoots(ctx, t, db)
+
+	if _, err := db.GarbageCollect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, dgst := range []digest.Digest{parentDesc.Digest, childDesc.Digest} {
+		if _, err := cs.Info(ctx, dgst); !errdefs.IsNotFound(err) {
+			t.Fatalf("expected content to be collected, got %v", err)
+		}
+	}
+}
+
+func TestOrphans(t *testing.T) {
+	ctx, db := testDB(t)
+	cs := db.ContentStore()
+
+	blobs := map[string]ocispec.Descriptor{}
+	for _, name := range []string{"image", "leased", "rooted", "orphan"} {
+		data := []byte(name + " content")
+		blobs[name] = ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageManifest,
+			Size:      int64(len(data)),
+			Digest:    digest.FromBytes(data),
+		}
+	}
+
+	lctx, remove, err := createLease(ctx, db, "lease-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, desc := range blobs {
+		if err := content.WriteBlob(lctx, cs, name, bytes.NewReader([]byte(name+" content")), desc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := NewImageStore(db).Create(ctx, images.Image{Name: "image", Target: blobs["image"]}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddContentRoot(ctx, blobs["rooted"].Digest); err != nil {
+		t.Fatal(err)
+	}
+
+	// Everything is referenced while the lease exists
+	if orphans, err := db.Orphans(ctx); err != nil {
+		t.Fatal(err)
+	} else if len(orphans) != 0 {
+		t.Fatalf("expected no orphans while leased, got %v", orphans)
+	}
+
+	if err := remove(); err != nil {
+		t.Fatal(err)
+	}
+	lctx, _, err = createLease(ctx, db, "lease-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := content.WriteBlob(lctx, cs, "leased", bytes.NewReader([]byte("leased content")), blobs["leased"]); err != nil {
+		t.Fatal(err)
+	}
+
+	orphans, err := db.Orphans(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 1 || orphans[0].Digest != blobs["orphan"].Digest {
+		t.Fatalf("expected only %s to be an orphan, got %v", blobs["orphan"].Digest, orphans)
+	}
+
+	// Orphans are exactly the content removed by garbage collection
+	if _, err := db.GarbageCollect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for name, desc := range blobs {
+		_, err := cs.Info(ctx, desc.Digest)
+		if name == "orphan" {
+			if !errdefs.IsNotFound(err) {
+				t.Fatalf("expected orphan to be collected, got %v", err)
+			}
+		} else if err != nil {
+			t.Fatalf("%s should not be collected: %v", name, err)
+		}
+	}
+}
+
+func checkRoots(ctx context.Context, t *testing.T, db *DB, expected ...digest.Digest) {
+	t.Helper()
+	roots, err := db.ContentRoots(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roots) != len(expected) {
+		t.Fatalf("unexpected roots %v, expected %v", roots, expected)
+	}
+	seen := map[digest.Digest]struct{}{}
+	for _, info := range roots {
+		seen[info.Digest] = struct{}{}
+	}
+	for _, dgst := range expected {
+		if _, ok := seen[dgst]; !ok {
+			t.Fatalf("missing root %s in %v", dgst, roots)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/containerd/snapshots/native"
+)
+
+func testSnapshotDB(t *testing.T) (context.Context, *DB, snapshots.Snapshotter) {
+	sn, err := native.NewSnapshotter(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, db := testDB(t, withDBOpts(WithSnapshotter("native", sn)))
+	return ctx, db, sn
+}
+
+func TestSnapshotterUnregistered(t *testing.T) {
+	_, db, _ := testSnapshotDB(t)
+	if sn := db.Snapshotter("overlayfs"); sn != nil {
+		t.Fatal("expected no snapshotter for unregistered name")
+	}
+}
+
+func TestSnapshotterGC(t *testing.T) {
+	ctx, db, backend := testSnapshotDB(t)
+	sn := db.Snapshotter("native")
+
+	rootLabels := snapshots.WithLabels(map[string]string{string(labelGCRoot): "always"})
+	if _, err := sn.Prepare(ctx, "root-active", "", rootLabels); err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Commit(ctx, "root", "root-active", rootLabels); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sn.Prepare(ctx, "child", "root"); err != nil {
+		t.Fatal(err)
+	}
+
+	lctx, _, err := createLease(ctx, db, "l1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sn.Prepare(lctx, "leased", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sn.Prepare(lctx, "leased", ""); !errdefs.IsAlreadyExists(err) {
+		t.Fatalf("expected already exists error, got %v", err)
+	}
+
+	// Snapshots created directly in the backend are not referenced
+	if _, err := backend.Prepare(ctx, "orphan", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.GarbageCollect(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	checkSnapshots(ctx, t, sn, "leased", "root")
+	if _, err := sn.Stat(ctx, "child"); !errdefs.IsNotFound(err) {
+		t.Fatalf("expected child to be collected, got %v", err)
+	}
+
+	// The backend snapshots of the collected and orphaned snapshots are
+	// removed along with the metadata
+	var backendKeys []string
+	if err := backend.Walk(ctx, func(ctx context.Context, info snapshots.Info) error {
+		backendKeys = append(backendKeys, info.Name)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(backendKeys) != 2 {
+		t.Fatalf("expected 2 backend snapshots, got %v", backendKeys)
+	}
+
+	if err := sn.Remove(ctx, "root"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GarbageCollect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	checkSnapshots(ctx, t, sn, "leased")
+}
+
+func TestSnapshotterRemoveParent(t *testing.T) {
+	ctx, db, _ := testSnapshotDB(t)
+	sn := db.Snapshotter("native")
+
+	if _, err := sn.Prepare(ctx, "parent-active", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Commit(ctx, "parent", "parent-active"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sn.Prepare(ctx, "child", "parent"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sn.Remove(ctx, "parent"); !errors.Is(err, errdefs.ErrFailedPrecondition) {
+		t.Fatalf("expected failed precondition removing parent, got %v", err)
+	}
+	if err := sn.Remove(ctx, "child"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Remove(ctx, "parent"); err != nil {
+		t.Fatal(err)
+	}
+	checkSnapshots(ctx, t, sn)
+}
+
+func checkSnapshots(ctx context.Context, t *testing.T, sn snapshots.Snapshotter, expected ...string) {
+	t.Helper()
+
+	var names []string
+	if err := sn.Walk(ctx, func(ctx context.Context, info snapshots.Info) error {
+		names = append(names, info.Name)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	if len(names) != len(expected) {
+		t.Fatalf("unexpected snapshots %v, expected %v", names, expected)
+	}
+	for i := range names {
+		if names[i] != expected[i] {
+			t.Fatalf("unexpected snapshots %v, expected %v", names, expected)
+		}
+	}
+}
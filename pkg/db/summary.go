@@ -0,0 +1,136 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	digest "github.com/opencontainers/go-digest"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Summary is a lightweight integrity summary of the metadata and content,
+// used to compare stores or detect unexpected changes to a store. The
+// checksums are computed from the metadata only, blob data is not read.
+type Summary struct {
+	// Images is the number of images
+	Images int `json:"images"`
+
+	// ImageChecksum is the digest of each image name and target digest
+	ImageChecksum digest.Digest `json:"imageChecksum"`
+
+	// Content is the number of committed blobs
+	Content int `json:"content"`
+
+	// ContentSize is the total size of committed blobs
+	ContentSize int64 `json:"contentSize"`
+
+	// ContentChecksum is the digest of each blob digest and size
+	ContentChecksum digest.Digest `json:"contentChecksum"`
+
+	// CreatedAt is when the summary was computed
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Equal returns whether the summaries describe the same metadata and
+// content, regardless of when they were computed
+func (s Summary) Equal(o Summary) bool {
+	s.CreatedAt, o.CreatedAt = time.Time{}, time.Time{}
+	return s == o
+}
+
+// Summary computes the integrity summary from a consistent view of the
+// images and content
+func (m *DB) Summary(ctx context.Context) (Summary, error) {
+	var s Summary
+	if err := m.db.View(func(tx *bolt.Tx) error {
+		ctx := WithTransactionContext(ctx, tx)
+		imgs, err := NewImageStore(m).List(ctx)
+		if err != nil {
+			return err
+		}
+		sort.Slice(imgs, func(i, j int) bool { return imgs[i].Name < imgs[j].Name })
+		ih := digest.Canonical.Digester()
+		for _, img := range imgs {
+			fmt.Fprintf(ih.Hash(), "%s\x00%s\n", img.Name, img.Target.Digest)
+		}
+		s.Images = len(imgs)
+		s.ImageChecksum = ih.Digest()
+
+		var infos []content.Info
+		if err := m.cs.Walk(ctx, func(info content.Info) error {
+			infos = append(infos, info)
+			return nil
+		}); err != nil {
+			return err
+		}
+		sort.Slice(infos, func(i, j int) bool { return infos[i].Digest < infos[j].Digest })
+		ch := digest.Canonical.Digester()
+		for _, info := range infos {
+			fmt.Fprintf(ch.Hash(), "%s\x00%d\n", info.Digest, info.Size)
+			s.ContentSize += info.Size
+		}
+		s.Content = len(infos)
+		s.ContentChecksum = ch.Digest()
+		return nil
+	}); err != nil {
+		return Summary{}, err
+	}
+	s.CreatedAt = time.Now().UTC()
+	return s, nil
+}
+
+// RecordSummary stores the summary as the last known state of the store
+func (m *DB) RecordSummary(ctx context.Context, s Summary) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return update(ctx, m, func(tx *bolt.Tx) error {
+		bkt, err := createBucketIfNotExists(tx, bucketKeyVersion)
+		if err != nil {
+			return err
+		}
+		return bkt.Put(bucketKeySummary, b)
+	})
+}
+
+// RecordedSummary returns the last recorded summary, returning
+// errdefs.ErrNotFound when no summary has been recorded
+func (m *DB) RecordedSummary(ctx context.Context) (Summary, error) {
+	var s Summary
+	if err := view(ctx, m, func(tx *bolt.Tx) error {
+		bkt := getBucket(tx, bucketKeyVersion)
+		if bkt == nil {
+			return fmt.Errorf("no summary recorded: %w", errdefs.ErrNotFound)
+		}
+		b := bkt.Get(bucketKeySummary)
+		if b == nil {
+			return fmt.Errorf("no summary recorded: %w", errdefs.ErrNotFound)
+		}
+		return json.Unmarshal(b, &s)
+	}); err != nil {
+		return Summary{}, err
+	}
+	return s, nil
+}
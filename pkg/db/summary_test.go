@@ -0,0 +1,109 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestSummary(t *testing.T) {
+	ctx, db := testDB(t)
+
+	summary := func() Summary {
+		t.Helper()
+		s, err := db.Summary(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	if _, err := db.RecordedSummary(ctx); !errdefs.IsNotFound(err) {
+		t.Fatalf("expected not found before recording, got %v", err)
+	}
+
+	empty := summary()
+	if empty.Images != 0 || empty.Content != 0 || empty.ContentSize != 0 {
+		t.Fatalf("unexpected summary for empty store: %+v", empty)
+	}
+
+	lctx, remove, err := createLease(ctx, db, "lease-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remove()
+	data := "image manifest"
+	desc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Digest:    digest.FromString(data),
+		Size:      int64(len(data)),
+	}
+	if err := content.WriteBlob(lctx, db.ContentStore(), "manifest", strings.NewReader(data), desc); err != nil {
+		t.Fatal(err)
+	}
+	withContent := summary()
+	if withContent.Content != 1 || withContent.ContentSize != desc.Size {
+		t.Fatalf("unexpected content in summary: %+v", withContent)
+	}
+	if withContent.ContentChecksum == empty.ContentChecksum || withContent.ImageChecksum != empty.ImageChecksum {
+		t.Fatalf("expected only content checksum to change: %+v", withContent)
+	}
+
+	// Stable when nothing has changed
+	if err := db.RecordSummary(ctx, withContent); err != nil {
+		t.Fatal(err)
+	}
+	if s := summary(); !s.Equal(withContent) {
+		t.Fatalf("expected summary to be stable, got %+v and %+v", s, withContent)
+	}
+	recorded, err := db.RecordedSummary(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !recorded.Equal(withContent) || !recorded.CreatedAt.Equal(withContent.CreatedAt) {
+		t.Fatalf("unexpected recorded summary %+v, expected %+v", recorded, withContent)
+	}
+
+	// Adding an image changes only the image summary
+	if _, err := NewImageStore(db).Create(ctx, images.Image{Name: "image", Target: desc}); err != nil {
+		t.Fatal(err)
+	}
+	withImage := summary()
+	if withImage.Equal(recorded) {
+		t.Fatal("expected summary to change after adding an image")
+	}
+	if withImage.Images != 1 || withImage.ImageChecksum == recorded.ImageChecksum || withImage.ContentChecksum != recorded.ContentChecksum {
+		t.Fatalf("expected only image summary to change: %+v", withImage)
+	}
+
+	// Retargeting the image with the same name changes the checksum
+	other := desc
+	other.Digest = digest.FromString("other")
+	if _, err := NewImageStore(db).Update(ctx, images.Image{Name: "image", Target: other}); err != nil {
+		t.Fatal(err)
+	}
+	if s := summary(); s.Images != withImage.Images || s.ImageChecksum == withImage.ImageChecksum {
+		t.Fatalf("expected image checksum to change after update: %+v", s)
+	}
+}
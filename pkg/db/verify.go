@@ -0,0 +1,156 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/lcontainerd/pkg/iobuf"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	bolt "go.etcd.io/bbolt"
+)
+
+// CorruptBlob is a blob whose data on disk does not match its digest
+type CorruptBlob struct {
+	// Digest is the digest the blob is stored under
+	Digest digest.Digest
+
+	// Size is the recorded size of the blob
+	Size int64
+
+	// Missing is set when the blob data is missing from disk
+	Missing bool
+
+	// Actual is the digest of the data on disk
+	Actual digest.Digest
+
+	// ActualSize is the size of the data on disk
+	ActualSize int64
+}
+
+// Truncated returns whether less data than the recorded size is on disk
+func (b CorruptBlob) Truncated() bool {
+	return !b.Missing && b.ActualSize < b.Size
+}
+
+// VerifyContent reads back the data of the given blobs, or every blob when
+// none are given, and returns the blobs whose data does not match their
+// digest. The data is streamed so blobs of any size may be verified.
+func (m *DB) VerifyContent(ctx context.Context, dgsts ...digest.Digest) ([]CorruptBlob, error) {
+	var infos []content.Info
+	if len(dgsts) == 0 {
+		if err := m.cs.Walk(ctx, func(info content.Info) error {
+			infos = append(infos, info)
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	} else {
+		for _, dgst := range dgsts {
+			info, err := m.cs.Info(ctx, dgst)
+			if err != nil {
+				return nil, err
+			}
+			infos = append(infos, info)
+		}
+	}
+
+	var corrupt []CorruptBlob
+	for _, info := range infos {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		b, err := m.verifyBlob(ctx, info)
+		if err != nil {
+			return nil, fmt.Errorf("failed to verify %s: %w", info.Digest, err)
+		}
+		if b != nil {
+			corrupt = append(corrupt, *b)
+		}
+	}
+	return corrupt, nil
+}
+
+// verifyBlob returns the corruption of the blob, nil when the data matches
+func (m *DB) verifyBlob(ctx context.Context, info content.Info) (*CorruptBlob, error) {
+	b := CorruptBlob{
+		Digest: info.Digest,
+		Size:   info.Size,
+	}
+	if err := info.Digest.Validate(); err != nil {
+		return nil, err
+	}
+	ra, err := m.cs.ReaderAt(ctx, ocispec.Descriptor{Digest: info.Digest, Size: info.Size})
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			b.Missing = true
+			return &b, nil
+		}
+		return nil, err
+	}
+	defer ra.Close()
+
+	digester := info.Digest.Algorithm().Digester()
+	n, err := iobuf.Copy(digester.Hash(), content.NewReader(ra))
+	if err != nil {
+		return nil, err
+	}
+	b.Actual = digester.Digest()
+	b.ActualSize = n
+	if b.Actual == info.Digest && n == info.Size {
+		return nil, nil
+	}
+	return &b, nil
+}
+
+// DanglingIngests returns the refs of the ingests whose data is missing from
+// the content store. The ingest can never be committed and should be
+// aborted.
+func (m *DB) DanglingIngests(ctx context.Context) ([]string, error) {
+	brefs := map[string]string{}
+	if err := view(ctx, m, func(tx *bolt.Tx) error {
+		bkt := getIngestsBucket(tx)
+		if bkt == nil {
+			return nil
+		}
+		return bkt.ForEach(func(k, v []byte) error {
+			if v == nil {
+				brefs[string(k)] = string(bkt.Bucket(k).Get(bucketKeyRef))
+			}
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+
+	var dangling []string
+	for ref, bref := range brefs {
+		if _, err := m.cs.Store.Status(ctx, bref); err != nil {
+			if !errdefs.IsNotFound(err) {
+				return nil, err
+			}
+			dangling = append(dangling, ref)
+		}
+	}
+	sort.Strings(dangling)
+	return dangling, nil
+}
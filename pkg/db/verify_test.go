@@ -0,0 +1,153 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestVerifyContent(t *testing.T) {
+	ctx, db := testDB(t)
+	cs := db.ContentStore()
+
+	var descs []ocispec.Descriptor
+	for i := 0; i < 4; i++ {
+		b := []byte(fmt.Sprintf("verify blob %d", i))
+		desc := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayer, Digest: digest.FromBytes(b), Size: int64(len(b))}
+		if err := content.WriteBlob(ctx, cs, desc.Digest.String(), bytes.NewReader(b), desc, content.WithLabels(map[string]string{string(labelGCRoot): "always"})); err != nil {
+			t.Fatal(err)
+		}
+		descs = append(descs, desc)
+	}
+
+	if corrupt, err := db.VerifyContent(ctx); err != nil {
+		t.Fatal(err)
+	} else if len(corrupt) != 0 {
+		t.Fatalf("expected no corrupt blobs, got %v", corrupt)
+	}
+
+	blobPath := func(dgst digest.Digest) string {
+		return filepath.Join(db.root, "content", "blobs", dgst.Algorithm().String(), dgst.Encoded())
+	}
+	// Overwrite, truncate, and remove the data of the last three blobs
+	if err := os.Chmod(blobPath(descs[1].Digest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(blobPath(descs[1].Digest), []byte("verify blob X"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(blobPath(descs[2].Digest), 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(blobPath(descs[3].Digest)); err != nil {
+		t.Fatal(err)
+	}
+
+	corrupt, err := db.VerifyContent(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byDigest := map[digest.Digest]CorruptBlob{}
+	for _, b := range corrupt {
+		byDigest[b.Digest] = b
+	}
+	if len(byDigest) != 3 {
+		t.Fatalf("expected 3 corrupt blobs, got %v", corrupt)
+	}
+	if b := byDigest[descs[1].Digest]; b.Missing || b.Truncated() || b.Actual != digest.FromString("verify blob X") {
+		t.Errorf("unexpected mismatched blob %+v", b)
+	}
+	if b := byDigest[descs[2].Digest]; !b.Truncated() || b.ActualSize != 4 {
+		t.Errorf("unexpected truncated blob %+v", b)
+	}
+	if b := byDigest[descs[3].Digest]; !b.Missing {
+		t.Errorf("unexpected missing blob %+v", b)
+	}
+
+	if corrupt, err := db.VerifyContent(ctx, descs[0].Digest); err != nil {
+		t.Fatal(err)
+	} else if len(corrupt) != 0 {
+		t.Fatalf("expected no corrupt blobs, got %v", corrupt)
+	}
+	if _, err := db.VerifyContent(ctx, digest.FromString("unknown")); !errdefs.IsNotFound(err) {
+		t.Fatalf("expected not found verifying unknown blob, got %v", err)
+	}
+}
+
+func TestDanglingIngests(t *testing.T) {
+	ctx, db := testDB(t)
+	cs := db.ContentStore()
+
+	for _, ref := range []string{"ingest-1", "ingest-2"} {
+		w, err := cs.Writer(ctx, content.WithRef(ref))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(ref)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if dangling, err := db.DanglingIngests(ctx); err != nil {
+		t.Fatal(err)
+	} else if len(dangling) != 0 {
+		t.Fatalf("expected no dangling ingests, got %v", dangling)
+	}
+
+	// Remove the data of the ingest from the backend
+	var bref string
+	if err := db.View(func(tx *bolt.Tx) error {
+		bref = getRef(tx, "ingest-2")
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.cs.Store.Abort(ctx, bref); err != nil {
+		t.Fatal(err)
+	}
+
+	dangling, err := db.DanglingIngests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dangling) != 1 || dangling[0] != "ingest-2" {
+		t.Fatalf("unexpected dangling ingests %v", dangling)
+	}
+
+	// Dangling ingests may still be aborted
+	if err := cs.Abort(ctx, "ingest-2"); err != nil {
+		t.Fatal(err)
+	}
+	if dangling, err := db.DanglingIngests(ctx); err != nil {
+		t.Fatal(err)
+	} else if len(dangling) != 0 {
+		t.Fatalf("expected no dangling ingests after abort, got %v", dangling)
+	}
+}
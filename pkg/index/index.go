@@ -0,0 +1,156 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package index provides helpers for building image indexes from existing
+// manifests.
+package index
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/lcontainerd/pkg/iobuf"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// MediaTypeEmptyJSON is the OCI media type for the empty descriptor, used as
+// the config of artifacts which have no config of their own
+const MediaTypeEmptyJSON = "application/vnd.oci.empty.v1+json"
+
+// Append adds the descriptor to the manifests of the index. When an image
+// manifest is appended without a platform, the platform is populated from
+// the manifest's config so the entry may be selected by platform.
+func Append(ctx context.Context, provider content.Provider, idx *ocispec.Index, desc ocispec.Descriptor) error {
+	if desc.Platform == nil && images.IsManifestType(desc.MediaType) {
+		p, err := ManifestPlatform(ctx, provider, desc)
+		if err != nil {
+			return err
+		}
+		desc.Platform = p
+	}
+	idx.Manifests = append(idx.Manifests, desc)
+	return nil
+}
+
+// AppendBlob appends the descriptor to the OCI index or Docker manifest list
+// described by target, returning the encoded index and the position of the
+// new entry. The media type of the index is kept.
+func AppendBlob(ctx context.Context, provider content.Provider, target, desc ocispec.Descriptor) ([]byte, int, error) {
+	if !images.IsIndexType(target.MediaType) {
+		return nil, 0, fmt.Errorf("cannot append to %s: %w", target.MediaType, errdefs.ErrInvalidArgument)
+	}
+	b, err := iobuf.ReadBlob(ctx, provider, target)
+	if err != nil {
+		return nil, 0, err
+	}
+	var idx ocispec.Index
+	if err := json.Unmarshal(b, &idx); err != nil {
+		return nil, 0, err
+	}
+	position := len(idx.Manifests)
+	if err := Append(ctx, provider, &idx, desc); err != nil {
+		return nil, 0, fmt.Errorf("failed to append to index: %w", err)
+	}
+	b, err = json.Marshal(idx)
+	if err != nil {
+		return nil, 0, err
+	}
+	return b, position, nil
+}
+
+// Annotate returns the descriptor with the annotations added, replacing any
+// existing annotations with the same keys. The annotations of the given
+// descriptor are copied rather than modified.
+func Annotate(desc ocispec.Descriptor, annotations map[string]string) ocispec.Descriptor {
+	if len(annotations) == 0 {
+		return desc
+	}
+	merged := make(map[string]string, len(desc.Annotations)+len(annotations))
+	for k, v := range desc.Annotations {
+		merged[k] = v
+	}
+	for k, v := range annotations {
+		merged[k] = v
+	}
+	desc.Annotations = merged
+	return desc
+}
+
+// ChildGCLabels adds the garbage collection labels referencing the
+// descriptor as the child at the position of its parent. Positions are
+// counted from the config for manifests and the first manifest for indexes.
+func ChildGCLabels(desc ocispec.Descriptor, position int, labels map[string]string) map[string]string {
+	prefixes := images.ChildGCLabels(desc)
+	if desc.MediaType == MediaTypeEmptyJSON {
+		// The empty descriptor is only referenced as a config
+		prefixes = []string{"containerd.io/gc.ref.content.config"}
+	}
+	if len(prefixes) > 0 {
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		for _, key := range prefixes {
+			if strings.HasSuffix(key, ".") {
+				key = fmt.Sprintf("%s%d", key, position)
+			}
+			labels[key] = desc.Digest.String()
+		}
+
+	}
+	return labels
+}
+
+// ManifestPlatform returns the platform described by the config of the
+// manifest. Nil is returned when the config is not an image config, does
+// not specify an operating system and architecture, or is not available.
+func ManifestPlatform(ctx context.Context, provider content.Provider, desc ocispec.Descriptor) (*ocispec.Platform, error) {
+	b, err := iobuf.ReadBlob(ctx, provider, desc)
+	if err != nil {
+		return nil, err
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return nil, err
+	}
+	switch manifest.Config.MediaType {
+	case images.MediaTypeDockerSchema2Config, ocispec.MediaTypeImageConfig:
+	default:
+		return nil, nil
+	}
+
+	b, err = iobuf.ReadBlob(ctx, provider, manifest.Config)
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var config ocispec.Image
+	if err := json.Unmarshal(b, &config); err != nil {
+		return nil, err
+	}
+	if config.OS == "" || config.Architecture == "" {
+		return nil, nil
+	}
+	p := platforms.Normalize(config.Platform)
+	return &p, nil
+}
@@ -0,0 +1,235 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package index
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/google/go-cmp/cmp"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestAppend(t *testing.T) {
+	ctx := context.Background()
+	cs, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	amd64 := writeTestManifest(ctx, t, cs, images.MediaTypeDockerSchema2Config, ocispec.Image{
+		Platform: ocispec.Platform{OS: "linux", Architecture: "amd64"},
+	})
+	arm64 := writeTestManifest(ctx, t, cs, ocispec.MediaTypeImageConfig, ocispec.Image{
+		Platform: ocispec.Platform{OS: "linux", Architecture: "arm64"},
+	})
+	artifact := writeTestManifest(ctx, t, cs, "application/vnd.example.config+json", ocispec.Image{
+		Platform: ocispec.Platform{OS: "linux", Architecture: "amd64"},
+	})
+	explicit := arm64
+	explicit.Platform = &ocispec.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}
+
+	var idx ocispec.Index
+	for _, desc := range []ocispec.Descriptor{amd64, arm64, artifact, explicit} {
+		if err := Append(ctx, cs, &idx, desc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []string{"linux/amd64", "linux/arm64", "", "linux/arm/v7"}
+	if len(idx.Manifests) != len(expected) {
+		t.Fatalf("expected %d manifests, got %d", len(expected), len(idx.Manifests))
+	}
+	for i, desc := range idx.Manifests {
+		var actual string
+		if desc.Platform != nil {
+			actual = platforms.Format(*desc.Platform)
+		}
+		if actual != expected[i] {
+			t.Errorf("manifest %d: expected platform %q, got %q", i, expected[i], actual)
+		}
+	}
+
+	// Entries select by platform after appending
+	b, err := json.Marshal(idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := writeTestBlob(ctx, t, cs, ocispec.MediaTypeImageIndex, b)
+	m, err := images.Manifest(ctx, cs, target, platforms.Only(platforms.MustParse("linux/arm64")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Config.Digest != configDigest(ctx, t, cs, arm64) {
+		t.Fatalf("expected arm64 manifest to be selected")
+	}
+}
+
+func TestAppendBlob(t *testing.T) {
+	ctx := context.Background()
+	cs, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	amd64 := writeTestManifest(ctx, t, cs, ocispec.MediaTypeImageConfig, ocispec.Image{
+		Platform: ocispec.Platform{OS: "linux", Architecture: "amd64"},
+	})
+	arm64 := writeTestManifest(ctx, t, cs, ocispec.MediaTypeImageConfig, ocispec.Image{
+		Platform: ocispec.Platform{OS: "linux", Architecture: "arm64"},
+	})
+
+	for _, mediaType := range []string{ocispec.MediaTypeImageIndex, images.MediaTypeDockerSchema2ManifestList} {
+		t.Run(mediaType, func(t *testing.T) {
+			ib, err := json.Marshal(ocispec.Index{
+				Versioned: specs.Versioned{SchemaVersion: 2},
+				MediaType: mediaType,
+				Manifests: []ocispec.Descriptor{amd64},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			target := writeTestBlob(ctx, t, cs, mediaType, ib)
+
+			b, position, err := AppendBlob(ctx, cs, target, arm64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if position != 1 {
+				t.Fatalf("expected appended at position 1, got %d", position)
+			}
+			var idx ocispec.Index
+			if err := json.Unmarshal(b, &idx); err != nil {
+				t.Fatal(err)
+			}
+			if idx.MediaType != mediaType {
+				t.Errorf("expected media type %s, got %s", mediaType, idx.MediaType)
+			}
+			if len(idx.Manifests) != 2 || idx.Manifests[1].Digest != arm64.Digest {
+				t.Fatalf("expected %s appended, got %v", arm64.Digest, idx.Manifests)
+			}
+			if p := idx.Manifests[1].Platform; p == nil || platforms.Format(*p) != "linux/arm64" {
+				t.Errorf("expected linux/arm64 platform, got %v", p)
+			}
+
+			labels := ChildGCLabels(idx.Manifests[position], position, map[string]string{
+				"containerd.io/gc.ref.content.m.0": amd64.Digest.String(),
+			})
+			expected := map[string]string{
+				"containerd.io/gc.ref.content.m.0": amd64.Digest.String(),
+				"containerd.io/gc.ref.content.m.1": arm64.Digest.String(),
+			}
+			if len(labels) != len(expected) {
+				t.Fatalf("unexpected labels %v", labels)
+			}
+			for k, v := range expected {
+				if labels[k] != v {
+					t.Errorf("expected label %s=%s, got %q", k, v, labels[k])
+				}
+			}
+		})
+	}
+
+	if _, _, err := AppendBlob(ctx, cs, amd64, arm64); !errdefs.IsInvalidArgument(err) {
+		t.Fatalf("expected invalid argument appending to a manifest, got %v", err)
+	}
+}
+
+func writeTestManifest(ctx context.Context, t *testing.T, cs content.Store, configType string, config ocispec.Image) ocispec.Descriptor {
+	t.Helper()
+	cb, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mb, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    writeTestBlob(ctx, t, cs, configType, cb),
+		Layers:    []ocispec.Descriptor{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writeTestBlob(ctx, t, cs, ocispec.MediaTypeImageManifest, mb)
+}
+
+func configDigest(ctx context.Context, t *testing.T, cs content.Store, desc ocispec.Descriptor) digest.Digest {
+	t.Helper()
+	b, err := content.ReadBlob(ctx, cs, desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	return manifest.Config.Digest
+}
+
+func writeTestBlob(ctx context.Context, t *testing.T, cs content.Store, mediaType string, b []byte) ocispec.Descriptor {
+	t.Helper()
+	desc := ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(b),
+		Size:      int64(len(b)),
+	}
+	if err := content.WriteBlob(ctx, cs, desc.Digest.String(), bytes.NewReader(b), desc); err != nil {
+		t.Fatal(err)
+	}
+	return desc
+}
+
+func TestAnnotate(t *testing.T) {
+	desc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Digest:    digest.FromString("manifest"),
+		Size:      8,
+	}
+
+	// Annotations are added to a descriptor without annotations
+	annotated := Annotate(desc, map[string]string{"a": "1"})
+	if diff := cmp.Diff(map[string]string{"a": "1"}, annotated.Annotations); diff != "" {
+		t.Fatalf("unexpected annotations (-want +got):\n%s", diff)
+	}
+	if desc.Annotations != nil {
+		t.Fatalf("expected original descriptor unchanged, got %v", desc.Annotations)
+	}
+
+	// Existing annotations are kept unless replaced
+	desc.Annotations = map[string]string{"a": "0", "b": "2"}
+	annotated = Annotate(desc, map[string]string{"a": "1", "c": "3"})
+	if diff := cmp.Diff(map[string]string{"a": "1", "b": "2", "c": "3"}, annotated.Annotations); diff != "" {
+		t.Fatalf("unexpected annotations (-want +got):\n%s", diff)
+	}
+	if desc.Annotations["a"] != "0" || len(desc.Annotations) != 2 {
+		t.Fatalf("expected original annotations unchanged, got %v", desc.Annotations)
+	}
+
+	// No annotations leaves the descriptor unchanged
+	if annotated := Annotate(ocispec.Descriptor{}, nil); annotated.Annotations != nil {
+		t.Fatalf("expected no annotations, got %v", annotated.Annotations)
+	}
+}
@@ -0,0 +1,76 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package iobuf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/containerd/containerd/content"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// DefaultMaxBlobSize is the largest blob read into memory by ReadBlob when
+// no maximum is configured
+const DefaultMaxBlobSize = 4 << 20
+
+// ErrBlobTooLarge is returned when a blob read into memory exceeds the
+// configured maximum size
+var ErrBlobTooLarge = errors.New("blob exceeds maximum size")
+
+var maxBlobSize int64 = DefaultMaxBlobSize
+
+// SetMaxBlobSize sets the largest blob read into memory by ReadBlob
+func SetMaxBlobSize(size int64) error {
+	if size <= 0 {
+		return fmt.Errorf("invalid maximum blob size %d, must be greater than zero", size)
+	}
+	atomic.StoreInt64(&maxBlobSize, size)
+	return nil
+}
+
+// ReadBlob reads a manifest, index, or config blob into memory, failing
+// without reading the blob when it is larger than the configured maximum.
+// Blobs which are parsed as JSON should be read with ReadBlob so that a
+// large blob with the wrong media type cannot exhaust memory.
+func ReadBlob(ctx context.Context, provider content.Provider, desc ocispec.Descriptor) ([]byte, error) {
+	max := atomic.LoadInt64(&maxBlobSize)
+	if desc.Size > max {
+		return nil, fmt.Errorf("%s of %d bytes: %w of %d bytes", desc.Digest, desc.Size, ErrBlobTooLarge, max)
+	}
+	ra, err := provider.ReaderAt(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+	defer ra.Close()
+
+	// The stored size is checked as the descriptor may not match
+	if size := ra.Size(); size > max {
+		return nil, fmt.Errorf("%s of %d bytes: %w of %d bytes", desc.Digest, size, ErrBlobTooLarge, max)
+	}
+	b, err := io.ReadAll(io.LimitReader(content.NewReader(ra), max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > max {
+		return nil, fmt.Errorf("%s: %w of %d bytes", desc.Digest, ErrBlobTooLarge, max)
+	}
+	return b, nil
+}
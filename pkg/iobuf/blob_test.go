@@ -0,0 +1,74 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package iobuf
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestReadBlobMaxSize(t *testing.T) {
+	ctx := context.Background()
+	cs, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		SetMaxBlobSize(DefaultMaxBlobSize)
+	})
+
+	b := bytes.Repeat([]byte("{}"), 1024)
+	desc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Digest:    digest.FromBytes(b),
+		Size:      int64(len(b)),
+	}
+	if err := content.WriteBlob(ctx, cs, desc.Digest.String(), bytes.NewReader(b), desc); err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := ReadBlob(ctx, cs, desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, b) {
+		t.Fatal("unexpected blob content")
+	}
+
+	if err := SetMaxBlobSize(0); err == nil {
+		t.Fatal("expected error for zero maximum size")
+	}
+	if err := SetMaxBlobSize(1024); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadBlob(ctx, cs, desc); !errors.Is(err, ErrBlobTooLarge) {
+		t.Fatalf("expected blob too large error, got %v", err)
+	}
+
+	// The stored size is checked when the descriptor size is wrong
+	desc.Size = 16
+	if _, err := ReadBlob(ctx, cs, desc); !errors.Is(err, ErrBlobTooLarge) {
+		t.Fatalf("expected blob too large error with wrong descriptor size, got %v", err)
+	}
+}
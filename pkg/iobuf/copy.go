@@ -0,0 +1,73 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package iobuf provides buffered copying using a pooled buffer of a
+// configurable size.
+package iobuf
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// DefaultBufferSize is the size of buffers used for copying when no size
+// is configured
+const DefaultBufferSize = 1 << 20
+
+var (
+	mu   sync.RWMutex
+	pool = newPool(DefaultBufferSize)
+)
+
+func newPool(size int) *sync.Pool {
+	return &sync.Pool{
+		New: func() interface{} {
+			b := make([]byte, size)
+			return &b
+		},
+	}
+}
+
+// SetBufferSize sets the size of buffers used for subsequent copies
+func SetBufferSize(size int) error {
+	if size <= 0 {
+		return fmt.Errorf("invalid buffer size %d, must be greater than zero", size)
+	}
+	mu.Lock()
+	pool = newPool(size)
+	mu.Unlock()
+	return nil
+}
+
+// Copy copies from src to dst using a pooled buffer of the configured size.
+// Unlike io.Copy, the buffer is used even when dst implements io.ReaderFrom,
+// which often falls back to copying with a small buffer.
+func Copy(dst io.Writer, src io.Reader) (int64, error) {
+	mu.RLock()
+	p := pool
+	mu.RUnlock()
+
+	buf := p.Get().(*[]byte)
+	defer p.Put(buf)
+
+	return io.CopyBuffer(writerOnly{dst}, src, *buf)
+}
+
+// writerOnly hides any io.ReaderFrom implementation of the writer
+type writerOnly struct {
+	io.Writer
+}
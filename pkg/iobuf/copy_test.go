@@ -0,0 +1,97 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package iobuf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopy(t *testing.T) {
+	data := make([]byte, 3*DefaultBufferSize+17)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	for _, size := range []int{1, 4096, DefaultBufferSize, 4 * DefaultBufferSize} {
+		if err := SetBufferSize(size); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		n, err := Copy(&buf, readerOnly{bytes.NewReader(data)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(len(data)) || !bytes.Equal(buf.Bytes(), data) {
+			t.Fatalf("copy with buffer size %d did not match source", size)
+		}
+	}
+	if err := SetBufferSize(0); err == nil {
+		t.Fatal("expected error setting zero buffer size")
+	}
+	if err := SetBufferSize(DefaultBufferSize); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// BenchmarkCopy compares copying a large blob to a file with io.Copy, which
+// uses the file's ReadFrom and a 32KiB buffer, against the pooled buffer.
+func BenchmarkCopy(b *testing.B) {
+	data := make([]byte, 64<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	f, err := os.Create(filepath.Join(b.TempDir(), "blob"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+
+	run := func(b *testing.B, copyFn func(io.Writer, io.Reader) (int64, error)) {
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				b.Fatal(err)
+			}
+			if _, err := copyFn(f, readerOnly{bytes.NewReader(data)}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+
+	b.Run("io.Copy", func(b *testing.B) {
+		run(b, io.Copy)
+	})
+	for _, size := range []int{32 << 10, DefaultBufferSize, 4 * DefaultBufferSize} {
+		size := size
+		b.Run(fmt.Sprintf("Copy-%dKiB", size>>10), func(b *testing.B) {
+			if err := SetBufferSize(size); err != nil {
+				b.Fatal(err)
+			}
+			defer SetBufferSize(DefaultBufferSize)
+			run(b, Copy)
+		})
+	}
+}
+
+// readerOnly hides any io.WriterTo implementation of the reader, as with
+// content store readers
+type readerOnly struct {
+	io.Reader
+}
@@ -0,0 +1,177 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package remote
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/remotes"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// BlobCache stores fetched index, manifest, and config blobs by digest so
+// that repeated fetches of the same digest are read locally.
+type BlobCache struct {
+	root string
+	mode os.FileMode
+}
+
+// NewBlobCache returns a cache storing blobs under the root directory, the
+// directory is created with the given mode when a blob is first cached.
+func NewBlobCache(root string, mode os.FileMode) *BlobCache {
+	return &BlobCache{
+		root: root,
+		mode: mode,
+	}
+}
+
+// Fetcher returns a fetcher which reads cacheable blobs from the cache before
+// falling back to the given fetcher, caching blobs fetched successfully.
+func (c *BlobCache) Fetcher(f remotes.Fetcher) remotes.Fetcher {
+	return remotes.FetcherFunc(func(ctx context.Context, desc ocispec.Descriptor) (io.ReadCloser, error) {
+		if !cacheable(desc) {
+			return f.Fetch(ctx, desc)
+		}
+		p, err := c.path(desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		if fp, err := os.Open(p); err == nil {
+			return fp, nil
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		rc, err := f.Fetch(ctx, desc)
+		if err != nil {
+			return nil, err
+		}
+		w, err := c.writer(p, desc)
+		if err != nil {
+			// Caching is best effort, continue without caching
+			return rc, nil
+		}
+		return &cachingReader{
+			ReadCloser: rc,
+			w:          w,
+		}, nil
+	})
+}
+
+// cacheable returns whether the blob is small metadata which is worth
+// caching, layers are never cached
+func cacheable(desc ocispec.Descriptor) bool {
+	return images.IsIndexType(desc.MediaType) || images.IsManifestType(desc.MediaType) || images.IsConfigType(desc.MediaType)
+}
+
+func (c *BlobCache) path(dgst digest.Digest) (string, error) {
+	if err := dgst.Validate(); err != nil {
+		return "", fmt.Errorf("%s: %w", err, errdefs.ErrInvalidArgument)
+	}
+	return filepath.Join(c.root, dgst.Algorithm().String(), dgst.Encoded()), nil
+}
+
+func (c *BlobCache) writer(p string, desc ocispec.Descriptor) (*cacheWriter, error) {
+	dir := filepath.Dir(p)
+	if err := os.MkdirAll(dir, c.mode); err != nil {
+		return nil, err
+	}
+	fp, err := os.CreateTemp(dir, ".tmp-"+desc.Digest.Encoded())
+	if err != nil {
+		return nil, err
+	}
+	return &cacheWriter{
+		fp:       fp,
+		path:     p,
+		desc:     desc,
+		digester: desc.Digest.Algorithm().Digester(),
+	}, nil
+}
+
+// cacheWriter writes a blob to a temporary file which is moved into the
+// cache once the full blob has been written and verified
+type cacheWriter struct {
+	fp       *os.File
+	path     string
+	desc     ocispec.Descriptor
+	digester digest.Digester
+	size     int64
+	err      error
+}
+
+func (w *cacheWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return len(p), nil
+	}
+	if _, err := w.fp.Write(p); err != nil {
+		w.err = err
+		return len(p), nil
+	}
+	w.digester.Hash().Write(p)
+	w.size += int64(len(p))
+	return len(p), nil
+}
+
+// commit moves the blob into the cache when it was completely written and
+// matches the expected digest, otherwise the temporary file is removed
+func (w *cacheWriter) commit(complete bool) {
+	name := w.fp.Name()
+	if err := w.fp.Close(); err != nil && w.err == nil {
+		w.err = err
+	}
+	if complete && w.err == nil && w.size == w.desc.Size && w.digester.Digest() == w.desc.Digest {
+		if err := os.Rename(name, w.path); err == nil {
+			return
+		}
+	}
+	os.Remove(name)
+}
+
+// cachingReader writes all data read to the cache writer, committing the
+// cached blob once the end of the blob is reached
+type cachingReader struct {
+	io.ReadCloser
+	w    *cacheWriter
+	done bool
+}
+
+func (r *cachingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 && !r.done {
+		r.w.Write(p[:n])
+	}
+	if err == io.EOF && !r.done {
+		r.done = true
+		r.w.commit(true)
+	}
+	return n, err
+}
+
+func (r *cachingReader) Close() error {
+	if !r.done {
+		r.done = true
+		r.w.commit(false)
+	}
+	return r.ReadCloser.Close()
+}
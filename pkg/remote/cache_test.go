@@ -0,0 +1,178 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package remote
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/remotes/docker"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+type countingFetcher struct {
+	blobs   map[digest.Digest][]byte
+	fetched int
+}
+
+func (f *countingFetcher) Fetch(ctx context.Context, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	f.fetched++
+	return io.NopCloser(bytes.NewReader(f.blobs[desc.Digest])), nil
+}
+
+func TestBlobCache(t *testing.T) {
+	var (
+		ctx      = context.Background()
+		manifest = []byte(`{"schemaVersion":2}`)
+		layer    = []byte("layer data")
+		blobs    = map[digest.Digest][]byte{
+			digest.FromBytes(manifest): manifest,
+			digest.FromBytes(layer):    layer,
+		}
+		manifestDesc = ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageManifest,
+			Digest:    digest.FromBytes(manifest),
+			Size:      int64(len(manifest)),
+		}
+		layerDesc = ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageLayerGzip,
+			Digest:    digest.FromBytes(layer),
+			Size:      int64(len(layer)),
+		}
+		remote = &countingFetcher{blobs: blobs}
+		cache  = NewBlobCache(t.TempDir(), 0700)
+	)
+
+	fetch := func(desc ocispec.Descriptor, readAll bool) []byte {
+		t.Helper()
+		rc, err := cache.Fetcher(remote).Fetch(ctx, desc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if !readAll {
+			return nil
+		}
+		b, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	expectFetched := func(expected int) {
+		t.Helper()
+		if remote.fetched != expected {
+			t.Fatalf("expected %d remote fetches, got %d", expected, remote.fetched)
+		}
+	}
+
+	// A partially read blob is not cached
+	fetch(manifestDesc, false)
+	expectFetched(1)
+
+	for i := 0; i < 2; i++ {
+		if b := fetch(manifestDesc, true); !bytes.Equal(b, manifest) {
+			t.Fatalf("unexpected manifest content %q", b)
+		}
+	}
+	expectFetched(2)
+
+	// Layers are always fetched from the remote
+	for i := 0; i < 2; i++ {
+		fetch(layerDesc, true)
+	}
+	expectFetched(4)
+
+	// Content not matching the descriptor is not cached
+	remote.blobs[digest.FromString("other")] = manifest
+	mismatched := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageIndex,
+		Digest:    digest.FromString("other"),
+		Size:      int64(len(manifest)),
+	}
+	for i := 0; i < 2; i++ {
+		fetch(mismatched, true)
+	}
+	expectFetched(6)
+}
+
+func TestBlobCacheRegistry(t *testing.T) {
+	var (
+		ctx      = context.Background()
+		manifest = []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`)
+		dgst     = digest.FromBytes(manifest)
+		gets     int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/test/manifests/latest" && r.URL.Path != "/v2/test/manifests/"+dgst.String() {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodGet {
+			gets++
+		}
+		w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+		w.Header().Set("Docker-Content-Digest", dgst.String())
+		w.Header().Set("Content-Length", strconv.Itoa(len(manifest)))
+		if r.Method == http.MethodGet {
+			w.Write(manifest)
+		}
+	}))
+	defer srv.Close()
+
+	cache := NewBlobCache(t.TempDir(), 0700)
+	inspect := func() {
+		t.Helper()
+		reg := NewRegistry(strings.TrimPrefix(srv.URL, "http://")+"/test:latest", WithPlainHTTP(docker.MatchLocalhost))
+		name, desc, err := reg.Resolve(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := reg.Fetcher(ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rc, err := cache.Fetcher(f).Fetch(ctx, desc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		b, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, manifest) {
+			t.Fatalf("unexpected manifest %q", b)
+		}
+	}
+
+	inspect()
+	if gets != 1 {
+		t.Fatalf("expected manifest to be fetched once, got %d", gets)
+	}
+	inspect()
+	if gets != 1 {
+		t.Fatalf("expected cached manifest to be used, got %d fetches", gets)
+	}
+}
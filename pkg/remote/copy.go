@@ -0,0 +1,304 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package remote
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/labels"
+	"github.com/containerd/containerd/pkg/transfer/image"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/reference"
+	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/lcontainerd/pkg/index"
+	"github.com/containerd/lcontainerd/pkg/iobuf"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// CopyStats counts the blobs and manifests handled by a copy
+type CopyStats struct {
+	// Copied is the number transferred from the source
+	Copied int
+	// Existing is the number already present on the destination or mounted
+	// from the source repository on the same registry
+	Existing int
+}
+
+// Copy copies an image directly from the source to the destination registry
+// without storing it locally. The destination is checked for each blob before
+// it is fetched so only blobs missing from the destination are transferred,
+// making repeated copies of a changing image incremental. Blobs missing from
+// a destination on the same registry host as the source are mounted from the
+// source repository. Manifests are pushed after the content they reference.
+func Copy(ctx context.Context, src, dst *Registry) (CopyStats, error) {
+	var stats CopyStats
+	if err := CheckAllowedHost(dst.reference, dst.allowlist); err != nil {
+		return stats, err
+	}
+	name, desc, err := src.Resolve(ctx)
+	if err != nil {
+		return stats, err
+	}
+	fetcher, err := src.Fetcher(ctx, name)
+	if err != nil {
+		return stats, err
+	}
+	pusher, err := dst.Pusher(ctx, desc)
+	if err != nil {
+		return stats, err
+	}
+	sourceKey, sourceRepo, err := distributionSource(name)
+	if err != nil {
+		return stats, err
+	}
+
+	c := &copier{
+		fetcher: fetcher,
+		pusher:  pusher,
+		source:  map[string]string{sourceKey: sourceRepo},
+		seen:    map[string]struct{}{},
+		stats:   &stats,
+	}
+	if err := c.copy(ctx, desc); err != nil {
+		return stats, err
+	}
+	return stats, nil
+}
+
+// TransferFunc runs a transfer from the source to the destination, such as
+// through a transfer service with progress reporting
+type TransferFunc func(ctx context.Context, src, dst interface{}) error
+
+// CopyThrough copies an image from the source to the destination registry
+// through the local content store. The image is fetched and stored locally
+// under the given name, then pushed to the destination from the local store.
+// When platforms are given, a fetched index is reduced to the manifests for
+// those platforms before pushing. Since the reduced image replaces the stored
+// image, the copy is refused when filtering platforms and an image with the
+// name already exists.
+func CopyThrough(ctx context.Context, transfer TransferFunc, cs content.Store, is images.Store, src, dst *Registry, name string, ps ...ocispec.Platform) error {
+	var sopts []image.StoreOpt
+	if len(ps) > 0 {
+		if _, err := is.Get(ctx, name); err == nil {
+			return fmt.Errorf("image %s would be replaced by the platform filtered copy: %w", name, errdefs.ErrAlreadyExists)
+		} else if !errdefs.IsNotFound(err) {
+			return err
+		}
+		sopts = append(sopts, image.WithPlatforms(ps...))
+	}
+
+	if err := transfer(ctx, src, image.NewStore(name, sopts...)); err != nil {
+		return err
+	}
+	if len(ps) > 0 {
+		if err := filterImagePlatforms(ctx, cs, is, name, platforms.Any(ps...)); err != nil {
+			return err
+		}
+	}
+	return transfer(ctx, image.NewStore(name), dst)
+}
+
+// filterImagePlatforms updates an image pointing to an index to only refer to
+// the manifests matching the platform. When only a single manifest matches,
+// the image is updated to point directly to that manifest.
+func filterImagePlatforms(ctx context.Context, store content.Store, is images.Store, name string, matcher platforms.MatchComparer) error {
+	img, err := is.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+	switch img.Target.MediaType {
+	case images.MediaTypeDockerSchema2ManifestList, ocispec.MediaTypeImageIndex:
+	default:
+		return nil
+	}
+
+	b, err := iobuf.ReadBlob(ctx, store, img.Target)
+	if err != nil {
+		return err
+	}
+	var idx ocispec.Index
+	if err := json.Unmarshal(b, &idx); err != nil {
+		return err
+	}
+
+	var manifests []ocispec.Descriptor
+	for _, m := range idx.Manifests {
+		if m.Platform == nil || !matcher.Match(*m.Platform) {
+			continue
+		}
+		manifests = append(manifests, m)
+	}
+	switch len(manifests) {
+	case 0:
+		return fmt.Errorf("no manifest in %s matches the requested platforms", name)
+	case len(idx.Manifests):
+		return nil
+	case 1:
+		img.Target = manifests[0]
+	default:
+		idx.Manifests = manifests
+		b, err = json.Marshal(idx)
+		if err != nil {
+			return err
+		}
+		var labels map[string]string
+		for i, m := range manifests {
+			labels = index.ChildGCLabels(m, i, labels)
+		}
+		img.Target = ocispec.Descriptor{
+			MediaType: img.Target.MediaType,
+			Digest:    digest.FromBytes(b),
+			Size:      int64(len(b)),
+		}
+		if err := content.WriteBlob(ctx, store, img.Target.Digest.String()+"-ingest", bytes.NewReader(b), img.Target, content.WithLabels(labels)); err != nil {
+			return fmt.Errorf("failed to write filtered index: %w", err)
+		}
+	}
+
+	_, err = is.Update(ctx, img, "target")
+	return err
+}
+
+// distributionSource returns the distribution source annotation for content
+// from the reference, used by the pusher to mount blobs from the repository
+func distributionSource(ref string) (key, repo string, err error) {
+	refspec, err := reference.Parse(ref)
+	if err != nil {
+		return "", "", err
+	}
+	u, err := url.Parse("dummy://" + refspec.Locator)
+	if err != nil {
+		return "", "", err
+	}
+	return labels.LabelDistributionSource + "." + u.Hostname(), strings.TrimPrefix(u.Path, "/"), nil
+}
+
+type copier struct {
+	fetcher remotes.Fetcher
+	pusher  remotes.Pusher
+	source  map[string]string
+	seen    map[string]struct{}
+	stats   *CopyStats
+}
+
+func (c *copier) copy(ctx context.Context, desc ocispec.Descriptor) error {
+	if _, ok := c.seen[desc.Digest.String()]; ok {
+		return nil
+	}
+	c.seen[desc.Digest.String()] = struct{}{}
+
+	switch desc.MediaType {
+	case images.MediaTypeDockerSchema2Manifest, ocispec.MediaTypeImageManifest,
+		images.MediaTypeDockerSchema2ManifestList, ocispec.MediaTypeImageIndex:
+	default:
+		desc.Annotations = c.annotate(desc.Annotations)
+		return c.push(ctx, desc, func() (io.ReadCloser, error) {
+			return c.fetcher.Fetch(ctx, desc)
+		})
+	}
+
+	// Manifests are always fetched to find the content they reference,
+	// which may be missing even when the manifest is on the destination
+	rc, err := c.fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return err
+	}
+	b, err := io.ReadAll(io.LimitReader(rc, maxIndexSize+1))
+	rc.Close()
+	if err != nil {
+		return err
+	}
+	if len(b) > maxIndexSize {
+		return fmt.Errorf("manifest %s exceeds maximum size of %d bytes", desc.Digest, maxIndexSize)
+	}
+	if actual := desc.Digest.Algorithm().FromBytes(b); actual != desc.Digest {
+		return fmt.Errorf("manifest fetched for %s does not match its digest, got %s", desc.Digest, actual)
+	}
+	var m struct {
+		Config    *ocispec.Descriptor  `json:"config"`
+		Layers    []ocispec.Descriptor `json:"layers"`
+		Manifests []ocispec.Descriptor `json:"manifests"`
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return fmt.Errorf("failed to read manifest %s: %w", desc.Digest, err)
+	}
+	children := append(m.Manifests, m.Layers...)
+	if m.Config != nil {
+		children = append(children, *m.Config)
+	}
+	for _, child := range children {
+		if err := c.copy(ctx, child); err != nil {
+			return err
+		}
+	}
+
+	return c.push(ctx, desc, func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(b)), nil
+	})
+}
+
+// annotate adds the source repository to the annotations, allowing the blob
+// to be mounted rather than transferred
+func (c *copier) annotate(annotations map[string]string) map[string]string {
+	a := make(map[string]string, len(annotations)+len(c.source))
+	for k, v := range annotations {
+		a[k] = v
+	}
+	for k, v := range c.source {
+		a[k] = v
+	}
+	return a
+}
+
+// push pushes the content to the destination, only opening the content when
+// the destination does not already have it
+func (c *copier) push(ctx context.Context, desc ocispec.Descriptor, open func() (io.ReadCloser, error)) error {
+	w, err := c.pusher.Push(ctx, desc)
+	if err != nil {
+		if errdefs.IsAlreadyExists(err) {
+			c.stats.Existing++
+			return nil
+		}
+		return err
+	}
+	defer w.Close()
+
+	rc, err := open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if err := content.Copy(ctx, w, rc, desc.Size, desc.Digest); err != nil {
+		if errdefs.IsAlreadyExists(err) {
+			c.stats.Existing++
+			return nil
+		}
+		return err
+	}
+	c.stats.Copied++
+	return nil
+}
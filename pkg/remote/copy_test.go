@@ -0,0 +1,393 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/pkg/transfer/local"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/containerd/lcontainerd/pkg/db"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestCopySkipExisting(t *testing.T) {
+	var (
+		srcReg = newTestRegistry()
+		dstReg = newTestRegistry()
+		src    = httptest.NewServer(srcReg)
+		dst    = httptest.NewServer(dstReg)
+		ctx    = context.Background()
+	)
+	defer src.Close()
+	defer dst.Close()
+
+	srcRef := strings.TrimPrefix(src.URL, "http://") + "/library/test:latest"
+	dstRef := strings.TrimPrefix(dst.URL, "http://") + "/mirror/test:latest"
+	copyImage := func() CopyStats {
+		t.Helper()
+		stats, err := Copy(ctx,
+			NewRegistry(srcRef, WithPlainHTTP(docker.MatchLocalhost)),
+			NewRegistry(dstRef, WithPlainHTTP(docker.MatchLocalhost)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stats
+	}
+
+	layers := []string{"layer one", "layer two"}
+	srcReg.addImage(t, "library/test", "latest", layers)
+	if stats := copyImage(); stats.Copied != 4 || stats.Existing != 0 {
+		t.Fatalf("expected config, layers, and manifest to be copied, got %+v", stats)
+	}
+	if n := len(dstReg.uploads); n != 3 {
+		t.Fatalf("expected 3 blob uploads, got %d", n)
+	}
+
+	// Only the new layer and manifest are transferred to the mirror
+	srcReg.reset()
+	dstReg.reset()
+	manifest := srcReg.addImage(t, "library/test", "latest", append(layers, "layer three"))
+	if stats := copyImage(); stats.Copied != 2 || stats.Existing != 3 {
+		t.Fatalf("expected only the new layer and manifest to be copied, got %+v", stats)
+	}
+	expected := []string{digest.FromString("layer three").String()}
+	if !equalStrings(srcReg.blobGets, expected) {
+		t.Errorf("expected only the new layer fetched from the source, got %v", srcReg.blobGets)
+	}
+	if !equalStrings(dstReg.uploads, expected) {
+		t.Errorf("expected only the new layer uploaded, got %v", dstReg.uploads)
+	}
+	if b, ok := dstReg.manifests["mirror/test:latest"]; !ok || digest.FromBytes(b) != manifest {
+		t.Fatalf("expected destination tag updated to %s", manifest)
+	}
+
+	// Nothing is transferred once up to date
+	srcReg.reset()
+	dstReg.reset()
+	if stats := copyImage(); stats.Copied != 0 || stats.Existing != 5 {
+		t.Fatalf("expected nothing to be copied, got %+v", stats)
+	}
+	if len(srcReg.blobGets) != 0 || len(dstReg.uploads) != 0 {
+		t.Fatalf("expected no blobs transferred, fetched %v and uploaded %v", srcReg.blobGets, dstReg.uploads)
+	}
+}
+
+func TestCopyMount(t *testing.T) {
+	reg := newTestRegistry()
+	srv := httptest.NewServer(reg)
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	reg.addImage(t, "library/test", "latest", []string{"layer one", "layer two"})
+	stats, err := Copy(context.Background(),
+		NewRegistry(host+"/library/test:latest", WithPlainHTTP(docker.MatchLocalhost)),
+		NewRegistry(host+"/mirror/test:latest", WithPlainHTTP(docker.MatchLocalhost)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Blobs are mounted, only the manifest is transferred
+	if stats.Copied != 1 || stats.Existing != 3 {
+		t.Fatalf("expected blobs to be mounted, got %+v", stats)
+	}
+	if len(reg.blobGets) != 0 || len(reg.uploads) != 0 {
+		t.Fatalf("expected no blobs transferred, fetched %v and uploaded %v", reg.blobGets, reg.uploads)
+	}
+	if len(reg.mounts) != 3 {
+		t.Fatalf("expected 3 blobs mounted, got %v", reg.mounts)
+	}
+}
+
+// testRegistry is a minimal registry storing blobs per repository, recording
+// the blobs fetched, uploaded, and mounted
+type testRegistry struct {
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	blobGets  []string
+	uploads   []string
+	mounts    []string
+}
+
+func TestCopyThrough(t *testing.T) {
+	var (
+		srcReg = newTestRegistry()
+		dstReg = newTestRegistry()
+		src    = httptest.NewServer(srcReg)
+		dst    = httptest.NewServer(dstReg)
+		ctx    = namespaces.WithNamespace(context.Background(), "testing")
+	)
+	defer src.Close()
+	defer dst.Close()
+
+	manifestDesc := func(repo string, dgst digest.Digest, platform string) ocispec.Descriptor {
+		b := srcReg.manifests[repo+"@"+dgst.String()]
+		p := platforms.MustParse(platform)
+		return ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageManifest,
+			Digest:    dgst,
+			Size:      int64(len(b)),
+			Platform:  &p,
+		}
+	}
+	amd := srcReg.addImage(t, "library/test", "amd64", []string{"amd64 layer"})
+	arm := srcReg.addImage(t, "library/test", "arm64", []string{"arm64 layer"})
+	idx := srcReg.addIndex(t, "library/test", "latest", []ocispec.Descriptor{
+		manifestDesc("library/test", amd, "linux/amd64"),
+		manifestDesc("library/test", arm, "linux/arm64"),
+	})
+
+	mdb, err := db.NewDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mdb.Close(ctx)
+	is := db.NewImageStore(mdb)
+	ts := local.NewTransferService(db.NewLeaseManager(mdb), mdb.ContentStore(), is, &local.TransferConfig{})
+	transfer := func(ctx context.Context, src, dst interface{}) error {
+		return ts.Transfer(ctx, src, dst)
+	}
+
+	srcRef := strings.TrimPrefix(src.URL, "http://") + "/library/test:latest"
+	dstRef := strings.TrimPrefix(dst.URL, "http://") + "/mirror/test:latest"
+	copyImage := func(name string, ps ...ocispec.Platform) error {
+		return CopyThrough(ctx, transfer, mdb.ContentStore(), is,
+			NewRegistry(srcRef, WithPlainHTTP(docker.MatchLocalhost)),
+			NewRegistry(dstRef, WithPlainHTTP(docker.MatchLocalhost)),
+			name, ps...)
+	}
+
+	// Only the requested platform is copied
+	if err := copyImage("local", platforms.MustParse("linux/arm64")); err != nil {
+		t.Fatal(err)
+	}
+	if b, ok := dstReg.manifests["mirror/test:latest"]; !ok || digest.FromBytes(b) != arm {
+		t.Fatalf("expected destination tag to refer to the arm64 manifest %s", arm)
+	}
+	for _, dgst := range dstReg.uploads {
+		if dgst == digest.FromString("amd64 layer").String() {
+			t.Fatal("expected amd64 layer not to be copied")
+		}
+	}
+	img, err := is.Get(ctx, "local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Target.Digest != arm {
+		t.Fatalf("expected local image to refer to %s, got %s", arm, img.Target.Digest)
+	}
+
+	// An existing image is not replaced by a platform filtered copy
+	if err := copyImage("local", platforms.MustParse("linux/amd64")); !errdefs.IsAlreadyExists(err) {
+		t.Fatalf("expected already exists copying over an existing image, got %v", err)
+	}
+	if img, err := is.Get(ctx, "local"); err != nil {
+		t.Fatal(err)
+	} else if img.Target.Digest != arm {
+		t.Fatalf("expected existing image unchanged, got %s", img.Target.Digest)
+	}
+
+	// Without platforms the whole index is copied
+	dstReg.reset()
+	if err := copyImage("local"); err != nil {
+		t.Fatal(err)
+	}
+	if b, ok := dstReg.manifests["mirror/test:latest"]; !ok || digest.FromBytes(b) != idx {
+		t.Fatalf("expected destination tag to refer to the index %s", idx)
+	}
+	if _, ok := dstReg.blobs["mirror/test@"+digest.FromString("amd64 layer").String()]; !ok {
+		t.Fatal("expected amd64 layer to be copied with the index")
+	}
+}
+
+func newTestRegistry() *testRegistry {
+	return &testRegistry{
+		blobs:     map[string][]byte{},
+		manifests: map[string][]byte{},
+	}
+}
+
+func (r *testRegistry) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.blobGets, r.uploads, r.mounts = nil, nil, nil
+}
+
+// addImage adds an image with the layers to the repository, returning the
+// digest of its manifest
+func (r *testRegistry) addImage(t *testing.T, repo, tag string, layers []string) digest.Digest {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	config := []byte(`{"architecture":"amd64","os":"linux","rootfs":{"type":"layers"}}`)
+	m := ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config: ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageConfig,
+			Digest:    digest.FromBytes(config),
+			Size:      int64(len(config)),
+		},
+	}
+	r.blobs[repo+"@"+m.Config.Digest.String()] = config
+	for _, l := range layers {
+		desc := ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageLayer,
+			Digest:    digest.FromString(l),
+			Size:      int64(len(l)),
+		}
+		r.blobs[repo+"@"+desc.Digest.String()] = []byte(l)
+		m.Layers = append(m.Layers, desc)
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.manifests[repo+":"+tag] = b
+	r.manifests[repo+"@"+digest.FromBytes(b).String()] = b
+	return digest.FromBytes(b)
+}
+
+// addIndex adds an index of the manifests to the repository, returning the
+// digest of the index
+func (r *testRegistry) addIndex(t *testing.T, repo, tag string, manifests []ocispec.Descriptor) digest.Digest {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b, err := json.Marshal(ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: manifests,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.manifests[repo+":"+tag] = b
+	r.manifests[repo+"@"+digest.FromBytes(b).String()] = b
+	return digest.FromBytes(b)
+}
+
+func (r *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	w.Header().Set("Docker-Distribution-Api-Version", "registry/2.0")
+	p := strings.TrimPrefix(req.URL.Path, "/v2/")
+	switch {
+	case p == "":
+	case strings.HasSuffix(p, "/blobs/uploads/"):
+		repo := strings.TrimSuffix(p, "/blobs/uploads/")
+		q := req.URL.Query()
+		if b, ok := r.blobs[q.Get("from")+"@"+q.Get("mount")]; ok {
+			r.blobs[repo+"@"+q.Get("mount")] = b
+			r.mounts = append(r.mounts, q.Get("mount"))
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		w.Header().Set("Location", "/v2/"+repo+"/blobs/uploads/"+strconv.Itoa(len(r.uploads)))
+		w.WriteHeader(http.StatusAccepted)
+	case strings.Contains(p, "/blobs/uploads/"):
+		repo := p[:strings.Index(p, "/blobs/uploads/")]
+		b, err := io.ReadAll(req.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		dgst := req.URL.Query().Get("digest")
+		if digest.FromBytes(b).String() != dgst {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.blobs[repo+"@"+dgst] = b
+		r.uploads = append(r.uploads, dgst)
+		w.Header().Set("Docker-Content-Digest", dgst)
+		w.WriteHeader(http.StatusCreated)
+	case strings.Contains(p, "/blobs/"):
+		i := strings.Index(p, "/blobs/")
+		dgst := p[i+len("/blobs/"):]
+		b, ok := r.blobs[p[:i]+"@"+dgst]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", dgst)
+		w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+		if req.Method == http.MethodGet {
+			r.blobGets = append(r.blobGets, dgst)
+			w.Write(b)
+		}
+	case strings.Contains(p, "/manifests/"):
+		i := strings.Index(p, "/manifests/")
+		repo, object := p[:i], p[i+len("/manifests/"):]
+		key := repo + ":" + object
+		if strings.Contains(object, ":") {
+			key = repo + "@" + object
+		}
+		if req.Method == http.MethodPut {
+			b, err := io.ReadAll(req.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			r.manifests[key] = b
+			r.manifests[repo+"@"+digest.FromBytes(b).String()] = b
+			w.Header().Set("Docker-Content-Digest", digest.FromBytes(b).String())
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		b, ok := r.manifests[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", manifestMediaType(b))
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(b).String())
+		w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+		if req.Method == http.MethodGet {
+			w.Write(b)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
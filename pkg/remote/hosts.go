@@ -0,0 +1,74 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package remote
+
+import (
+	"net/http"
+	"sync"
+)
+
+// HostTransport sends requests with the transport set for the host of the
+// request, requests to other hosts are sent with the default transport. It
+// is used as the base of a client's transport so the transports wrapping it,
+// such as for tracing and rate limiting, also apply to the hosts configured
+// with certificates in the hosts directory, see WithHostTransport.
+type HostTransport struct {
+	rt         http.RoundTripper
+	skipVerify []string
+
+	mu    sync.RWMutex
+	hosts map[string]http.RoundTripper
+}
+
+// NewHostTransport returns a transport sending requests to hosts without a
+// transport set with rt. When rt is nil, the default transport is used. The
+// TLS certificates of the skipVerify registry hosts are not verified, with
+// rt or the transport set for the host, see NewSkipVerifyTransport.
+func NewHostTransport(rt http.RoundTripper, skipVerify ...string) *HostTransport {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	if len(skipVerify) > 0 {
+		rt = NewSkipVerifyTransport(rt, skipVerify...)
+	}
+	return &HostTransport{
+		rt:         rt,
+		skipVerify: skipVerify,
+		hosts:      map[string]http.RoundTripper{},
+	}
+}
+
+// SetHost sets the transport used for requests to the host, the host is
+// compared with the host and port of the request URL
+func (t *HostTransport) SetHost(host string, rt http.RoundTripper) {
+	if len(t.skipVerify) > 0 {
+		rt = NewSkipVerifyTransport(rt, t.skipVerify...)
+	}
+	t.mu.Lock()
+	t.hosts[host] = rt
+	t.mu.Unlock()
+}
+
+func (t *HostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.RLock()
+	rt, ok := t.hosts[req.URL.Host]
+	t.mu.RUnlock()
+	if !ok {
+		rt = t.rt
+	}
+	return rt.RoundTrip(req)
+}
@@ -0,0 +1,87 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/leases"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/lcontainerd/pkg/iobuf"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// InspectMetadata fetches the index, manifests, and configs for the
+// descriptor matching the platform, skipping layers, and calls fn to inspect
+// the fetched content. The content is held by a temporary lease which is
+// deleted once fn returns, nothing fetched is kept once the lease's content
+// is garbage collected.
+func InspectMetadata(ctx context.Context, lm leases.Manager, cs content.Store, fetcher remotes.Fetcher, desc ocispec.Descriptor, matcher platforms.Matcher, fn func(context.Context) error) error {
+	l, err := lm.Create(ctx, leases.WithRandomID(), leases.WithExpiration(time.Hour))
+	if err != nil {
+		return err
+	}
+	defer lm.Delete(ctx, l)
+	ctx = leases.WithLease(ctx, l.ID)
+
+	if err := fetchMetadata(ctx, cs, fetcher, desc, matcher); err != nil {
+		return err
+	}
+	return fn(ctx)
+}
+
+// fetchMetadata fetches the index, manifests, and configs for the descriptor
+// matching the platform, skipping layers
+func fetchMetadata(ctx context.Context, cs content.Store, fetcher remotes.Fetcher, desc ocispec.Descriptor, matcher platforms.Matcher) error {
+	children := images.FilterPlatforms(images.ChildrenHandler(cs), matcher)
+	metadataChildren := images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		descs, err := children(ctx, desc)
+		if err != nil {
+			return nil, err
+		}
+		var filtered []ocispec.Descriptor
+		for _, d := range descs {
+			if images.IsIndexType(d.MediaType) || images.IsManifestType(d.MediaType) || images.IsConfigType(d.MediaType) {
+				filtered = append(filtered, d)
+			} else if images.IsManifestType(desc.MediaType) && isManifestConfig(ctx, cs, desc, d) {
+				filtered = append(filtered, d)
+			}
+		}
+		return filtered, nil
+	})
+
+	return images.Dispatch(ctx, images.Handlers(remotes.FetchHandler(cs, fetcher), metadataChildren), nil, desc)
+}
+
+// isManifestConfig returns whether the descriptor is the config of the manifest,
+// used to fetch configs with media types which are not known config types
+func isManifestConfig(ctx context.Context, provider content.Provider, manifest, desc ocispec.Descriptor) bool {
+	b, err := iobuf.ReadBlob(ctx, provider, manifest)
+	if err != nil {
+		return false
+	}
+	var m ocispec.Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return false
+	}
+	return m.Config.Digest == desc.Digest
+}
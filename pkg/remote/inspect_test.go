@@ -0,0 +1,112 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package remote
+
+import (
+	"context"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/containerd/lcontainerd/pkg/db"
+	digest "github.com/opencontainers/go-digest"
+)
+
+func TestInspectMetadata(t *testing.T) {
+	reg := newTestRegistry()
+	srv := httptest.NewServer(reg)
+	defer srv.Close()
+
+	manifest := reg.addImage(t, "library/test", "latest", []string{"layer"})
+	ctx := namespaces.WithNamespace(context.Background(), "testing")
+	root := filepath.Join(t.TempDir(), "root")
+	mdb, err := db.NewDB(root, db.WithDirMode(0700))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRegistry(strings.TrimPrefix(srv.URL, "http://")+"/library/test:latest", WithPlainHTTP(docker.MatchLocalhost))
+	name, desc, err := r.Resolve(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetcher, err := r.Fetcher(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := mdb.ContentStore()
+	if err := InspectMetadata(ctx, db.NewLeaseManager(mdb), cs, fetcher, desc, platforms.All, func(ctx context.Context) error {
+		b, err := content.ReadBlob(ctx, cs, desc)
+		if err != nil {
+			return err
+		}
+		if digest.FromBytes(b) != manifest {
+			t.Errorf("unexpected manifest %s", digest.FromBytes(b))
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, dgst := range reg.blobGets {
+		if dgst == digest.FromString("layer").String() {
+			t.Fatal("expected layer not to be fetched")
+		}
+	}
+	if err := mdb.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing fetched remains once the database is collected on close
+	mdb, err = db.NewDB(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mdb.Close(ctx)
+	var blobs []digest.Digest
+	if err := mdb.ContentStore().Walk(ctx, func(info content.Info) error {
+		blobs = append(blobs, info.Digest)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(blobs) > 0 {
+		t.Fatalf("expected no content after inspect, got %v", blobs)
+	}
+	if statuses, err := mdb.ContentStore().ListStatuses(ctx); err != nil {
+		t.Fatal(err)
+	} else if len(statuses) > 0 {
+		t.Fatalf("expected no ingests after inspect, got %d", len(statuses))
+	}
+	if ls, err := db.NewLeaseManager(mdb).List(ctx); err != nil {
+		t.Fatal(err)
+	} else if len(ls) > 0 {
+		t.Fatalf("expected no leases after inspect, got %d", len(ls))
+	}
+	if imgs, err := db.NewImageStore(mdb).List(ctx); err != nil {
+		t.Fatal(err)
+	} else if len(imgs) > 0 {
+		t.Fatalf("expected no images after inspect, got %d", len(imgs))
+	}
+	if _, err := mdb.ContentStore().Info(ctx, manifest); err == nil {
+		t.Fatal("expected manifest to be removed")
+	}
+}
@@ -0,0 +1,65 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package remote
+
+import (
+	"net/http"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+type rateLimitTransport struct {
+	rt    http.RoundTripper
+	limit rate.Limit
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+// NewRateLimitTransport returns a transport which limits the requests sent
+// to each host to the given number of requests per second. When rt is nil,
+// the default transport is used.
+func NewRateLimitTransport(rt http.RoundTripper, requestsPerSecond float64) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return &rateLimitTransport{
+		rt:       rt,
+		limit:    rate.Limit(requestsPerSecond),
+		limiters: map[string]*rate.Limiter{},
+	}
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter(req.URL.Host).Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	return t.rt.RoundTrip(req)
+}
+
+// limiter returns the limiter for the host, each host is limited separately
+func (t *rateLimitTransport) limiter(host string) *rate.Limiter {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l, ok := t.limiters[host]
+	if !ok {
+		l = rate.NewLimiter(t.limit, 1)
+		t.limiters[host] = l
+	}
+	return l
+}
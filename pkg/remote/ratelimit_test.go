@@ -0,0 +1,60 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package remote
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimitTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer other.Close()
+
+	const rps = 20
+	client := &http.Client{Transport: NewRateLimitTransport(srv.Client().Transport, rps)}
+	get := func(url string) {
+		t.Helper()
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	// The first request is allowed immediately, each following request
+	// waits for the rate interval
+	const requests = 5
+	start := time.Now()
+	for i := 0; i < requests; i++ {
+		get(srv.URL)
+	}
+	if elapsed, min := time.Since(start), (requests-1)*time.Second/rps; elapsed < min {
+		t.Fatalf("expected %d requests to take at least %s, took %s", requests, min, elapsed)
+	}
+
+	// Other hosts are limited separately
+	start = time.Now()
+	get(other.URL)
+	if elapsed := time.Since(start); elapsed >= time.Second/rps {
+		t.Fatalf("expected request to another host to not wait, took %s", elapsed)
+	}
+}
@@ -0,0 +1,60 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	dockerref "github.com/containerd/containerd/reference/docker"
+)
+
+// NormalizeReference returns the fully qualified form of the image
+// reference, such as "docker.io/library/ubuntu:latest" for "ubuntu", and the
+// registry host of the reference
+func NormalizeReference(ref string) (name, host string, err error) {
+	named, err := dockerref.ParseDockerRef(ref)
+	if err != nil {
+		return "", "", err
+	}
+	return named.String(), dockerref.Domain(named), nil
+}
+
+// ErrHostNotAllowed is returned for references to a registry host which is
+// not in the allowlist
+var ErrHostNotAllowed = errors.New("registry host not allowed")
+
+// CheckAllowedHost returns an ErrHostNotAllowed error when the
+// registry host of the reference is not in the allowlist. Hosts are compared
+// as returned by NormalizeReference, references without a host are from
+// "docker.io". An empty allowlist allows all hosts.
+func CheckAllowedHost(ref string, allowlist []string) error {
+	if len(allowlist) == 0 {
+		return nil
+	}
+	_, host, err := NormalizeReference(ref)
+	if err != nil {
+		return err
+	}
+	for _, allowed := range allowlist {
+		if strings.EqualFold(host, allowed) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q is not in the allowlist", ErrHostNotAllowed, host)
+}
@@ -0,0 +1,521 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package remote provides transfer sources and destinations for remote
+// registries with a configurable registry client.
+package remote
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/pkg/transfer"
+	"github.com/containerd/containerd/pkg/transfer/registry"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/reference"
+	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/containerd/containerd/remotes/docker/config"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// LabelSourceRef is the image label holding the registry reference an image
+// was pulled from
+const LabelSourceRef = "lctr.io/source-ref"
+
+// maxIndexSize is the largest index read when checking platforms
+const maxIndexSize = 4 << 20
+
+type registryOpts struct {
+	creds     registry.CredentialHelper
+	headers   http.Header
+	client    *http.Client
+	plain     func(string) (bool, error)
+	noResolve bool
+	allowlist []string
+	hostsDir  string
+	proxy     func(*http.Request) (*url.URL, error)
+	hostTr    *HostTransport
+}
+
+// RegistryOpt configures a registry
+type RegistryOpt func(*registryOpts)
+
+// WithCredentials sets the credential helper used to authorize requests
+func WithCredentials(creds registry.CredentialHelper) RegistryOpt {
+	return func(o *registryOpts) {
+		o.creds = creds
+	}
+}
+
+// WithHeaders sets the headers sent with every request
+func WithHeaders(headers http.Header) RegistryOpt {
+	return func(o *registryOpts) {
+		o.headers = headers
+	}
+}
+
+// WithClient sets the http client used for registry and authorization
+// requests, the default client is used when not set
+func WithClient(client *http.Client) RegistryOpt {
+	return func(o *registryOpts) {
+		o.client = client
+	}
+}
+
+// WithPlainHTTP sets the function used to determine whether a registry host
+// should be accessed over plain http
+func WithPlainHTTP(plain func(string) (bool, error)) RegistryOpt {
+	return func(o *registryOpts) {
+		o.plain = plain
+	}
+}
+
+// WithHostsDir configures registry hosts from the containerd hosts.toml
+// files in dir, such as mirrors and per host certificates. Hosts without a
+// configuration use the default resolution. The client set with WithClient
+// is only used for hosts which are configured with certificates when the
+// base of its transport is set with WithHostTransport.
+func WithHostsDir(dir string) RegistryOpt {
+	return func(o *registryOpts) {
+		o.hostsDir = dir
+	}
+}
+
+// WithProxy sets the proxy used for hosts configured with certificates in
+// the hosts directory, requests to other hosts are sent with the client set
+// with WithClient which is expected to use the same proxy
+func WithProxy(proxy func(*http.Request) (*url.URL, error)) RegistryOpt {
+	return func(o *registryOpts) {
+		o.proxy = proxy
+	}
+}
+
+// WithHostTransport sets the transport at the base of the client set with
+// WithClient. Hosts configured with certificates in the hosts directory are
+// added to it so their requests are sent with the client's transport, such
+// as one tracing or rate limiting requests, rather than bypassing it.
+func WithHostTransport(ht *HostTransport) RegistryOpt {
+	return func(o *registryOpts) {
+		o.hostTr = ht
+	}
+}
+
+// WithNoResolve fetches the manifest of a digest reference directly rather
+// than resolving the reference first, saving a request to the registry.
+// The fetched manifest is verified against the digest of the reference.
+func WithNoResolve(o *registryOpts) {
+	o.noResolve = true
+}
+
+// WithAllowedHosts only allows requests to the given registry hosts, the
+// reference is rejected before any request when its host is not allowed
+func WithAllowedHosts(hosts ...string) RegistryOpt {
+	return func(o *registryOpts) {
+		o.allowlist = append(o.allowlist, hosts...)
+	}
+}
+
+// Registry is an OCI registry which may be used as the source for fetching
+// or the destination for pushing an image with the transfer service.
+type Registry struct {
+	reference string
+	resolver  remotes.Resolver
+	noResolve bool
+	allowlist []string
+
+	// name and mdesc hold the resolved reference and manifest holds the
+	// manifest fetched before the transfer, such as by digest when not
+	// resolving or when checking platforms. They are served to the transfer
+	// so each is only requested from the registry once.
+	mu       sync.Mutex
+	name     string
+	manifest []byte
+	mdesc    ocispec.Descriptor
+}
+
+var (
+	_ transfer.ImageFetcher = &Registry{}
+	_ transfer.ImagePusher  = &Registry{}
+)
+
+// NewRegistry returns a registry for the image reference
+func NewRegistry(ref string, opts ...RegistryOpt) *Registry {
+	var ro registryOpts
+	for _, opt := range opts {
+		opt(&ro)
+	}
+
+	var credsFn func(string) (string, string, error)
+	if ro.creds != nil {
+		creds := ro.creds
+		credsFn = func(host string) (string, string, error) {
+			c, err := creds.GetCredentials(context.Background(), ref, host)
+			if err != nil {
+				return "", "", err
+			}
+
+			return c.Username, c.Secret, nil
+		}
+	}
+
+	var hosts docker.RegistryHosts
+	if ro.hostsDir != "" {
+		hosts = configureHosts(ro, credsFn)
+	} else {
+		aopts := []docker.AuthorizerOpt{
+			docker.WithAuthClient(ro.client),
+		}
+		if credsFn != nil {
+			aopts = append(aopts, docker.WithAuthCreds(credsFn))
+		}
+		hosts = docker.ConfigureDefaultRegistries(
+			docker.WithAuthorizer(docker.NewDockerAuthorizer(aopts...)),
+			docker.WithClient(ro.client),
+			docker.WithPlainHTTP(ro.plain),
+		)
+	}
+
+	return &Registry{
+		reference: ref,
+		noResolve: ro.noResolve,
+		allowlist: ro.allowlist,
+		resolver: docker.NewResolver(docker.ResolverOptions{
+			Hosts:   hosts,
+			Headers: ro.headers,
+		}),
+	}
+}
+
+// configureHosts returns the registry hosts configured by the hosts.toml
+// files in the hosts directory
+func configureHosts(ro registryOpts, credsFn func(string) (string, string, error)) docker.RegistryHosts {
+	return func(host string) ([]docker.RegistryHost, error) {
+		var scheme string
+		if ro.plain != nil {
+			plain, err := ro.plain(host)
+			if err != nil {
+				return nil, err
+			}
+			if plain {
+				scheme = "http"
+			}
+		}
+		hosts, err := config.ConfigureHosts(context.Background(), config.HostOptions{
+			HostDir:       config.HostDirFromRoot(ro.hostsDir),
+			Credentials:   credsFn,
+			DefaultScheme: scheme,
+			UpdateClient: func(c *http.Client) error {
+				tr, ok := c.Transport.(*http.Transport)
+				if !ok {
+					return nil
+				}
+				// Keep the transport of hosts configured with certificates
+				if ro.client != nil && !hasTLSConfig(tr.TLSClientConfig) {
+					c.Transport = ro.client.Transport
+				} else if ro.proxy != nil {
+					tr.Proxy = ro.proxy
+				}
+				return nil
+			},
+		})(host)
+		if err != nil || ro.client == nil || ro.hostTr == nil {
+			return hosts, err
+		}
+		for _, h := range hosts {
+			tr, ok := h.Client.Transport.(*http.Transport)
+			if !ok || !hasTLSConfig(tr.TLSClientConfig) {
+				continue
+			}
+			// Send the host's requests through the client's transport,
+			// the client is shared with the host's authorizer
+			ro.hostTr.SetHost(h.Host, tr)
+			h.Client.Transport = ro.client.Transport
+		}
+		return hosts, nil
+	}
+}
+
+// hasTLSConfig returns whether the TLS configuration changes how
+// certificates are verified or presented
+func hasTLSConfig(c *tls.Config) bool {
+	return c != nil && (c.RootCAs != nil || len(c.Certificates) > 0 || c.InsecureSkipVerify)
+}
+
+func (r *Registry) String() string {
+	return fmt.Sprintf("OCI Registry (%s)", r.reference)
+}
+
+// Image returns the image reference
+func (r *Registry) Image() string {
+	return r.reference
+}
+
+// SourceLabels returns the labels with the source reference label set to
+// the registry reference, for labeling images pulled from the registry
+func (r *Registry) SourceLabels(labels map[string]string) map[string]string {
+	sl := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		sl[k] = v
+	}
+	sl[LabelSourceRef] = r.reference
+	return sl
+}
+
+// Resolve resolves the image reference to its name and descriptor.
+// Deprecated schema 1 manifests are rejected with an errdefs.ErrNotImplemented
+// and hosts not in the allowlist with an ErrHostNotAllowed.
+func (r *Registry) Resolve(ctx context.Context) (name string, desc ocispec.Descriptor, err error) {
+	if err := CheckAllowedHost(r.reference, r.allowlist); err != nil {
+		return "", ocispec.Descriptor{}, err
+	}
+	if r.noResolve {
+		desc, err = r.fetchManifest(ctx)
+		if err != nil {
+			return "", ocispec.Descriptor{}, err
+		}
+		return r.reference, desc, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.name != "" {
+		return r.name, r.mdesc, nil
+	}
+	name, desc, err = r.resolver.Resolve(ctx, r.reference)
+	if err != nil {
+		return "", ocispec.Descriptor{}, err
+	}
+	if err := checkSchema1(r.reference, desc); err != nil {
+		return "", ocispec.Descriptor{}, err
+	}
+	r.name, r.mdesc = name, desc
+	return name, desc, nil
+}
+
+// Fetcher returns a fetcher for the resolved reference
+func (r *Registry) Fetcher(ctx context.Context, ref string) (transfer.Fetcher, error) {
+	if err := CheckAllowedHost(ref, r.allowlist); err != nil {
+		return nil, err
+	}
+	f, err := r.resolver.Fetcher(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.manifest != nil {
+		return &manifestFetcher{Fetcher: f, desc: r.mdesc, manifest: r.manifest}, nil
+	}
+	return f, nil
+}
+
+// fetchManifest fetches the manifest for the digest of the reference without
+// resolving, the media type is read from the manifest
+func (r *Registry) fetchManifest(ctx context.Context) (ocispec.Descriptor, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.manifest != nil {
+		return r.mdesc, nil
+	}
+
+	refspec, err := reference.Parse(r.reference)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	dgst := refspec.Digest()
+	if dgst == "" {
+		return ocispec.Descriptor{}, fmt.Errorf("%s must be a digest reference to fetch without resolving: %w", r.reference, errdefs.ErrInvalidArgument)
+	}
+	if err := dgst.Validate(); err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("invalid digest in %s: %w", r.reference, err)
+	}
+
+	fetcher, err := r.resolver.Fetcher(ctx, r.reference)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	// The media type selects the manifests endpoint, the registry returns
+	// the manifest stored for the digest whatever its type. The size is
+	// unknown until fetched.
+	rc, err := fetcher.Fetch(ctx, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageIndex, Digest: dgst, Size: -1})
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	b, err := io.ReadAll(io.LimitReader(rc, maxIndexSize+1))
+	rc.Close()
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	if len(b) > maxIndexSize {
+		return ocispec.Descriptor{}, fmt.Errorf("manifest %s exceeds maximum size of %d bytes", dgst, maxIndexSize)
+	}
+	if actual := dgst.Algorithm().FromBytes(b); actual != dgst {
+		return ocispec.Descriptor{}, fmt.Errorf("manifest fetched for %s does not match its digest, got %s", r.reference, actual)
+	}
+
+	desc := ocispec.Descriptor{
+		MediaType: manifestMediaType(b),
+		Digest:    dgst,
+		Size:      int64(len(b)),
+	}
+	if desc.MediaType == "" {
+		return ocispec.Descriptor{}, fmt.Errorf("unknown manifest type for %s: %w", r.reference, errdefs.ErrNotImplemented)
+	}
+	if err := checkSchema1(r.reference, desc); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	r.manifest, r.mdesc = b, desc
+	return desc, nil
+}
+
+// manifestMediaType returns the media type set in the manifest, or detected
+// from its fields for manifests which do not set one
+func manifestMediaType(b []byte) string {
+	var m struct {
+		SchemaVersion int               `json:"schemaVersion"`
+		MediaType     string            `json:"mediaType"`
+		Config        json.RawMessage   `json:"config"`
+		Manifests     json.RawMessage   `json:"manifests"`
+		FSLayers      []json.RawMessage `json:"fsLayers"`
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return ""
+	}
+	switch {
+	case m.MediaType != "":
+		return m.MediaType
+	case m.SchemaVersion == 1 || m.FSLayers != nil:
+		return images.MediaTypeDockerSchema1Manifest
+	case m.Manifests != nil:
+		return ocispec.MediaTypeImageIndex
+	case m.Config != nil:
+		return ocispec.MediaTypeImageManifest
+	}
+	return ""
+}
+
+// manifestFetcher serves the manifest already fetched before the transfer
+type manifestFetcher struct {
+	transfer.Fetcher
+	desc     ocispec.Descriptor
+	manifest []byte
+}
+
+func (f *manifestFetcher) Fetch(ctx context.Context, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	if desc.Digest == f.desc.Digest {
+		return io.NopCloser(bytes.NewReader(f.manifest)), nil
+	}
+	return f.Fetcher.Fetch(ctx, desc)
+}
+
+// Pusher returns a pusher for the descriptor
+func (r *Registry) Pusher(ctx context.Context, desc ocispec.Descriptor) (transfer.Pusher, error) {
+	var ref = r.reference
+	// Annotate ref with digest to push only push tag for single digest
+	if !strings.Contains(ref, "@") {
+		ref = ref + "@" + desc.Digest.String()
+	}
+	return r.resolver.Pusher(ctx, ref)
+}
+
+// CheckPlatforms returns an error listing the available platforms when the
+// image is an index which has no manifest matching any of the platforms. This
+// avoids fetching any content for an image which cannot satisfy the platforms.
+// Indexes with entries missing a platform are not checked since the entries
+// may match any platform.
+func (r *Registry) CheckPlatforms(ctx context.Context, ps []ocispec.Platform) error {
+	if len(ps) == 0 {
+		return nil
+	}
+	name, desc, err := r.Resolve(ctx)
+	if err != nil {
+		return err
+	}
+	if !images.IsIndexType(desc.MediaType) {
+		return nil
+	}
+	b, err := r.fetchIndex(ctx, name, desc)
+	if err != nil {
+		return err
+	}
+	var idx ocispec.Index
+	if err := json.Unmarshal(b, &idx); err != nil {
+		return fmt.Errorf("failed to read index for %s: %w", r.reference, err)
+	}
+
+	var (
+		matcher   = platforms.Any(ps...)
+		available []string
+	)
+	for _, m := range idx.Manifests {
+		if m.Platform == nil {
+			return nil
+		}
+		if matcher.Match(*m.Platform) {
+			return nil
+		}
+		available = append(available, platforms.Format(*m.Platform))
+	}
+	requested := make([]string, len(ps))
+	for i, p := range ps {
+		requested[i] = platforms.Format(p)
+	}
+	return fmt.Errorf("%s has no manifest for platform %s, available platforms: %s: %w",
+		r.reference, strings.Join(requested, ", "), strings.Join(available, ", "), errdefs.ErrNotFound)
+}
+
+// fetchIndex returns the resolved index, fetching it once and keeping it to
+// be served to the transfer
+func (r *Registry) fetchIndex(ctx context.Context, name string, desc ocispec.Descriptor) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.manifest != nil && r.mdesc.Digest == desc.Digest {
+		return r.manifest, nil
+	}
+
+	fetcher, err := r.resolver.Fetcher(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+	b, err := io.ReadAll(io.LimitReader(rc, maxIndexSize+1))
+	rc.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxIndexSize {
+		return nil, fmt.Errorf("index %s exceeds maximum size of %d bytes", desc.Digest, maxIndexSize)
+	}
+	if actual := desc.Digest.Algorithm().FromBytes(b); actual != desc.Digest {
+		return nil, fmt.Errorf("index fetched for %s does not match its digest, got %s", r.reference, actual)
+	}
+	r.manifest, r.mdesc = b, desc
+	return b, nil
+}
@@ -0,0 +1,612 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package remote
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/pkg/transfer/image"
+	"github.com/containerd/containerd/pkg/transfer/local"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/containerd/lcontainerd/pkg/db"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestCheckPlatforms(t *testing.T) {
+	idx, err := json.Marshal(ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{
+			{
+				MediaType: ocispec.MediaTypeImageManifest,
+				Digest:    digest.FromString("amd64"),
+				Size:      5,
+				Platform:  &ocispec.Platform{OS: "linux", Architecture: "amd64"},
+			},
+			{
+				MediaType: ocispec.MediaTypeImageManifest,
+				Digest:    digest.FromString("arm64"),
+				Size:      5,
+				Platform:  &ocispec.Platform{OS: "linux", Architecture: "arm64"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dgst := digest.FromBytes(idx)
+
+	var manifestRequests, indexRequests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Docker-Distribution-Api-Version", "registry/2.0")
+		switch r.URL.Path {
+		case "/v2/":
+		case "/v2/library/test/manifests/latest", "/v2/library/test/manifests/" + dgst.String():
+			atomic.AddInt32(&indexRequests, 1)
+			w.Header().Set("Content-Type", ocispec.MediaTypeImageIndex)
+			w.Header().Set("Docker-Content-Digest", dgst.String())
+			w.Header().Set("Content-Length", strconv.Itoa(len(idx)))
+			if r.Method == http.MethodGet {
+				w.Write(idx)
+			}
+		default:
+			// Only the index should be requested
+			atomic.AddInt32(&manifestRequests, 1)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	reg := NewRegistry(host+"/library/test:latest", WithPlainHTTP(docker.MatchLocalhost))
+	ctx := context.Background()
+
+	for _, p := range []string{"linux/arm64", "linux/amd64"} {
+		if err := reg.CheckPlatforms(ctx, []ocispec.Platform{platforms.MustParse(p)}); err != nil {
+			t.Fatalf("expected %s to be available: %v", p, err)
+		}
+	}
+
+	err = reg.CheckPlatforms(ctx, []ocispec.Platform{platforms.MustParse("linux/riscv64")})
+	if !errdefs.IsNotFound(err) {
+		t.Fatalf("expected not found for missing platform, got %v", err)
+	}
+	for _, expected := range []string{"linux/riscv64", "linux/amd64", "linux/arm64"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q in error: %v", expected, err)
+		}
+	}
+
+	// Any matching platform is enough
+	if err := reg.CheckPlatforms(ctx, []ocispec.Platform{platforms.MustParse("linux/riscv64"), platforms.MustParse("linux/arm64")}); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&manifestRequests); n != 0 {
+		t.Fatalf("expected only the index to be fetched, got %d other requests", n)
+	}
+
+	// The transfer is served the index resolved and fetched by the checks
+	name, desc, err := reg.Resolve(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetcher, err := reg.Fetcher(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.Close()
+	if n := atomic.LoadInt32(&indexRequests); n != 2 {
+		t.Fatalf("expected the index to be resolved and fetched once, got %d requests", n)
+	}
+}
+
+func TestPullSourceLabel(t *testing.T) {
+	config, err := json.Marshal(ocispec.Image{
+		Platform: ocispec.Platform{OS: "linux", Architecture: "amd64"},
+		RootFS:   ocispec.RootFS{Type: "layers"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config: ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageConfig,
+			Digest:    digest.FromBytes(config),
+			Size:      int64(len(config)),
+		},
+		Layers: []ocispec.Descriptor{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs := map[string][]byte{
+		"/v2/library/test/manifests/latest":                                 manifest,
+		"/v2/library/test/manifests/" + digest.FromBytes(manifest).String(): manifest,
+		"/v2/library/test/blobs/" + digest.FromBytes(config).String():       config,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Docker-Distribution-Api-Version", "registry/2.0")
+		if r.URL.Path == "/v2/" {
+			return
+		}
+		b, ok := blobs[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if strings.Contains(r.URL.Path, "/manifests/") {
+			w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+		}
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(b).String())
+		w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+		if r.Method == http.MethodGet {
+			w.Write(b)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := namespaces.WithNamespace(context.Background(), "testing")
+	mdb, err := db.NewDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mdb.Close(ctx)
+
+	ref := strings.TrimPrefix(srv.URL, "http://") + "/library/test:latest"
+	reg := NewRegistry(ref, WithPlainHTTP(docker.MatchLocalhost))
+	is := db.NewImageStore(mdb)
+	ts := local.NewTransferService(db.NewLeaseManager(mdb), mdb.ContentStore(), is, &local.TransferConfig{})
+	store := image.NewStore(ref, image.WithImageLabels(reg.SourceLabels(map[string]string{"other": "label"})))
+	if err := ts.Transfer(ctx, reg, store); err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := is.Get(ctx, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Labels[LabelSourceRef] != ref || img.Labels["other"] != "label" {
+		t.Fatalf("unexpected labels on pulled image: %v", img.Labels)
+	}
+
+	// Images are selected by their source
+	for filter, expected := range map[string]int{
+		`labels."lctr.io/source-ref"~=library/test`: 1,
+		`labels."lctr.io/source-ref"~=docker.io`:    0,
+	} {
+		imgs, err := is.List(ctx, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(imgs) != expected {
+			t.Errorf("expected %d images for %s, got %d", expected, filter, len(imgs))
+		}
+	}
+}
+
+func TestPullLease(t *testing.T) {
+	config, err := json.Marshal(ocispec.Image{
+		Platform: ocispec.Platform{OS: "linux", Architecture: "amd64"},
+		RootFS:   ocispec.RootFS{Type: "layers"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config: ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageConfig,
+			Digest:    digest.FromBytes(config),
+			Size:      int64(len(config)),
+		},
+		Layers: []ocispec.Descriptor{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs := map[string][]byte{
+		"/v2/library/test/manifests/latest":                                 manifest,
+		"/v2/library/test/manifests/" + digest.FromBytes(manifest).String(): manifest,
+		"/v2/library/test/blobs/" + digest.FromBytes(config).String():       config,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Docker-Distribution-Api-Version", "registry/2.0")
+		if r.URL.Path == "/v2/" {
+			return
+		}
+		b, ok := blobs[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if strings.Contains(r.URL.Path, "/manifests/") {
+			w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+		}
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(b).String())
+		w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+		if r.Method == http.MethodGet {
+			w.Write(b)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := namespaces.WithNamespace(context.Background(), "testing")
+	mdb, err := db.NewDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mdb.Close(ctx)
+
+	lm := db.NewLeaseManager(mdb)
+	lctx, err := db.WithLease(ctx, lm, "pull-lease")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An existing lease is reused
+	if _, err := db.WithLease(ctx, lm, "pull-lease"); err != nil {
+		t.Fatal(err)
+	}
+
+	ref := strings.TrimPrefix(srv.URL, "http://") + "/library/test:latest"
+	is := db.NewImageStore(mdb)
+	ts := local.NewTransferService(lm, mdb.ContentStore(), is, &local.TransferConfig{})
+	if err := ts.Transfer(lctx, NewRegistry(ref, WithPlainHTTP(docker.MatchLocalhost)), image.NewStore(ref)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The lease holds the content once the image is removed
+	if err := is.Delete(ctx, ref); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdb.GarbageCollect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range [][]byte{manifest, config} {
+		if _, err := mdb.ContentStore().Info(ctx, digest.FromBytes(b)); err != nil {
+			t.Fatalf("expected leased content %s: %v", digest.FromBytes(b), err)
+		}
+	}
+
+	ls, err := lm.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ls) != 1 || ls[0].ID != "pull-lease" {
+		t.Fatalf("expected only the given lease, got %v", ls)
+	}
+	if err := lm.Delete(ctx, ls[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdb.GarbageCollect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdb.ContentStore().Info(ctx, digest.FromBytes(manifest)); !errdefs.IsNotFound(err) {
+		t.Fatalf("expected content removed with the lease, got %v", err)
+	}
+}
+
+func TestPullAllowedHosts(t *testing.T) {
+	config, err := json.Marshal(ocispec.Image{
+		Platform: ocispec.Platform{OS: "linux", Architecture: "amd64"},
+		RootFS:   ocispec.RootFS{Type: "layers"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config: ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageConfig,
+			Digest:    digest.FromBytes(config),
+			Size:      int64(len(config)),
+		},
+		Layers: []ocispec.Descriptor{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs := map[string][]byte{
+		"/v2/library/test/manifests/latest":                                 manifest,
+		"/v2/library/test/manifests/" + digest.FromBytes(manifest).String(): manifest,
+		"/v2/library/test/blobs/" + digest.FromBytes(config).String():       config,
+	}
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Docker-Distribution-Api-Version", "registry/2.0")
+		if r.URL.Path == "/v2/" {
+			return
+		}
+		b, ok := blobs[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if strings.Contains(r.URL.Path, "/manifests/") {
+			w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+		}
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(b).String())
+		w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+		if r.Method == http.MethodGet {
+			w.Write(b)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := namespaces.WithNamespace(context.Background(), "testing")
+	mdb, err := db.NewDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mdb.Close(ctx)
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	ref := host + "/library/test:latest"
+	is := db.NewImageStore(mdb)
+	ts := local.NewTransferService(db.NewLeaseManager(mdb), mdb.ContentStore(), is, &local.TransferConfig{})
+
+	// A host not in the allowlist fails before any request
+	reg := NewRegistry(ref, WithPlainHTTP(docker.MatchLocalhost), WithAllowedHosts("registry.example.com", "docker.io"))
+	if err := reg.CheckPlatforms(ctx, []ocispec.Platform{platforms.MustParse("linux/amd64")}); !errors.Is(err, ErrHostNotAllowed) {
+		t.Fatalf("expected host not allowed error, got %v", err)
+	}
+	if err := ts.Transfer(ctx, reg, image.NewStore(ref)); !errors.Is(err, ErrHostNotAllowed) {
+		t.Fatalf("expected host not allowed error, got %v", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 0 {
+		t.Fatalf("expected no requests to a disallowed host, got %d", n)
+	}
+
+	reg = NewRegistry(ref, WithPlainHTTP(docker.MatchLocalhost), WithAllowedHosts("registry.example.com", host))
+	if err := ts.Transfer(ctx, reg, image.NewStore(ref)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := is.Get(ctx, ref); err != nil {
+		t.Fatal(err)
+	}
+
+	// References without a host are from docker.io
+	if err := CheckAllowedHost("ubuntu", []string{"docker.io"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckAllowedHost("ubuntu", []string{host}); !errors.Is(err, ErrHostNotAllowed) {
+		t.Fatalf("expected host not allowed error, got %v", err)
+	}
+}
+
+func TestPullNoResolve(t *testing.T) {
+	config, err := json.Marshal(ocispec.Image{
+		Platform: ocispec.Platform{OS: "linux", Architecture: "amd64"},
+		RootFS:   ocispec.RootFS{Type: "layers"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The manifest media type is detected when not set in the manifest
+	manifest, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		Config: ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageConfig,
+			Digest:    digest.FromBytes(config),
+			Size:      int64(len(config)),
+		},
+		Layers: []ocispec.Descriptor{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		mdgst    = digest.FromBytes(manifest)
+		tampered = digest.FromString("tampered")
+		blobs    = map[string][]byte{
+			"/v2/library/test/manifests/" + mdgst.String():                manifest,
+			"/v2/library/test/manifests/" + tampered.String():             manifest,
+			"/v2/library/test/blobs/" + digest.FromBytes(config).String(): config,
+		}
+		requests = map[string]int{}
+		mu       sync.Mutex
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Docker-Distribution-Api-Version", "registry/2.0")
+		if r.URL.Path == "/v2/" {
+			return
+		}
+		mu.Lock()
+		requests[r.Method+" "+r.URL.Path]++
+		mu.Unlock()
+		b, ok := blobs[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+		w.Write(b)
+	}))
+	defer srv.Close()
+
+	ctx := namespaces.WithNamespace(context.Background(), "testing")
+	mdb, err := db.NewDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mdb.Close(ctx)
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	ref := host + "/library/test@" + mdgst.String()
+	reg := NewRegistry(ref, WithPlainHTTP(docker.MatchLocalhost), WithNoResolve)
+	// Checking platforms shares the fetched manifest
+	if err := reg.CheckPlatforms(ctx, []ocispec.Platform{platforms.MustParse("linux/amd64")}); err != nil {
+		t.Fatal(err)
+	}
+	is := db.NewImageStore(mdb)
+	ts := local.NewTransferService(db.NewLeaseManager(mdb), mdb.ContentStore(), is, &local.TransferConfig{})
+	if err := ts.Transfer(ctx, reg, image.NewStore(ref)); err != nil {
+		t.Fatal(err)
+	}
+	img, err := is.Get(ctx, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Target.Digest != mdgst || img.Target.MediaType != ocispec.MediaTypeImageManifest {
+		t.Fatalf("unexpected image target: %v", img.Target)
+	}
+	for req, n := range requests {
+		if strings.HasPrefix(req, http.MethodHead) {
+			t.Errorf("unexpected resolve request %q", req)
+		}
+		if strings.Contains(req, "/manifests/") && n != 1 {
+			t.Errorf("manifest fetched %d times", n)
+		}
+	}
+
+	// The fetched manifest must match the digest of the reference
+	reg = NewRegistry(host+"/library/test@"+tampered.String(), WithPlainHTTP(docker.MatchLocalhost), WithNoResolve)
+	if _, _, err := reg.Resolve(ctx); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("expected digest mismatch error, got %v", err)
+	}
+
+	reg = NewRegistry(host+"/library/test:latest", WithPlainHTTP(docker.MatchLocalhost), WithNoResolve)
+	if _, _, err := reg.Resolve(ctx); !errdefs.IsInvalidArgument(err) {
+		t.Fatalf("expected invalid argument for tag reference, got %v", err)
+	}
+}
+
+func TestHostsDir(t *testing.T) {
+	manifest := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`)
+	dgst := digest.FromBytes(manifest)
+
+	var mirrored int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Docker-Distribution-Api-Version", "registry/2.0")
+		switch r.URL.Path {
+		case "/v2/library/test/manifests/latest":
+			atomic.AddInt32(&mirrored, 1)
+			w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+			w.Header().Set("Docker-Content-Digest", dgst.String())
+			w.Header().Set("Content-Length", strconv.Itoa(len(manifest)))
+			if r.Method == http.MethodGet {
+				w.Write(manifest)
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	// Requests for the unresolvable registry are sent to the mirror
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "registry.invalid"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	hosts := fmt.Sprintf("server = \"https://registry.invalid\"\n\n[host.%q]\n  capabilities = [\"pull\", \"resolve\"]\n", srv.URL)
+	if err := os.WriteFile(filepath.Join(dir, "registry.invalid", "hosts.toml"), []byte(hosts), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reg := NewRegistry("registry.invalid/library/test:latest", WithHostsDir(dir), WithClient(srv.Client()))
+	_, desc, err := reg.Resolve(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.Digest != dgst {
+		t.Fatalf("expected digest %s, got %s", dgst, desc.Digest)
+	}
+	if n := atomic.LoadInt32(&mirrored); n == 0 {
+		t.Fatal("expected the manifest to be resolved from the mirror")
+	}
+}
+
+func TestHostsDirCA(t *testing.T) {
+	manifest := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`)
+	dgst := digest.FromBytes(manifest)
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Docker-Distribution-Api-Version", "registry/2.0")
+		if r.URL.Path != "/v2/library/test/manifests/latest" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+		w.Header().Set("Docker-Content-Digest", dgst.String())
+		w.Header().Set("Content-Length", strconv.Itoa(len(manifest)))
+		if r.Method == http.MethodGet {
+			w.Write(manifest)
+		}
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "https://")
+
+	// The server certificate is only trusted through the hosts.toml ca
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, host), 0755); err != nil {
+		t.Fatal(err)
+	}
+	ca := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hosts := fmt.Sprintf("server = %q\n\n[host.%q]\n  capabilities = [\"pull\", \"resolve\"]\n  ca = %q\n", srv.URL, srv.URL, ca)
+	if err := os.WriteFile(filepath.Join(dir, host, "hosts.toml"), []byte(hosts), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		trace bytes.Buffer
+		ht    = NewHostTransport(nil)
+		reg   = NewRegistry(host+"/library/test:latest",
+			WithHostsDir(dir),
+			WithClient(&http.Client{Transport: NewTraceTransport(&trace, ht)}),
+			WithHostTransport(ht))
+	)
+	_, desc, err := reg.Resolve(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.Digest != dgst {
+		t.Fatalf("expected digest %s, got %s", dgst, desc.Digest)
+	}
+	if !strings.Contains(trace.String(), srv.URL+"/v2/library/test/manifests/latest") {
+		t.Fatalf("expected the request to the host configured with a ca to be traced, got:\n%s", trace.String())
+	}
+}
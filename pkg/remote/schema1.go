@@ -0,0 +1,51 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package remote
+
+import (
+	"fmt"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// mediaTypeDockerSchema1ManifestUnsigned is the unsigned variant of the
+// Docker schema 1 manifest
+const mediaTypeDockerSchema1ManifestUnsigned = "application/vnd.docker.distribution.manifest.v1+json"
+
+// IsSchema1 returns whether the media type is a deprecated Docker schema 1
+// manifest
+func IsSchema1(mediaType string) bool {
+	switch mediaType {
+	case images.MediaTypeDockerSchema1Manifest, mediaTypeDockerSchema1ManifestUnsigned:
+		return true
+	}
+	return false
+}
+
+// checkSchema1 returns an actionable error when the resolved descriptor is a
+// schema 1 manifest, which would otherwise fail later with an opaque error
+// from the transfer or while decoding the manifest
+func checkSchema1(ref string, desc ocispec.Descriptor) error {
+	if !IsSchema1(desc.MediaType) {
+		return nil
+	}
+	return fmt.Errorf("%s resolved to a deprecated Docker schema 1 manifest (%s) which is not supported, "+
+		"the image must be rebuilt or pushed again by a client which produces schema 2 or OCI manifests: %w",
+		ref, desc.MediaType, errdefs.ErrNotImplemented)
+}
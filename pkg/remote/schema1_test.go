@@ -0,0 +1,59 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package remote
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/remotes/docker"
+)
+
+func TestResolveSchema1(t *testing.T) {
+	for _, contentType := range []string{
+		images.MediaTypeDockerSchema1Manifest,
+		mediaTypeDockerSchema1ManifestUnsigned,
+		"text/plain; charset=utf-8",
+	} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Docker-Distribution-Api-Version", "registry/2.0")
+			if r.URL.Path == "/v2/" {
+				return
+			}
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("Docker-Content-Digest", "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855")
+			w.Header().Set("Content-Length", "2")
+		}))
+
+		host := strings.TrimPrefix(srv.URL, "http://")
+		reg := NewRegistry(host+"/library/legacy:latest", WithPlainHTTP(docker.MatchLocalhost))
+		_, _, err := reg.Resolve(context.Background())
+		srv.Close()
+
+		if !errdefs.IsNotImplemented(err) {
+			t.Fatalf("expected not implemented resolving %q, got %v", contentType, err)
+		}
+		if !strings.Contains(err.Error(), "schema 1") || !strings.Contains(err.Error(), "legacy:latest") {
+			t.Fatalf("expected error to name the image and schema 1, got %v", err)
+		}
+	}
+}
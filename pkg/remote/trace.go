@@ -0,0 +1,102 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package remote
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redactedHeaders are headers which are never written to the trace
+var redactedHeaders = map[string]struct{}{
+	"Authorization":       {},
+	"Proxy-Authorization": {},
+	"Cookie":              {},
+	"Set-Cookie":          {},
+}
+
+type traceTransport struct {
+	rt http.RoundTripper
+
+	mu sync.Mutex
+	w  io.Writer
+	id int
+}
+
+// NewTraceTransport returns a transport which writes the method, URL, and
+// headers of each request along with the status and headers of the response
+// to w. Credentials in headers are redacted. When rt is nil, the default
+// transport is used.
+func NewTraceTransport(w io.Writer, rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return &traceTransport{
+		rt: rt,
+		w:  w,
+	}
+}
+
+func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	t.id++
+	id := t.id
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%d] > %s %s\n", id, req.Method, req.URL)
+	writeHeaders(&b, id, ">", req.Header)
+	io.WriteString(t.w, b.String())
+	t.mu.Unlock()
+
+	start := time.Now()
+	resp, err := t.rt.RoundTrip(req)
+	elapsed := time.Since(start)
+
+	b.Reset()
+	if err != nil {
+		fmt.Fprintf(&b, "[%d] ! %v (%s)\n", id, err, elapsed)
+	} else {
+		fmt.Fprintf(&b, "[%d] < %s (%s)\n", id, resp.Status, elapsed)
+		writeHeaders(&b, id, "<", resp.Header)
+	}
+	t.mu.Lock()
+	io.WriteString(t.w, b.String())
+	t.mu.Unlock()
+
+	return resp, err
+}
+
+func writeHeaders(b *strings.Builder, id int, dir string, h http.Header) {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if _, ok := redactedHeaders[http.CanonicalHeaderKey(k)]; ok {
+			fmt.Fprintf(b, "[%d] %s %s: [redacted]\n", id, dir, k)
+			continue
+		}
+		for _, v := range h[k] {
+			fmt.Fprintf(b, "[%d] %s %s: %s\n", id, dir, k, v)
+		}
+	}
+}
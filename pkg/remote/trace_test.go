@@ -0,0 +1,84 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package remote
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/pkg/transfer/registry"
+	"github.com/containerd/containerd/remotes/docker"
+)
+
+type staticCredentials struct {
+	username, secret string
+}
+
+func (s staticCredentials) GetCredentials(ctx context.Context, ref, host string) (registry.Credentials, error) {
+	return registry.Credentials{Host: host, Username: s.username, Secret: s.secret}, nil
+}
+
+func TestTraceTransport(t *testing.T) {
+	const secret = "supersecretpassword"
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, ok := r.BasicAuth(); !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Set-Cookie", "session="+secret)
+		w.Header().Set("Docker-Distribution-Api-Version", "registry/2.0")
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	var trace bytes.Buffer
+	client := &http.Client{Transport: NewTraceTransport(&trace, srv.Client().Transport)}
+
+	// Use the test server as a plain http registry through the resolver
+	host := strings.TrimPrefix(srv.URL, "http://")
+	reg := NewRegistry(host+"/library/test:latest",
+		WithClient(client),
+		WithCredentials(staticCredentials{"user", secret}),
+		WithPlainHTTP(docker.MatchLocalhost),
+	)
+	if _, _, err := reg.Resolve(context.Background()); err == nil {
+		t.Fatal("expected resolve to fail")
+	}
+
+	out := trace.String()
+	for _, expected := range []string{
+		"> HEAD " + srv.URL + "/v2/library/test/manifests/latest",
+		"< 401 Unauthorized",
+		"< Www-Authenticate: Basic realm=\"test\"",
+		"> Authorization: [redacted]",
+		"< 404 Not Found",
+		"< Set-Cookie: [redacted]",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in trace:\n%s", expected, out)
+		}
+	}
+	if strings.Contains(out, secret) {
+		t.Fatalf("trace contains secret:\n%s", out)
+	}
+}
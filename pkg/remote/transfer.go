@@ -0,0 +1,36 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package remote
+
+import (
+	"fmt"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/pkg/transfer/local"
+)
+
+// NewTransferConfig returns the configuration of a local transfer service
+// limiting the number of layers fetched concurrently by each transfer, 0
+// keeps the transfer service default of no limit
+func NewTransferConfig(maxConcurrentDownloads int) (*local.TransferConfig, error) {
+	if maxConcurrentDownloads < 0 {
+		return nil, fmt.Errorf("invalid max concurrent downloads %d, must not be negative: %w", maxConcurrentDownloads, errdefs.ErrInvalidArgument)
+	}
+	return &local.TransferConfig{
+		MaxConcurrentDownloads: maxConcurrentDownloads,
+	}, nil
+}
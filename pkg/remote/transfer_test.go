@@ -0,0 +1,38 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package remote
+
+import (
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+)
+
+func TestNewTransferConfig(t *testing.T) {
+	for _, n := range []int{0, 3} {
+		config, err := NewTransferConfig(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.MaxConcurrentDownloads != n {
+			t.Errorf("expected max concurrent downloads %d, got %d", n, config.MaxConcurrentDownloads)
+		}
+	}
+	if _, err := NewTransferConfig(-1); !errdefs.IsInvalidArgument(err) {
+		t.Fatalf("expected invalid argument for a negative limit, got %v", err)
+	}
+}
@@ -0,0 +1,412 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package squash flattens the layers of an image manifest into a single
+// layer by merging the layer tar streams, without applying them to a
+// filesystem.
+package squash
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/archive/compression"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/lcontainerd/pkg/iobuf"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = whiteoutPrefix + whiteoutPrefix + ".opq"
+)
+
+// Squash merges all the layers of the manifest into a single layer and
+// writes a new config and manifest referring to the squashed layer. The
+// descriptor of the new manifest is returned.
+func Squash(ctx context.Context, cs content.Store, desc ocispec.Descriptor) (ocispec.Descriptor, error) {
+	if !images.IsManifestType(desc.MediaType) {
+		return ocispec.Descriptor{}, fmt.Errorf("cannot squash %s, must be a manifest", desc.MediaType)
+	}
+	b, err := iobuf.ReadBlob(ctx, cs, desc)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	if len(manifest.Layers) == 0 {
+		return ocispec.Descriptor{}, fmt.Errorf("cannot squash manifest without layers")
+	}
+	for _, l := range manifest.Layers {
+		if !images.IsLayerType(l.MediaType) {
+			return ocispec.Descriptor{}, fmt.Errorf("cannot squash layer with media type %s", l.MediaType)
+		}
+	}
+
+	keep, err := survivingEntries(ctx, cs, manifest.Layers)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	layer, diffID, err := writeLayer(ctx, cs, manifest.Layers, keep, layerMediaType(desc.MediaType))
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	config, err := writeConfig(ctx, cs, manifest.Config, diffID, len(manifest.Layers))
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	manifest.Config = config
+	manifest.Layers = []ocispec.Descriptor{layer}
+	mb, err := json.MarshalIndent(manifest, "", "   ")
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	target := ocispec.Descriptor{
+		MediaType: desc.MediaType,
+		Digest:    digest.FromBytes(mb),
+		Size:      int64(len(mb)),
+	}
+	labels := map[string]string{
+		"containerd.io/gc.ref.content.config": config.Digest.String(),
+		"containerd.io/gc.ref.content.l.0":    layer.Digest.String(),
+	}
+	if err := content.WriteBlob(ctx, cs, target.Digest.String()+"-ingest", bytes.NewReader(mb), target, content.WithLabels(labels)); err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("failed to write squashed manifest: %w", err)
+	}
+
+	return target, nil
+}
+
+// layerMediaType returns the gzip layer media type matching the manifest
+func layerMediaType(manifestType string) string {
+	if manifestType == images.MediaTypeDockerSchema2Manifest {
+		return images.MediaTypeDockerSchema2LayerGzip
+	}
+	return ocispec.MediaTypeImageLayerGzip
+}
+
+// survivingEntries returns, for each layer, which tar entries are visible in
+// the final filesystem. Layers are read from the top down so that entries are
+// hidden when an upper layer replaces, removes, or makes opaque their path.
+func survivingEntries(ctx context.Context, cs content.Store, layers []ocispec.Descriptor) ([][]bool, error) {
+	var (
+		keep = make([][]bool, len(layers))
+		// upper holds paths defined by upper layers, true when the path
+		// is a directory which lower layers may still add children to
+		upper = map[string]bool{}
+		// opaque holds directories which hide all lower children
+		opaque = map[string]struct{}{}
+	)
+	for i := len(layers) - 1; i >= 0; i-- {
+		var (
+			added   = map[string]bool{}
+			opaqued []string
+		)
+		if err := walkLayer(ctx, cs, layers[i], func(hdr *tar.Header, _ io.Reader) error {
+			name := cleanName(hdr.Name)
+			dir, base := path.Split(name)
+			dir = strings.TrimSuffix(dir, "/")
+
+			switch {
+			case base == whiteoutOpaque:
+				opaqued = append(opaqued, dir)
+				keep[i] = append(keep[i], false)
+				return nil
+			case strings.HasPrefix(base, whiteoutPrefix):
+				// The removed path is hidden from lower layers, including
+				// any children of a removed directory
+				added[path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))] = false
+				keep[i] = append(keep[i], false)
+				return nil
+			}
+
+			visible := !hidden(name, upper, opaque)
+			keep[i] = append(keep[i], visible)
+			if visible {
+				added[name] = hdr.Typeflag == tar.TypeDir
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+
+		// Changes only apply to lower layers
+		for name, isDir := range added {
+			upper[name] = isDir
+		}
+		for _, dir := range opaqued {
+			opaque[dir] = struct{}{}
+		}
+	}
+	return keep, nil
+}
+
+// hidden returns whether the path from a lower layer is hidden by the paths
+// and opaque directories of upper layers
+func hidden(name string, upper map[string]bool, opaque map[string]struct{}) bool {
+	if _, ok := upper[name]; ok {
+		return true
+	}
+	for dir := path.Dir(name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if isDir, ok := upper[dir]; ok && !isDir {
+			return true
+		}
+		if _, ok := opaque[dir]; ok {
+			return true
+		}
+	}
+	_, ok := opaque[""]
+	return ok
+}
+
+// entryRef is the position of a tar entry within the layers
+type entryRef struct {
+	layer, index int
+}
+
+// writeLayer writes the visible entries of all layers from the bottom up as
+// a single gzip compressed layer, returning the layer descriptor and diff ID.
+// A visible hard link whose target is hidden by an upper layer is written
+// as a regular file with the content of the target, so the link does not
+// refer to a path which is removed or replaced in the squashed layer.
+func writeLayer(ctx context.Context, cs content.Store, layers []ocispec.Descriptor, keep [][]bool, mediaType string) (ocispec.Descriptor, digest.Digest, error) {
+	ref := fmt.Sprintf("squash-%d", time.Now().UnixNano())
+	w, err := content.OpenWriter(ctx, cs, content.WithRef(ref))
+	if err != nil {
+		return ocispec.Descriptor{}, "", err
+	}
+	defer w.Close()
+
+	var (
+		gw     = gzip.NewWriter(w)
+		diffID = digest.Canonical.Digester()
+		tw     = tar.NewWriter(io.MultiWriter(gw, diffID.Hash()))
+		// defined holds the latest entry at each path
+		defined = map[string]entryRef{}
+		// sources holds the regular file entry each hard link refers to
+		sources = map[entryRef]entryRef{}
+		// copied holds the name written with the content of a source
+		// in place of a hidden hard link target
+		copied   = map[entryRef]string{}
+		copyKept = func(i int) func(*tar.Header, io.Reader) error {
+			n := 0
+			return func(hdr *tar.Header, r io.Reader) error {
+				entry := entryRef{layer: i, index: n}
+				n++
+				name := cleanName(hdr.Name)
+
+				var hiddenSrc *entryRef
+				if hdr.Typeflag == tar.TypeLink {
+					// Links to unknown targets are left to the applier
+					if target, ok := defined[cleanName(hdr.Linkname)]; ok {
+						src := target
+						if s, ok := sources[target]; ok {
+							src = s
+						}
+						sources[entry] = src
+						if !keep[target.layer][target.index] {
+							hiddenSrc = &src
+						}
+					}
+				}
+				if _, base := path.Split(name); !strings.HasPrefix(base, whiteoutPrefix) {
+					defined[name] = entry
+				}
+				if hiddenSrc != nil && keep[i][entry.index] {
+					return writeHiddenLink(ctx, cs, tw, layers[hiddenSrc.layer], hdr, *hiddenSrc, copied)
+				}
+				return writeEntry(tw, hdr, r, keep[i][entry.index])
+			}
+		}
+	)
+	for i := range layers {
+		if err := walkLayer(ctx, cs, layers[i], copyKept(i)); err != nil {
+			return ocispec.Descriptor{}, "", err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return ocispec.Descriptor{}, "", err
+	}
+	if err := gw.Close(); err != nil {
+		return ocispec.Descriptor{}, "", err
+	}
+
+	st, err := w.Status()
+	if err != nil {
+		return ocispec.Descriptor{}, "", err
+	}
+	layer := ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    w.Digest(),
+		Size:      st.Offset,
+	}
+	if err := w.Commit(ctx, layer.Size, layer.Digest); err != nil && !errdefs.IsAlreadyExists(err) {
+		return ocispec.Descriptor{}, "", fmt.Errorf("failed to commit squashed layer: %w", err)
+	}
+	return layer, diffID.Digest(), nil
+}
+
+// writeEntry writes the entry to the tar when it is kept
+func writeEntry(tw *tar.Writer, hdr *tar.Header, r io.Reader, keep bool) error {
+	if !keep {
+		return nil
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := iobuf.Copy(tw, r)
+	return err
+}
+
+// writeHiddenLink writes a hard link whose target is hidden by an upper
+// layer as a regular file with the metadata and content of the source
+// entry. Later links to the same source are written as links to the first
+// file written for it.
+func writeHiddenLink(ctx context.Context, cs content.Store, tw *tar.Writer, layer ocispec.Descriptor, hdr *tar.Header, src entryRef, copied map[entryRef]string) error {
+	if name, ok := copied[src]; ok {
+		link := *hdr
+		link.Linkname = name
+		return tw.WriteHeader(&link)
+	}
+	copied[src] = hdr.Name
+	return walkEntry(ctx, cs, layer, src.index, func(srcHdr *tar.Header, r io.Reader) error {
+		if srcHdr.Typeflag != tar.TypeReg && srcHdr.Typeflag != tar.TypeRegA {
+			return fmt.Errorf("cannot squash hard link %s to hidden %s of type %q", hdr.Name, srcHdr.Name, srcHdr.Typeflag)
+		}
+		file := *srcHdr
+		file.Name = hdr.Name
+		file.Typeflag = tar.TypeReg
+		return writeEntry(tw, &file, r, true)
+	})
+}
+
+// writeConfig writes a copy of the config with the rootfs replaced by the
+// squashed layer and a history entry recording the squash. Unknown config
+// fields are preserved.
+func writeConfig(ctx context.Context, cs content.Store, desc ocispec.Descriptor, diffID digest.Digest, squashed int) (ocispec.Descriptor, error) {
+	b, err := iobuf.ReadBlob(ctx, cs, desc)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	var config map[string]json.RawMessage
+	if err := json.Unmarshal(b, &config); err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("invalid image config: %w", err)
+	}
+
+	created := time.Now().UTC()
+	if config["rootfs"], err = json.Marshal(ocispec.RootFS{
+		Type:    "layers",
+		DiffIDs: []digest.Digest{diffID},
+	}); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	if config["history"], err = json.Marshal([]ocispec.History{
+		{
+			Created:   &created,
+			CreatedBy: "lctr image squash",
+			Comment:   fmt.Sprintf("squashed %d layers", squashed),
+		},
+	}); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	if b, err = json.Marshal(config); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	updated := ocispec.Descriptor{
+		MediaType: desc.MediaType,
+		Digest:    digest.FromBytes(b),
+		Size:      int64(len(b)),
+	}
+	if err := content.WriteBlob(ctx, cs, updated.Digest.String()+"-ingest", bytes.NewReader(b), updated); err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("failed to write squashed config: %w", err)
+	}
+	return updated, nil
+}
+
+// walkLayer calls fn for each entry in the decompressed layer
+func walkLayer(ctx context.Context, cs content.Store, desc ocispec.Descriptor, fn func(*tar.Header, io.Reader) error) error {
+	ra, err := cs.ReaderAt(ctx, desc)
+	if err != nil {
+		return err
+	}
+	defer ra.Close()
+
+	ds, err := compression.DecompressStream(content.NewReader(ra))
+	if err != nil {
+		return fmt.Errorf("failed to decompress layer %s: %w", desc.Digest, err)
+	}
+	defer ds.Close()
+
+	tr := tar.NewReader(ds)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read layer %s: %w", desc.Digest, err)
+		}
+		if err := fn(hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// errEntryFound stops walking a layer once the requested entry is found
+var errEntryFound = errors.New("entry found")
+
+// walkEntry calls fn for the entry at the index of the decompressed layer
+func walkEntry(ctx context.Context, cs content.Store, desc ocispec.Descriptor, index int, fn func(*tar.Header, io.Reader) error) error {
+	n := 0
+	err := walkLayer(ctx, cs, desc, func(hdr *tar.Header, r io.Reader) error {
+		if n++; n-1 != index {
+			return nil
+		}
+		if err := fn(hdr, r); err != nil {
+			return err
+		}
+		return errEntryFound
+	})
+	if err == nil {
+		return fmt.Errorf("entry %d not found in layer %s: %w", index, desc.Digest, errdefs.ErrNotFound)
+	} else if errors.Is(err, errEntryFound) {
+		return nil
+	}
+	return err
+}
+
+// cleanName returns the path of the entry relative to the root
+func cleanName(name string) string {
+	name = path.Clean("/" + name)
+	return strings.TrimPrefix(name, "/")
+}
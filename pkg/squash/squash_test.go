@@ -0,0 +1,316 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package squash
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/google/go-cmp/cmp"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// entry is a tar entry for a test layer, data is the link name for links
+type entry struct {
+	name     string
+	typeflag byte
+	data     string
+}
+
+func TestSquash(t *testing.T) {
+	ctx := context.Background()
+	cs, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lower, lowerDiff := writeTestLayer(ctx, t, cs, []entry{
+		{"etc/", tar.TypeDir, ""},
+		{"etc/a", tar.TypeReg, "a1"},
+		{"etc/b", tar.TypeReg, "b"},
+		{"lib/", tar.TypeDir, ""},
+		{"lib/f", tar.TypeReg, "f"},
+		{"opt/", tar.TypeDir, ""},
+		{"opt/x", tar.TypeReg, "x"},
+		{"opt/sub/", tar.TypeDir, ""},
+		{"opt/sub/y", tar.TypeReg, "y"},
+		{"usr/", tar.TypeDir, ""},
+		{"usr/bin/", tar.TypeDir, ""},
+		{"usr/bin/tool", tar.TypeReg, "tool"},
+	})
+	upper, upperDiff := writeTestLayer(ctx, t, cs, []entry{
+		{"etc/", tar.TypeDir, ""},
+		{"etc/a", tar.TypeReg, "a2"},
+		{"etc/.wh.b", tar.TypeReg, ""},
+		{"lib", tar.TypeReg, "lib"},
+		{"opt/", tar.TypeDir, ""},
+		{"opt/.wh..wh..opq", tar.TypeReg, ""},
+		{"opt/z", tar.TypeReg, "z"},
+		{"usr/bin/other", tar.TypeReg, "other"},
+	})
+
+	cb, err := json.Marshal(ocispec.Image{
+		Platform: ocispec.Platform{OS: "linux", Architecture: "amd64"},
+		RootFS: ocispec.RootFS{
+			Type:    "layers",
+			DiffIDs: []digest.Digest{lowerDiff, upperDiff},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := writeTestBlob(ctx, t, cs, ocispec.MediaTypeImageConfig, cb)
+	mb, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []ocispec.Descriptor{lower, upper},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc := writeTestBlob(ctx, t, cs, ocispec.MediaTypeImageManifest, mb)
+
+	squashed, err := Squash(ctx, cs, desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := content.ReadBlob(ctx, cs, squashed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Layers) != 1 {
+		t.Fatalf("expected a single layer, got %d", len(manifest.Layers))
+	}
+
+	b, err = content.ReadBlob(ctx, cs, manifest.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var img ocispec.Image
+	if err := json.Unmarshal(b, &img); err != nil {
+		t.Fatal(err)
+	}
+	if img.Architecture != "amd64" {
+		t.Fatalf("config fields not preserved: %s", b)
+	}
+	if len(img.RootFS.DiffIDs) != 1 || len(img.History) != 1 {
+		t.Fatalf("unexpected rootfs or history: %s", b)
+	}
+
+	files, diffID := readTestLayer(ctx, t, cs, manifest.Layers[0])
+	if diffID != img.RootFS.DiffIDs[0] {
+		t.Fatalf("diff ID %s does not match config %s", diffID, img.RootFS.DiffIDs[0])
+	}
+	expected := map[string]string{
+		"etc/":          "dir",
+		"etc/a":         "a2",
+		"lib":           "lib",
+		"opt/":          "dir",
+		"opt/z":         "z",
+		"usr/":          "dir",
+		"usr/bin/":      "dir",
+		"usr/bin/tool":  "tool",
+		"usr/bin/other": "other",
+	}
+	if diff := cmp.Diff(expected, files); diff != "" {
+		t.Fatalf("unexpected squashed filesystem (-want +got):\n%s", diff)
+	}
+}
+
+func TestSquashHardLinks(t *testing.T) {
+	ctx := context.Background()
+	cs, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lower, _ := writeTestLayer(ctx, t, cs, []entry{
+		{"bin/", tar.TypeDir, ""},
+		{"bin/tool", tar.TypeReg, "tool"},
+		{"bin/alias", tar.TypeLink, "bin/tool"},
+		{"bin/other", tar.TypeLink, "bin/alias"},
+		{"bin/third", tar.TypeLink, "bin/tool"},
+		{"etc/", tar.TypeDir, ""},
+		{"etc/f", tar.TypeReg, "f"},
+		{"etc/g", tar.TypeLink, "etc/f"},
+		{"lib/", tar.TypeDir, ""},
+		{"lib/data", tar.TypeReg, "data"},
+	})
+	middle, _ := writeTestLayer(ctx, t, cs, []entry{
+		{"lib/", tar.TypeDir, ""},
+		{"lib/ref", tar.TypeLink, "lib/data"},
+	})
+	upper, _ := writeTestLayer(ctx, t, cs, []entry{
+		{"bin/", tar.TypeDir, ""},
+		{"bin/.wh.tool", tar.TypeReg, ""},
+		{"lib/", tar.TypeDir, ""},
+		{"lib/data", tar.TypeReg, "new"},
+	})
+	mb, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    writeTestBlob(ctx, t, cs, ocispec.MediaTypeImageConfig, []byte("{}")),
+		Layers:    []ocispec.Descriptor{lower, middle, upper},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	squashed, err := Squash(ctx, cs, writeTestBlob(ctx, t, cs, ocispec.MediaTypeImageManifest, mb))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := content.ReadBlob(ctx, cs, squashed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := readTestLayer(ctx, t, cs, manifest.Layers[0])
+	expected := map[string]string{
+		"bin/":      "dir",
+		"bin/alias": "tool",
+		"bin/other": "link:bin/alias",
+		"bin/third": "link:bin/alias",
+		"etc/":      "dir",
+		"etc/f":     "f",
+		"etc/g":     "link:etc/f",
+		"lib/":      "dir",
+		"lib/data":  "new",
+		"lib/ref":   "data",
+	}
+	if diff := cmp.Diff(expected, files); diff != "" {
+		t.Fatalf("unexpected squashed filesystem (-want +got):\n%s", diff)
+	}
+}
+
+func writeTestLayer(ctx context.Context, t *testing.T, cs content.Store, entries []entry) (ocispec.Descriptor, digest.Digest) {
+	t.Helper()
+	var (
+		buf    bytes.Buffer
+		gw     = gzip.NewWriter(&buf)
+		diffID = digest.Canonical.Digester()
+		tw     = tar.NewWriter(io.MultiWriter(gw, diffID.Hash()))
+	)
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typeflag,
+			Mode:     0644,
+			Size:     int64(len(e.data)),
+		}
+		if e.typeflag == tar.TypeDir {
+			hdr.Mode = 0755
+		}
+		if e.typeflag == tar.TypeLink {
+			hdr.Linkname = e.data
+			hdr.Size = 0
+			e.data = ""
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return writeTestBlob(ctx, t, cs, ocispec.MediaTypeImageLayerGzip, buf.Bytes()), diffID.Digest()
+}
+
+func readTestLayer(ctx context.Context, t *testing.T, cs content.Store, desc ocispec.Descriptor) (map[string]string, digest.Digest) {
+	t.Helper()
+	files := map[string]string{}
+	b, err := content.ReadBlob(ctx, cs, desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest.FromBytes(b) != desc.Digest {
+		t.Fatal("layer digest mismatch")
+	}
+	gr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	diffID := digest.Canonical.Digester()
+	tr := tar.NewReader(io.TeeReader(gr, diffID.Hash()))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := files[hdr.Name]; ok {
+			t.Fatalf("duplicate entry %s", hdr.Name)
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			files[hdr.Name] = "dir"
+			continue
+		}
+		if hdr.Typeflag == tar.TypeLink {
+			files[hdr.Name] = "link:" + hdr.Linkname
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[hdr.Name] = string(data)
+	}
+	// Read any trailing padding so the diff ID covers the full stream
+	if _, err := io.Copy(io.Discard, io.TeeReader(gr, diffID.Hash())); err != nil {
+		t.Fatal(err)
+	}
+	return files, diffID.Digest()
+}
+
+func writeTestBlob(ctx context.Context, t *testing.T, cs content.Store, mediaType string, b []byte) ocispec.Descriptor {
+	t.Helper()
+	desc := ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(b),
+		Size:      int64(len(b)),
+	}
+	if err := content.WriteBlob(ctx, cs, desc.Digest.String(), bytes.NewReader(b), desc); err != nil {
+		t.Fatal(err)
+	}
+	return desc
+}
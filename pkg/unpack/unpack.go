@@ -0,0 +1,149 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package unpack applies the layers of an image manifest to a snapshotter.
+package unpack
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/diff"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/labels"
+	"github.com/containerd/containerd/log"
+	"github.com/containerd/containerd/pkg/snapshotters"
+	"github.com/containerd/containerd/rootfs"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/lcontainerd/pkg/iobuf"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/identity"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+type options struct {
+	ref    string
+	labels map[string]string
+}
+
+// Opt configures an unpack
+type Opt func(*options)
+
+// WithImageRef sets the image reference given to the snapshotter in the
+// labels of each layer
+func WithImageRef(ref string) Opt {
+	return func(o *options) {
+		o.ref = ref
+	}
+}
+
+// WithSnapshotterLabels sets labels given to the snapshotter when preparing
+// and committing the snapshot of every layer
+func WithSnapshotterLabels(labels map[string]string) Opt {
+	return func(o *options) {
+		o.labels = labels
+	}
+}
+
+// Unpack applies the layers of the manifest to the snapshotter, committing
+// each layer under its chain ID. Layers already unpacked are not applied
+// again. The chain ID of the top layer is returned.
+//
+// The snapshotter is given any inherited snapshot labels annotated on the
+// layer descriptor and labels derived from the manifest describing the image
+// reference, the manifest, and the layer, along with the labels set with
+// WithSnapshotterLabels. Remote snapshotters use these labels to fetch the
+// layer contents lazily.
+func Unpack(ctx context.Context, cs content.Store, sn snapshots.Snapshotter, a diff.Applier, desc ocispec.Descriptor, opts ...Opt) (digest.Digest, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if !images.IsManifestType(desc.MediaType) {
+		return "", fmt.Errorf("cannot unpack %s, must be a manifest", desc.MediaType)
+	}
+	b, err := iobuf.ReadBlob(ctx, cs, desc)
+	if err != nil {
+		return "", err
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return "", err
+	}
+	diffIDs, err := images.RootFS(ctx, cs, manifest.Config)
+	if err != nil {
+		return "", fmt.Errorf("failed to read image config: %w", err)
+	}
+	if len(diffIDs) != len(manifest.Layers) {
+		return "", fmt.Errorf("mismatched image rootfs and manifest layers")
+	}
+	if len(diffIDs) == 0 {
+		return "", fmt.Errorf("manifest %s has no layers to unpack", desc.Digest)
+	}
+
+	var chain []digest.Digest
+	for i, layer := range manifest.Layers {
+		l := rootfs.Layer{
+			Blob: layer,
+			Diff: ocispec.Descriptor{
+				MediaType: ocispec.MediaTypeImageLayer,
+				Digest:    diffIDs[i],
+			},
+		}
+		sopts := []snapshots.Opt{snapshots.WithLabels(layerLabels(ctx, o, desc, manifest.Layers[i:]))}
+		if _, err := rootfs.ApplyLayerWithOpts(ctx, l, chain, sn, a, sopts, nil); err != nil {
+			return "", fmt.Errorf("failed to unpack layer %s: %w", layer.Digest, err)
+		}
+		chain = append(chain, diffIDs[i])
+	}
+	return identity.ChainID(chain), nil
+}
+
+// layerLabels returns the snapshotter labels for the first of the layers,
+// the remaining layers are listed so a remote snapshotter may prefetch them
+func layerLabels(ctx context.Context, o options, manifest ocispec.Descriptor, layers []ocispec.Descriptor) map[string]string {
+	l := snapshots.FilterInheritedLabels(layers[0].Annotations)
+	if l == nil {
+		l = map[string]string{}
+	}
+	if o.ref != "" {
+		l[snapshotters.TargetRefLabel] = o.ref
+	}
+	l[snapshotters.TargetManifestDigestLabel] = manifest.Digest.String()
+	l[snapshotters.TargetLayerDigestLabel] = layers[0].Digest.String()
+
+	// Include as many layers as fit within the label size limit
+	var imageLayers string
+	for _, layer := range layers {
+		item := layer.Digest.String()
+		if imageLayers != "" {
+			item = "," + item
+		}
+		if err := labels.Validate(snapshotters.TargetImageLayersLabel, imageLayers+item); err != nil {
+			log.G(ctx).WithError(err).WithField("digest", layer.Digest).Debug("omitting digest in the layers label")
+			break
+		}
+		imageLayers += item
+	}
+	l[snapshotters.TargetImageLayersLabel] = imageLayers
+
+	for k, v := range o.labels {
+		l[k] = v
+	}
+	return l
+}
@@ -0,0 +1,199 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package unpack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/diff"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/mount"
+	"github.com/containerd/containerd/pkg/snapshotters"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/google/go-cmp/cmp"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/identity"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// fakeSnapshotter records the labels given to Prepare and Commit
+type fakeSnapshotter struct {
+	snapshots.Snapshotter
+	committed map[string]snapshots.Info
+	prepared  map[string]map[string]string
+}
+
+func (s *fakeSnapshotter) Stat(ctx context.Context, key string) (snapshots.Info, error) {
+	info, ok := s.committed[key]
+	if !ok {
+		return snapshots.Info{}, errdefs.ErrNotFound
+	}
+	return info, nil
+}
+
+func (s *fakeSnapshotter) Prepare(ctx context.Context, key, parent string, opts ...snapshots.Opt) ([]mount.Mount, error) {
+	var info snapshots.Info
+	for _, opt := range opts {
+		if err := opt(&info); err != nil {
+			return nil, err
+		}
+	}
+	s.prepared[key] = info.Labels
+	return nil, nil
+}
+
+func (s *fakeSnapshotter) Commit(ctx context.Context, name, key string, opts ...snapshots.Opt) error {
+	info := snapshots.Info{Name: name}
+	for _, opt := range opts {
+		if err := opt(&info); err != nil {
+			return err
+		}
+	}
+	s.committed[name] = info
+	return nil
+}
+
+func (s *fakeSnapshotter) Remove(ctx context.Context, key string) error {
+	return nil
+}
+
+// fakeApplier returns the diff of the applied layer without applying it
+type fakeApplier map[digest.Digest]digest.Digest
+
+func (a fakeApplier) Apply(ctx context.Context, desc ocispec.Descriptor, mounts []mount.Mount, opts ...diff.ApplyOpt) (ocispec.Descriptor, error) {
+	return ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayer, Digest: a[desc.Digest]}, nil
+}
+
+func TestUnpackLabels(t *testing.T) {
+	ctx := context.Background()
+	cs, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		layers  []ocispec.Descriptor
+		diffIDs []digest.Digest
+		applier = fakeApplier{}
+	)
+	for _, data := range []string{"lower", "upper"} {
+		layer := writeBlob(ctx, t, cs, ocispec.MediaTypeImageLayerGzip, []byte(data))
+		diffID := digest.FromString(data + " diff")
+		applier[layer.Digest] = diffID
+		layers = append(layers, layer)
+		diffIDs = append(diffIDs, diffID)
+	}
+	layers[1].Annotations = map[string]string{
+		"containerd.io/snapshot/remote.token": "token",
+		"org.example.not-inherited":           "value",
+	}
+	cb, err := json.Marshal(ocispec.Image{
+		RootFS: ocispec.RootFS{Type: "layers", DiffIDs: diffIDs},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mb, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    writeBlob(ctx, t, cs, ocispec.MediaTypeImageConfig, cb),
+		Layers:    layers,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := writeBlob(ctx, t, cs, ocispec.MediaTypeImageManifest, mb)
+
+	sn := &fakeSnapshotter{
+		committed: map[string]snapshots.Info{},
+		prepared:  map[string]map[string]string{},
+	}
+	chainID, err := Unpack(ctx, cs, sn, applier, manifest,
+		WithImageRef("example.com/image:latest"),
+		WithSnapshotterLabels(map[string]string{"containerd.io/snapshot/remote.mode": "lazy"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := identity.ChainID(diffIDs); chainID != expected {
+		t.Fatalf("expected chain ID %s, got %s", expected, chainID)
+	}
+
+	expected := map[string]map[string]string{
+		identity.ChainID(diffIDs[:1]).String(): {
+			"containerd.io/snapshot/remote.mode":   "lazy",
+			snapshotters.TargetRefLabel:            "example.com/image:latest",
+			snapshotters.TargetManifestDigestLabel: manifest.Digest.String(),
+			snapshotters.TargetLayerDigestLabel:    layers[0].Digest.String(),
+			snapshotters.TargetImageLayersLabel:    layers[0].Digest.String() + "," + layers[1].Digest.String(),
+		},
+		chainID.String(): {
+			"containerd.io/snapshot/remote.mode":   "lazy",
+			"containerd.io/snapshot/remote.token":  "token",
+			snapshotters.TargetRefLabel:            "example.com/image:latest",
+			snapshotters.TargetManifestDigestLabel: manifest.Digest.String(),
+			snapshotters.TargetLayerDigestLabel:    layers[1].Digest.String(),
+			snapshotters.TargetImageLayersLabel:    layers[1].Digest.String(),
+		},
+	}
+	committed := map[string]map[string]string{}
+	for name, info := range sn.committed {
+		committed[name] = info.Labels
+	}
+	if diff := cmp.Diff(expected, committed); diff != "" {
+		t.Fatalf("unexpected commit labels (-want +got):\n%s", diff)
+	}
+	if len(sn.prepared) != 2 {
+		t.Fatalf("expected 2 prepared snapshots, got %d", len(sn.prepared))
+	}
+	for key, labels := range sn.prepared {
+		if labels[snapshotters.TargetLayerDigestLabel] == layers[0].Digest.String() {
+			if diff := cmp.Diff(expected[identity.ChainID(diffIDs[:1]).String()], labels); diff != "" {
+				t.Fatalf("unexpected prepare labels for %s (-want +got):\n%s", key, diff)
+			}
+		} else if diff := cmp.Diff(expected[chainID.String()], labels); diff != "" {
+			t.Fatalf("unexpected prepare labels for %s (-want +got):\n%s", key, diff)
+		}
+	}
+
+	// Layers already unpacked are not prepared again
+	sn.prepared = map[string]map[string]string{}
+	if _, err := Unpack(ctx, cs, sn, applier, manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(sn.prepared) != 0 {
+		t.Fatalf("expected unpacked layers to be skipped, prepared %d", len(sn.prepared))
+	}
+}
+
+func writeBlob(ctx context.Context, t *testing.T, cs content.Store, mediaType string, b []byte) ocispec.Descriptor {
+	t.Helper()
+	desc := ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(b),
+		Size:      int64(len(b)),
+	}
+	if err := content.WriteBlob(ctx, cs, desc.Digest.String(), bytes.NewReader(b), desc); err != nil {
+		t.Fatal(err)
+	}
+	return desc
+}